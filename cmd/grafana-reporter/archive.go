@@ -0,0 +1,68 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+)
+
+// maxChecksumBytes is the size up to which zip responses get an X-Archive-Sha256 header.
+// The checksum has to be computed before the response is sent, so larger zips go without.
+const maxChecksumBytes = 64 << 20
+
+var zipMagic = []byte("PK\x03\x04")
+
+// archiveChecksum returns the hex sha256 of the report when it is a zip of at most maxChecksumBytes.
+// The report is rewound afterwards, so it can still be delivered.
+func archiveChecksum(report io.Reader) (sum string, ok bool) {
+	rs, seekable := report.(io.ReadSeeker)
+	if !seekable {
+		return "", false
+	}
+	defer func() {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			log.Println("Error rewinding the report:", err)
+			sum, ok = "", false
+		}
+	}()
+
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil || size > maxChecksumBytes {
+		return "", false
+	}
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", false
+	}
+	head := make([]byte, len(zipMagic))
+	_, err = io.ReadFull(rs, head)
+	if err != nil || !bytes.Equal(head, zipMagic) {
+		return "", false
+	}
+	h := sha256.New()
+	h.Write(head)
+	_, err = io.Copy(h, rs)
+	if err != nil {
+		log.Println("Error computing the zip checksum:", err)
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
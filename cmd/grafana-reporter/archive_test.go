@@ -0,0 +1,89 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fileReport returns the file at path, like reports written to the temporary directory
+type fileReport struct {
+	path string
+}
+
+func (f fileReport) Generate() (io.ReadCloser, error) {
+	return os.Open(f.path)
+}
+
+func (f fileReport) Clean() {}
+
+func TestArchiveChecksum(t *testing.T) {
+	Convey("When a report is served", t, func() {
+		dir, err := ioutil.TempDir("", "archive")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		var rep report.Report
+		newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+			return rep
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{grafana.NewV4Client, newReport, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+
+		Convey("A zip should carry the sha256 of the whole archive", func() {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			w, _ := zw.Create("volume-1-of-1.pdf")
+			w.Write([]byte(fakePdf))
+			So(zw.Close(), ShouldBeNil)
+			path := filepath.Join(dir, "report.zip")
+			So(ioutil.WriteFile(path, buf.Bytes(), 0644), ShouldBeNil)
+			rep = fileReport{path}
+
+			router.ServeHTTP(rec, req)
+			sum := sha256.Sum256(rec.Body.Bytes())
+			So(rec.Header().Get("X-Archive-Sha256"), ShouldEqual, hex.EncodeToString(sum[:]))
+			So(rec.Body.Bytes(), ShouldResemble, buf.Bytes())
+		})
+
+		Convey("A pdf should not carry an archive checksum", func() {
+			path := filepath.Join(dir, "report.pdf")
+			So(ioutil.WriteFile(path, []byte(fakePdf), 0644), ShouldBeNil)
+			rep = fileReport{path}
+
+			router.ServeHTTP(rec, req)
+			So(rec.Header().Get("X-Archive-Sha256"), ShouldBeEmpty)
+			So(rec.Body.String(), ShouldEqual, fakePdf)
+		})
+	})
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/delivery"
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+var cli = flag.Bool("cli", false, "Generate the report of -dash into -o and exit instead of serving reports, e.g. from cron. Exits non-zero when the report fails")
+var cliDash = flag.String("dash", "", "Uid of the dashboard whose report -cli generates")
+var cliFrom = flag.String("from", "now-1h", "Start of the time range of the -cli report, in Grafana's time syntax, e.g. now-7d or an epoch in milliseconds")
+var cliTo = flag.String("to", "now", "End of the time range of the -cli report")
+var cliTemplate = flag.String("template", "", "Name of the TeX template in -templates used for the -cli report, without .tex. The built-in style when empty")
+var cliOutput = flag.String("o", "report.pdf", "File the -cli report is written to, - for stdout")
+var cliVars = cliVariables{}
+
+func init() {
+	flag.Var(cliVars, "var", "Dashboard variable of the -cli report as name=value, e.g. host=dev. Repeat it for several variables or values")
+}
+
+// cliVariables collects the repeated -var flags as Grafana template variable url values, e.g. var-host=dev
+type cliVariables url.Values
+
+func (v cliVariables) String() string {
+	return url.Values(v).Encode()
+}
+
+func (v cliVariables) Set(s string) error {
+	if s == "" {
+		//no variable, e.g. var: "" as printed by -print-config
+		return nil
+	}
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimPrefix(name, "var-")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	url.Values(v).Add("var-"+name, value)
+	return nil
+}
+
+// runCLI generates the report selected with the -cli flags and writes it to -o
+func runCLI(h ServeReportHandler) error {
+	if *cliDash == "" {
+		return errors.New("-cli needs the uid of a dashboard with -dash")
+	}
+	opts := report.Options{
+		MaxPagesPerVolume: *maxPagesPerVolume,
+		CheckVersion:      *checkDashboardVersion,
+		StrictVersion:     *strictDashboardVersion,
+		IgnoreNowDelay:    *ignoreNowDelay,
+	}
+	if len(retention) > 0 {
+		opts.Retention = retention
+	}
+	tex := ""
+	params := report.DefaultTemplateParams()
+	if *cliTemplate != "" {
+		//unlike requests, which fall back to the built-in style, a cron job should fail for a template it can't use
+		var err error
+		tex, err = readTemplate(*cliTemplate)
+		if err != nil {
+			return fmt.Errorf("template %v: %w", *cliTemplate, err)
+		}
+		if params, err = report.ParseTemplateParams(tex); err != nil {
+			return fmt.Errorf("template %v: %w", *cliTemplate, err)
+		}
+		opts.TemplateDir = *templateDir
+	}
+	if missing := missingCLIParams(url.Values(cliVars), params); len(missing) > 0 {
+		return fmt.Errorf("missing parameters required by the template: %v", strings.Join(missing, ", "))
+	}
+
+	vars, panelVars := grafana.SplitPanelVariables(grafana.DedupeVariables(url.Values(cliVars)))
+	g := h.newGrafanaClient(*proto+*ip, *serverAPIToken, vars, grafana.WithPanelVariables(panelVars))
+	t := grafana.NewTimeRange(*cliFrom, *cliTo)
+	t.TZ = *forceTimezone
+
+	rep := h.newReport(g, *cliDash, t, tex, opts)
+	defer rep.Clean()
+	pdf, err := rep.Generate()
+	if err != nil {
+		return fmt.Errorf("error generating report of dashboard %v: %w", *cliDash, err)
+	}
+	defer pdf.Close()
+	meta := delivery.ReportMeta{Dashboard: *cliDash, Time: t, GeneratedAt: stdtime.Now()}
+	return cliSink(*cliOutput).Deliver(context.Background(), meta, pdf)
+}
+
+// cliSink returns the sink of the -cli report: stdout for "-", else the named file, which is replaced when it exists
+func cliSink(name string) delivery.Sink {
+	if name == "-" {
+		return delivery.NewStdoutSink()
+	}
+	return delivery.FileSink{Dir: filepath.Dir(name), NameTemplate: filepath.Base(name), Overwrite: true}
+}
+
+// missingCLIParams adds the template's declared variable defaults to vars and returns the required parameters it lacks.
+// The command line only sets variables, so other declared parameters are never given: their defaults are ignored and
+// requiring them fails.
+func missingCLIParams(vars url.Values, params report.TemplateParams) []string {
+	mergeTemplateDefaults(vars, onlyVariables(params.Defaults))
+	var missing []string
+	for _, key := range params.Required {
+		if vars.Get(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// onlyVariables returns the var- parameters of values
+func onlyVariables(values url.Values) url.Values {
+	vars := url.Values{}
+	for key, v := range values {
+		if strings.HasPrefix(key, "var-") {
+			vars[key] = v
+		}
+	}
+	return vars
+}
@@ -0,0 +1,122 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/delivery"
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCLIVariables(t *testing.T) {
+	Convey("When -var flags are parsed", t, func() {
+		vars := cliVariables{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.Var(vars, "var", "")
+
+		Convey("Repeated flags should become template variables", func() {
+			err := fs.Parse([]string{"-var", "host=dev", "-var", "host=prod", "-var", "var-dc=a=b", "-var", "empty="})
+			So(err, ShouldBeNil)
+			So(url.Values(vars), ShouldResemble, url.Values{"var-host": {"dev", "prod"}, "var-dc": {"a=b"}, "var-empty": {""}})
+		})
+
+		Convey("Flags without a name should fail", func() {
+			So(fs.Parse([]string{"-var", "host"}), ShouldNotBeNil)
+			So(fs.Parse([]string{"-var", "=dev"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRunCLI(t *testing.T) {
+	Convey("When a report is generated from the command line", t, func() {
+		defer func(dash, from, to, tmpl, out string) {
+			*cliDash, *cliFrom, *cliTo, *cliTemplate, *cliOutput = dash, from, to, tmpl, out
+		}(*cliDash, *cliFrom, *cliTo, *cliTemplate, *cliOutput)
+		defer func(vars cliVariables) { cliVars = vars }(cliVars)
+		dir, err := ioutil.TempDir("", "cli")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		var clVars url.Values
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			clVars = variables
+			return grafana.NewV5Client(url, apiToken, variables, opts...)
+		}
+		var repDash string
+		var repTime grafana.TimeRange
+		var rep report.Report = pdfReport{}
+		newReport := func(g grafana.Client, dashName string, time grafana.TimeRange, _ string, opts report.Options) report.Report {
+			repDash, repTime = dashName, time
+			return rep
+		}
+		h := ServeReportHandler{newGrafanaClient, newReport, nil}
+		*cliDash, *cliFrom, *cliTo, *cliTemplate = "abc", "now-7d", "now", ""
+		*cliOutput = filepath.Join(dir, "report.pdf")
+		cliVars = cliVariables{"var-host": {"dev"}}
+
+		Convey("It should write the report of the dashboard to the output file", func() {
+			So(runCLI(h), ShouldBeNil)
+			So(repDash, ShouldEqual, "abc")
+			So(repTime.From, ShouldEqual, "now-7d")
+			So(clVars, ShouldResemble, url.Values{"var-host": {"dev"}})
+			pdf, err := ioutil.ReadFile(*cliOutput)
+			So(err, ShouldBeNil)
+			So(string(pdf), ShouldEqual, fakePdf)
+		})
+
+		Convey("It should replace the output file of a previous run", func() {
+			So(ioutil.WriteFile(*cliOutput, []byte("last week"), 0644), ShouldBeNil)
+			So(runCLI(h), ShouldBeNil)
+			pdf, err := ioutil.ReadFile(*cliOutput)
+			So(err, ShouldBeNil)
+			So(string(pdf), ShouldEqual, fakePdf)
+		})
+
+		Convey("It should write to stdout for -", func() {
+			So(cliSink("-"), ShouldResemble, delivery.NewStdoutSink())
+		})
+
+		Convey("It should fail without writing a file when the report fails", func() {
+			rep = errReport{errors.New("pdflatex failed")}
+			err := runCLI(h)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "pdflatex failed")
+			_, err = os.Stat(*cliOutput)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("It should fail without a dashboard", func() {
+			*cliDash = ""
+			So(runCLI(h), ShouldNotBeNil)
+		})
+
+		Convey("It should fail for a template it can't read", func() {
+			*cliTemplate = "missing"
+			So(runCLI(h), ShouldNotBeNil)
+		})
+	})
+}
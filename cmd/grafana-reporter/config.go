@@ -0,0 +1,184 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var configFile = flag.String("config", "", "YAML file of settings named like the flags, e.g. ip: grafana:3000. Flags given on the command line override it. Secrets can be read from files with the _file suffix, e.g. api_token_file: /run/secrets/grafana-token")
+var printConfig = flag.Bool("print-config", false, "Print the effective settings of the flags and -config file as YAML, with secrets redacted, and exit")
+
+// secretFlags are redacted by -print-config and can be read from a file named by their <name>_file setting
+var secretFlags = map[string]bool{"api-token": true, "probe-secret": true}
+
+// loadConfig sets the flags of fs from the settings of the named YAML file, except those set on the command line.
+// Only flat mappings of scalars are supported, e.g. a line ip: grafana:3000 per setting. Underscores in names are read as hyphens.
+func loadConfig(fs *flag.FlagSet, file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading config: %v", err)
+	}
+	settings, err := parseConfig(string(data))
+	if err != nil {
+		return fmt.Errorf("error parsing config %v: %v", file, err)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, s := range settings {
+		name, value := s.name, s.value
+		if secret := strings.TrimSuffix(name, "-file"); secret != name && secretFlags[secret] {
+			content, err := ioutil.ReadFile(value)
+			if err != nil {
+				return fmt.Errorf("config %v, line %d: error reading %v: %v", file, s.line, name, err)
+			}
+			name, value = secret, strings.TrimRight(string(content), "\r\n")
+		}
+		if name == "config" || name == "print-config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("config %v, line %d: unknown setting %q", file, s.line, s.name)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config %v, line %d: invalid %v: %v", file, s.line, s.name, err)
+		}
+	}
+	return nil
+}
+
+type configSetting struct {
+	line  int
+	name  string
+	value string
+}
+
+// parseConfig reads the name: value lines of a YAML mapping of scalars. Values may be quoted, comments start with #.
+func parseConfig(data string) ([]configSetting, error) {
+	var settings []configSetting
+	seen := map[string]bool{}
+	for i, line := range strings.Split(data, "\n") {
+		n := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(trimmed, "- ") {
+			return nil, fmt.Errorf("line %d: only settings of the form name: value are supported", n)
+		}
+		name, rest, ok := strings.Cut(trimmed, ":")
+		if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+			return nil, fmt.Errorf("line %d: expected name: value", n)
+		}
+		name = strings.ReplaceAll(strings.TrimSpace(name), "_", "-")
+		value, err := configValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("line %d: %v is set twice", n, name)
+		}
+		seen[name] = true
+		settings = append(settings, configSetting{n, name, value})
+	}
+	return settings, nil
+}
+
+// configValue returns the scalar of a setting: the content of a single or double quoted value, or a plain value up to a comment
+func configValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.LastIndex(s, `"`)
+		if end == 0 || !isComment(s[end+1:]) {
+			return "", errors.New("unterminated double quoted value")
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		end := strings.LastIndex(s, "'")
+		if end == 0 || !isComment(s[end+1:]) {
+			return "", errors.New("unterminated single quoted value")
+		}
+		return strings.ReplaceAll(s[1:end], "''", "'"), nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{") || strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">"):
+		return "", errors.New("only scalar values are supported, quote the value or use a comma separated list")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if s == "~" || s == "null" {
+		return "", nil
+	}
+	return s, nil
+}
+
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || strings.HasPrefix(s, "#")
+}
+
+// writeConfig prints the settings of all flags of fs as a YAML config, redacting secretFlags
+func writeConfig(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "REDACTED"
+		}
+		fmt.Fprintf(w, "%s: %s\n", f.Name, yamlScalar(value))
+	})
+}
+
+// yamlScalar quotes values that would not be read back as the same plain string
+func yamlScalar(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, "#\"'\\\n") || strings.ContainsAny(s[:1], "[]{}|>&*!%@`-?,~") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// validateConfig fails for settings that contradict each other
+func validateConfig() error {
+	var problems []string
+	if *tokensFile != "" && *tokenUserHeader == "" {
+		problems = append(problems, "-tokens is set but -token-user-header is empty, so no user could select a token")
+	}
+	if *probeDashboard != "" && *probeSecret == "" {
+		problems = append(problems, "-probe-dashboard is set but -probe-secret is empty, which disables the probe")
+	}
+	if *cli && *cliDash == "" {
+		problems = append(problems, "-cli is set but -dash is empty")
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		problems = append(problems, "-cert and -key must be set together")
+	}
+	if *maxPagesPerVolume < 0 || *maxConcurrentRenders < 0 || *maxLargeDownloads < 0 {
+		problems = append(problems, "-max-pages-per-volume, -max-concurrent-renders and -max-large-downloads must not be negative")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
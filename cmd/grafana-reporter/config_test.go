@@ -0,0 +1,143 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	stdtime "time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfig(t *testing.T) {
+	Convey("When settings are loaded from a config file", t, func() {
+		dir, err := ioutil.TempDir("", "config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		writeFile := func(name string, content string) string {
+			file := filepath.Join(dir, name)
+			So(ioutil.WriteFile(file, []byte(content), 0600), ShouldBeNil)
+			return file
+		}
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		ip := fs.String("ip", "localhost:3000", "")
+		port := fs.String("port", ":8686", "")
+		token := fs.String("api-token", "", "")
+		timeout := fs.Duration("latex-timeout", stdtime.Minute, "")
+		ui := fs.Bool("ui", false, "")
+		help := fs.String("error-help", "", "")
+
+		Convey("It should set the flags it names", func() {
+			file := writeFile("reporter.yaml", "---\n# reporter settings\nip: grafana:3000\nlatex_timeout: 5m # slow disks\nui: true\nerror-help: 'Ask #ops, it''s urgent'\n")
+			So(fs.Parse(nil), ShouldBeNil)
+			So(loadConfig(fs, file), ShouldBeNil)
+			So(*ip, ShouldEqual, "grafana:3000")
+			So(*timeout, ShouldEqual, 5*stdtime.Minute)
+			So(*ui, ShouldBeTrue)
+			So(*help, ShouldEqual, "Ask #ops, it's urgent")
+			So(*port, ShouldEqual, ":8686")
+		})
+
+		Convey("Flags on the command line should override it", func() {
+			file := writeFile("reporter.yaml", "ip: grafana:3000\nport: \":9000\"\n")
+			So(fs.Parse([]string{"-ip", "other:3000"}), ShouldBeNil)
+			So(loadConfig(fs, file), ShouldBeNil)
+			So(*ip, ShouldEqual, "other:3000")
+			So(*port, ShouldEqual, ":9000")
+		})
+
+		Convey("Secrets should be read from the files named by their _file setting", func() {
+			secret := writeFile("token", "s3cret\n")
+			file := writeFile("reporter.yaml", "api_token_file: "+secret+"\n")
+			So(fs.Parse(nil), ShouldBeNil)
+			So(loadConfig(fs, file), ShouldBeNil)
+			So(*token, ShouldEqual, "s3cret")
+
+			Convey("And be redacted when the config is printed", func() {
+				var out bytes.Buffer
+				writeConfig(&out, fs)
+				So(out.String(), ShouldContainSubstring, "api-token: REDACTED\n")
+				So(out.String(), ShouldContainSubstring, "ip: localhost:3000\n")
+				So(out.String(), ShouldContainSubstring, "error-help: \"\"\n")
+				So(out.String(), ShouldNotContainSubstring, "s3cret")
+			})
+		})
+
+		Convey("It should fail with the line of an invalid setting", func() {
+			So(fs.Parse(nil), ShouldBeNil)
+			for content, msg := range map[string]string{
+				"ip: grafana\nnope: 1\n":            `line 2: unknown setting "nope"`,
+				"latex-timeout: soon\n":             "line 1: invalid latex-timeout",
+				"ip: a\nip: b\n":                    "line 2: ip is set twice",
+				"ip:\n  host: grafana\n":            "line 2: only settings of the form name: value are supported",
+				"ip: [a, b]\n":                      "only scalar values are supported",
+				"port: \":9000\n":                   "unterminated double quoted value",
+				"api-token-file: /does/not/exist\n": "error reading api-token-file",
+			} {
+				err := loadConfig(fs, writeFile("reporter.yaml", content))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, msg)
+			}
+		})
+
+		Convey("A printed config should load to the same settings", func() {
+			*help = "Call: 555 #1"
+			*timeout = 90 * stdtime.Second
+			var out bytes.Buffer
+			writeConfig(&out, fs)
+			*help, *timeout = "", stdtime.Minute
+			So(fs.Parse(nil), ShouldBeNil)
+			So(loadConfig(fs, writeFile("printed.yaml", out.String())), ShouldBeNil)
+			So(*help, ShouldEqual, "Call: 555 #1")
+			So(*timeout, ShouldEqual, 90*stdtime.Second)
+		})
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	Convey("When the configuration is validated", t, func() {
+		defer func(dash, secret string) { *probeDashboard, *probeSecret = dash, secret }(*probeDashboard, *probeSecret)
+
+		Convey("The default configuration should be valid", func() {
+			So(validateConfig(), ShouldBeNil)
+		})
+
+		Convey("Contradicting settings should fail with a clear message", func() {
+			*probeDashboard, *probeSecret = "abc", ""
+			err := validateConfig()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "-probe-secret is empty")
+		})
+
+		Convey("A certificate without its key should fail", func() {
+			defer func(cert, key string) { *certFile, *keyFile = cert, key }(*certFile, *keyFile)
+			*certFile, *keyFile = "server.pem", ""
+			err := validateConfig()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "-cert and -key must be set together")
+			*certFile, *keyFile = "", "server.key"
+			So(validateConfig(), ShouldNotBeNil)
+		})
+	})
+}
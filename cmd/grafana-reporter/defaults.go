@@ -0,0 +1,69 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// dashboardDefaultParams are the report parameters a dashboard may declare defaults for. They only change the
+// report's presentation: parameters selecting the template, signing, annotating, timeouts, dashboard versions,
+// extras, variables or credentials are left to the request.
+var dashboardDefaultParams = map[string]bool{
+	"trim":                true,
+	"noDataBadge":         true,
+	"summary":             true,
+	"style":               true,
+	"panelsPerPage":       true,
+	"glossary":            true,
+	"locale":              true,
+	"imageFormat":         true,
+	"noteSkippedPanels":   true,
+	"contactSheet":        true,
+	"contactSheetColumns": true,
+}
+
+// applyDashboardDefaults adds the report parameters declared by the dashboard to the request's query.
+// Parameters given by the request win, and only the dashboardDefaultParams are taken from the dashboard.
+func applyDashboardDefaults(req *http.Request, dash grafana.Dashboard) {
+	query := req.URL.Query()
+	if mergeDashboardDefaults(query, dash.ReportDefaults) {
+		req.URL.RawQuery = query.Encode()
+	}
+}
+
+// mergeDashboardDefaults adds the allowed defaults missing from query and reports whether any were added
+func mergeDashboardDefaults(query url.Values, defaults url.Values) bool {
+	added := false
+	for key, values := range defaults {
+		if !dashboardDefaultParams[key] {
+			log.Printf("Ignoring dashboard report default %v", key)
+			continue
+		}
+		if _, ok := query[key]; ok {
+			continue
+		}
+		log.Printf("Using dashboard report default %v=%v", key, values)
+		query[key] = values
+		added = true
+	}
+	return added
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDashboardReportDefaults(t *testing.T) {
+	Convey("When reporting a dashboard declaring report defaults", t, func() {
+		dashJSON := `{"dashboard":{"title":"Routers","tags":["report:trim","report:apitoken=stolen","report:var-host=other"],` +
+			`"description":"reporter:{\"style\":\"plain\",\"summary\":[4,7]}"}}`
+		fetches := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			fmt.Fprint(w, dashJSON)
+		}))
+		defer ts.Close()
+		var clAPIToken string
+		var clVars url.Values
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			clAPIToken, clVars = apiToken, variables
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		var repOptions report.Options
+		newReport := func(_ grafana.Client, _ string, _ grafana.TimeRange, _ string, opts report.Options) report.Report {
+			repOptions = opts
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		rec := httptest.NewRecorder()
+
+		Convey("The defaults should be used when the request does not set them", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repOptions.Trim, ShouldBeTrue)
+			So(repOptions.Style, ShouldEqual, "plain")
+			So(repOptions.Summary, ShouldResemble, []int{4, 7})
+		})
+
+		Convey("Request parameters should take precedence", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?trim=false&style=compact&summary=", nil)
+			router.ServeHTTP(rec, req)
+			So(repOptions.Trim, ShouldBeFalse)
+			So(repOptions.Style, ShouldEqual, "compact")
+			So(repOptions.Summary, ShouldBeNil)
+		})
+
+		Convey("Api tokens and variables should never be taken from the dashboard", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?apitoken=1234", nil)
+			router.ServeHTTP(rec, req)
+			So(clAPIToken, ShouldEqual, "1234")
+			So(clVars, ShouldResemble, url.Values{})
+		})
+
+		Convey("Only presentation parameters should be taken from the dashboard", func() {
+			dashJSON = `{"dashboard":{"title":"Routers","tags":["report:sign","report:annotate","report:trim"],` +
+				`"description":"reporter:{\"template\":\"other\",\"timeout\":\"1h\",\"dashboardVersion\":3,\"extra-customer\":\"ACME\",\"style\":\"plain\"}"}}`
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repOptions.Trim, ShouldBeTrue)
+			So(repOptions.Style, ShouldEqual, "plain")
+			So(repOptions.Sign, ShouldBeFalse)
+			So(repOptions.TemplateDir, ShouldEqual, "")
+			So(repOptions.Extras, ShouldBeNil)
+			So(repOptions.Dashboard.Title, ShouldEqual, "Routers")
+		})
+
+		Convey("The dashboard should be fetched once, for its defaults and the report", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(fetches, ShouldEqual, 1)
+			So(repOptions.Dashboard, ShouldNotBeNil)
+		})
+
+		Convey("The dashboard should be fetched with the request's context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req, _ := http.NewRequestWithContext(ctx, "GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(fetches, ShouldEqual, 0)
+			So(repOptions.Dashboard, ShouldBeNil)
+		})
+
+		Convey("A malformed blob should be ignored", func() {
+			dashJSON = `{"dashboard":{"title":"Routers","tags":["report:trim"],"description":"reporter:{\"style\":"}}`
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repOptions.Trim, ShouldBeTrue)
+			So(repOptions.Style, ShouldEqual, "")
+		})
+	})
+}
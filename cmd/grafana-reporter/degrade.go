@@ -0,0 +1,101 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"syscall"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+// degradeLevel is a step of the -auto-degrade fallback chain
+type degradeLevel struct {
+	desc       string
+	clientOpts []grafana.ClientOption
+}
+
+// degradeLevels are tried in order after a full quality report failed for lack of resources
+var degradeLevels = []degradeLevel{
+	{"JPEG panel images", []grafana.ClientOption{grafana.WithImageFormat(grafana.ImageJPEG)}},
+	{"JPEG panel images at half size", []grafana.ClientOption{grafana.WithImageFormat(grafana.ImageJPEG), grafana.WithRenderScale(0.5)}},
+}
+
+// resourceFailure describes err if the report failed for lack of resources, which a reduced-quality report may avoid.
+// It returns "" for all other errors, e.g. template, Grafana or authorization errors.
+func resourceFailure(err error) string {
+	var latexErr *report.LaTeXError
+	if errors.As(err, &latexErr) && latexErr.Reason == report.LaTeXKilled {
+		return "LaTeX did not finish in time"
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return "the disk was full"
+	}
+	return ""
+}
+
+// degradingReport generates the full quality report and, if that fails for lack of resources, retries
+// with the degradeLevels in order until a report succeeds or fails for another reason
+type degradingReport struct {
+	newReport func(level *degradeLevel, opts report.Options) report.Report
+	opts      report.Options
+	current   report.Report
+	degraded  string
+}
+
+func (d *degradingReport) Generate() (io.ReadCloser, error) {
+	d.current = d.newReport(nil, d.opts)
+	file, err := d.current.Generate()
+	for i := range degradeLevels {
+		cause := resourceFailure(err)
+		if cause == "" {
+			break
+		}
+		level := &degradeLevels[i]
+		log.Printf("Report failed because %s, retrying with %s: %v", cause, level.desc, err)
+		d.current.Clean()
+		opts := d.opts
+		opts.Degraded = fmt.Sprintf("This report was generated in reduced-quality mode (%s) because %s.", level.desc, cause)
+		d.current = d.newReport(level, opts)
+		d.degraded = opts.Degraded
+		file, err = d.current.Generate()
+	}
+	return file, err
+}
+
+func (d *degradingReport) Clean() {
+	if d.current != nil {
+		d.current.Clean()
+	}
+}
+
+// Stats returns the stats of the report that was generated last
+func (d *degradingReport) Stats() report.Stats {
+	if r, ok := d.current.(report.StatsReporter); ok {
+		return r.Stats()
+	}
+	return report.Stats{}
+}
+
+// Degraded returns why the report was generated in reduced-quality mode, "" for a full quality report
+func (d *degradingReport) Degraded() string {
+	return d.degraded
+}
@@ -0,0 +1,210 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// scriptedReport renders a panel with its client, then fails with err
+type scriptedReport struct {
+	g   grafana.Client
+	err error
+}
+
+func (s scriptedReport) Generate() (io.ReadCloser, error) {
+	body, err := s.g.GetPanelPng(grafana.Panel{Id: 1, Type: "graph"}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"})
+	if err == nil {
+		body.Close()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return ioutil.NopCloser(bytes.NewReader([]byte("%PDF"))), nil
+}
+
+func (s scriptedReport) Clean() {}
+
+func TestAutoDegrade(t *testing.T) {
+	Convey("When reports fail with auto-degrade enabled", t, func() {
+		*autoDegrade = true
+		defer func() { *autoDegrade = false }()
+		var renders []url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/dashboards/uid/testDash":
+				fmt.Fprint(w, `{"dashboard":{"title":"test","version":3}}`)
+			default:
+				renders = append(renders, r.URL.Query())
+			}
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+
+		var failures []error
+		var degraded []string
+		newReport := func(g grafana.Client, _ string, _ grafana.TimeRange, _ string, opts report.Options) report.Report {
+			degraded = append(degraded, opts.Degraded)
+			var err error
+			if len(failures) > 0 {
+				err, failures = failures[0], failures[1:]
+			}
+			return scriptedReport{g, err}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{newGrafanaClient, newReport, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash?from=1500000000000&to=1500003600000", nil)
+		timeout := &report.LaTeXError{Err: errors.New("not finished within 10m0s"), Reason: report.LaTeXKilled}
+
+		Convey("A full quality report should be served as is", func() {
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(degraded, ShouldResemble, []string{""})
+			So(rec.Header().Get("X-Report-Degraded"), ShouldEqual, "")
+			So(rec.Header().Get("ETag"), ShouldNotEqual, "")
+		})
+
+		Convey("A LaTeX timeout should be retried with JPEG images", func() {
+			failures = []error{timeout}
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(renders, ShouldHaveLength, 2)
+			So(renders[0].Get("encoding"), ShouldEqual, "")
+			So(renders[1].Get("encoding"), ShouldEqual, "jpeg")
+			So(renders[1].Get("width"), ShouldEqual, "1000")
+			So(degraded[1], ShouldEqual, "This report was generated in reduced-quality mode (JPEG panel images) because LaTeX did not finish in time.")
+			So(rec.Header().Get("X-Report-Degraded"), ShouldEqual, degraded[1])
+			So(rec.Header().Get("ETag"), ShouldEqual, "")
+		})
+
+		Convey("A second resource failure should be retried with half size images", func() {
+			failures = []error{timeout, &os.PathError{Op: "write", Path: "report.pdf", Err: syscall.ENOSPC}}
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(renders, ShouldHaveLength, 3)
+			So(renders[2].Get("encoding"), ShouldEqual, "jpeg")
+			So(renders[2].Get("width"), ShouldEqual, "500")
+			So(degraded[2], ShouldContainSubstring, "JPEG panel images at half size")
+			So(degraded[2], ShouldContainSubstring, "the disk was full")
+		})
+
+		Convey("The last level's resource failure should be returned", func() {
+			failures = []error{timeout, timeout, timeout}
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusGatewayTimeout)
+			So(degraded, ShouldHaveLength, 3)
+		})
+
+		Convey("Other failures should not be retried", func() {
+			failures = []error{&report.LaTeXError{Err: errors.New("exit status 1"), Reason: report.LaTeXCompileFailed}}
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(degraded, ShouldHaveLength, 1)
+		})
+
+		Convey("Failures after degrading for other reasons should not be retried further", func() {
+			failures = []error{timeout, &report.TeXLimitError{Limit: "TeX file size", Value: 2, Max: 1}}
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(degraded, ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestAutoDegradeDiskFull(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("needs /dev/full to fail writes with ENOSPC")
+	}
+	Convey("When a report's panel image can't be written because the disk is full", t, func() {
+		*autoDegrade = true
+		defer func() { *autoDegrade = false }()
+		defer func(orig string) { report.TmpRoot = orig }(report.TmpRoot)
+		report.TmpRoot = t.TempDir()
+		var renders []url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/dashboards/uid/testDash":
+				fmt.Fprint(w, `{"dashboard":{"title":"test","version":3,"panels":[{"id":1,"type":"graph"}]}}`)
+			default:
+				renders = append(renders, r.URL.Query())
+				if len(renders) == 1 {
+					//the full quality report writes its panel image to /dev/full
+					dirs, _ := filepath.Glob(filepath.Join(report.TmpRoot, "report-*", "images"))
+					for _, dir := range dirs {
+						os.Symlink("/dev/full", filepath.Join(dir, "image1.png"))
+					}
+				}
+				w.Write([]byte("\x89PNG\r\n\x1a\n"))
+			}
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		var degraded []string
+		newReport := func(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string, opts report.Options) report.Report {
+			degraded = append(degraded, opts.Degraded)
+			if opts.Degraded == "" {
+				return report.New(g, dashName, time, texTemplate, opts)
+			}
+			return scriptedReport{g, nil}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{newGrafanaClient, newReport, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash?from=1500000000000&to=1500003600000", nil)
+
+		Convey("It should be retried with JPEG images", func() {
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(degraded, ShouldHaveLength, 2)
+			So(degraded[1], ShouldContainSubstring, "the disk was full")
+			So(renders[1].Get("encoding"), ShouldEqual, "jpeg")
+		})
+	})
+}
+
+func TestResourceFailure(t *testing.T) {
+	Convey("When classifying report failures", t, func() {
+		So(resourceFailure(&report.LaTeXError{Reason: report.LaTeXKilled}), ShouldNotEqual, "")
+		So(resourceFailure(fmt.Errorf("error compiling volume 1: %w", &report.LaTeXError{Reason: report.LaTeXKilled})), ShouldNotEqual, "")
+		So(resourceFailure(&os.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}), ShouldNotEqual, "")
+		So(resourceFailure(&report.LaTeXError{Reason: report.LaTeXCompileFailed}), ShouldEqual, "")
+		So(resourceFailure(&report.TeXLimitError{}), ShouldEqual, "")
+		So(resourceFailure(grafana.ErrCircuitOpen), ShouldEqual, "")
+		So(resourceFailure(errors.New("error obtaining dashboard: 401 Unauthorized")), ShouldEqual, "")
+	})
+}
@@ -0,0 +1,100 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	stdtime "time"
+)
+
+// largeDownloads holds a slot for each report being delivered from disk, i.e. larger than the spool memory limit.
+// nil when the number of such downloads is not limited.
+var largeDownloads chan struct{}
+
+// setMaxLargeDownloads limits the number of reports delivered from disk at once. 0 for no limit
+func setMaxLargeDownloads(n int) {
+	if n <= 0 {
+		largeDownloads = nil
+		return
+	}
+	largeDownloads = make(chan struct{}, n)
+}
+
+// acquireLargeDownload waits for a large download slot, until ctx is done
+func acquireLargeDownload(ctx context.Context) error {
+	if largeDownloads == nil {
+		return nil
+	}
+	select {
+	case largeDownloads <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseLargeDownload() {
+	if largeDownloads != nil {
+		<-largeDownloads
+	}
+}
+
+// idleTimeoutWriter renews the response's write deadline before each write. A client that stops reading
+// fails the download after timeout, however long the whole download of a large report takes.
+type idleTimeoutWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	timeout stdtime.Duration
+}
+
+// newIdleTimeoutWriter wraps w with a write idle timeout. Call done once the response was written,
+// so the deadline doesn't carry over to the next request on the connection. A timeout of 0 returns w as it is.
+func newIdleTimeoutWriter(w http.ResponseWriter, timeout stdtime.Duration) (writer http.ResponseWriter, done func()) {
+	if timeout <= 0 {
+		return w, func() {}
+	}
+	iw := &idleTimeoutWriter{w: w, rc: http.NewResponseController(w), timeout: timeout}
+	return iw, func() { iw.setDeadline(stdtime.Time{}) }
+}
+
+func (iw *idleTimeoutWriter) Header() http.Header {
+	return iw.w.Header()
+}
+
+func (iw *idleTimeoutWriter) WriteHeader(code int) {
+	iw.w.WriteHeader(code)
+}
+
+func (iw *idleTimeoutWriter) Write(p []byte) (int, error) {
+	iw.setDeadline(stdtime.Now().Add(iw.timeout))
+	return iw.w.Write(p)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (iw *idleTimeoutWriter) Unwrap() http.ResponseWriter {
+	return iw.w
+}
+
+func (iw *idleTimeoutWriter) setDeadline(deadline stdtime.Time) {
+	err := iw.rc.SetWriteDeadline(deadline)
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println("Error setting the response write deadline:", err)
+	}
+}
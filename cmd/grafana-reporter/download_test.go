@@ -0,0 +1,192 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/delivery"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sizedReport is a report delivered from a file of the given size, noting when it was cleaned
+type sizedReport struct {
+	path    string
+	cleaned chan struct{}
+}
+
+func newSizedReport(size int) (*sizedReport, error) {
+	f, err := ioutil.TempFile("", "report")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Write(make([]byte, size)); err != nil {
+		return nil, err
+	}
+	return &sizedReport{f.Name(), make(chan struct{})}, nil
+}
+
+func (r *sizedReport) Generate() (io.ReadCloser, error) {
+	return os.Open(r.path)
+}
+
+func (r *sizedReport) Clean() {
+	os.Remove(r.path)
+	close(r.cleaned)
+}
+
+func (r *sizedReport) wasCleaned(within stdtime.Duration) bool {
+	select {
+	case <-r.cleaned:
+		return true
+	case <-stdtime.After(within):
+		return false
+	}
+}
+
+func TestLargeDownloads(t *testing.T) {
+	Convey("When delivering reports larger than the spool memory limit", t, func() {
+		defer func(orig int64) { *spoolMemoryLimit = orig }(*spoolMemoryLimit)
+		defer func(orig stdtime.Duration) { *writeTimeout = orig }(*writeTimeout)
+		defer setMaxLargeDownloads(0)
+		*spoolMemoryLimit = 1024
+		*writeTimeout = 200 * stdtime.Millisecond
+
+		Convey("A client that stops reading should time out and the report's files be removed", func() {
+			rep, err := newSizedReport(64 << 20)
+			So(err, ShouldBeNil)
+			delivered := make(chan bool, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				_, ok := serveReport(w, req, rep, delivery.ReportMeta{Dashboard: "slow"}, "slow")
+				delivered <- ok
+			}))
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: reporter\r\n\r\n")
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			So(rep.wasCleaned(5*stdtime.Second), ShouldBeTrue)
+			So(<-delivered, ShouldBeFalse)
+			_, err = os.Stat(rep.path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("A client that keeps reading should get the whole report, however long it takes", func() {
+			rep, err := newSizedReport(4 << 20)
+			So(err, ShouldBeNil)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				serveReport(w, req, rep, delivery.ReportMeta{Dashboard: "steady"}, "steady")
+			}))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			//reading the whole report takes longer than the write timeout
+			start := stdtime.Now()
+			n := 0
+			buf := make([]byte, 64<<10)
+			for {
+				m, err := io.ReadFull(resp.Body, buf)
+				n += m
+				if err != nil {
+					So(err, ShouldEqual, io.EOF)
+					break
+				}
+				stdtime.Sleep(10 * stdtime.Millisecond)
+			}
+			So(stdtime.Since(start), ShouldBeGreaterThan, *writeTimeout)
+			So(n, ShouldEqual, 4<<20)
+			So(rep.wasCleaned(stdtime.Second), ShouldBeTrue)
+		})
+
+		Convey("Downloads beyond the limit should wait for a slot", func() {
+			setMaxLargeDownloads(1)
+			So(acquireLargeDownload(context.Background()), ShouldBeNil)
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+
+			Convey("And be delivered once one is free", func() {
+				rep, err := newSizedReport(4096)
+				So(err, ShouldBeNil)
+				rec := httptest.NewRecorder()
+				delivered := make(chan bool, 1)
+				go func() {
+					_, ok := serveReport(rec, req, rep, delivery.ReportMeta{Dashboard: "large"}, "large")
+					delivered <- ok
+				}()
+				stdtime.Sleep(50 * stdtime.Millisecond)
+				So(delivered, ShouldBeEmpty)
+				releaseLargeDownload()
+				So(<-delivered, ShouldBeTrue)
+				So(rec.Body.Len(), ShouldEqual, 4096)
+			})
+
+			Convey("And be answered with 503 when the report times out meanwhile, their files removed", func() {
+				defer func(orig stdtime.Duration) { *reportTimeout = orig }(*reportTimeout)
+				*reportTimeout = 50 * stdtime.Millisecond
+				req, cancel, err := withReportTimeout(req)
+				So(err, ShouldBeNil)
+				defer cancel()
+				rep, err := newSizedReport(4096)
+				So(err, ShouldBeNil)
+				rec := httptest.NewRecorder()
+				_, ok := serveReport(rec, req, rep, delivery.ReportMeta{Dashboard: "large"}, "large")
+				So(ok, ShouldBeFalse)
+				So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(rec.Header().Get("Retry-After"), ShouldNotBeEmpty)
+				So(rep.wasCleaned(stdtime.Second), ShouldBeTrue)
+			})
+
+			Convey("And stop waiting when the client disconnects, their files removed", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				rep, err := newSizedReport(4096)
+				So(err, ShouldBeNil)
+				rec := httptest.NewRecorder()
+				_, ok := serveReport(rec, req.WithContext(ctx), rep, delivery.ReportMeta{Dashboard: "large"}, "large")
+				So(ok, ShouldBeFalse)
+				So(rec.Body.Len(), ShouldEqual, 0)
+				So(rep.wasCleaned(stdtime.Second), ShouldBeTrue)
+			})
+
+			Convey("Reports held in memory should not need a slot", func() {
+				rep, err := newSizedReport(512)
+				So(err, ShouldBeNil)
+				rec := httptest.NewRecorder()
+				_, ok := serveReport(rec, req, rep, delivery.ReportMeta{Dashboard: "small"}, "small")
+				So(ok, ShouldBeTrue)
+				So(rec.Body.Len(), ShouldEqual, 512)
+			})
+		})
+	})
+}
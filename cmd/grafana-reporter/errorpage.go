@@ -0,0 +1,156 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+// writeReportError answers a failed report request in the format selected by the errorFormat parameter:
+// text (the default) with the error message, json with the message, category and error id,
+// or pdf with a one page document for embedding the report URL, e.g. in an iframe.
+// The pdf only states the error category, so it never shows credentials or LaTeX logs.
+// The error id is logged with the error, so users quoting it can be matched to the log.
+func writeReportError(w http.ResponseWriter, req *http.Request, err error) {
+	status := generateErrorStatus(err)
+	id := correlationID()
+	log.Printf("Error %v: %v", id, err)
+	w.Header().Set("X-Error-Id", id)
+
+	switch req.URL.Query().Get("errorFormat") {
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.WriteHeader(status)
+		w.Write(errorPDF([]string{
+			"The report could not be generated",
+			errorCategory(err),
+			"Error id: " + id,
+			*errorHelp,
+		}))
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(struct {
+			Error    string `json:"error"`
+			Category string `json:"category"`
+			ID       string `json:"id"`
+		}{err.Error(), errorCategory(err), id})
+	default:
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// errorCategory describes the kind of error in terms meaningful to report readers
+func errorCategory(err error) string {
+	var connErr *grafana.ConnectionError
+	var limitErr *report.TeXLimitError
+	var versionErr *report.VersionChangedError
+	var latexErr *report.LaTeXError
+	switch {
+	case errors.Is(err, grafana.ErrCircuitOpen), errors.As(err, &connErr):
+		return "Grafana could not be reached."
+	case errors.As(err, &limitErr):
+		return "The report exceeds the configured size limits."
+	case errors.As(err, &versionErr):
+		return "The dashboard was changed while the report was generated. Please try again."
+	case errors.As(err, &latexErr) && latexErr.Reason == report.LaTeXNotInstalled:
+		return "The report service is not installed completely."
+	case errors.As(err, &latexErr) && latexErr.Reason == report.LaTeXKilled:
+		return "Typesetting the report took too long or ran out of resources."
+	case errors.As(err, &latexErr) && latexErr.Reason == report.LaTeXNoPDF:
+		return "The typeset report could not be stored."
+	case errors.As(err, &latexErr):
+		return "The report could not be typeset."
+	default:
+		return "An error occurred while generating the report."
+	}
+}
+
+func correlationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// errorPDF returns a minimal one page PDF showing the lines, the first one as a heading.
+// It needs no LaTeX, which may be the reason the report failed.
+func errorPDF(lines []string) []byte {
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 16 Tf\n72 720 Td\n")
+	for i, l := range lines {
+		if i == 1 {
+			content.WriteString("/F1 11 Tf\n0 -12 Td\n")
+		}
+		fmt.Fprintf(&content, "(%s) Tj\n0 -20 Td\n", pdfText(l))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Length " + strconv.Itoa(content.Len()) + " >>\nstream\n" + content.String() + "endstream",
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// pdfText escapes s for a PDF string literal. Characters outside printable ASCII, which the
+// standard Helvetica encoding may not cover, are replaced with '?'
+func pdfText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < ' ' || r > '~':
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
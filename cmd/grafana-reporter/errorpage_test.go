@@ -0,0 +1,111 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrorFormat(t *testing.T) {
+	Convey("When generating a report fails", t, func() {
+		latexErr := &report.LaTeXError{Err: errors.New("exit status 1"), Output: "! Undefined control sequence \\secretmacro"}
+		var repErr error = latexErr
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(url, apiToken, variables, opts...)
+		}
+		newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+			return errReport{repErr}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		rec := httptest.NewRecorder()
+		serve := func(query string) {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?apitoken=secrettoken"+query, nil)
+			router.ServeHTTP(rec, req)
+		}
+
+		Convey("The default text format should return the error message", func() {
+			serve("")
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(rec.Body.String(), ShouldContainSubstring, "Undefined control sequence")
+			So(rec.Header().Get("X-Error-Id"), ShouldNotBeEmpty)
+		})
+
+		Convey("The json format should return the message, category and error id", func() {
+			serve("&errorFormat=json")
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			var body struct {
+				Error, Category, ID string
+			}
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Error, ShouldContainSubstring, "error calling LaTeX")
+			So(body.Category, ShouldEqual, "The report could not be typeset.")
+			So(body.ID, ShouldEqual, rec.Header().Get("X-Error-Id"))
+		})
+
+		Convey("The pdf format should return an error page without the error details", func() {
+			serve("&errorFormat=pdf")
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/pdf")
+			pdf := rec.Body.String()
+			So(pdf, ShouldStartWith, "%PDF-1.4")
+			So(strings.TrimSpace(pdf), ShouldEndWith, "%%EOF")
+			So(pdf, ShouldContainSubstring, "(The report could not be typeset.) Tj")
+			So(pdf, ShouldContainSubstring, "(Error id: "+rec.Header().Get("X-Error-Id")+") Tj")
+			So(pdf, ShouldNotContainSubstring, "secret")
+			So(pdf, ShouldNotContainSubstring, "Undefined control sequence")
+		})
+
+		Convey("The pdf format should keep the status of the error", func() {
+			repErr = &report.TeXLimitError{Limit: "TeX file size", Value: 2, Max: 1}
+			serve("&errorFormat=pdf")
+			So(rec.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(rec.Body.String(), ShouldContainSubstring, "size limits")
+		})
+	})
+}
+
+func TestErrorPDF(t *testing.T) {
+	Convey("When creating an error pdf", t, func() {
+		pdf := string(errorPDF([]string{"Title", `a (b) c\d`, "Grüße"}))
+
+		Convey("Special characters should be escaped", func() {
+			So(pdf, ShouldContainSubstring, `(a \(b\) c\\d) Tj`)
+			So(pdf, ShouldContainSubstring, `(Gr??e) Tj`)
+		})
+
+		Convey("The cross reference table should point at the objects", func() {
+			xref := pdf[strings.Index(pdf, "xref\n"):]
+			So(xref, ShouldContainSubstring, "0 6\n")
+			So(pdf[9:], ShouldStartWith, "1 0 obj")
+			So(xref, ShouldContainSubstring, "0000000009 00000 n \n")
+		})
+	})
+}
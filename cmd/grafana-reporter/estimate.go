@@ -0,0 +1,244 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+// maxEstimateEntries bounds the dashboards of one estimate request, as each is fetched from Grafana
+const maxEstimateEntries = 200
+
+// reportTimings holds the per panel generation times of recently generated reports, for estimating batches
+var reportTimings = newTimingStats(500)
+
+// timingStats is a rolling window of per panel generation times, in seconds
+type timingStats struct {
+	mu      sync.Mutex
+	samples []float64
+	next    int
+	size    int
+}
+
+func newTimingStats(size int) *timingStats {
+	return &timingStats{samples: make([]float64, 0, size), size: size}
+}
+
+// record adds the time per panel of a generated report, replacing the oldest sample once the window is full.
+// Reports without panels tell nothing about panel timing and are ignored.
+func (s *timingStats) record(stats report.Stats) {
+	if stats.Panels <= 0 {
+		return
+	}
+	perPanel := stats.Duration.Seconds() / float64(stats.Panels)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) < s.size {
+		s.samples = append(s.samples, perPanel)
+		return
+	}
+	s.samples[s.next] = perPanel
+	s.next = (s.next + 1) % s.size
+}
+
+// percentiles returns the number of samples and the nearest-rank percentiles p of the per panel times,
+// e.g. percentiles(50, 90). The percentiles are 0 without samples.
+func (s *timingStats) percentiles(p ...float64) (int, []float64) {
+	s.mu.Lock()
+	sorted := append([]float64(nil), s.samples...)
+	s.mu.Unlock()
+	sort.Float64s(sorted)
+
+	values := make([]float64, len(p))
+	if len(sorted) == 0 {
+		return 0, values
+	}
+	for i, pct := range p {
+		rank := int(math.Ceil(pct / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		values[i] = sorted[rank-1]
+	}
+	return len(sorted), values
+}
+
+// estimateEntry is a report of a batch to estimate. Times and variables are given like the report parameters.
+type estimateEntry struct {
+	Dashboard string     `json:"dashboard"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Template  string     `json:"template"`
+	Style     string     `json:"style"`
+	Variables url.Values `json:"variables"`
+}
+
+// estimateCost is the expected cost of one report or of the whole batch.
+// The expected durations are omitted while no reports have been generated to base them on.
+type estimateCost struct {
+	Panels          int      `json:"panels"`
+	RenderCalls     int      `json:"renderCalls"`
+	ExpectedSeconds *seconds `json:"expectedSeconds,omitempty"`
+}
+
+// seconds are expected durations: the median and the 90th percentile
+type seconds struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+}
+
+type estimateResult struct {
+	Dashboard  string           `json:"dashboard"`
+	Title      string           `json:"title,omitempty"`
+	Valid      bool             `json:"valid"`
+	Errors     []string         `json:"errors,omitempty"`
+	RenderURLs []panelRenderURL `json:"renderUrls,omitempty"`
+	estimateCost
+}
+
+// panelRenderURL is the url a report would render a panel's image from, for comparing the panel in Grafana with the report
+type panelRenderURL struct {
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type estimateResponse struct {
+	Entries []estimateResult `json:"entries"`
+	Total   estimateCost     `json:"total"`
+	Invalid int              `json:"invalid"`
+	History int              `json:"history"` //number of generated reports the expected durations are based on
+}
+
+// ServeEstimateHTTP estimates the cost of a batch of reports without rendering them. It fetches and validates
+// each entry's dashboard, time range and template, and expects the panels to take as long as those of recent reports.
+// With renderUrls=true, each entry lists the render urls of its panels. The dashboards are fetched one after another,
+// and no further ones once the client disconnected.
+func (h ServeReportHandler) ServeEstimateHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Print("Batch estimate called")
+	token, err := tokens.token(req)
+	if err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var body struct {
+		Entries []estimateEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		if bodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", *maxRequestBytes))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid estimate request: %v", err))
+		return
+	}
+	if len(body.Entries) == 0 || len(body.Entries) > maxEstimateEntries {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("an estimate needs between 1 and %d entries, got %d", maxEstimateEntries, len(body.Entries)))
+		return
+	}
+
+	renderURLs := req.URL.Query().Get("renderUrls") == "true"
+	history, p := reportTimings.percentiles(50, 90)
+	resp := estimateResponse{History: history}
+	for _, e := range body.Entries {
+		if err := req.Context().Err(); err != nil {
+			log.Println("Client disconnected, estimate aborted:", err)
+			return
+		}
+		result := h.estimate(req, token, e, renderURLs)
+		if history > 0 {
+			result.ExpectedSeconds = &seconds{float64(result.Panels) * p[0], float64(result.Panels) * p[1]}
+		}
+		if !result.Valid {
+			resp.Invalid++
+		}
+		resp.Total.Panels += result.Panels
+		resp.Total.RenderCalls += result.RenderCalls
+		resp.Entries = append(resp.Entries, result)
+	}
+	if history > 0 {
+		resp.Total.ExpectedSeconds = &seconds{float64(resp.Total.Panels) * p[0], float64(resp.Total.Panels) * p[1]}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// estimate fetches and validates the dashboard of an entry, collecting every problem that would fail its report
+func (h ServeReportHandler) estimate(req *http.Request, token string, e estimateEntry, renderURLs bool) estimateResult {
+	result := estimateResult{Dashboard: e.Dashboard}
+	if e.Dashboard == "" {
+		result.Errors = append(result.Errors, "no dashboard given")
+		return result
+	}
+
+	t := grafana.NewTimeRange(e.From, e.To)
+	if _, _, err := t.Resolve(stdtime.Now(), stdtime.UTC); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("invalid time range: %v", err))
+	}
+
+	opts := report.Options{Style: e.Style}
+	tex := ""
+	if e.Template != "" {
+		opts.TemplateDir = *templateDir
+		var err error
+		if tex, err = readTemplate(e.Template); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("template %v can't be read: %v", e.Template, err))
+		}
+	}
+	if tex != "" {
+		if err := report.ValidateTemplate(tex, opts); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("invalid template %v: %v", e.Template, err))
+		}
+	}
+
+	vars, panelVars := grafana.SplitPanelVariables(e.Variables)
+	g := h.newGrafanaClient(*proto+*ip, token, vars, grafana.WithContext(req.Context()), grafana.WithHeaders(forwardedHeaders(req)), grafana.WithPanelVariables(panelVars), skipPanelTypesOption(req))
+	dash, err := g.GetDashboard(e.Dashboard)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("error fetching dashboard: %v", err))
+		return result
+	}
+	result.Title = dash.PlainTitle
+	result.Panels = len(dash.Panels)
+	result.RenderCalls = len(dash.Panels)
+	result.Valid = len(result.Errors) == 0
+	if renderURLs {
+		//the time range the report would use, see report.Generate
+		t.TZ = grafana.ResolveTimezone(*forceTimezone, dash.Timezone)
+		if !*ignoreNowDelay {
+			t, _ = t.ApplyNowDelay(dash.Timepicker.NowDelay)
+		}
+		for _, p := range dash.Panels {
+			if u := grafana.PanelURL(g, p, e.Dashboard, t); u != "" {
+				result.RenderURLs = append(result.RenderURLs, panelRenderURL{p.Id, p.PlainTitle, u})
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,199 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTimingStats(t *testing.T) {
+	Convey("When recording report timings", t, func() {
+		s := newTimingStats(4)
+
+		Convey("Percentiles should be 0 without history", func() {
+			n, p := s.percentiles(50, 90)
+			So(n, ShouldEqual, 0)
+			So(p, ShouldResemble, []float64{0, 0})
+		})
+
+		Convey("The per panel time should be kept and reports without panels ignored", func() {
+			s.record(report.Stats{Panels: 4, Duration: 8 * stdtime.Second})
+			s.record(report.Stats{Panels: 0, Duration: stdtime.Minute})
+			n, p := s.percentiles(50)
+			So(n, ShouldEqual, 1)
+			So(p[0], ShouldEqual, 2)
+		})
+
+		Convey("The oldest samples should be replaced once the window is full", func() {
+			for i := 1; i <= 6; i++ {
+				s.record(report.Stats{Panels: 1, Duration: stdtime.Duration(i) * stdtime.Second})
+			}
+			n, p := s.percentiles(0, 50, 90, 100)
+			So(n, ShouldEqual, 4)
+			So(p, ShouldResemble, []float64{3, 4, 6, 6})
+		})
+	})
+}
+
+func TestServeEstimate(t *testing.T) {
+	Convey("When estimating a batch of reports", t, func() {
+		fetches := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/big"):
+				w.Write([]byte(`{"dashboard":{"title":"Big","panels":[{"type":"graph","id":1},{"type":"graph","id":2},{"type":"singlestat","id":3},{"type":"dashlist","id":4}]}}`))
+			case strings.HasSuffix(r.URL.Path, "/small"):
+				w.Write([]byte(`{"dashboard":{"title":"Small","panels":[{"type":"graph","id":1}]}}`))
+			default:
+				http.Error(w, "not found", http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, nil, nil})
+
+		dir, err := ioutil.TempDir("", "templates")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(ioutil.WriteFile(filepath.Join(dir, "good.tex"), []byte(`[[.Title]]`), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "broken.tex"), []byte(`[[range .Panels]]`), 0644), ShouldBeNil)
+		defer func(d string) { *templateDir = d }(*templateDir)
+		*templateDir = dir
+
+		defer func(s *timingStats) { reportTimings = s }(reportTimings)
+		reportTimings = newTimingStats(10)
+
+		estimate := func(body string) (*httptest.ResponseRecorder, estimateResponse) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/report/batch/estimate", strings.NewReader(body)))
+			var resp estimateResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			return rec, resp
+		}
+		batch := `{"entries":[
+			{"dashboard":"big","from":"now-7d","template":"good","variables":{"var-host":["a/b"]}},
+			{"dashboard":"small","from":"yesterday"},
+			{"dashboard":"missing"},
+			{"dashboard":"small","template":"broken"},
+			{"dashboard":"small","template":"absent"}]}`
+
+		Convey("Each entry should be fetched and validated without rendering", func() {
+			rec, resp := estimate(batch)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(resp.Entries, ShouldHaveLength, 5)
+
+			So(resp.Entries[0].Valid, ShouldBeTrue)
+			So(resp.Entries[0].Title, ShouldEqual, "Big")
+			So(resp.Entries[0].Panels, ShouldEqual, 3)
+			So(resp.Entries[0].RenderCalls, ShouldEqual, 3)
+
+			So(resp.Entries[1].Valid, ShouldBeFalse)
+			So(resp.Entries[1].Errors[0], ShouldContainSubstring, "invalid time range")
+			So(resp.Entries[1].Panels, ShouldEqual, 1)
+
+			So(resp.Entries[2].Valid, ShouldBeFalse)
+			So(resp.Entries[2].Errors[0], ShouldContainSubstring, "error fetching dashboard")
+
+			So(resp.Entries[3].Errors[0], ShouldContainSubstring, "invalid template broken")
+			So(resp.Entries[4].Errors[0], ShouldContainSubstring, "template absent can't be read")
+
+			So(resp.Total.Panels, ShouldEqual, 6)
+			So(resp.Invalid, ShouldEqual, 4)
+		})
+
+		Convey("Without history no durations should be expected", func() {
+			_, resp := estimate(batch)
+			So(resp.History, ShouldEqual, 0)
+			So(resp.Total.ExpectedSeconds, ShouldBeNil)
+			So(resp.Entries[0].ExpectedSeconds, ShouldBeNil)
+		})
+
+		Convey("Durations should be expected from the history of generated reports", func() {
+			reportTimings.record(report.Stats{Panels: 2, Duration: 2 * stdtime.Second})
+			reportTimings.record(report.Stats{Panels: 1, Duration: 3 * stdtime.Second})
+			_, resp := estimate(`{"entries":[{"dashboard":"big"},{"dashboard":"small"}]}`)
+			So(resp.History, ShouldEqual, 2)
+			So(*resp.Entries[0].ExpectedSeconds, ShouldResemble, seconds{3, 9})
+			So(*resp.Total.ExpectedSeconds, ShouldResemble, seconds{4, 12})
+		})
+
+		Convey("Render urls should be listed only on request", func() {
+			_, resp := estimate(batch)
+			So(resp.Entries[0].RenderURLs, ShouldBeEmpty)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/report/batch/estimate?renderUrls=true",
+				strings.NewReader(`{"entries":[{"dashboard":"big","from":"now-7d","to":"now","variables":{"var-host":["a/b"]}}]}`)))
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			urls := resp.Entries[0].RenderURLs
+			So(urls, ShouldHaveLength, 3)
+			So(urls[0].Id, ShouldEqual, 1)
+			So(urls[0].URL, ShouldStartWith, ts.URL+"/render/d-solo/big/_?")
+			So(urls[0].URL, ShouldContainSubstring, "var-host=a%2Fb")
+			So(urls[0].URL, ShouldContainSubstring, "from=now-7d")
+			So(urls[0].URL, ShouldNotContainSubstring, "reporterNonce")
+		})
+
+		Convey("Malformed and empty batches should be rejected", func() {
+			rec, _ := estimate(`{"entries":`)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			rec, _ = estimate(`{"entries":[]}`)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("A body read past the size limit should be rejected with 413", func() {
+			defer func(n int64) { *maxRequestBytes = n }(*maxRequestBytes)
+			*maxRequestBytes = 20
+			req := httptest.NewRequest("POST", "/api/report/batch/estimate", ioutil.NopCloser(strings.NewReader(batch)))
+			req.ContentLength = -1
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			So(rec.Body.String(), ShouldContainSubstring, "20 bytes")
+		})
+
+		Convey("No dashboards should be fetched once the client disconnected", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest("POST", "/api/report/batch/estimate", strings.NewReader(batch)).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			So(fetches, ShouldEqual, 0)
+			So(rec.Body.Len(), ShouldEqual, 0)
+		})
+	})
+}
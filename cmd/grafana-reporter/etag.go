@@ -0,0 +1,60 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/IzakMarais/reporter/delivery"
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+// reportETag returns a strong ETag for reports over an absolute time range, which only change when the
+// request, the dashboard, the template the report is made from or the reporter change. dash is the dashboard
+// fetched for the report. It returns "" for relative time ranges, if the dashboard couldn't be fetched and for
+// POST requests, whose body is not part of the ETag.
+func reportETag(req *http.Request, dash *grafana.Dashboard, template string, meta delivery.ReportMeta) string {
+	if !meta.Time.IsAbsolute() || req.Method == http.MethodPost || dash == nil {
+		return ""
+	}
+	canonical := fmt.Sprintf("%s?%s\n%+v\n%d\n%x\n%s", req.URL.EscapedPath(), canonicalQuery(req), meta.Time, dash.Version,
+		sha256.Sum256([]byte(template)), report.ReporterVersion)
+	return fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(canonical)))
+}
+
+// canonicalQuery encodes the request's query so that equivalent requests give the same string:
+// parameters are sorted by name and repeated identical values are dropped. Values are decoded once and
+// encoded again, so different spellings of a value match while values that only differ after decoding,
+// such as "%7Bid%7D" and "{id}", never collide.
+func canonicalQuery(req *http.Request) string {
+	return grafana.DedupeVariables(req.URL.Query()).Encode()
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
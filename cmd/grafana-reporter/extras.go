@@ -0,0 +1,74 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on the extra-<key> values a request may pass to the template
+const (
+	maxExtras           = 20
+	maxExtraValueLength = 500 //characters
+)
+
+// extraKeyRegExp matches keys usable as .Extras.key in templates
+var extraKeyRegExp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// extras collects the request's extra-<key> parameters and, for JSON POST requests, the body's extras map.
+// Query parameters take precedence. It returns nil when there are none.
+func extras(r *http.Request) (map[string]string, error) {
+	var extras map[string]string
+	if r.Method == http.MethodPost && r.Body != nil {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/json" {
+			var body struct {
+				Extras map[string]string `json:"extras"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return nil, fmt.Errorf("invalid request body: %w", err)
+			}
+			extras = body.Extras
+		}
+	}
+	for k, v := range r.URL.Query() {
+		if strings.HasPrefix(k, "extra-") {
+			if extras == nil {
+				extras = map[string]string{}
+			}
+			extras[strings.TrimPrefix(k, "extra-")] = v[0]
+		}
+	}
+	if len(extras) > maxExtras {
+		return nil, fmt.Errorf("too many extras: %d, at most %d are allowed", len(extras), maxExtras)
+	}
+	for k, v := range extras {
+		if !extraKeyRegExp.MatchString(k) {
+			return nil, fmt.Errorf("invalid extra key %q: keys start with a letter and have at most 64 letters, digits or underscores", k)
+		}
+		if n := utf8.RuneCountInString(v); n > maxExtraValueLength {
+			return nil, fmt.Errorf("extra %v has %d characters, at most %d are allowed", k, n, maxExtraValueLength)
+		}
+	}
+	return extras, nil
+}
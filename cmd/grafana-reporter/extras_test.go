@@ -0,0 +1,95 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExtras(t *testing.T) {
+	Convey("When the report server handler is called with extras", t, func() {
+		var repOptions report.Options
+		newReport := func(_ grafana.Client, _ string, _ grafana.TimeRange, _ string, opts report.Options) report.Report {
+			repOptions = opts
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{grafana.NewV4Client, newReport, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+		rec := httptest.NewRecorder()
+
+		Convey("extra- parameters should be passed to the report", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?extra-preparedFor=ACME+Corp&extra-reviewer=Jane", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repOptions.Extras, ShouldResemble, map[string]string{"preparedFor": "ACME Corp", "reviewer": "Jane"})
+		})
+
+		Convey("The extras of a JSON POST body should be merged, with query parameters taking precedence", func() {
+			body := `{"extras":{"preparedFor":"ACME Corp","reviewer":"Jane"}}`
+			req, _ := http.NewRequest("POST", "/api/v5/report/testDash?extra-reviewer=John", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repOptions.Extras, ShouldResemble, map[string]string{"preparedFor": "ACME Corp", "reviewer": "John"})
+		})
+
+		Convey("A malformed POST body should be rejected with 400", func() {
+			req, _ := http.NewRequest("POST", "/api/v5/report/testDash", strings.NewReader(`{"extras":`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Keys that cannot be used in templates should be rejected with 400", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?extra-prepared-for=ACME", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "prepared-for")
+		})
+
+		Convey("Too long values should be rejected with 400", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?extra-note="+strings.Repeat("x", maxExtraValueLength+1), nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Too many extras should be rejected with 400", func() {
+			var params []string
+			for i := 0; i <= maxExtras; i++ {
+				params = append(params, fmt.Sprintf("extra-key%d=v", i))
+			}
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?"+strings.Join(params, "&"), nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("The playlist endpoint should reject invalid extras too", func() {
+			req, _ := http.NewRequest("GET", "/api/report/playlist/7?extra-1=x", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
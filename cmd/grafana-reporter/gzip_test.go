@@ -0,0 +1,115 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const fakePdf = "%PDF-1.5 not really a pdf"
+
+type pdfReport struct{}
+
+func (p pdfReport) Generate() (pdf io.ReadCloser, err error) {
+	return ioutil.NopCloser(bytes.NewBufferString(fakePdf)), nil
+}
+
+func (p pdfReport) Clean() {}
+
+func TestGzipMiddleware(t *testing.T) {
+	Convey("When responses pass through the gzip middleware", t, func() {
+		jsonBody := `{"panels":[1,2,3]}`
+		handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/json":
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, jsonBody)
+			case "/pdf":
+				io.WriteString(w, fakePdf)
+			}
+		}))
+		rec := httptest.NewRecorder()
+
+		Convey("JSON responses should be compressed when the client accepts gzip", func() {
+			req, _ := http.NewRequest("GET", "/json", nil)
+			req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+			handler.ServeHTTP(rec, req)
+
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(rec.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+			gz, err := gzip.NewReader(rec.Body)
+			So(err, ShouldBeNil)
+			body, err := ioutil.ReadAll(gz)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, jsonBody)
+		})
+
+		Convey("JSON responses should not be compressed when the client does not accept gzip", func() {
+			req, _ := http.NewRequest("GET", "/json", nil)
+			handler.ServeHTTP(rec, req)
+
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "")
+			So(rec.Body.String(), ShouldEqual, jsonBody)
+		})
+
+		Convey("JSON responses should not be compressed when the client refuses gzip", func() {
+			req, _ := http.NewRequest("GET", "/json", nil)
+			req.Header.Set("Accept-Encoding", "gzip;q=0")
+			handler.ServeHTTP(rec, req)
+
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "")
+		})
+
+		Convey("PDF responses should never be compressed", func() {
+			req, _ := http.NewRequest("GET", "/pdf", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			handler.ServeHTTP(rec, req)
+
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "")
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/pdf")
+			So(rec.Body.String(), ShouldEqual, fakePdf)
+		})
+	})
+
+	Convey("When a report is requested with gzip accepted", t, func() {
+		newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+			return pdfReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{grafana.NewV4Client, newReport, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		router.ServeHTTP(rec, req)
+
+		Convey("The PDF should be sent uncompressed", func() {
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "")
+			So(rec.Body.String(), ShouldEqual, fakePdf)
+		})
+	})
+}
@@ -17,53 +17,418 @@
 package main
 
 import (
-	"io"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
+	stdtime "time"
 
+	"github.com/IzakMarais/reporter/delivery"
+	"github.com/IzakMarais/reporter/format"
 	"github.com/IzakMarais/reporter/grafana"
 	"github.com/IzakMarais/reporter/report"
 	"github.com/gorilla/mux"
 )
 
+// retention is parsed from the retention flag at startup
+var retention grafana.Retention
+
 // ServeReportHandler interface facilitates testsing the reportServing http handler
 type ServeReportHandler struct {
-	newGrafanaClient func(url string, apiToken string, variables url.Values) grafana.Client
-	newReport        func(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string) report.Report
+	newGrafanaClient func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client
+	newReport        func(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string, opts report.Options) report.Report
+	newMultiReport   func(g grafana.Client, title string, dashNames []string, missing []string, time grafana.TimeRange, texTemplate string, opts report.Options) report.Report
 }
 
 // RegisterHandlers registers all http.Handler's with their associated routes to the router
 // Two different serve report handlers are used to provide support for both Grafana v4 (and older) and v5 APIs
 func RegisterHandlers(router *mux.Router, reportServerV4, reportServerV5 ServeReportHandler) {
+	router.Use(limitsMiddleware)
+	router.Use(gzipMiddleware)
+	router.HandleFunc("/api/report/batch/estimate", reportServerV5.ServeEstimateHTTP).Methods("POST")
 	router.Handle("/api/report/{dashId}", reportServerV4)
 	router.Handle("/api/v5/report/{dashId}", reportServerV5)
+	router.Handle("/api/report/playlist/{playlistId}", http.HandlerFunc(reportServerV5.ServePlaylistHTTP))
+	router.Handle("/api/report/public/{accessToken}", http.HandlerFunc(reportServerV5.ServePublicHTTP))
+	router.Handle("/api/report/{dashId}/source", http.HandlerFunc(reportServerV5.ServeSourceHTTP))
+	router.HandleFunc("/api/styles", serveStyles).Methods("GET")
+	router.HandleFunc("/api/ready", serveReady).Methods("GET")
+	router.HandleFunc("/healthz", serveHealthz).Methods("GET")
+	router.HandleFunc("/readyz", reportServerV5.serveReadyz).Methods("GET")
+	router.HandleFunc("/api/probe", reportServerV5.ServeProbeHTTP).Methods("GET")
+}
+
+// serveStyles lists the built-in report styles that can be selected with the style parameter
+func serveStyles(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Default string   `json:"default"`
+		Styles  []string `json:"styles"`
+	}{report.DefaultStyle, report.Styles()})
 }
 
 func (h ServeReportHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	log.Print("Reporter called")
-	g := h.newGrafanaClient(*proto+*ip, apiToken(req), dashVariables(req))
-	rep := h.newReport(g, dashID(req), time(req), texTemplate(req))
+	token, err := tokens.token(req)
+	if err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	req, cancel, err := withReportTimeout(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+	vars, panelVars := grafana.SplitPanelVariables(dashVariables(req))
+	g := h.newGrafanaClient(*proto+*ip, token, vars, reportClientOptions(req, panelVars)...)
+	//fetched once for its report defaults, the ETag and the report. When it can't be, generating the report reports the error
+	var dash *grafana.Dashboard
+	if d, err := g.GetDashboard(dashID(req)); err == nil {
+		dash = &d
+		applyDashboardDefaults(req, d)
+	} else {
+		log.Println("Error fetching dashboard, the report fetches it again:", err)
+	}
+	//read after the dashboard defaults, which may select the style
+	tex, params, added, err := declaredTemplate(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if added {
+		vars, panelVars = grafana.SplitPanelVariables(dashVariables(req))
+	}
+	clientOpts := reportClientOptions(req, panelVars)
+	g = h.newGrafanaClient(*proto+*ip, token, vars, clientOpts...)
+	opts, err := reportOptions(w, req)
+	if err != nil {
+		writeOptionsError(w, err)
+		return
+	}
+	if missing := missingTemplateParams(req, params, &opts); len(missing) > 0 {
+		writeMissingParams(w, missing)
+		return
+	}
+	if !added {
+		//the template's defaults may change the variables the dashboard is fetched with, so it is fetched again then
+		opts.Dashboard = dash
+	}
+	meta := delivery.ReportMeta{Dashboard: dashID(req), Time: time(req)}
+	if etag := reportETag(req, dash, report.ResolveTemplate(tex, opts.Style), meta); etag != "" {
+		w.Header().Set("ETag", etag)
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			log.Println("Report not modified")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	var rep report.Report
+	if *autoDegrade {
+		rep = &degradingReport{newReport: func(level *degradeLevel, opts report.Options) report.Report {
+			if level == nil {
+				return h.newReport(g, meta.Dashboard, meta.Time, tex, opts)
+			}
+			levelOpts := append(append([]grafana.ClientOption{}, clientOpts...), level.clientOpts...)
+			return h.newReport(h.newGrafanaClient(*proto+*ip, token, vars, levelOpts...), meta.Dashboard, meta.Time, tex, opts)
+		}, opts: opts}
+	} else {
+		rep = h.newReport(g, meta.Dashboard, meta.Time, tex, opts)
+	}
+	label := meta.Dashboard
+	if dash == nil {
+		label = unknownDashboard
+	}
+	meta, ok := serveReport(w, req, rep, meta, label)
+	if ok && req.URL.Query().Get("annotate") == "true" {
+		annotateReport(g, req, meta)
+	}
+}
+
+// reportClientOptions returns the options of the Grafana client a dashboard's report is generated with
+func reportClientOptions(req *http.Request, panelVars map[int]url.Values) []grafana.ClientOption {
+	return []grafana.ClientOption{grafana.WithContext(req.Context()), grafana.WithHeaders(forwardedHeaders(req)), grafana.WithPanelVariables(panelVars), grafana.WithImageFormat(imageFormat(req)), grafana.WithCollapsedRows(req.URL.Query().Get("includeCollapsed") == "true"), grafana.WithDatasourceNames(req.URL.Query().Get("showDatasource") == "true"), grafana.WithDashboardVersion(dashboardVersion(req)), skipPanelTypesOption(req)}
+}
+
+// sourceKey marks requests for the TeX source of a report in their context
+type sourceKey struct{}
+
+// ServeSourceHTTP serves the TeX source of a dashboard's report with its images as a tar.gz, instead of the pdf.
+// It takes the same parameters as the report and compiles with a plain pdflatex report.tex, e.g. for archiving.
+func (h ServeReportHandler) ServeSourceHTTP(w http.ResponseWriter, req *http.Request) {
+	h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), sourceKey{}, true)))
+}
+
+// isSourceRequest reports whether the request is for the report's source rather than the pdf
+func isSourceRequest(req *http.Request) bool {
+	source, _ := req.Context().Value(sourceKey{}).(bool)
+	return source
+}
+
+// newPublicClient creates clients for public dashboards, replaced in tests
+var newPublicClient = grafana.NewPublicClient
+
+// ServePublicHTTP serves the report of a Grafana public dashboard, identified by its access token.
+// Public dashboards need no api token, and their template variables cannot be changed.
+func (h ServeReportHandler) ServePublicHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Print("Public dashboard reporter called")
+	token := mux.Vars(req)["accessToken"]
+	req, cancel, err := withReportTimeout(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+	g := newPublicClient(*proto+*ip, token, grafana.WithContext(req.Context()))
+	tex, params, _, err := declaredTemplate(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts, err := reportOptions(w, req)
+	if err != nil {
+		writeOptionsError(w, err)
+		return
+	}
+	if missing := missingTemplateParams(req, params, &opts); len(missing) > 0 {
+		writeMissingParams(w, missing)
+		return
+	}
+	meta := delivery.ReportMeta{Dashboard: "public", Time: time(req)}
+	rep := h.newReport(g, meta.Dashboard, meta.Time, tex, opts)
+	serveReport(w, req, rep, meta, meta.Dashboard)
+}
 
+// ServePlaylistHTTP serves a single report combining the dashboards of a Grafana playlist, in playlist order
+func (h ServeReportHandler) ServePlaylistHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Print("Playlist reporter called")
+	token, err := tokens.token(req)
+	if err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	req, cancel, err := withReportTimeout(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+	if unsupported := unsupportedPlaylistParams(req.URL.Query()); len(unsupported) > 0 {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("%v not supported for playlist reports, the multi-dashboard template has no use for them", strings.Join(unsupported, ", ")))
+		return
+	}
+	tex, params, err := declaredPlaylistTemplate(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	vars, panelVars := grafana.SplitPanelVariables(dashVariables(req))
+	g := h.newGrafanaClient(*proto+*ip, token, vars, grafana.WithContext(req.Context()), grafana.WithHeaders(forwardedHeaders(req)), grafana.WithPanelVariables(panelVars), grafana.WithImageFormat(imageFormat(req)), grafana.WithCollapsedRows(req.URL.Query().Get("includeCollapsed") == "true"), grafana.WithDatasourceNames(req.URL.Query().Get("showDatasource") == "true"), skipPanelTypesOption(req))
+	playlistID := mux.Vars(req)["playlistId"]
+	log.Println("Called with playlist:", playlistID)
+	opts, err := reportOptions(w, req)
+	if err != nil {
+		writeOptionsError(w, err)
+		return
+	}
+	if opts.Summary != nil {
+		writeJSONError(w, http.StatusBadRequest, "summary is not supported for playlist reports, its panel ids would be ambiguous")
+		return
+	}
+	if missing := missingTemplateParams(req, params, &opts); len(missing) > 0 {
+		writeMissingParams(w, missing)
+		return
+	}
+
+	pl, err := g.GetPlaylist(playlistID)
+	if err != nil {
+		log.Println("Error fetching playlist:", err)
+		writeReportError(w, req, err)
+		return
+	}
+	dashNames, missing, err := grafana.ResolvePlaylist(g, pl)
+	if err != nil {
+		log.Println("Error resolving playlist:", err)
+		writeReportError(w, req, err)
+		return
+	}
+	log.Printf("Playlist %v resolved to dashboards %v, missing: %v", pl.Name, dashNames, missing)
+
+	meta := delivery.ReportMeta{Dashboard: pl.Name, Time: time(req)}
+	rep := h.newMultiReport(g, pl.Name, dashNames, missing, meta.Time, tex, opts)
+	serveReport(w, req, rep, meta, meta.Dashboard)
+}
+
+// unsupportedPlaylistParams returns the layout parameters of single dashboard reports that query sets,
+// which playlist reports would ignore
+func unsupportedPlaylistParams(query url.Values) []string {
+	var unsupported []string
+	if query.Get("style") != "" {
+		unsupported = append(unsupported, "style")
+	}
+	if panelsPerPage(query.Get("panelsPerPage")) > 0 {
+		unsupported = append(unsupported, "panelsPerPage")
+	}
+	for _, key := range []string{"contactSheet", "glossary", "noteSkippedPanels"} {
+		if query.Get(key) == "true" {
+			unsupported = append(unsupported, key)
+		}
+	}
+	return unsupported
+}
+
+// serveReport generates the report and delivers it as the response. label is the report's dashboard label in the metrics.
+// It returns the meta data of the delivered report and whether the delivery succeeded.
+func serveReport(w http.ResponseWriter, req *http.Request, rep report.Report, meta delivery.ReportMeta, label string) (_ delivery.ReportMeta, ok bool) {
+	finished := metrics.reportStarted(label)
+	defer func() { finished(ok) }()
 	file, err := rep.Generate()
+	if err != nil && reportTimedOut(req) {
+		rep.Clean()
+		writeTimeoutError(w, req, err)
+		return meta, false
+	}
+	if err != nil && req.Context().Err() != nil {
+		log.Println("Client disconnected, report generation aborted:", err)
+		rep.Clean()
+		return meta, false
+	}
 	if err != nil {
 		log.Println("Error generating report:", err)
+		writeReportError(w, req, err)
+		return meta, false
+	}
+	meta.GeneratedAt = stdtime.Now()
+	sum, hasSum := archiveChecksum(file)
+	if req.URL.Query().Get("sign") == "true" {
+		if err := setSignatureHeaders(w.Header(), file); err != nil {
+			log.Println("Error signing report:", err)
+			file.Close()
+			rep.Clean()
+			http.Error(w, err.Error(), 500)
+			return meta, false
+		}
+	}
+
+	// the spool closes the file and cleans the report once it was delivered
+	spool, err := delivery.NewSpool(file, *spoolMemoryLimit, rep.Clean)
+	if err != nil {
+		log.Println("Error spooling report:", err)
 		http.Error(w, err.Error(), 500)
-		return
+		return meta, false
+	}
+	if !spool.InMemory() {
+		//waits no longer than the report may take, see withReportTimeout
+		if err := acquireLargeDownload(req.Context()); err != nil {
+			spool.Release()
+			if deliveryContext(req).Err() != nil {
+				log.Println("Client disconnected while waiting to download the report:", err)
+				return meta, false
+			}
+			w.Header().Del("ETag")
+			w.Header().Set("Retry-After", "60")
+			writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("timed out waiting for one of the %d large report downloads to finish", *maxLargeDownloads))
+			return meta, false
+		}
+		defer releaseLargeDownload()
+	}
+
+	w.Header().Set("Last-Modified", meta.GeneratedAt.UTC().Format(http.TimeFormat))
+	if hasSum {
+		w.Header().Set("X-Archive-Sha256", sum)
+	}
+	if isSourceRequest(req) {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-source.tar.gz"`, strings.TrimSuffix(delivery.FileSink{}.FileName(meta), ".pdf")))
+	}
+	if r, ok := rep.(report.StatsReporter); ok {
+		setStatsHeaders(w.Header(), r.Stats())
+		reportTimings.record(r.Stats())
+	}
+	if d, ok := rep.(*degradingReport); ok && d.Degraded() != "" {
+		//the ETag was computed for the full quality report
+		w.Header().Del("ETag")
+		w.Header().Set("X-Report-Degraded", d.Degraded())
 	}
-	defer rep.Clean()
-	defer file.Close()
 
-	_, err = io.Copy(w, file)
+	out, done := newIdleTimeoutWriter(w, *writeTimeout)
+	defer done()
+	err = spool.Deliver(deliveryContext(req), meta, delivery.WriterSink{W: out})
 	if err != nil {
 		log.Println("Error copying data to response:", err)
 		http.Error(w, err.Error(), 500)
-		return
+		return meta, false
 	}
 	log.Println("Report generated correctly")
+	return meta, true
+}
+
+// setStatsHeaders describes the generated report, e.g. for showing "14 pages, 32 panels, 2.1 MB" before the download
+func setStatsHeaders(h http.Header, stats report.Stats) {
+	if stats.Pages > 0 {
+		h.Set("X-Report-Pages", strconv.Itoa(stats.Pages))
+	}
+	h.Set("X-Report-Panels", strconv.Itoa(stats.Panels))
+	h.Set("X-Report-Bytes", strconv.FormatInt(stats.Bytes, 10))
+	h.Set("X-Report-Duration", strconv.FormatFloat(stats.Duration.Seconds(), 'f', 3, 64))
+	h.Set("X-Report-Build-Bytes", strconv.FormatInt(stats.BuildBytes, 10))
+}
+
+// annotateReport marks the delivered report on the dashboard's timeline. The report has already been
+// delivered, so failures are only logged.
+func annotateReport(g grafana.Client, req *http.Request, meta delivery.ReportMeta) {
+	link := *req.URL
+	query := link.Query()
+	query.Del("apitoken")
+	link.RawQuery = query.Encode()
+	text := fmt.Sprintf("Report %s published: %s", delivery.FileSink{}.FileName(meta), link.RequestURI())
+	a, err := grafana.NewReportAnnotation(meta.Dashboard, meta.Time, meta.GeneratedAt, text)
+	if err == nil {
+		err = g.CreateAnnotation(a)
+	}
+	if err != nil {
+		log.Printf("Error annotating dashboard %v with the report: %v", meta.Dashboard, err)
+		return
+	}
+	log.Println("Annotated dashboard", meta.Dashboard, "with the report")
+}
+
+// generateErrorStatus maps report generation errors to an HTTP status code
+func generateErrorStatus(err error) int {
+	if errors.Is(err, grafana.ErrCircuitOpen) {
+		return http.StatusBadGateway
+	}
+	if errors.Is(err, grafana.ErrLoginRedirect) {
+		return http.StatusUnauthorized
+	}
+	var limitErr *report.TeXLimitError
+	if errors.As(err, &limitErr) {
+		return http.StatusUnprocessableEntity
+	}
+	var versionErr *report.VersionChangedError
+	if errors.As(err, &versionErr) {
+		return http.StatusConflict
+	}
+	var latexErr *report.LaTeXError
+	if errors.As(err, &latexErr) {
+		switch latexErr.Reason {
+		case report.LaTeXNotInstalled:
+			return http.StatusServiceUnavailable
+		case report.LaTeXKilled:
+			return http.StatusGatewayTimeout
+		case report.LaTeXNoPDF:
+			return http.StatusInsufficientStorage
+		}
+	}
+	return http.StatusInternalServerError
 }
 
 func dashID(r *http.Request) string {
@@ -76,16 +441,70 @@ func dashID(r *http.Request) string {
 func time(r *http.Request) grafana.TimeRange {
 	params := r.URL.Query()
 	t := grafana.NewTimeRange(params.Get("from"), params.Get("to"))
+	t.TZ = params.Get("tz")
+	if t.TZ == "" || t.TZ == "browser" {
+		t.TZ = *forceTimezone
+	}
 	log.Println("Called with time range:", t)
 	return t
 }
 
+// apiToken returns the request's apitoken parameter, falling back to the token configured with -api-token
 func apiToken(r *http.Request) string {
 	apiToken := r.URL.Query().Get("apitoken")
+	if apiToken == "" && *serverAPIToken != "" {
+		log.Println("Called without api Token, using the configured one")
+		return *serverAPIToken
+	}
 	log.Println("Called with api Token:", apiToken)
 	return apiToken
 }
 
+// dashboardVersion returns the dashboard version pinned with the dashboardVersion parameter, 0 for the current version
+func dashboardVersion(r *http.Request) int {
+	v := r.URL.Query().Get("dashboardVersion")
+	if v == "" {
+		return 0
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		log.Printf("Ignoring invalid dashboard version %q", v)
+		return 0
+	}
+	log.Println("Called with dashboard version:", version)
+	return version
+}
+
+// imageFormat returns the panel image format requested with the imageFormat parameter, PNG by default
+func imageFormat(r *http.Request) string {
+	switch f := strings.ToLower(r.URL.Query().Get("imageFormat")); f {
+	case "", grafana.ImagePNG:
+		return grafana.ImagePNG
+	case grafana.ImageJPEG, "jpg":
+		return grafana.ImageJPEG
+	default:
+		log.Printf("Ignoring unknown image format %q, using png", f)
+		return grafana.ImagePNG
+	}
+}
+
+// forwardedHeaders returns the incoming request headers named by the forward-auth-headers flag.
+// They are credentials, so only their names are logged.
+func forwardedHeaders(r *http.Request) http.Header {
+	output := http.Header{}
+	for _, name := range strings.Split(*forwardAuthHeaders, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if v, ok := r.Header[http.CanonicalHeaderKey(name)]; ok {
+			log.Println("Forwarding header:", http.CanonicalHeaderKey(name))
+			output[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	return output
+}
+
 func dashVariables(r *http.Request) url.Values {
 	output := url.Values{}
 	for k, v := range r.URL.Query() {
@@ -99,22 +518,177 @@ func dashVariables(r *http.Request) url.Values {
 	if len(output) == 0 {
 		log.Println("Called without variable")
 	}
-	return output
+	return grafana.DedupeVariables(output)
+}
+
+// reportOptions reads the report options from the request. It fails for invalid extras.
+func reportOptions(w http.ResponseWriter, r *http.Request) (report.Options, error) {
+	params := r.URL.Query()
+	extraValues, err := extras(r)
+	if err != nil {
+		return report.Options{}, err
+	}
+	opts := report.Options{
+		Trim:              params.Get("trim") == "true",
+		NoDataBadge:       params.Get("noDataBadge") == "true",
+		Summary:           panelIDs(params.Get("summary")),
+		Style:             params.Get("style"),
+		MaxPagesPerVolume: *maxPagesPerVolume,
+		CheckVersion:      *checkDashboardVersion,
+		StrictVersion:     *strictDashboardVersion,
+		IgnoreNowDelay:    *ignoreNowDelay,
+		PanelsPerPage:     panelsPerPage(params.Get("panelsPerPage")),
+		Workers:           workers(w, params.Get("workers")),
+		Extras:            extraValues,
+		Glossary:          params.Get("glossary") == "true",
+		Source:            isSourceRequest(r),
+		NoteSkippedPanels: params.Get("noteSkippedPanels") == "true",
+		ContactSheet:      params.Get("contactSheet") == "true",
+		Sign:              params.Get("sign") == "true",
+	}
+	if tz := params.Get("tz"); !grafana.ValidTimezone(tz) {
+		return report.Options{}, fmt.Errorf("unknown time zone %q", tz)
+	}
+	if opts.Sign && report.SigningKey == nil {
+		return report.Options{}, errors.New("sign=true needs the reporter to be started with a -signing-key")
+	}
+	if s := params.Get("contactSheetColumns"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			opts.ContactSheetColumns = n
+		} else {
+			log.Printf("Ignoring invalid contactSheetColumns %q", s)
+		}
+	}
+	if len(retention) > 0 {
+		opts.Retention = retention
+	}
+	if params.Get("template") != "" {
+		//templates are only read from the admin-provisioned templates directory, which makes them trusted
+		opts.TemplateDir = *templateDir
+	}
+	if tag := params.Get("locale"); tag != "" {
+		opts.Locale, err = format.ParseLocale(tag)
+		if err != nil {
+			return report.Options{}, err
+		}
+	}
+	if report.TraceFooter {
+		opts.RequestedBy = requestingHost(r)
+	}
+	log.Printf("Called with report options: %+v", opts)
+	//set after logging the options, a context prints as its whole chain
+	opts.Context = r.Context()
+	return opts, nil
+}
+
+// skipPanelTypesOption returns the panel types to leave out of the report: those of the skipPanelTypes parameter,
+// comma separated and possibly empty to keep all panels, else those of the -skip-panel-types flag
+func skipPanelTypesOption(req *http.Request) grafana.ClientOption {
+	types, ok := req.URL.Query()["skipPanelTypes"]
+	if !ok {
+		return grafana.WithSkipPanelTypes(grafana.SkipPanelTypes)
+	}
+	return grafana.WithSkipPanelTypes(splitList(strings.Join(types, ",")))
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// requestingHost returns the address of the client that requested the report, without its port
+func requestingHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
-func texTemplate(r *http.Request) string {
+// panelsPerPage parses the panelsPerPage parameter, treating invalid values like an absent one
+func panelsPerPage(s string) int {
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Printf("Ignoring invalid panelsPerPage %q", s)
+		return 0
+	}
+	return n
+}
+
+// workers parses the workers parameter. Values above the -max-workers flag are clamped, adding a Warning header to the response.
+// Invalid values are treated like an absent one, using the server default.
+func workers(w http.ResponseWriter, s string) int {
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		log.Printf("Ignoring invalid workers %q", s)
+		return 0
+	}
+	if n > *maxWorkers {
+		msg := fmt.Sprintf("workers=%d exceeds the limit, using %d", n, *maxWorkers)
+		log.Println(msg)
+		w.Header().Add("Warning", fmt.Sprintf("199 grafana-reporter %q", msg))
+		return *maxWorkers
+	}
+	return n
+}
+
+// panelIDs parses a comma separated list of panel ids, skipping invalid ids
+func panelIDs(list string) []int {
+	var ids []int
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			log.Printf("Ignoring invalid panel id %q", s)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// texTemplate returns the content of the template selected with the template parameter, "" for the built-in styles.
+// Templates that can't be read are logged and replaced by the built-in style; it fails for templates that are not UTF-8.
+func texTemplate(r *http.Request) (string, error) {
 	fName := r.URL.Query().Get("template")
 	if fName == "" {
-		return ""
+		return "", nil
 	}
-	file := filepath.Join(*templateDir, fName+".tex")
+	customTemplate, err := readTemplate(fName)
+	var encodingErr *report.EncodingError
+	if errors.As(err, &encodingErr) {
+		return "", fmt.Errorf("template %v: %v", fName, err)
+	}
+	if err != nil {
+		log.Printf("Error reading template file: %q", err)
+		return "", nil
+	}
+	return customTemplate, nil
+}
+
+// readTemplate returns the content of the named template from the -templates directory, normalized by report.NormalizeTemplate
+func readTemplate(name string) (string, error) {
+	file := filepath.Join(*templateDir, name+".tex")
 	log.Println("Called with template:", file)
 
 	customTemplate, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Printf("Error reading template file: %q", err)
-		return ""
+		return "", err
 	}
-
-	return string(customTemplate)
+	return report.NormalizeTemplate(customTemplate)
 }
@@ -18,13 +18,21 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	stdtime "time"
 
+	"github.com/IzakMarais/reporter/format"
 	"github.com/IzakMarais/reporter/grafana"
 	"github.com/IzakMarais/reporter/report"
 	"github.com/gorilla/mux"
@@ -40,25 +48,49 @@ func (m mockReport) Generate() (pdf io.ReadCloser, err error) {
 
 func (m mockReport) Clean() {}
 
+// statsReport is a mockReport that summarizes itself
+type statsReport struct {
+	mockReport
+	stats report.Stats
+}
+
+func (s statsReport) Stats() report.Stats {
+	return s.stats
+}
+
+type errReport struct {
+	err error
+}
+
+func (e errReport) Generate() (pdf io.ReadCloser, err error) {
+	return nil, e.err
+}
+
+func (e errReport) Clean() {}
+
 func TestV4ServeReportHandler(t *testing.T) {
 	Convey("When the v4 report server handler is called", t, func() {
 		//mock new grafana client function to capture and validate its input parameters
 		var clAPIToken string
 		var clVars url.Values
-		newGrafanaClient := func(url string, apiToken string, variables url.Values) grafana.Client {
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
 			clAPIToken = apiToken
 			clVars = variables
-			return grafana.NewV4Client(url, apiToken, variables)
+			return grafana.NewV4Client(url, apiToken, variables, opts...)
 		}
 		//mock new report function to capture and validate its input parameters
 		var repDashName string
-		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string) report.Report {
+		var repOptions report.Options
+		var repTime grafana.TimeRange
+		newReport := func(g grafana.Client, dashName string, time grafana.TimeRange, _ string, opts report.Options) report.Report {
 			repDashName = dashName
+			repTime = time
+			repOptions = opts
 			return &mockReport{}
 		}
 
 		router := mux.NewRouter()
-		RegisterHandlers(router, ServeReportHandler{newGrafanaClient, newReport}, ServeReportHandler{nil, nil})
+		RegisterHandlers(router, ServeReportHandler{newGrafanaClient, newReport, nil}, ServeReportHandler{nil, nil, nil})
 		rec := httptest.NewRecorder()
 
 		Convey("It should extract dashboard ID from the URL and forward it to the new reporter ", func() {
@@ -67,6 +99,57 @@ func TestV4ServeReportHandler(t *testing.T) {
 			So(repDashName, ShouldEqual, "testDash")
 		})
 
+		Convey("It should extract the time zone from the URL and forward it to the new reporter ", func() {
+			*forceTimezone = "Europe/Berlin"
+			defer func() { *forceTimezone = "" }()
+			req, _ := http.NewRequest("GET", "/api/report/testDash?tz=Asia/Tokyo", nil)
+			router.ServeHTTP(rec, req)
+			So(repTime.TZ, ShouldEqual, "Asia/Tokyo")
+
+			Convey("The forced time zone should be used when the request has none ", func() {
+				req, _ := http.NewRequest("GET", "/api/report/testDash", nil)
+				router.ServeHTTP(rec, req)
+				So(repTime.TZ, ShouldEqual, "Europe/Berlin")
+			})
+
+			Convey("The forced time zone should be used for the browser's time zone ", func() {
+				req, _ := http.NewRequest("GET", "/api/report/testDash?tz=browser", nil)
+				router.ServeHTTP(rec, req)
+				So(repTime.TZ, ShouldEqual, "Europe/Berlin")
+			})
+
+			Convey("An unknown time zone should be rejected rather than skipped ", func() {
+				rec := httptest.NewRecorder()
+				repTime = grafana.TimeRange{}
+				req, _ := http.NewRequest("GET", "/api/report/testDash?tz=Not/AZone", nil)
+				router.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusBadRequest)
+				So(rec.Body.String(), ShouldContainSubstring, "Not/AZone")
+				So(repTime.TZ, ShouldEqual, "")
+			})
+		})
+
+		Convey("It should extract the report options from the URL and forward them to the new reporter ", func() {
+			req, _ := http.NewRequest("GET", "/api/report/testDash?trim=true&summary=4,x,12", nil)
+			router.ServeHTTP(rec, req)
+			So(repOptions.Trim, ShouldBeTrue)
+			So(repOptions.Summary, ShouldResemble, []int{4, 12})
+
+			Convey("An invalid panelsPerPage should be ignored ", func() {
+				req, _ := http.NewRequest("GET", "/api/report/testDash?panelsPerPage=-2", nil)
+				router.ServeHTTP(rec, req)
+				So(repOptions.PanelsPerPage, ShouldEqual, 0)
+			})
+
+			Convey("Options should default to off when not given ", func() {
+				req, _ := http.NewRequest("GET", "/api/report/testDash", nil)
+				router.ServeHTTP(rec, req)
+				So(repOptions.Context, ShouldNotBeNil)
+				repOptions.Context = nil
+				So(repOptions, ShouldResemble, report.Options{})
+			})
+		})
+
 		Convey("It should extract the apiToken from the URL and forward it to the new Grafana Client ", func() {
 			req, _ := http.NewRequest("GET", "/api/report/testDash?apitoken=1234", nil)
 			router.ServeHTTP(rec, req)
@@ -87,6 +170,14 @@ func TestV4ServeReportHandler(t *testing.T) {
 				expected.Add("var-test", "testValue") //apitoken not expected here
 				So(clVars, ShouldResemble, expected)
 			})
+
+			Convey("Variables should not contain panel specific variables ", func() {
+				req, _ := http.NewRequest("GET", "/api/report/testDash?var-test=testValue&var-test.4=panelValue", nil)
+				router.ServeHTTP(rec, req)
+				expected := url.Values{}
+				expected.Add("var-test", "testValue")
+				So(clVars, ShouldResemble, expected)
+			})
 		})
 	})
 }
@@ -96,20 +187,24 @@ func TestV5ServeReportHandler(t *testing.T) {
 		//mock new grafana client function to capture and validate its input parameters
 		var clAPIToken string
 		var clVars url.Values
-		newGrafanaClient := func(url string, apiToken string, variables url.Values) grafana.Client {
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
 			clAPIToken = apiToken
 			clVars = variables
-			return grafana.NewV4Client(url, apiToken, variables)
+			return grafana.NewV4Client(url, apiToken, variables, opts...)
 		}
 		//mock new report function to capture and validate its input parameters
 		var repDashName string
-		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string) report.Report {
+		var repOptions report.Options
+		var repTime grafana.TimeRange
+		newReport := func(g grafana.Client, dashName string, time grafana.TimeRange, _ string, opts report.Options) report.Report {
 			repDashName = dashName
+			repTime = time
+			repOptions = opts
 			return &mockReport{}
 		}
 
 		router := mux.NewRouter()
-		RegisterHandlers(router, ServeReportHandler{nil, nil}, ServeReportHandler{newGrafanaClient, newReport})
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
 		rec := httptest.NewRecorder()
 
 		Convey("It should extract dashboard ID from the URL and forward it to the new reporter ", func() {
@@ -118,6 +213,98 @@ func TestV5ServeReportHandler(t *testing.T) {
 			So(repDashName, ShouldEqual, "testDash")
 		})
 
+		Convey("The source endpoint should request the report's source as a tar.gz ", func() {
+			req, _ := http.NewRequest("GET", "/api/report/testDash/source?trim=true", nil)
+			router.ServeHTTP(rec, req)
+			So(repDashName, ShouldEqual, "testDash")
+			So(repOptions.Source, ShouldBeTrue)
+			So(repOptions.Trim, ShouldBeTrue)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/gzip")
+			So(rec.Header().Get("Content-Disposition"), ShouldStartWith, `attachment; filename="testDash-`)
+			So(rec.Header().Get("Content-Disposition"), ShouldEndWith, `-source.tar.gz"`)
+
+			Convey("The report endpoint should not ", func() {
+				rec := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+				router.ServeHTTP(rec, req)
+				So(repOptions.Source, ShouldBeFalse)
+				So(rec.Header().Get("Content-Disposition"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("It should extract the time zone from the URL and forward it to the new reporter ", func() {
+			*forceTimezone = "Europe/Berlin"
+			defer func() { *forceTimezone = "" }()
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?tz=Asia/Tokyo", nil)
+			router.ServeHTTP(rec, req)
+			So(repTime.TZ, ShouldEqual, "Asia/Tokyo")
+
+			Convey("The forced time zone should be used when the request has none ", func() {
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+				router.ServeHTTP(rec, req)
+				So(repTime.TZ, ShouldEqual, "Europe/Berlin")
+			})
+		})
+
+		Convey("It should extract the report options from the URL and forward them to the new reporter ", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?trim=true&panelsPerPage=4&noDataBadge=true&glossary=true&locale=de-DE&noteSkippedPanels=true&contactSheet=true&contactSheetColumns=3", nil)
+			router.ServeHTTP(rec, req)
+			So(repOptions.Trim, ShouldBeTrue)
+			So(repOptions.ContactSheet, ShouldBeTrue)
+			So(repOptions.ContactSheetColumns, ShouldEqual, 3)
+			So(repOptions.NoteSkippedPanels, ShouldBeTrue)
+			So(repOptions.Locale, ShouldResemble, format.Locale{Decimal: ",", Group: "."})
+			So(repOptions.Glossary, ShouldBeTrue)
+			So(repOptions.PanelsPerPage, ShouldEqual, 4)
+			So(repOptions.NoDataBadge, ShouldBeTrue)
+
+			Convey("Unknown locales should be rejected with 400 ", func() {
+				rec := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash?locale=xx", nil)
+				router.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusBadRequest)
+				So(rec.Body.String(), ShouldContainSubstring, "unknown locale")
+			})
+
+			Convey("The number of workers should be forwarded ", func() {
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash?workers=1", nil)
+				router.ServeHTTP(rec, req)
+				So(repOptions.Workers, ShouldEqual, 1)
+				So(rec.Header().Get("Warning"), ShouldBeEmpty)
+			})
+
+			Convey("Workers above the limit should be clamped with a warning ", func() {
+				defer func(orig int) { *maxWorkers = orig }(*maxWorkers)
+				*maxWorkers = 3
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash?workers=20", nil)
+				router.ServeHTTP(rec, req)
+				So(repOptions.Workers, ShouldEqual, 3)
+				So(rec.Header().Get("Warning"), ShouldStartWith, "199 grafana-reporter")
+				So(rec.Header().Get("Warning"), ShouldContainSubstring, "workers=20")
+			})
+
+			Convey("Invalid workers should use the server default ", func() {
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash?workers=0", nil)
+				router.ServeHTTP(rec, req)
+				So(repOptions.Workers, ShouldEqual, 0)
+			})
+
+			Convey("Options should default to off when not given ", func() {
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+				router.ServeHTTP(rec, req)
+				So(repOptions.Context, ShouldNotBeNil)
+				repOptions.Context = nil
+				So(repOptions, ShouldResemble, report.Options{})
+			})
+
+			Convey("The requesting host should be forwarded for the trace footer ", func() {
+				req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+				req.RemoteAddr = "10.0.0.7:51234"
+				router.ServeHTTP(rec, req)
+				So(repOptions.RequestedBy, ShouldEqual, "10.0.0.7")
+			})
+		})
+
 		Convey("It should extract the apiToken from the URL and forward it to the new Grafana Client ", func() {
 			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?apitoken=1234", nil)
 			router.ServeHTTP(rec, req)
@@ -141,3 +328,561 @@ func TestV5ServeReportHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestServeReportHandlerErrors(t *testing.T) {
+	Convey("When report generation fails", t, func() {
+		var genErr error
+		newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+			return errReport{genErr}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{grafana.NewV4Client, newReport, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+
+		Convey("It should respond with 502 when Grafana's circuit breaker is open", func() {
+			genErr = fmt.Errorf("error fetching dashboard testDash: %w", grafana.ErrCircuitOpen)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadGateway)
+		})
+
+		Convey("It should respond with 401 when Grafana redirected to its login page", func() {
+			genErr = fmt.Errorf("error fetching dashboard testDash: %w", &grafana.RedirectError{Op: "getDashboard", URL: "http://grafana/api/dashboards/uid/testDash", Err: grafana.ErrLoginRedirect})
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			So(rec.Body.String(), ShouldContainSubstring, "check the API token")
+		})
+
+		Convey("It should respond with 422 naming the limit when the TeX file exceeds a limit", func() {
+			genErr = fmt.Errorf("error generating TeX file: %w", &report.TeXLimitError{Limit: "max-tex-bytes", Value: 2000, Max: 1000})
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(rec.Body.String(), ShouldContainSubstring, "max-tex-bytes")
+		})
+
+		Convey("It should respond with 409 when the dashboard changed in strict mode", func() {
+			genErr = &report.VersionChangedError{Dashboard: "testDash", From: 1, To: 2}
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("It should respond with a status for each kind of LaTeX failure", func() {
+			statuses := map[report.LaTeXReason]int{
+				report.LaTeXCompileFailed: http.StatusInternalServerError,
+				report.LaTeXNotInstalled:  http.StatusServiceUnavailable,
+				report.LaTeXKilled:        http.StatusGatewayTimeout,
+				report.LaTeXNoPDF:         http.StatusInsufficientStorage,
+			}
+			for reason, status := range statuses {
+				genErr = fmt.Errorf("error compiling volume 2: %w", &report.LaTeXError{Err: errors.New("failed"), Reason: reason})
+				So(generateErrorStatus(genErr), ShouldEqual, status)
+			}
+		})
+
+		Convey("It should respond with 500 for other errors", func() {
+			genErr = errors.New("LaTeX failed")
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
+
+func TestServePlaylistReportHandler(t *testing.T) {
+	Convey("When the playlist report handler is called", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/playlists/7":
+				fmt.Fprint(w, `{"name":"Weekly","items":[{"type":"dashboard_by_uid","value":"uidB","order":2},{"type":"dashboard_by_id","value":"1","order":1}]}`)
+			case "/api/search":
+				fmt.Fprint(w, `[]`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+
+		var repTitle string
+		var repDashNames, repMissing []string
+		newMultiReport := func(g grafana.Client, title string, dashNames []string, missing []string, _ grafana.TimeRange, _ string, _ report.Options) report.Report {
+			repTitle, repDashNames, repMissing = title, dashNames, missing
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, nil, newMultiReport})
+		rec := httptest.NewRecorder()
+
+		Convey("It should forward the playlist's dashboards in order to the new multi-dashboard reporter", func() {
+			req, _ := http.NewRequest("GET", "/api/report/playlist/7", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repTitle, ShouldEqual, "Weekly")
+			So(repDashNames, ShouldResemble, []string{"uidB"})
+			So(repMissing, ShouldResemble, []string{"dashboard id 1: not found"})
+		})
+
+		Convey("It should respond with an error for an unknown playlist", func() {
+			req, _ := http.NewRequest("GET", "/api/report/playlist/8", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+
+		Convey("It should reject summaries, whose panel ids would be ambiguous", func() {
+			repTitle = ""
+			req, _ := http.NewRequest("GET", "/api/report/playlist/7?summary=2", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "summary")
+			So(repTitle, ShouldEqual, "")
+		})
+
+		Convey("It should reject layout options the multi-dashboard template doesn't use", func() {
+			repTitle = ""
+			req, _ := http.NewRequest("GET", "/api/report/playlist/7?style=compact&panelsPerPage=2&contactSheet=true&glossary=true&noteSkippedPanels=true", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "style, panelsPerPage, contactSheet, glossary, noteSkippedPanels not supported for playlist reports")
+			So(repTitle, ShouldEqual, "")
+		})
+
+		Convey("Disabled layout options should be accepted", func() {
+			req, _ := http.NewRequest("GET", "/api/report/playlist/7?glossary=false&panelsPerPage=0", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(repTitle, ShouldEqual, "Weekly")
+		})
+	})
+}
+
+func TestForwardAuthHeaders(t *testing.T) {
+	Convey("When the report server handler is called with auth proxy headers", t, func() {
+		var grafanaHeaders http.Header
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			grafanaHeaders = r.Header
+			fmt.Fprint(w, `{"dashboard":{"title":"test"}}`)
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ report.Options) report.Report {
+			g.GetDashboard(dashName)
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+		req.Header.Set("X-Webauth-User", "secret-user")
+		req.Header.Set("X-Other", "other")
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(ioutil.Discard)
+
+		Convey("Without the forward-auth-headers flag nothing should be forwarded", func() {
+			router.ServeHTTP(rec, req)
+			So(grafanaHeaders.Get("X-Webauth-User"), ShouldEqual, "")
+		})
+
+		Convey("With the forward-auth-headers flag the listed headers should be forwarded to Grafana", func() {
+			*forwardAuthHeaders = "X-WEBAUTH-USER, X-WEBAUTH-GROUPS"
+			defer func() { *forwardAuthHeaders = "" }()
+			router.ServeHTTP(rec, req)
+			So(grafanaHeaders.Get("X-Webauth-User"), ShouldEqual, "secret-user")
+			So(grafanaHeaders.Get("X-Webauth-Groups"), ShouldEqual, "")
+			So(grafanaHeaders.Get("X-Other"), ShouldEqual, "")
+
+			Convey("The forwarded header values should not be logged", func() {
+				So(logs.String(), ShouldContainSubstring, "X-Webauth-User")
+				So(logs.String(), ShouldNotContainSubstring, "secret-user")
+			})
+		})
+	})
+}
+
+func TestReportETag(t *testing.T) {
+	Convey("When a report over a fixed time range is requested", t, func() {
+		version := 1
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"dashboard":{"title":"test","version":%d}}`, version)
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		generated := 0
+		var repOptions report.Options
+		newReport := func(_ grafana.Client, _ string, _ grafana.TimeRange, _ string, opts report.Options) report.Report {
+			generated++
+			repOptions = opts
+			return pdfReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		fixed := "/api/v5/report/testDash?from=1453206447000&to=1453213647000"
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", fixed, nil))
+		etag := rec.Header().Get("ETag")
+
+		Convey("The response should have an ETag and Last-Modified", func() {
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(etag, ShouldNotBeEmpty)
+			So(rec.Header().Get("Last-Modified"), ShouldNotBeEmpty)
+		})
+
+		Convey("A request with a matching If-None-Match should be answered with 304 without generating", func() {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fixed, nil)
+			req.Header.Set("If-None-Match", etag)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotModified)
+			So(rec.Body.Len(), ShouldEqual, 0)
+			So(generated, ShouldEqual, 1)
+		})
+
+		Convey("A request for a different range should not match", func() {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fixed+"&var-host=other", nil)
+			req.Header.Set("If-None-Match", etag)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("ETag"), ShouldNotEqual, etag)
+		})
+
+		Convey("Equivalent variables in a different order or repeated should match", func() {
+			first := httptest.NewRecorder()
+			router.ServeHTTP(first, httptest.NewRequest("GET", fixed+"&var-host=a&var-env=prod", nil))
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fixed+"&var-env=prod&var-host=a&var-host=a", nil)
+			req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotModified)
+		})
+
+		Convey("Values that only differ once decoded should not match", func() {
+			for _, pair := range [][2]string{
+				{"var-path=%257Bid%257D", "var-path=%7Bid%7D"},
+				{"var-path=a%2Fb", "var-path=a%252Fb"},
+				{"var-q=a%2Bb", "var-q=a+b"},
+				{"var-q=a%26b", "var-q=a&b"},
+			} {
+				first := httptest.NewRecorder()
+				router.ServeHTTP(first, httptest.NewRequest("GET", fixed+"&"+pair[0], nil))
+				second := httptest.NewRecorder()
+				router.ServeHTTP(second, httptest.NewRequest("GET", fixed+"&"+pair[1], nil))
+				So(second.Header().Get("ETag"), ShouldNotEqual, first.Header().Get("ETag"))
+			}
+		})
+
+		Convey("Different spellings of the same value should match", func() {
+			first := httptest.NewRecorder()
+			router.ServeHTTP(first, httptest.NewRequest("GET", fixed+"&var-path=/api/v1&var-city=M%C3%BCnchen", nil))
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fixed+"&var-path=%2Fapi%2Fv1&var-city=M%c3%bcnchen", nil)
+			req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotModified)
+		})
+
+		Convey("An edited dashboard should change the ETag", func() {
+			version = 2
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fixed, nil)
+			req.Header.Set("If-None-Match", etag)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("ETag"), ShouldNotEqual, etag)
+			So(generated, ShouldEqual, 2)
+		})
+
+		Convey("A relative time range should not have an ETag", func() {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v5/report/testDash?from=now-1h&to=now", nil))
+			So(rec.Header().Get("ETag"), ShouldBeEmpty)
+		})
+
+		Convey("The report should be made from the dashboard fetched for the ETag", func() {
+			So(repOptions.Dashboard, ShouldNotBeNil)
+			So(repOptions.Dashboard.Version, ShouldEqual, 1)
+		})
+
+		Convey("An edited template should change the ETag", func() {
+			dir := t.TempDir()
+			defer func(d string) { *templateDir = d }(*templateDir)
+			*templateDir = dir
+			So(ioutil.WriteFile(filepath.Join(dir, "house.tex"), []byte("first"), 0644), ShouldBeNil)
+			first := httptest.NewRecorder()
+			router.ServeHTTP(first, httptest.NewRequest("GET", fixed+"&template=house", nil))
+			So(ioutil.WriteFile(filepath.Join(dir, "house.tex"), []byte("second"), 0644), ShouldBeNil)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fixed+"&template=house", nil)
+			req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("ETag"), ShouldNotEqual, first.Header().Get("ETag"))
+		})
+
+		Convey("A new reporter version should change the ETag", func() {
+			defer func(v string) { report.ReporterVersion = v }(report.ReporterVersion)
+			report.ReporterVersion = "9.9-9"
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", fixed, nil)
+			req.Header.Set("If-None-Match", etag)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("ETag"), ShouldNotEqual, etag)
+		})
+	})
+}
+
+func TestServeStyles(t *testing.T) {
+	Convey("When the styles endpoint is called", t, func() {
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{nil, nil, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/styles", nil)
+		router.ServeHTTP(rec, req)
+
+		Convey("It should list the built-in styles and the default", func() {
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var body struct {
+				Default string
+				Styles  []string
+			}
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Default, ShouldEqual, "classic")
+			So(body.Styles, ShouldContain, "compact")
+			So(body.Styles, ShouldContain, "executive")
+		})
+	})
+}
+
+func TestImageFormat(t *testing.T) {
+	Convey("When reading the requested image format", t, func() {
+		format := func(query string) string {
+			return imageFormat(httptest.NewRequest("GET", "/api/v5/report/testDash"+query, nil))
+		}
+
+		Convey("It should default to png", func() {
+			So(format(""), ShouldEqual, grafana.ImagePNG)
+		})
+
+		Convey("It should accept jpeg and jpg", func() {
+			So(format("?imageFormat=jpeg"), ShouldEqual, grafana.ImageJPEG)
+			So(format("?imageFormat=JPG"), ShouldEqual, grafana.ImageJPEG)
+		})
+
+		Convey("It should fall back to png for unknown formats", func() {
+			So(format("?imageFormat=webp"), ShouldEqual, grafana.ImagePNG)
+		})
+	})
+}
+
+func TestServePublicReportHandler(t *testing.T) {
+	Convey("When the public dashboard report handler is called", t, func() {
+		defer func(orig func(string, string, ...grafana.ClientOption) grafana.Client) { newPublicClient = orig }(newPublicClient)
+		var clientToken string
+		newPublicClient = func(url string, accessToken string, _ ...grafana.ClientOption) grafana.Client {
+			clientToken = accessToken
+			return grafana.NewPublicClient(url, accessToken)
+		}
+		var repClient grafana.Client
+		newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ report.Options) report.Report {
+			repClient = g
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{nil, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/report/public/abc123?apitoken=1234", nil)
+		router.ServeHTTP(rec, req)
+
+		Convey("It should generate the report with a public client for the access token", func() {
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(clientToken, ShouldEqual, "abc123")
+			So(repClient, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDashboardVersionParameter(t *testing.T) {
+	Convey("When reading the pinned dashboard version", t, func() {
+		version := func(query string) int {
+			return dashboardVersion(httptest.NewRequest("GET", "/api/v5/report/testDash"+query, nil))
+		}
+
+		Convey("It should default to the current version", func() {
+			So(version(""), ShouldEqual, 0)
+		})
+
+		Convey("It should read a version number", func() {
+			So(version("?dashboardVersion=12"), ShouldEqual, 12)
+		})
+
+		Convey("It should ignore invalid versions", func() {
+			So(version("?dashboardVersion=latest"), ShouldEqual, 0)
+			So(version("?dashboardVersion=-1"), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestDashVariables(t *testing.T) {
+	Convey("When reading the template variables of a request", t, func() {
+		req := httptest.NewRequest("GET", "/api/v5/report/testDash?var-host=a&var-host=b&var-host=a&var-host=a&var-env=prod&from=now-1h", nil)
+		vars := dashVariables(req)
+
+		Convey("Repeated identical values should be removed, keeping the order of distinct values", func() {
+			So(vars, ShouldResemble, url.Values{"var-host": {"a", "b"}, "var-env": {"prod"}})
+		})
+
+		Convey("Encoded values should be decoded exactly once", func() {
+			req := httptest.NewRequest("GET", "/api/v5/report/testDash?var-path=%2Fapi%2Fv1%2F%257Bid%257D&var-q=a%2Bb+c%26d&var-pct=50%25&var-city=M%C3%BCnchen", nil)
+			So(dashVariables(req), ShouldResemble, url.Values{
+				"var-path": {"/api/v1/%7Bid%7D"},
+				"var-q":    {"a+b c&d"},
+				"var-pct":  {"50%"},
+				"var-city": {"München"},
+			})
+		})
+	})
+}
+
+// annotatingClient records the annotations created through it
+type annotatingClient struct {
+	grafana.Client
+	annotations []grafana.Annotation
+	err         error
+}
+
+func (c *annotatingClient) CreateAnnotation(a grafana.Annotation) error {
+	c.annotations = append(c.annotations, a)
+	return c.err
+}
+
+func TestAnnotateReport(t *testing.T) {
+	Convey("When a report is requested with annotate=true", t, func() {
+		g := &annotatingClient{}
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			g.Client = grafana.NewV5Client(url, apiToken, variables, opts...)
+			return g
+		}
+		var rep report.Report = &mockReport{}
+		newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+			return rep
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		rec := httptest.NewRecorder()
+
+		Convey("The dashboard should be annotated at the end of the report's time range", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?annotate=true&from=1521590400000&to=1521676800000&apitoken=secret", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(g.annotations, ShouldHaveLength, 1)
+			a := g.annotations[0]
+			So(a.DashboardUID, ShouldEqual, "testDash")
+			So(a.Time, ShouldEqual, 1521676800000)
+			So(a.Tags, ShouldResemble, []string{"report"})
+			So(a.Text, ShouldContainSubstring, "testDash-")
+			So(a.Text, ShouldContainSubstring, "/api/v5/report/testDash?")
+			So(a.Text, ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("A failure to annotate should not fail the report", func() {
+			g.err = errors.New("grafana is down")
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?annotate=true", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(g.annotations, ShouldHaveLength, 1)
+		})
+
+		Convey("A failed report should not be annotated", func() {
+			rep = errReport{errors.New("render failed")}
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash?annotate=true", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(g.annotations, ShouldBeEmpty)
+		})
+
+		Convey("Reports should not be annotated by default", func() {
+			req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+			router.ServeHTTP(rec, req)
+			So(g.annotations, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestReportStatsHeaders(t *testing.T) {
+	Convey("When a report that summarizes itself is served", t, func() {
+		rep := statsReport{stats: report.Stats{Pages: 14, Panels: 32, Bytes: 2201843, Duration: 12345 * stdtime.Millisecond, BuildBytes: 5242880}}
+		newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+			return rep
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{grafana.NewV4Client, newReport, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash", nil)
+		router.ServeHTTP(rec, req)
+
+		Convey("Its stats should be returned as headers", func() {
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("X-Report-Pages"), ShouldEqual, "14")
+			So(rec.Header().Get("X-Report-Panels"), ShouldEqual, "32")
+			So(rec.Header().Get("X-Report-Bytes"), ShouldEqual, "2201843")
+			So(rec.Header().Get("X-Report-Duration"), ShouldEqual, "12.345")
+			So(rec.Header().Get("X-Report-Build-Bytes"), ShouldEqual, "5242880")
+		})
+
+		Convey("An unknown page count should be left out", func() {
+			rep.stats.Pages = 0
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			So(rec.Header().Get("X-Report-Pages"), ShouldEqual, "")
+			So(rec.Header().Get("X-Report-Panels"), ShouldEqual, "32")
+		})
+	})
+}
+
+func TestSplitList(t *testing.T) {
+	Convey("Comma separated lists should be split, dropping empty items", t, func() {
+		So(splitList("dashlist, news,,alertlist "), ShouldResemble, []string{"dashlist", "news", "alertlist"})
+		So(splitList(""), ShouldBeEmpty)
+	})
+}
+
+func TestTemplateEncoding(t *testing.T) {
+	Convey("When a report uses a custom template file", t, func() {
+		dir, err := ioutil.TempDir("", "templates")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer func(d string) { *templateDir = d }(*templateDir)
+		*templateDir = dir
+		So(ioutil.WriteFile(filepath.Join(dir, "windows.tex"), []byte("\xef\xbb\xbf\\begin{document}\r\n[[.Title]]\r\n\\end{document}\r\n"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "latin1.tex"), []byte("\\title{Gr\xf6\xdfe}"), 0644), ShouldBeNil)
+
+		var repTemplate string
+		newReport := func(_ grafana.Client, _ string, _ grafana.TimeRange, texTemplate string, _ report.Options) report.Report {
+			repTemplate = texTemplate
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+
+		Convey("A byte order mark and Windows line endings should be removed", func() {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v5/report/testDash?template=windows", nil))
+			So(repTemplate, ShouldEqual, "\\begin{document}\n[[.Title]]\n\\end{document}\n")
+		})
+
+		Convey("A template that is not UTF-8 should be rejected with 400 naming the offset", func() {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v5/report/testDash?template=latin1", nil))
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "template latin1: not valid UTF-8 at byte offset 9")
+		})
+	})
+}
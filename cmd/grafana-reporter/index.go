@@ -0,0 +1,124 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+)
+
+//go:embed ui/index.html
+var uiFS embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(uiFS, "ui/index.html"))
+
+// RegisterUIHandlers registers the interactive report page at / and the JSON endpoints it uses.
+// Grafana is queried with the server-side token, the page never asks for one.
+func RegisterUIHandlers(router *mux.Router, reportServerV5 ServeReportHandler) {
+	router.HandleFunc("/", serveIndex).Methods("GET")
+	router.HandleFunc("/api/dashboards", reportServerV5.serveDashboards).Methods("GET")
+	router.HandleFunc("/api/dashboards/{uid}/variables", reportServerV5.serveVariables).Methods("GET")
+}
+
+// serveIndex renders the page for picking a dashboard, time range, variables and template
+func serveIndex(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := indexTemplate.Execute(w, struct {
+		Templates    []string
+		Styles       []string
+		DefaultStyle string
+	}{customTemplates(), report.Styles(), report.DefaultStyle})
+	if err != nil {
+		log.Println("Error rendering index page:", err)
+	}
+}
+
+// customTemplates lists the names of the TeX templates in the templates directory, sorted
+func customTemplates() []string {
+	files, err := ioutil.ReadDir(*templateDir)
+	if err != nil {
+		log.Println("Error listing templates directory:", err)
+		return nil
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".tex" {
+			names = append(names, strings.TrimSuffix(f.Name(), ".tex"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serveDashboards lists the dashboards found by Grafana's search API
+func (h ServeReportHandler) serveDashboards(w http.ResponseWriter, req *http.Request) {
+	token, err := tokens.token(req)
+	if err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	g := h.newGrafanaClient(*proto+*ip, token, url.Values{}, grafana.WithHeaders(forwardedHeaders(req)))
+	refs, err := g.SearchDashboards(url.Values{})
+	if err != nil {
+		writeJSONError(w, generateErrorStatus(err), err.Error())
+		return
+	}
+	type dashboard struct {
+		Uid   string   `json:"uid"`
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+	}
+	dashboards := []dashboard{}
+	for _, ref := range refs {
+		tags := ref.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		dashboards = append(dashboards, dashboard{ref.Uid, ref.Title, tags})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dashboards)
+}
+
+// serveVariables lists the template variables of the dashboard with their current values and options
+func (h ServeReportHandler) serveVariables(w http.ResponseWriter, req *http.Request) {
+	token, err := tokens.token(req)
+	if err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	g := h.newGrafanaClient(*proto+*ip, token, url.Values{}, grafana.WithHeaders(forwardedHeaders(req)))
+	dash, err := g.GetDashboard(mux.Vars(req)["uid"])
+	if err != nil {
+		writeJSONError(w, generateErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dash.Templating.Variables())
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUIHandlers(t *testing.T) {
+	Convey("When the UI handlers are registered", t, func() {
+		var grafanaToken string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			grafanaToken = r.Header.Get("Authorization")
+			switch r.URL.Path {
+			case "/api/search":
+				fmt.Fprint(w, `[{"id":1,"uid":"abc","title":"Servers","tags":["ops"]},{"id":2,"uid":"def","title":"Apps"}]`)
+			case "/api/dashboards/uid/abc":
+				fmt.Fprint(w, `{"dashboard":{"title":"Servers","templating":{"list":[
+					{"name":"host","label":"Host","current":{"value":["a","b"]},"options":[{"value":"a"},{"value":"b"},{"value":"c"}]},
+					{"name":"interval","current":{"value":"5m"}}]}}}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		router := mux.NewRouter()
+		RegisterUIHandlers(router, ServeReportHandler{newGrafanaClient, nil, nil})
+		rec := httptest.NewRecorder()
+
+		Convey("The index page should render", func() {
+			req, _ := http.NewRequest("GET", "/", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/html")
+			So(rec.Body.String(), ShouldContainSubstring, `<option value="style:classic" selected>`)
+		})
+
+		Convey("It should list the dashboards found by Grafana's search", func() {
+			req, _ := http.NewRequest("GET", "/api/dashboards", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var dashboards []map[string]interface{}
+			So(json.Unmarshal(rec.Body.Bytes(), &dashboards), ShouldBeNil)
+			So(dashboards, ShouldResemble, []map[string]interface{}{
+				{"uid": "abc", "title": "Servers", "tags": []interface{}{"ops"}},
+				{"uid": "def", "title": "Apps", "tags": []interface{}{}},
+			})
+		})
+
+		Convey("It should list the dashboard's variables with their current values and options", func() {
+			req, _ := http.NewRequest("GET", "/api/dashboards/abc/variables", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			var vars []grafana.Variable
+			So(json.Unmarshal(rec.Body.Bytes(), &vars), ShouldBeNil)
+			So(vars, ShouldResemble, []grafana.Variable{
+				{Name: "host", Label: "Host", Current: []string{"a", "b"}, Options: []string{"a", "b", "c"}},
+				{Name: "interval", Current: []string{"5m"}, Options: []string{}},
+			})
+		})
+
+		Convey("It should answer with a JSON error when Grafana fails", func() {
+			req, _ := http.NewRequest("GET", "/api/dashboards/missing/variables", nil)
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldNotEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		})
+
+		Convey("It should query Grafana with the configured server-side token", func() {
+			*serverAPIToken = "secret"
+			defer func() { *serverAPIToken = "" }()
+			req, _ := http.NewRequest("GET", "/api/dashboards", nil)
+			router.ServeHTTP(rec, req)
+			So(grafanaToken, ShouldEqual, "Bearer secret")
+		})
+	})
+}
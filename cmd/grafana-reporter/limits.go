@@ -0,0 +1,74 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// maxQueryLength is the longest accepted query string, in bytes. Longer requests are answered with 414.
+const maxQueryLength = 8 * 1024
+
+// limitsMiddleware rejects requests whose query string or body exceed the configured limits.
+// Bodies without a Content-Length are limited while they are read, so reading past the limit fails.
+func limitsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.URL.RawQuery) > maxQueryLength {
+			writeJSONError(w, http.StatusRequestURITooLong, fmt.Sprintf("query string exceeds %d bytes", maxQueryLength))
+			return
+		}
+		if req.ContentLength > *maxRequestBytes {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", *maxRequestBytes))
+			return
+		}
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, *maxRequestBytes)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// bodyTooLarge reports whether err comes from reading a request body past the limit set by limitsMiddleware
+func bodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// writeOptionsError rejects a request whose report options are invalid with 400, or with 413 when its body was read
+// past the size limit, as the limitsMiddleware does for bodies declaring a larger Content-Length
+func writeOptionsError(w http.ResponseWriter, err error) {
+	if bodyTooLarge(err) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", *maxRequestBytes))
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
+
+// writeJSONError responds with status and a JSON body of the form {"error": msg}
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	log.Println("Rejecting request:", msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimitsMiddleware(t *testing.T) {
+	Convey("When requests pass through the limits middleware", t, func() {
+		*maxRequestBytes = 100
+		defer func() { *maxRequestBytes = 1 << 20 }()
+		var readErr error
+		handler := limitsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, readErr = ioutil.ReadAll(r.Body)
+		}))
+		rec := httptest.NewRecorder()
+
+		jsonError := func() string {
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			var body struct{ Error string }
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			return body.Error
+		}
+
+		Convey("A body at the limit should be accepted", func() {
+			req := httptest.NewRequest("POST", "/api/v5/report/testDash", strings.NewReader(strings.Repeat("a", 100)))
+			handler.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(readErr, ShouldBeNil)
+		})
+
+		Convey("A body just over the limit should be rejected with 413", func() {
+			req := httptest.NewRequest("POST", "/api/v5/report/testDash", strings.NewReader(strings.Repeat("a", 101)))
+			handler.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(jsonError(), ShouldContainSubstring, "100 bytes")
+		})
+
+		Convey("A body of unknown length should fail when read past the limit", func() {
+			req := httptest.NewRequest("POST", "/api/v5/report/testDash", ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 101))))
+			req.ContentLength = -1
+			handler.ServeHTTP(rec, req)
+			So(readErr, ShouldNotBeNil)
+		})
+
+		Convey("A report request whose extras body is read past the limit should be rejected with 413", func() {
+			ts := httptest.NewServer(http.NotFoundHandler())
+			defer ts.Close()
+			newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+				return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+			}
+			newReport := func(_ grafana.Client, _ string, _ grafana.TimeRange, _ string, _ report.Options) report.Report {
+				return &mockReport{}
+			}
+			router := mux.NewRouter()
+			RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+			//no Content-Length, so the body is only found to be too large while the extras are decoded
+			body := `{"extras":{"customer":"` + strings.Repeat("a", 100) + `"}}`
+			for _, target := range []string{"/api/v5/report/testDash", "/api/report/public/abc", "/api/report/playlist/7"} {
+				req := httptest.NewRequest("POST", target, ioutil.NopCloser(strings.NewReader(body)))
+				req.Header.Set("Content-Type", "application/json")
+				req.ContentLength = -1
+				rec = httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+				So(jsonError(), ShouldContainSubstring, "100 bytes")
+			}
+		})
+
+		Convey("A query string at the limit should be accepted", func() {
+			req := httptest.NewRequest("GET", "/api/v5/report/testDash?"+strings.Repeat("a", maxQueryLength), nil)
+			handler.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("A query string just over the limit should be rejected with 414", func() {
+			req := httptest.NewRequest("GET", "/api/v5/report/testDash?"+strings.Repeat("a", maxQueryLength+1), nil)
+			handler.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusRequestURITooLong)
+			So(jsonError(), ShouldContainSubstring, "query string")
+		})
+	})
+}
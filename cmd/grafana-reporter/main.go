@@ -18,9 +18,17 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	stdtime "time"
 
 	"github.com/IzakMarais/reporter/grafana"
 	"github.com/IzakMarais/reporter/report"
@@ -31,21 +39,227 @@ var proto = flag.String("proto", "http://", "Grafana Protocol")
 var ip = flag.String("ip", "localhost:3000", "Grafana IP and port")
 var port = flag.String("port", ":8686", "Port to serve on")
 var templateDir = flag.String("templates", "templates/", "Directory for custom TeX templates")
+var defaultTemplate = flag.String("default-template", "", "TeX template file used instead of the built-in classic style when a request selects neither a template nor a style. Files it includes are read from its directory")
+var breakerThreshold = flag.Int("breaker-threshold", 5, "Consecutive Grafana connection failures after which requests fail fast with 502. 0 disables the circuit breaker")
+var breakerCoolDown = flag.Duration("breaker-cooldown", 30*stdtime.Second, "Time the Grafana circuit breaker stays open before probing Grafana again")
+
+// grafanaBreaker is the circuit breaker shared by the Grafana clients, nil when -breaker-threshold is 0
+var grafanaBreaker *grafana.Breaker
+var forwardAuthHeaders = flag.String("forward-auth-headers", "", "Comma separated request header names copied onto all Grafana requests, e.g. X-WEBAUTH-USER for Grafana's auth proxy. Nothing is forwarded when empty")
+var maxTitleLength = flag.Int("max-title-length", 200, "Number of characters after which dashboard, row and panel titles are truncated with an ellipsis. 0 disables truncation")
+var retentionFlag = flag.String("retention", "", "Comma separated data source retention periods, e.g. prom=30d,loki=7d. Reports reaching back further warn about incomplete data")
+var maxRequestBytes = flag.Int64("max-request-bytes", 1<<20, "Largest accepted request body in bytes. Larger requests are answered with 413")
+var maxPagesPerVolume = flag.Int("max-pages-per-volume", 0, "Reports with more pages are split into volumes of at most this many pages and returned as a zip. 0 disables splitting")
+var maxTeXBytes = flag.Int64("max-tex-bytes", 10<<20, "Generated TeX files larger than this many bytes are rejected with 422 instead of compiled. 0 disables the check")
+var maxAuxBytes = flag.Int64("max-aux-bytes", 5<<20, "Reports whose LaTeX aux files grow beyond this many bytes during compilation are rejected with 422. 0 disables the check")
+var latexTimeout = flag.Duration("latex-timeout", 10*stdtime.Minute, "Time after which compiling a report with pdflatex is aborted")
+var templateTimeout = flag.Duration("template-timeout", report.TemplateTimeout, "Time after which executing a report's TeX template is aborted")
+var ignoreNowDelay = flag.Bool("ignore-now-delay", false, "Render ranges ending now up to now, even for dashboards whose time picker excludes recent data with a nowDelay")
+var checkDashboardVersion = flag.Bool("check-dashboard-version", false, "Fetch each dashboard again after rendering its panels and warn in the report when it was changed meanwhile")
+var strictDashboardVersion = flag.Bool("strict-dashboard-version", false, "Fail reports with 409 instead of warning when the dashboard was changed while rendering. Implies -check-dashboard-version")
+var errorHelp = flag.String("error-help", "Please contact your Grafana administrator and quote the error id.", "Help text shown on the error pages of failed reports requested with errorFormat=pdf")
+var maxWorkers = flag.Int("max-workers", report.DefaultWorkers, "Largest number of panels a report may render concurrently with the workers parameter. Larger values are clamped")
+var skipUnderscorePanels = flag.Bool("skip-underscore-panels", false, "Leave panels whose title starts with an underscore out of all reports, e.g. internal debug panels")
+var skipPanelTypes = flag.String("skip-panel-types", strings.Join(grafana.DefaultSkipPanelTypes, ","), "Comma separated panel types left out of all reports, e.g. dashlist for dashboard lists. Requests can override them with the skipPanelTypes parameter")
+var ui = flag.Bool("ui", false, "Serve a page at / for picking a dashboard, time range, variables and template and generating its report")
+var serverAPIToken = flag.String("api-token", "", "Grafana API token used for requests without an apitoken parameter, e.g. those made from the -ui page")
+var autoDegrade = flag.Bool("auto-degrade", false, "Retry dashboard reports that failed because LaTeX timed out or the disk was full with JPEG panel images, then with half size JPEG images. The cover notes the reduced quality")
+var noDataThreshold = flag.Float64("no-data-threshold", report.NoDataThreshold, "Share of a panel image's pixels that must have the background colour for noDataBadge=true to caption the panel, when its data can't be queried")
+var spoolMemoryLimit = flag.Int64("spool-memory-limit", 8<<20, "Reports up to this many bytes are held in memory while they are delivered and their build files removed at once. Larger reports are delivered from disk")
+var noTraceFooter = flag.Bool("no-trace-footer", false, "Leave out the footer line naming the reporter and Grafana versions, generation time and requesting host, e.g. for reports handed to external readers")
+var maxConcurrentDashboards = flag.Int("max-concurrent-dashboards", report.MaxConcurrentDashboards, "Number of dashboards of a playlist report whose panels are rendered at once, each with the report's workers")
+var maxConcurrentRenders = flag.Int("max-concurrent-renders", 0, "Largest number of panels rendered at once by all reports together, however many reports and dashboards run concurrently. 0 for no limit")
+var tmpRoot = flag.String("tmp-dir", report.TmpRoot, "Directory in which each report gets its own build directory, removed after the report was delivered")
+var tmpPerm = flag.String("tmp-perm", "0640", "Octal permissions of the files written to -tmp-dir. Directories also get the execute bit where the read bit is set, e.g. 0750")
+var writeTimeout = flag.Duration("write-timeout", 2*stdtime.Minute, "Time a client may stop reading a report download before it is aborted and the report's files removed. Renewed while the client reads, so large downloads are not cut off. 0 disables the timeout")
+var maxLargeDownloads = flag.Int("max-large-downloads", 0, "Largest number of reports above -spool-memory-limit delivered at once. Further requests wait for a slot until their -report-timeout, then are answered with 503. 0 for no limit")
+var tokensFile = flag.String("tokens", "", "JSON file of named Grafana API tokens and the users allowed to select each with the tokenName parameter. Tokens stay on the server")
+var tokenUserHeader = flag.String("token-user-header", "X-WEBAUTH-USER", "Request header with the user authenticated by the proxy in front of the reporter, checked against the -tokens allowlist")
+var renderMode = flag.String("render-mode", grafana.RenderImages, "How panel images are made: images to render them with Grafana's image renderer, data to draw time series and stat panels from their data for Grafana installations without a renderer, or auto to draw them from their data where rendering fails")
+var noRenderNonce = flag.Bool("no-render-nonce", false, "Request panel images without the unique reporterNonce parameter that keeps caches in front of the image renderer from serving old images")
+var maxRenderAge = flag.Duration("max-render-age", 0, "Caption panel images whose render response is older than this, e.g. 5m, as possibly served from a stale cache. 0 disables the check")
+var probeDashboard = flag.String("probe-dashboard", "", "Uid of a small dashboard whose report /api/probe generates when called without a dash parameter")
+var probeSecret = flag.String("probe-secret", "", "Bearer token required by /api/probe. The probe is disabled when empty")
+var probeTimeout = flag.Duration("probe-timeout", 30*stdtime.Second, "Time after which /api/probe fails with the timeout stage")
+var waitForGrafana = flag.Duration("wait-for-grafana", 0, "At startup, retry Grafana's health endpoint with exponential backoff for up to this long, e.g. 2m, reporting the reporter as not ready on /readyz meanwhile. 0 disables waiting")
+var summaryInterval = flag.Duration("summary-interval", 0, "Log a summary of panel renders every interval: renders by HTTP status class, the panels that failed most and the average render time. 0 disables it")
+var signingKey = flag.String("signing-key", "", "PEM file of an Ed25519 or RSA private key signing the reports requested with sign=true. Check signatures with grafana-reporter verify")
+var metricsEnabled = flag.Bool("metrics", false, "Serve report, panel render and LaTeX metrics on /metrics in Prometheus' text format")
+var forceTimezone = flag.String("force-timezone", "", "Time zone used for all reports, overriding the dashboards' time zones, e.g. UTC. A request's tz parameter still takes precedence")
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if err := runVerify(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	flag.Parse()
-	log.SetOutput(os.Stdout)
+	log.SetOutput(os.Stderr)
+	if *configFile != "" {
+		if err := loadConfig(flag.CommandLine, *configFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *printConfig {
+		writeConfig(os.Stdout, flag.CommandLine)
+		return
+	}
+	if err := validateConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if !*cli {
+		//with -cli, the report may be written to stdout
+		log.SetOutput(os.Stdout)
+	}
+	grafana.MaxTitleLength = *maxTitleLength
+	grafana.SkipUnderscorePanels = *skipUnderscorePanels
+	grafana.SkipPanelTypes = splitList(*skipPanelTypes)
+	report.LaTeXTimeout = *latexTimeout
+	report.TemplateTimeout = *templateTimeout
+	report.MaxTeXBytes = *maxTeXBytes
+	report.MaxAuxBytes = *maxAuxBytes
+	report.NoDataThreshold = *noDataThreshold
+	report.MaxRenderAge = *maxRenderAge
+	grafana.RenderNonce = !*noRenderNonce
+	switch *renderMode {
+	case grafana.RenderImages, grafana.RenderData, grafana.RenderAuto:
+		grafana.RenderMode = *renderMode
+	default:
+		log.Fatalf("Invalid -render-mode %q, expected %v, %v or %v", *renderMode, grafana.RenderImages, grafana.RenderData, grafana.RenderAuto)
+	}
+	report.TraceFooter = !*noTraceFooter
+	report.TmpRoot = *tmpRoot
+	perm, err := strconv.ParseUint(*tmpPerm, 8, 32)
+	if err != nil || perm > 0777 || perm&0600 != 0600 {
+		log.Fatalf("Invalid -tmp-perm %q, expected octal permissions that let the owner read and write, e.g. 0640", *tmpPerm)
+	}
+	report.TmpPerm = os.FileMode(perm)
+	report.MaxConcurrentDashboards = *maxConcurrentDashboards
+	report.SetMaxConcurrentRenders(*maxConcurrentRenders)
+	setMaxLargeDownloads(*maxLargeDownloads)
+	report.ReporterVersion = fmt.Sprintf("%s.%s-%s", generatedMajor, generatedMinor, generatedRelease)
+	retention, err = grafana.ParseRetention(*retentionFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *defaultTemplate != "" {
+		if err := report.LoadDefaultTemplate(*defaultTemplate); err != nil {
+			log.Printf("WARNING: ignoring -default-template, reports without a template or style use the built-in %v style: %v", report.DefaultStyle, err)
+		} else {
+			log.Println("Using default template", *defaultTemplate)
+		}
+	}
+	if *signingKey != "" {
+		report.SigningKey, err = report.LoadSigningKey(*signingKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Signing reports requested with sign=true with a %v key", report.SignatureAlgorithm(report.SigningKey.Public()))
+	}
+	if *tokensFile != "" {
+		tokens, err = loadNamedTokens(*tokensFile, *tokenUserHeader)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *cli {
+		if err := runCLI(ServeReportHandler{grafana.NewV5Client, report.New, report.NewMulti}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	//'generated*'' variables injected from build.gradle: task 'injectGoVersion()'
 	log.Printf("grafana reporter, version: %s.%s-%s hash: %s", generatedMajor, generatedMinor, generatedRelease, generatedGitHash)
 	log.Printf("serving at '%s' and using grafana at '%s'", *port, *ip)
+	srv := &http.Server{}
+	if *certFile != "" {
+		certs, err := newCertReloader(*certFile, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.TLSConfig, err = tlsConfig(certs, *minTLSVersion)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go certs.reloadOn(hup)
+		log.Printf("serving HTTPS with certificate %v, TLS %v or newer", *certFile, *minTLSVersion)
+	}
+
+	latexStatus = report.CheckPrerequisites()
+	if latexStatus != nil {
+		log.Println("LaTeX prerequisites missing, reports will fail until they are installed:", latexStatus)
+	}
+
+	newV4Client, newV5Client := grafana.NewV4Client, grafana.NewV5Client
+	if *breakerThreshold > 0 {
+		//v4 and v5 endpoints talk to the same Grafana, so they share one breaker
+		grafanaBreaker = grafana.NewBreaker(*breakerThreshold, *breakerCoolDown)
+		newV4Client = func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafanaBreaker.Wrap(grafana.NewV4Client(url, apiToken, variables, opts...))
+		}
+		newV5Client = func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafanaBreaker.Wrap(grafana.NewV5Client(url, apiToken, variables, opts...))
+		}
+	}
+
+	if *waitForGrafana > 0 {
+		g := newV5Client(*proto+*ip, *serverAPIToken, url.Values{}, grafana.WithTimeout(readyzTimeout))
+		grafanaWait.begin()
+		go grafanaWait.wait(*waitForGrafana, func() error {
+			_, err := g.GetHealth()
+			return err
+		})
+	}
 
 	router := mux.NewRouter()
 	RegisterHandlers(
 		router,
-		ServeReportHandler{grafana.NewV4Client, report.New},
-		ServeReportHandler{grafana.NewV5Client, report.New},
+		ServeReportHandler{newV4Client, report.New, report.NewMulti},
+		ServeReportHandler{newV5Client, report.New, report.NewMulti},
 	)
+	var hooks report.Hooks
+	if *metricsEnabled {
+		hooks = report.Hooks{PanelRendered: metrics.observePanelRender, LaTeXCompiled: metrics.observeLaTeX}
+		router.HandleFunc("/metrics", serveMetrics).Methods("GET")
+	}
+	if *summaryInterval > 0 {
+		observeMetrics := hooks.PanelRendered
+		hooks.PanelRendered = func(dashName string, p grafana.Panel, d stdtime.Duration, err error) {
+			if observeMetrics != nil {
+				observeMetrics(dashName, p, d, err)
+			}
+			renderSummaries.observe(dashName, p, d, err)
+		}
+		//the last window is logged when the reporter stops
+		stopSummaries, summariesDone := make(chan struct{}), make(chan struct{})
+		go func() {
+			renderSummaries.run(*summaryInterval, stopSummaries)
+			close(summariesDone)
+		}()
+		defer func() {
+			close(stopSummaries)
+			<-summariesDone
+		}()
+	}
+	report.SetHooks(hooks)
+	if *ui {
+		RegisterUIHandlers(router, ServeReportHandler{newV5Client, report.New, report.NewMulti})
+	}
 
-	log.Fatal(http.ListenAndServe(*port, router))
+	l, err := net.Listen("tcp", *port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	srv.Handler = router
+	if err := serveUntil(srv, l, stop, *shutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,152 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// metrics are served on /metrics in Prometheus' text format when the reporter is started with -metrics
+var metrics = newReporterMetrics()
+
+// unknownDashboard labels the reports of dashboards that could not be fetched, so that requests for made up
+// dashboard ids don't add series
+const unknownDashboard = "unknown"
+
+// reportOutcome labels the reports counter
+type reportOutcome struct {
+	dashboard string
+	outcome   string //"success" or "failure"
+}
+
+type reporterMetrics struct {
+	mu          sync.Mutex
+	reports     map[reportOutcome]int
+	inFlight    int
+	panelRender *histogram
+	latex       *histogram
+}
+
+func newReporterMetrics() *reporterMetrics {
+	return &reporterMetrics{
+		reports:     map[reportOutcome]int{},
+		panelRender: newHistogram(0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60),
+		latex:       newHistogram(0.5, 1, 2.5, 5, 10, 30, 60, 120, 300),
+	}
+}
+
+// reportStarted counts a report being generated and delivered. Call the returned func with whether it was delivered.
+func (m *reporterMetrics) reportStarted(dashboard string) (finished func(ok bool)) {
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+	return func(ok bool) {
+		outcome := "success"
+		if !ok {
+			outcome = "failure"
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inFlight--
+		m.reports[reportOutcome{dashboard, outcome}]++
+	}
+}
+
+func (m *reporterMetrics) observePanelRender(_ string, _ grafana.Panel, d stdtime.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.panelRender.observe(d.Seconds())
+}
+
+func (m *reporterMetrics) observeLaTeX(d stdtime.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latex.observe(d.Seconds())
+}
+
+// histogram counts observations in cumulative buckets, like a Prometheus histogram
+type histogram struct {
+	bounds []float64 //upper bounds of the buckets, ascending, without +Inf
+	counts []int     //observations up to each bound, not cumulative
+	sum    float64
+	count  int
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+func (h *histogram) write(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	cumulative := 0
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+}
+
+// labelEscaper escapes label values as the Prometheus text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *reporterMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprint(w, "# HELP reporter_reports_total Reports requested, by dashboard and whether they were generated and delivered\n# TYPE reporter_reports_total counter\n")
+	keys := make([]reportOutcome, 0, len(m.reports))
+	for k := range m.reports {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dashboard != keys[j].dashboard {
+			return keys[i].dashboard < keys[j].dashboard
+		}
+		return keys[i].outcome < keys[j].outcome
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "reporter_reports_total{dashboard=\"%s\",outcome=\"%s\"} %d\n", labelEscaper.Replace(k.dashboard), k.outcome, m.reports[k])
+	}
+	fmt.Fprintf(w, "# HELP reporter_reports_in_flight Reports being generated or delivered\n# TYPE reporter_reports_in_flight gauge\nreporter_reports_in_flight %d\n", m.inFlight)
+	m.panelRender.write(w, "reporter_panel_render_duration_seconds", "Time to fetch and store a panel image")
+	m.latex.write(w, "reporter_latex_duration_seconds", "Time of both LaTeX passes of a report, section or volume")
+	if grafanaBreaker != nil {
+		fmt.Fprintf(w, "# HELP reporter_grafana_breaker_state State of the Grafana circuit breaker: 0 closed, 1 open, 2 half-open\n# TYPE reporter_grafana_breaker_state gauge\nreporter_grafana_breaker_state %d\n", grafanaBreaker.State())
+	}
+}
+
+// serveMetrics writes the metrics in Prometheus' text format
+func serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.write(w)
+}
@@ -0,0 +1,122 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("When metrics are collected", t, func() {
+		defer func(orig *reporterMetrics) { metrics = orig }(metrics)
+		metrics = newReporterMetrics()
+
+		Convey("Reports in flight should be counted until they finish", func() {
+			finished := metrics.reportStarted("abc")
+			body := scrape()
+			So(body, ShouldContainSubstring, "reporter_reports_in_flight 1\n")
+			So(body, ShouldNotContainSubstring, "reporter_reports_total{")
+
+			finished(true)
+			metrics.reportStarted("abc")(false)
+			metrics.reportStarted(`a"b`)(true)
+			body = scrape()
+			So(body, ShouldContainSubstring, "reporter_reports_in_flight 0\n")
+			So(body, ShouldContainSubstring, "reporter_reports_total{dashboard=\"abc\",outcome=\"success\"} 1\n")
+			So(body, ShouldContainSubstring, "reporter_reports_total{dashboard=\"abc\",outcome=\"failure\"} 1\n")
+			So(body, ShouldContainSubstring, `reporter_reports_total{dashboard="a\"b",outcome="success"} 1`)
+		})
+
+		Convey("Durations should be counted in cumulative buckets", func() {
+			metrics.observePanelRender("abc", grafana.Panel{}, 300*stdtime.Millisecond, nil)
+			metrics.observePanelRender("abc", grafana.Panel{}, 2*stdtime.Minute, errors.New("timeout"))
+			metrics.observeLaTeX(3*stdtime.Second, nil)
+			body := scrape()
+			So(body, ShouldContainSubstring, "# TYPE reporter_panel_render_duration_seconds histogram\n")
+			So(body, ShouldContainSubstring, "reporter_panel_render_duration_seconds_bucket{le=\"0.25\"} 0\n")
+			So(body, ShouldContainSubstring, "reporter_panel_render_duration_seconds_bucket{le=\"0.5\"} 1\n")
+			So(body, ShouldContainSubstring, "reporter_panel_render_duration_seconds_bucket{le=\"60\"} 1\n")
+			So(body, ShouldContainSubstring, "reporter_panel_render_duration_seconds_bucket{le=\"+Inf\"} 2\n")
+			So(body, ShouldContainSubstring, "reporter_panel_render_duration_seconds_sum 120.3\n")
+			So(body, ShouldContainSubstring, "reporter_latex_duration_seconds_bucket{le=\"5\"} 1\n")
+			So(body, ShouldContainSubstring, "reporter_latex_duration_seconds_count 1\n")
+		})
+
+		Convey("Reports of dashboards that could not be fetched should be labeled unknown", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/dashboards/uid/testDash":
+					fmt.Fprint(w, `{"dashboard":{"title":"test"}}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer ts.Close()
+			newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+				return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+			}
+			newReport := func(g grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ report.Options) report.Report {
+				if _, err := g.GetDashboard(dashName); err != nil {
+					return errReport{err}
+				}
+				return &mockReport{}
+			}
+			router := mux.NewRouter()
+			RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient, newReport, nil})
+			for _, target := range []string{"/api/v5/report/testDash", "/api/v5/report/made-up-1", "/api/v5/report/made-up-2"} {
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+			}
+			body := scrape()
+			So(body, ShouldContainSubstring, "reporter_reports_total{dashboard=\"testDash\",outcome=\"success\"} 1\n")
+			So(body, ShouldContainSubstring, "reporter_reports_total{dashboard=\"unknown\",outcome=\"failure\"} 2\n")
+			So(body, ShouldNotContainSubstring, "made-up")
+		})
+
+		Convey("The state of the circuit breaker should be a gauge", func() {
+			defer func(orig *grafana.Breaker) { grafanaBreaker = orig }(grafanaBreaker)
+			grafanaBreaker = nil
+			So(scrape(), ShouldNotContainSubstring, "reporter_grafana_breaker_state")
+
+			grafanaBreaker = grafana.NewBreaker(1, stdtime.Hour)
+			body := scrape()
+			So(body, ShouldContainSubstring, "# TYPE reporter_grafana_breaker_state gauge\n")
+			So(body, ShouldContainSubstring, "reporter_grafana_breaker_state 0\n")
+
+			closed := httptest.NewServer(http.NotFoundHandler())
+			closed.Close()
+			grafanaBreaker.Wrap(grafana.NewV5Client(closed.URL, "", url.Values{})).GetHealth()
+			So(scrape(), ShouldContainSubstring, "reporter_grafana_breaker_state 1\n")
+		})
+	})
+}
+
+func scrape() string {
+	rec := httptest.NewRecorder()
+	serveMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
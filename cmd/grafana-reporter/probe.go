@@ -0,0 +1,166 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+// probes lets a single probe run at a time, so that the endpoint can't be used to flood Grafana
+var probes = make(chan struct{}, 1)
+
+// probeResult describes a probe: the failed stage, if any, and the seconds each stage took
+type probeResult struct {
+	Success   bool               `json:"success"`
+	Dashboard string             `json:"dashboard"`
+	Stage     string             `json:"stage,omitempty"` //the stage that failed: dashboard, render, latex or timeout
+	Error     string             `json:"error,omitempty"`
+	Stages    map[string]float64 `json:"stages"`
+	Duration  float64            `json:"duration"`
+}
+
+// ServeProbeHTTP generates a report of the probe dashboard and discards it, to monitor the whole report pipeline.
+// It needs the -probe-secret as bearer token, answers 200 with the time each stage took, or 5xx with the failed stage.
+// Its panels are rendered like those of other reports, so they count against -max-concurrent-renders.
+func (h ServeReportHandler) ServeProbeHTTP(w http.ResponseWriter, req *http.Request) {
+	log.Print("Probe called")
+	if *probeSecret == "" || subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+*probeSecret)) != 1 {
+		writeJSONError(w, http.StatusForbidden, "probing requires the probe secret as bearer token")
+		return
+	}
+	dash := req.URL.Query().Get("dash")
+	if dash == "" {
+		dash = *probeDashboard
+	}
+	if dash == "" {
+		writeJSONError(w, http.StatusBadRequest, "no dash parameter and no -probe-dashboard configured")
+		return
+	}
+	select {
+	case probes <- struct{}{}:
+	default:
+		w.Header().Set("Retry-After", "10")
+		writeJSONError(w, http.StatusTooManyRequests, "a probe is already running")
+		return
+	}
+
+	result := h.probe(dash, *probeTimeout, func() { <-probes })
+	status := http.StatusOK
+	switch result.Stage {
+	case "":
+	case "timeout":
+		status = http.StatusGatewayTimeout
+	case "dashboard":
+		status = http.StatusBadGateway
+	default:
+		status = http.StatusInternalServerError
+	}
+	if req.URL.Query().Get("format") == "prometheus" {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(status)
+		writeProbeMetrics(w, result)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// probe fetches the dashboard, then generates its report of the last five minutes with the server's api token.
+// A report still running after timeout is left to finish and clean up in the background. release is called
+// once the probe has finished, including such a report, so that timed out probes don't pile up.
+func (h ServeReportHandler) probe(dash string, timeout stdtime.Duration, release func()) probeResult {
+	start := stdtime.Now()
+	result := probeResult{Dashboard: dash, Stages: map[string]float64{}}
+	fail := func(stage string, err error) probeResult {
+		log.Printf("Probe of %v failed at %v: %v", dash, stage, err)
+		result.Stage, result.Error = stage, err.Error()
+		result.Duration = stdtime.Since(start).Seconds()
+		return result
+	}
+
+	g := h.newGrafanaClient(*proto+*ip, *serverAPIToken, url.Values{})
+	if _, err := g.GetDashboard(dash); err != nil {
+		release()
+		return fail("dashboard", err)
+	}
+	result.Stages["dashboard"] = stdtime.Since(start).Seconds()
+
+	type generated struct {
+		err      error
+		duration stdtime.Duration
+	}
+	done := make(chan generated, 1)
+	go func() {
+		genStart := stdtime.Now()
+		rep := h.newReport(g, dash, grafana.NewTimeRange("now-5m", "now"), "", report.Options{})
+		pdf, err := rep.Generate()
+		if err == nil {
+			io.Copy(ioutil.Discard, pdf)
+			pdf.Close()
+		}
+		rep.Clean()
+		//released before answering, so that the next probe finds the slot free
+		release()
+		done <- generated{err, stdtime.Since(genStart)}
+	}()
+
+	select {
+	case gen := <-done:
+		if gen.err != nil {
+			var latexErr *report.LaTeXError
+			if errors.As(gen.err, &latexErr) {
+				return fail("latex", gen.err)
+			}
+			return fail("render", gen.err)
+		}
+		result.Stages["report"] = gen.duration.Seconds()
+	case <-stdtime.After(timeout):
+		return fail("timeout", fmt.Errorf("the report took longer than %v", timeout))
+	}
+	result.Success = true
+	result.Duration = stdtime.Since(start).Seconds()
+	return result
+}
+
+// writeProbeMetrics writes the probe result in Prometheus' text format, like the blackbox exporter's probes
+func writeProbeMetrics(w io.Writer, result probeResult) {
+	success := 0
+	if result.Success {
+		success = 1
+	}
+	fmt.Fprintf(w, "# HELP probe_success Whether the probe report was generated\n# TYPE probe_success gauge\nprobe_success %d\n", success)
+	fmt.Fprintf(w, "# HELP probe_duration_seconds Time the probe took\n# TYPE probe_duration_seconds gauge\nprobe_duration_seconds %g\n", result.Duration)
+	fmt.Fprint(w, "# HELP probe_stage_duration_seconds Time each completed stage of the probe took\n# TYPE probe_stage_duration_seconds gauge\n")
+	for _, stage := range []string{"dashboard", "report"} {
+		if d, ok := result.Stages[stage]; ok {
+			fmt.Fprintf(w, "probe_stage_duration_seconds{stage=%q} %g\n", stage, d)
+		}
+	}
+}
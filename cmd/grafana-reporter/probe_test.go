@@ -0,0 +1,145 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingReport generates once unblocked
+type blockingReport struct {
+	mockReport
+	unblock chan struct{}
+}
+
+func (b blockingReport) Generate() (io.ReadCloser, error) {
+	<-b.unblock
+	return b.mockReport.Generate()
+}
+
+func TestServeProbe(t *testing.T) {
+	Convey("When probing the report pipeline", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/probe") {
+				fmt.Fprint(w, `{"dashboard":{"title":"Probe","panels":[{"type":"graph","id":1}]}}`)
+				return
+			}
+			http.Error(w, "not found", http.StatusNotFound)
+		}))
+		defer ts.Close()
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		var rep report.Report = mockReport{}
+		var repDash string
+		newReport := func(_ grafana.Client, dashName string, _ grafana.TimeRange, _ string, _ report.Options) report.Report {
+			repDash = dashName
+			return rep
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+
+		defer func(secret, dash string, timeout stdtime.Duration) {
+			*probeSecret, *probeDashboard, *probeTimeout = secret, dash, timeout
+		}(*probeSecret, *probeDashboard, *probeTimeout)
+		*probeSecret, *probeDashboard, *probeTimeout = "s3cret", "probe", 5*stdtime.Second
+
+		probe := func(query string, secret string) (*httptest.ResponseRecorder, probeResult) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/api/probe"+query, nil)
+			if secret != "" {
+				req.Header.Set("Authorization", "Bearer "+secret)
+			}
+			router.ServeHTTP(rec, req)
+			var result probeResult
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			return rec, result
+		}
+
+		Convey("Requests without the probe secret should be forbidden", func() {
+			rec, _ := probe("", "")
+			So(rec.Code, ShouldEqual, http.StatusForbidden)
+			rec, _ = probe("", "guess")
+			So(rec.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("A generated report should give 200 with the stage timings", func() {
+			rec, result := probe("", "s3cret")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(result.Success, ShouldBeTrue)
+			So(result.Dashboard, ShouldEqual, "probe")
+			So(repDash, ShouldEqual, "probe")
+			So(result.Stages, ShouldContainKey, "dashboard")
+			So(result.Stages, ShouldContainKey, "report")
+		})
+
+		Convey("A missing dashboard should fail the dashboard stage with 502", func() {
+			rec, result := probe("?dash=missing", "s3cret")
+			So(rec.Code, ShouldEqual, http.StatusBadGateway)
+			So(result.Success, ShouldBeFalse)
+			So(result.Stage, ShouldEqual, "dashboard")
+		})
+
+		Convey("Failed renders and LaTeX runs should be told apart", func() {
+			rep = errReport{errors.New("error rendering PNGs")}
+			rec, result := probe("", "s3cret")
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(result.Stage, ShouldEqual, "render")
+
+			rep = errReport{&report.LaTeXError{Err: errors.New("exit status 1")}}
+			_, result = probe("", "s3cret")
+			So(result.Stage, ShouldEqual, "latex")
+		})
+
+		Convey("A report running past the timeout should fail with 504, and block further probes until it ends", func() {
+			*probeTimeout = 50 * stdtime.Millisecond
+			unblock := make(chan struct{})
+			rep = blockingReport{unblock: unblock}
+			rec, result := probe("", "s3cret")
+			So(rec.Code, ShouldEqual, http.StatusGatewayTimeout)
+			So(result.Stage, ShouldEqual, "timeout")
+
+			rec, _ = probe("", "s3cret")
+			So(rec.Code, ShouldEqual, http.StatusTooManyRequests)
+
+			close(unblock)
+			probes <- struct{}{} //waits for the timed out report to release its slot
+			<-probes
+		})
+
+		Convey("The result should be available in Prometheus' text format", func() {
+			rec, _ := probe("?format=prometheus", "s3cret")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, "probe_success 1\n")
+			So(rec.Body.String(), ShouldContainSubstring, `probe_stage_duration_seconds{stage="report"}`)
+		})
+	})
+}
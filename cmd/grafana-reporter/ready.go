@@ -0,0 +1,182 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+)
+
+// latexStatus is the result of the LaTeX prerequisite check run at startup
+var latexStatus error
+
+// serveReady answers 200 when reports can be compiled, otherwise 503 with the missing LaTeX prerequisites
+func serveReady(w http.ResponseWriter, req *http.Request) {
+	type status struct {
+		Ready    bool     `json:"ready"`
+		Error    string   `json:"error,omitempty"`
+		Guidance []string `json:"guidance,omitempty"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if latexStatus == nil {
+		json.NewEncoder(w).Encode(status{Ready: true})
+		return
+	}
+	s := status{Error: latexStatus.Error()}
+	var latexErr *report.LaTeXError
+	if errors.As(latexStatus, &latexErr) {
+		s.Error = latexErr.Err.Error()
+		s.Guidance = latexErr.Guidance
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(s)
+}
+
+// readyzTimeout bounds each dependency check of /readyz, so a hung dependency fails the probe rather than blocking it.
+// A variable, so tests can shorten it.
+var readyzTimeout = 2 * stdtime.Second
+
+// serveHealthz answers 200 while the process serves requests, for liveness probes
+func serveHealthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// dependency is the status of one dependency checked by /readyz
+type dependency struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Error   string `json:"error,omitempty"`
+	Breaker string `json:"breaker,omitempty"` //state of the Grafana circuit breaker, when enabled
+}
+
+// serveReadyz checks the dependencies of reports: LaTeX, and Grafana by its health endpoint.
+// It answers 200 when all are ready, otherwise 503, listing each dependency with its status.
+func (h ServeReportHandler) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	deps := []dependency{{Name: "latex", Ready: latexStatus == nil}}
+	if latexStatus != nil {
+		deps[0].Error = latexStatus.Error()
+	}
+	g := h.newGrafanaClient(*proto+*ip, *serverAPIToken, url.Values{}, grafana.WithTimeout(readyzTimeout))
+	//Grafana's health endpoint answers 503 when its own database is down
+	grafanaDep := dependency{Name: "grafana", Ready: true}
+	if err := grafanaWait.pending(); err != nil {
+		grafanaDep = dependency{Name: "grafana", Error: err.Error()}
+	} else if _, err := g.GetHealth(); err != nil {
+		grafanaDep = dependency{Name: "grafana", Error: err.Error()}
+	}
+	if grafanaBreaker != nil {
+		grafanaDep.Breaker = grafanaBreaker.State().String()
+	}
+	deps = append(deps, grafanaDep)
+
+	ready := true
+	for _, d := range deps {
+		ready = ready && d.Ready
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Ready        bool         `json:"ready"`
+		Dependencies []dependency `json:"dependencies"`
+	}{ready, deps})
+}
+
+// grafanaWait is the wait for Grafana at startup, see -wait-for-grafana
+var grafanaWait = &startupWait{name: "Grafana"}
+
+// waitBackoff is the delay after the first failed attempt of a startupWait. It doubles after each attempt, up to maxWaitBackoff.
+// A variable, so tests can shorten it.
+var waitBackoff = 500 * stdtime.Millisecond
+
+const maxWaitBackoff = 30 * stdtime.Second
+
+// startupWait waits for a dependency that may start after the reporter, e.g. Grafana started by the same docker-compose.
+// While it waits, the dependency is reported as not ready.
+type startupWait struct {
+	name    string
+	mu      sync.Mutex
+	waiting bool
+	lastErr error
+}
+
+// begin marks the dependency as waited for, before the first attempt of wait
+func (s *startupWait) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waiting, s.lastErr = true, errors.New("not checked yet")
+}
+
+// pending returns an error while the wait is going on, naming the last failure
+func (s *startupWait) pending() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.waiting {
+		return nil
+	}
+	return fmt.Errorf("waiting for %v to start: %v", s.name, s.lastErr)
+}
+
+// wait calls check until it succeeds or bound has passed, sleeping with exponential backoff and jitter between the attempts.
+// It returns whether check succeeded. After the bound the reporter continues in degraded mode, with readiness checking
+// the dependency on every call.
+func (s *startupWait) wait(bound stdtime.Duration, check func() error) bool {
+	deadline := stdtime.Now().Add(bound)
+	backoff := waitBackoff
+	for attempt := 1; ; attempt++ {
+		err := check()
+		s.mu.Lock()
+		s.lastErr = err
+		if err == nil || !stdtime.Now().Before(deadline) {
+			s.waiting = false
+		}
+		s.mu.Unlock()
+		if err == nil {
+			log.Printf("%v is available after %d attempts", s.name, attempt)
+			return true
+		}
+		remaining := stdtime.Until(deadline)
+		if remaining <= 0 {
+			log.Printf("%v is still unavailable after %d attempts, continuing without it: %v", s.name, attempt, err)
+			return false
+		}
+		//jitter keeps reporters started together from retrying in lockstep
+		delay := backoff/2 + stdtime.Duration(rand.Int63n(int64(backoff/2)+1))
+		if delay > remaining {
+			delay = remaining
+		}
+		log.Printf("%v is unavailable (attempt %d), retrying in %v: %v", s.name, attempt, delay.Round(stdtime.Millisecond), err)
+		stdtime.Sleep(delay)
+		if backoff *= 2; backoff > maxWaitBackoff {
+			backoff = maxWaitBackoff
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServeReady(t *testing.T) {
+	Convey("When the readiness endpoint is called", t, func() {
+		defer func(orig error) { latexStatus = orig }(latexStatus)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/ready", nil)
+
+		Convey("It should answer 200 when LaTeX is usable", func() {
+			latexStatus = nil
+			serveReady(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, `"ready":true`)
+		})
+
+		Convey("It should answer 503 with the guidance when prerequisites are missing", func() {
+			latexStatus = &report.LaTeXError{Err: errors.New("exit status 1"), Guidance: []string{"graphicx.sty not found"}}
+			serveReady(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+			var body struct {
+				Ready    bool
+				Error    string
+				Guidance []string
+			}
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Ready, ShouldBeFalse)
+			So(body.Error, ShouldEqual, "exit status 1")
+			So(body.Guidance, ShouldResemble, []string{"graphicx.sty not found"})
+		})
+	})
+}
+
+func TestServeHealthz(t *testing.T) {
+	Convey("When the liveness endpoint is called", t, func() {
+		rec := httptest.NewRecorder()
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{})
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+		Convey("It should answer 200 without checking any dependency", func() {
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, `"status":"ok"`)
+		})
+	})
+}
+
+func TestServeReadyz(t *testing.T) {
+	Convey("When the readiness endpoint is called", t, func() {
+		defer func(orig error) { latexStatus = orig }(latexStatus)
+		latexStatus = nil
+		status := http.StatusOK
+		hang := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/health" {
+				http.NotFound(w, r)
+				return
+			}
+			if status == 0 {
+				<-hang
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"database":"ok","version":"9.5.2"}`))
+		}))
+		defer ts.Close()
+		defer close(hang)
+		newGrafanaClient := func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			return grafana.NewV5Client(ts.URL, apiToken, variables, opts...)
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{newGrafanaClient, nil, nil})
+		readyz := func() (int, map[string]dependency) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+			var body struct {
+				Ready        bool
+				Dependencies []dependency
+			}
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Ready, ShouldEqual, rec.Code == http.StatusOK)
+			deps := map[string]dependency{}
+			for _, d := range body.Dependencies {
+				deps[d.Name] = d
+			}
+			return rec.Code, deps
+		}
+
+		Convey("It should answer 200 when Grafana and LaTeX are ready", func() {
+			code, deps := readyz()
+			So(code, ShouldEqual, http.StatusOK)
+			So(deps["grafana"].Ready, ShouldBeTrue)
+			So(deps["latex"].Ready, ShouldBeTrue)
+		})
+
+		Convey("It should answer 503 naming Grafana when its health check fails", func() {
+			status = http.StatusServiceUnavailable
+			code, deps := readyz()
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(deps["grafana"].Ready, ShouldBeFalse)
+			So(deps["grafana"].Error, ShouldContainSubstring, "503")
+			So(deps["latex"].Ready, ShouldBeTrue)
+		})
+
+		Convey("It should give up on a hung Grafana after the timeout", func() {
+			defer func(orig stdtime.Duration) { readyzTimeout = orig }(readyzTimeout)
+			readyzTimeout = 50 * stdtime.Millisecond
+			status = 0
+			start := stdtime.Now()
+			code, deps := readyz()
+			So(stdtime.Since(start), ShouldBeLessThan, 2*stdtime.Second)
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(deps["grafana"].Ready, ShouldBeFalse)
+		})
+
+		Convey("It should answer 503 while waiting for Grafana at startup", func() {
+			defer func(orig *startupWait) { grafanaWait = orig }(grafanaWait)
+			grafanaWait = &startupWait{name: "Grafana"}
+			grafanaWait.begin()
+			code, deps := readyz()
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(deps["grafana"].Error, ShouldContainSubstring, "waiting for Grafana to start")
+		})
+
+		Convey("It should report the state of the circuit breaker", func() {
+			defer func(orig *grafana.Breaker) { grafanaBreaker = orig }(grafanaBreaker)
+			grafanaBreaker = grafana.NewBreaker(1, stdtime.Hour)
+			router := mux.NewRouter()
+			RegisterHandlers(router, ServeReportHandler{}, ServeReportHandler{func(_ string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+				return grafanaBreaker.Wrap(grafana.NewV5Client(ts.URL, apiToken, variables, opts...))
+			}, nil, nil})
+			readyz := func() (int, dependency) {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+				var body struct{ Dependencies []dependency }
+				So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+				return rec.Code, body.Dependencies[1]
+			}
+			code, dep := readyz()
+			So(code, ShouldEqual, http.StatusOK)
+			So(dep.Breaker, ShouldEqual, "closed")
+
+			//a connection failure opens the circuit
+			closed := httptest.NewServer(http.NotFoundHandler())
+			closed.Close()
+			grafanaBreaker.Wrap(grafana.NewV5Client(closed.URL, "", url.Values{})).GetHealth()
+			code, dep = readyz()
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(dep.Breaker, ShouldEqual, "open")
+			So(dep.Error, ShouldEqual, grafana.ErrCircuitOpen.Error())
+		})
+
+		Convey("The circuit breaker should be left out when it is disabled", func() {
+			_, deps := readyz()
+			So(deps["grafana"].Breaker, ShouldBeEmpty)
+		})
+
+		Convey("It should answer 503 naming LaTeX when its prerequisites are missing", func() {
+			latexStatus = errors.New("pdflatex not found")
+			code, deps := readyz()
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(deps["latex"].Error, ShouldEqual, "pdflatex not found")
+			So(deps["grafana"].Ready, ShouldBeTrue)
+		})
+	})
+}
+
+func TestStartupWait(t *testing.T) {
+	Convey("When waiting for a dependency at startup", t, func() {
+		defer func(orig stdtime.Duration) { waitBackoff = orig }(waitBackoff)
+		waitBackoff = stdtime.Millisecond
+		attempts, availableAfter := 0, 3
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts <= availableAfter {
+				http.Error(w, "starting", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"database":"ok"}`))
+		}))
+		defer ts.Close()
+		g := grafana.NewV5Client(ts.URL, "", url.Values{}, grafana.WithTimeout(stdtime.Second))
+		check := func() error {
+			_, err := g.GetHealth()
+			return err
+		}
+		s := &startupWait{name: "Grafana"}
+		s.begin()
+		So(s.pending(), ShouldNotBeNil)
+
+		Convey("It should retry until the dependency answers", func() {
+			So(s.wait(5*stdtime.Second, check), ShouldBeTrue)
+			So(attempts, ShouldEqual, availableAfter+1)
+			So(s.pending(), ShouldBeNil)
+		})
+
+		Convey("It should give up after the bound and stop reporting the wait", func() {
+			availableAfter = 1000
+			start := stdtime.Now()
+			So(s.wait(50*stdtime.Millisecond, check), ShouldBeFalse)
+			So(stdtime.Since(start), ShouldBeLessThan, stdtime.Second)
+			So(attempts, ShouldBeGreaterThan, 1)
+			So(s.pending(), ShouldBeNil)
+		})
+	})
+}
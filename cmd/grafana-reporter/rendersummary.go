@@ -0,0 +1,159 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// topFailingPanels is how many of the panels that failed most often a render summary names
+const topFailingPanels = 5
+
+// renderSummaries are logged every -summary-interval
+var renderSummaries = &renderSummary{}
+
+// renderSummary aggregates the panel renders of a window, for a periodic summary log line
+type renderSummary struct {
+	mu       sync.Mutex
+	outcomes map[string]int //renders by status class, e.g. "2xx", "5xx" or "connection"
+	failures map[failingPanel]int
+	titles   map[failingPanel]string
+	count    int
+	total    stdtime.Duration
+}
+
+type failingPanel struct {
+	dashboard string
+	id        int
+}
+
+// observe records a render, as a report.Hooks PanelRendered hook
+func (s *renderSummary) observe(dashName string, p grafana.Panel, d stdtime.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outcomes == nil {
+		s.outcomes = map[string]int{}
+		s.failures = map[failingPanel]int{}
+		s.titles = map[failingPanel]string{}
+	}
+	s.outcomes[statusClass(err)]++
+	s.count++
+	s.total += d
+	if err != nil {
+		key := failingPanel{dashName, p.Id}
+		s.failures[key]++
+		s.titles[key] = p.PlainTitle
+		if s.titles[key] == "" {
+			s.titles[key] = p.Title
+		}
+	}
+}
+
+// statusClass classifies a render by Grafana's answer
+func statusClass(err error) string {
+	var statusErr *grafana.StatusError
+	var connErr *grafana.ConnectionError
+	switch {
+	case err == nil:
+		return "2xx"
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("%dxx", statusErr.StatusCode/100)
+	case errors.As(err, &connErr):
+		return "connection"
+	default:
+		return "other"
+	}
+}
+
+// flush returns the summary of the window and starts a new one. It returns "" when nothing was rendered.
+func (s *renderSummary) flush(window stdtime.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 {
+		return ""
+	}
+
+	classes := make([]string, 0, len(s.outcomes))
+	for class := range s.outcomes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	counts := make([]string, len(classes))
+	for i, class := range classes {
+		counts[i] = fmt.Sprintf("%s=%d", class, s.outcomes[class])
+	}
+	line := fmt.Sprintf("Render summary for the last %v: %d panels (%s), average %v", window, s.count, strings.Join(counts, " "),
+		(s.total / stdtime.Duration(s.count)).Round(stdtime.Millisecond))
+
+	failing := make([]failingPanel, 0, len(s.failures))
+	for key := range s.failures {
+		failing = append(failing, key)
+	}
+	sort.Slice(failing, func(i, j int) bool {
+		a, b := failing[i], failing[j]
+		if s.failures[a] != s.failures[b] {
+			return s.failures[a] > s.failures[b]
+		}
+		if a.dashboard != b.dashboard {
+			return a.dashboard < b.dashboard
+		}
+		return a.id < b.id
+	})
+	if len(failing) > topFailingPanels {
+		failing = failing[:topFailingPanels]
+	}
+	if len(failing) > 0 {
+		top := make([]string, len(failing))
+		for i, key := range failing {
+			top[i] = fmt.Sprintf("%s/%d %q (%d)", key.dashboard, key.id, s.titles[key], s.failures[key])
+		}
+		line += "; most failures: " + strings.Join(top, ", ")
+	}
+
+	s.outcomes, s.failures, s.titles = nil, nil, nil
+	s.count, s.total = 0, 0
+	return line
+}
+
+// run logs a summary every interval until stop is closed, then logs the last window's summary
+func (s *renderSummary) run(interval stdtime.Duration, stop <-chan struct{}) {
+	ticker := stdtime.NewTicker(interval)
+	defer ticker.Stop()
+	start := stdtime.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			if line := s.flush(interval); line != "" {
+				log.Println(line)
+			}
+			start = now
+		case <-stop:
+			if line := s.flush(stdtime.Since(start).Round(stdtime.Second)); line != "" {
+				log.Println(line)
+			}
+			return
+		}
+	}
+}
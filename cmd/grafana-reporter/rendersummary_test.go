@@ -0,0 +1,68 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderSummary(t *testing.T) {
+	Convey("When panel renders are summarized", t, func() {
+		s := &renderSummary{}
+		serverErr := fmt.Errorf("error getting panel: %w", &grafana.StatusError{StatusCode: 503, Status: "503 Service Unavailable"})
+		notFound := &grafana.StatusError{StatusCode: 404, Status: "404 Not Found"}
+		connErr := &grafana.ConnectionError{Op: "getPanelPng", URL: "http://grafana", Err: errors.New("connection refused")}
+
+		Convey("An empty window should not be summarized", func() {
+			So(s.flush(stdtime.Minute), ShouldEqual, "")
+		})
+
+		Convey("Renders should be counted by status class and averaged", func() {
+			s.observe("abc", grafana.Panel{Id: 1}, 1*stdtime.Second, nil)
+			s.observe("abc", grafana.Panel{Id: 1}, 3*stdtime.Second, nil)
+			s.observe("abc", grafana.Panel{Id: 2, Title: "CPU"}, 2*stdtime.Second, serverErr)
+			s.observe("abc", grafana.Panel{Id: 3, Title: "Disk", PlainTitle: "Disk space"}, 0, notFound)
+			s.observe("def", grafana.Panel{Id: 2, Title: "Memory"}, 4*stdtime.Second, connErr)
+			s.observe("def", grafana.Panel{Id: 4}, 2*stdtime.Second, errors.New("disk full"))
+
+			So(s.flush(5*stdtime.Minute), ShouldEqual, `Render summary for the last 5m0s: 6 panels (2xx=2 4xx=1 5xx=1 connection=1 other=1), average 2s; `+
+				`most failures: abc/2 "CPU" (1), abc/3 "Disk space" (1), def/2 "Memory" (1), def/4 "" (1)`)
+
+			Convey("And the next window should start empty", func() {
+				So(s.flush(5*stdtime.Minute), ShouldEqual, "")
+			})
+		})
+
+		Convey("Only the panels that failed most should be named", func() {
+			for id := 1; id <= 7; id++ {
+				for i := 0; i < id; i++ {
+					s.observe("abc", grafana.Panel{Id: id}, stdtime.Second, serverErr)
+				}
+			}
+			s.observe("abc", grafana.Panel{Id: 8}, 1500*stdtime.Millisecond, nil)
+
+			So(s.flush(stdtime.Minute), ShouldEqual, `Render summary for the last 1m0s: 29 panels (2xx=1 5xx=28), average 1.017s; `+
+				`most failures: abc/7 "" (7), abc/6 "" (6), abc/5 "" (5), abc/4 "" (4), abc/3 "" (3)`)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/report"
+)
+
+var shutdownTimeout = flag.Duration("shutdown-timeout", 2*stdtime.Minute, "Time the reporter waits on SIGINT or SIGTERM for the reports being generated to finish. Reports still running then are aborted")
+
+// draining is set while the reporter shuts down
+var draining int32
+
+// rejectWhileDraining answers requests with 503 once the reporter shuts down, e.g. those arriving on kept-alive
+// connections, so clients retry against another instance
+func rejectWhileDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&draining) != 0 {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "10")
+			writeJSONError(w, http.StatusServiceUnavailable, "the reporter is shutting down")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// serveUntil serves handler with srv until a signal arrives on stop. It then waits for the requests being
+// served for at most timeout, aborts those still running and removes the build directories left behind.
+// It returns the error of the server, nil after shutting down.
+func serveUntil(srv *http.Server, l net.Listener, stop <-chan os.Signal, timeout stdtime.Duration) error {
+	base, abort := context.WithCancel(context.Background())
+	defer abort()
+	srv.Handler = rejectWhileDraining(srv.Handler)
+	srv.BaseContext = func(net.Listener) context.Context { return base }
+
+	served := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			//the certificate comes from the TLSConfig
+			served <- srv.ServeTLS(l, "", "")
+			return
+		}
+		served <- srv.Serve(l)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %v, waiting up to %v for %d reports to finish", sig, timeout, report.ActiveBuildDirs())
+	}
+
+	atomic.StoreInt32(&draining, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Println("Shutdown timeout exceeded, aborting the reports still running")
+		//canceling the requests' contexts kills their pdflatex processes
+		abort()
+		srv.Close()
+	} else if err != nil {
+		log.Println("Error shutting down:", err)
+	}
+	if n := report.RemoveBuildDirs(); n > 0 {
+		log.Printf("Removed %d report build directories", n)
+	}
+	log.Println("Reporter stopped")
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	stdtime "time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRejectWhileDraining(t *testing.T) {
+	Convey("When the reporter shuts down", t, func() {
+		defer atomic.StoreInt32(&draining, 0)
+		h := rejectWhileDraining(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+		Convey("Requests should be served until it drains", func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v5/report/testDash", nil))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("New requests should be answered with 503 while it drains", func() {
+			atomic.StoreInt32(&draining, 1)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v5/report/testDash", nil))
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(rec.Header().Get("Retry-After"), ShouldNotBeEmpty)
+			So(rec.Body.String(), ShouldContainSubstring, "shutting down")
+		})
+	})
+}
+
+func TestServeUntil(t *testing.T) {
+	Convey("When the reporter is signalled to stop while a report is generated", t, func() {
+		defer atomic.StoreInt32(&draining, 0)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		started, release := make(chan struct{}), make(chan struct{})
+		aborted := make(chan bool, 1)
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			close(started)
+			select {
+			case <-release:
+				w.Write([]byte("report"))
+			case <-req.Context().Done():
+				aborted <- true
+			}
+		})}
+		stop := make(chan os.Signal, 1)
+		type result struct {
+			body string
+			err  error
+		}
+		responses := make(chan result, 1)
+		get := func() {
+			resp, err := http.Get("http://" + l.Addr().String() + "/api/v5/report/testDash")
+			if err != nil {
+				responses <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			responses <- result{string(body), err}
+		}
+
+		Convey("It should wait for the report to finish", func() {
+			stopped := make(chan error, 1)
+			go func() { stopped <- serveUntil(srv, l, stop, stdtime.Minute) }()
+			go get()
+			<-started
+			stop <- syscall.SIGTERM
+			stdtime.Sleep(50 * stdtime.Millisecond)
+			So(atomic.LoadInt32(&draining), ShouldEqual, 1)
+			close(release)
+			r := <-responses
+			So(r.err, ShouldBeNil)
+			So(r.body, ShouldEqual, "report")
+			So(<-stopped, ShouldBeNil)
+		})
+
+		Convey("It should abort a report that doesn't finish within the shutdown timeout", func() {
+			stopped := make(chan error, 1)
+			go func() { stopped <- serveUntil(srv, l, stop, 50*stdtime.Millisecond) }()
+			go get()
+			<-started
+			stop <- syscall.SIGTERM
+			So(<-stopped, ShouldBeNil)
+			So(<-aborted, ShouldBeTrue)
+			<-responses
+		})
+	})
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/IzakMarais/reporter/report"
+)
+
+// declaredTemplate returns the template selected by the request, like texTemplate, with the parameters it declares.
+// Requests without template or style use the declarations of the -default-template.
+// Declared defaults other than extras are added to the request's query where missing, and added reports whether any were.
+func declaredTemplate(req *http.Request) (tex string, params report.TemplateParams, added bool, err error) {
+	return declaredTemplateOr(req, report.DefaultTemplateParams())
+}
+
+// declaredPlaylistTemplate is declaredTemplate for playlist reports. Without a template they use the built-in
+// multi-dashboard template, which declares nothing, rather than the -default-template.
+func declaredPlaylistTemplate(req *http.Request) (tex string, params report.TemplateParams, err error) {
+	tex, params, _, err = declaredTemplateOr(req, report.TemplateParams{})
+	return tex, params, err
+}
+
+// declaredTemplateOr is declaredTemplate with builtin as the declarations of requests without template or style
+func declaredTemplateOr(req *http.Request, builtin report.TemplateParams) (tex string, params report.TemplateParams, added bool, err error) {
+	tex, err = texTemplate(req)
+	if err != nil {
+		return "", params, false, err
+	}
+	query := req.URL.Query()
+	switch {
+	case tex != "":
+		params, err = report.ParseTemplateParams(tex)
+		if err != nil {
+			return "", params, false, fmt.Errorf("template %v: %w", query.Get("template"), err)
+		}
+	case query.Get("style") == "":
+		params = builtin
+	}
+	if mergeTemplateDefaults(query, params.Defaults) {
+		req.URL.RawQuery = query.Encode()
+		added = true
+	}
+	return tex, params, added, nil
+}
+
+// mergeTemplateDefaults adds the defaults missing from query, except extras, and reports whether any were added
+func mergeTemplateDefaults(query url.Values, defaults url.Values) bool {
+	added := false
+	for key, values := range defaults {
+		if _, ok := query[key]; ok || strings.HasPrefix(key, "extra-") {
+			continue
+		}
+		log.Printf("Using template default %v=%v", key, values[0])
+		query[key] = values
+		added = true
+	}
+	return added
+}
+
+// missingTemplateParams adds the declared extra defaults to opts where the request gave none, since extras may also
+// come from the request body. It returns the required parameters the request lacks, empty values count as missing.
+func missingTemplateParams(req *http.Request, params report.TemplateParams, opts *report.Options) []string {
+	for key, values := range params.Defaults {
+		name := strings.TrimPrefix(key, "extra-")
+		if name == key {
+			continue
+		}
+		if _, ok := opts.Extras[name]; !ok {
+			if opts.Extras == nil {
+				opts.Extras = map[string]string{}
+			}
+			opts.Extras[name] = values[0]
+		}
+	}
+	query := req.URL.Query()
+	var missing []string
+	for _, key := range params.Required {
+		if name := strings.TrimPrefix(key, "extra-"); name != key {
+			if opts.Extras[name] == "" {
+				missing = append(missing, key)
+			}
+			continue
+		}
+		if query.Get(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// writeMissingParams rejects a request that lacks parameters required by its template, listing them
+func writeMissingParams(w http.ResponseWriter, missing []string) {
+	msg := "missing parameters required by the template: " + strings.Join(missing, ", ")
+	log.Println("Rejecting request:", msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(struct {
+		Error   string   `json:"error"`
+		Missing []string `json:"missing"`
+	}{msg, missing})
+}
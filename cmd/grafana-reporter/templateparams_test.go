@@ -0,0 +1,152 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTemplateParams(t *testing.T) {
+	Convey("When a report uses a template that declares parameters", t, func() {
+		dir, err := ioutil.TempDir("", "templates")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer func(d string) { *templateDir = d }(*templateDir)
+		*templateDir = dir
+		So(ioutil.WriteFile(filepath.Join(dir, "invoice.tex"), []byte("%% reporter: require extra-customer, require var-region\n%% reporter: default panelsPerPage=2, default extra-currency=EUR, default var-env=prod\n[[.Title]]\n"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "broken.tex"), []byte("%% reporter: require\n[[.Title]]\n"), 0644), ShouldBeNil)
+
+		var clVars url.Values
+		newGrafanaClient := func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+			clVars = variables
+			return grafana.NewV5Client(url, apiToken, variables, opts...)
+		}
+		var repOpts report.Options
+		var called bool
+		newReport := func(_ grafana.Client, _ string, _ grafana.TimeRange, _ string, opts report.Options) report.Report {
+			called, repOpts = true, opts
+			return &mockReport{}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+		serve := func(target string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", target, nil)
+			if body != "" {
+				req = httptest.NewRequest("POST", target, strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("Declared defaults should apply where the request gives none", func() {
+			serve("/api/v5/report/testDash?template=invoice&extra-customer=ACME&var-region=eu", "")
+			So(called, ShouldBeTrue)
+			So(repOpts.PanelsPerPage, ShouldEqual, 2)
+			So(repOpts.Extras, ShouldResemble, map[string]string{"customer": "ACME", "currency": "EUR"})
+			So(clVars, ShouldResemble, url.Values{"var-region": {"eu"}, "var-env": {"prod"}})
+		})
+
+		Convey("The request should win over declared defaults", func() {
+			serve("/api/v5/report/testDash?template=invoice&var-region=eu&panelsPerPage=4&var-env=dev", `{"extras": {"customer": "ACME", "currency": "USD"}}`)
+			So(called, ShouldBeTrue)
+			So(repOpts.PanelsPerPage, ShouldEqual, 4)
+			So(repOpts.Extras, ShouldResemble, map[string]string{"customer": "ACME", "currency": "USD"})
+			So(clVars, ShouldResemble, url.Values{"var-region": {"eu"}, "var-env": {"dev"}})
+		})
+
+		Convey("Requests missing required parameters should be rejected with 400 listing them", func() {
+			rec := serve("/api/v5/report/testDash?template=invoice&var-region=", "")
+			So(called, ShouldBeFalse)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			var body struct {
+				Error   string
+				Missing []string
+			}
+			So(json.NewDecoder(rec.Body).Decode(&body), ShouldBeNil)
+			So(body.Missing, ShouldResemble, []string{"extra-customer", "var-region"})
+			So(body.Error, ShouldContainSubstring, "extra-customer, var-region")
+		})
+
+		Convey("Playlist reports should check the declarations too", func() {
+			rec := serve("/api/report/playlist/1?template=invoice&extra-customer=ACME", "")
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, `"missing":["var-region"]`)
+		})
+
+		Convey("Playlist reports should only check the declarations of a template they name", func() {
+			builtin := report.TemplateParams{Required: []string{"extra-customer"}}
+			_, params, _, err := declaredTemplateOr(httptest.NewRequest("GET", "/api/v5/report/testDash", nil), builtin)
+			So(err, ShouldBeNil)
+			So(params, ShouldResemble, builtin)
+
+			//without a template they use the multi-dashboard template, not the -default-template
+			_, params, err = declaredPlaylistTemplate(httptest.NewRequest("GET", "/api/report/playlist/1", nil))
+			So(err, ShouldBeNil)
+			So(params, ShouldResemble, report.TemplateParams{})
+			_, params, err = declaredPlaylistTemplate(httptest.NewRequest("GET", "/api/report/playlist/1?template=invoice", nil))
+			So(err, ShouldBeNil)
+			So(params.Required, ShouldResemble, []string{"extra-customer", "var-region"})
+		})
+
+		Convey("A template with malformed declarations should be rejected with 400", func() {
+			rec := serve("/api/v5/report/testDash?template=broken", "")
+			So(called, ShouldBeFalse)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "template broken: invalid reporter declaration on line 1")
+		})
+
+		Convey("Built-in styles should declare nothing", func() {
+			rec := serve("/api/v5/report/testDash?style=plain", "")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(called, ShouldBeTrue)
+		})
+	})
+}
+
+func TestMissingCLIParams(t *testing.T) {
+	Convey("When a command line report's template declares parameters", t, func() {
+		params := report.TemplateParams{
+			Required: []string{"var-region", "extra-customer"},
+			Defaults: url.Values{"var-env": {"prod"}, "panelsPerPage": {"2"}},
+		}
+
+		Convey("Variable defaults should be added, and other required parameters can never be given", func() {
+			vars := url.Values{"var-region": {"eu"}, "var-env": {"dev"}}
+			So(missingCLIParams(vars, params), ShouldResemble, []string{"extra-customer"})
+			So(vars, ShouldResemble, url.Values{"var-region": {"eu"}, "var-env": {"dev"}})
+
+			vars = url.Values{}
+			So(missingCLIParams(vars, params), ShouldResemble, []string{"var-region", "extra-customer"})
+			So(vars, ShouldResemble, url.Values{"var-env": {"prod"}})
+		})
+	})
+}
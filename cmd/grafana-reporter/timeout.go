@@ -0,0 +1,97 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/report"
+)
+
+var reportTimeout = flag.Duration("report-timeout", 10*stdtime.Minute, "Time after which generating a report is aborted and answered with 504. Requests can ask for less with the timeout parameter. 0 disables the timeout")
+
+// reportDeadlineKey holds the reportDeadline of requests bounded by withReportTimeout
+type reportDeadlineKey struct{}
+
+// reportDeadline is the timeout applied to a request, and its context before the timeout
+type reportDeadline struct {
+	parent  context.Context
+	timeout stdtime.Duration
+}
+
+// withReportTimeout bounds generating the request's report by the -report-timeout flag, or by the timeout
+// parameter when that is shorter. It fails for invalid timeout parameters.
+// The returned request's context ends at the deadline, deliveryContext returns the unbounded one.
+func withReportTimeout(req *http.Request) (*http.Request, context.CancelFunc, error) {
+	timeout := *reportTimeout
+	if s := req.URL.Query().Get("timeout"); s != "" {
+		d, err := stdtime.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return req, func() {}, fmt.Errorf("invalid timeout %q, expected a positive duration like 90s or 5m", s)
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout <= 0 {
+		return req, func() {}, nil
+	}
+	parent := req.Context()
+	ctx, cancel := context.WithTimeout(context.WithValue(parent, reportDeadlineKey{}, reportDeadline{parent, timeout}), timeout)
+	return req.WithContext(ctx), cancel, nil
+}
+
+// deliveryContext returns the request's context without the deadline of withReportTimeout,
+// so a report generated in time is not cut off while it is downloaded
+func deliveryContext(req *http.Request) context.Context {
+	if d, ok := req.Context().Value(reportDeadlineKey{}).(reportDeadline); ok {
+		return d.parent
+	}
+	return req.Context()
+}
+
+// reportTimedOut reports whether the report of req was aborted by withReportTimeout's deadline,
+// rather than by the client disconnecting
+func reportTimedOut(req *http.Request) bool {
+	return errors.Is(req.Context().Err(), context.DeadlineExceeded) && deliveryContext(req).Err() == nil
+}
+
+// writeTimeoutError answers a request whose report timed out with 504, naming the phase that did not finish
+func writeTimeoutError(w http.ResponseWriter, req *http.Request, err error) {
+	phase := ""
+	var abortedErr *report.AbortedError
+	if errors.As(err, &abortedErr) {
+		phase = abortedErr.Phase
+	}
+	d, _ := req.Context().Value(reportDeadlineKey{}).(reportDeadline)
+	log.Printf("Report generation timed out during %v: %v", phase, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		Phase   string `json:"phase,omitempty"`
+		Timeout string `json:"timeout"`
+	}{fmt.Sprintf("report generation did not finish within %v", d.timeout), phase, d.timeout.String()})
+}
@@ -0,0 +1,139 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	stdtime "time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stuckReport blocks generating until its context ends, like a render that never finishes
+type stuckReport struct {
+	ctx     context.Context
+	cleaned *bool
+}
+
+func (r stuckReport) Generate() (io.ReadCloser, error) {
+	<-r.ctx.Done()
+	return nil, &report.AbortedError{Phase: report.PhaseRendering, Err: r.ctx.Err()}
+}
+
+func (r stuckReport) Clean() { *r.cleaned = true }
+
+func TestWithReportTimeout(t *testing.T) {
+	Convey("When the timeout of a report is set", t, func() {
+		defer func(d stdtime.Duration) { *reportTimeout = d }(*reportTimeout)
+		*reportTimeout = stdtime.Minute
+		timeout := func(target string) (stdtime.Duration, error) {
+			req, cancel, err := withReportTimeout(httptest.NewRequest("GET", target, nil))
+			defer cancel()
+			deadline, ok := req.Context().Deadline()
+			if !ok {
+				return 0, err
+			}
+			return stdtime.Until(deadline).Round(stdtime.Second), err
+		}
+
+		Convey("The -report-timeout flag should apply by default", func() {
+			d, err := timeout("/api/v5/report/testDash")
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, stdtime.Minute)
+		})
+
+		Convey("The timeout parameter should shorten it, but not extend it", func() {
+			d, err := timeout("/api/v5/report/testDash?timeout=30s")
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 30*stdtime.Second)
+			d, err = timeout("/api/v5/report/testDash?timeout=1h")
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, stdtime.Minute)
+		})
+
+		Convey("Without a -report-timeout only the timeout parameter should apply", func() {
+			*reportTimeout = 0
+			d, err := timeout("/api/v5/report/testDash")
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 0)
+			d, err = timeout("/api/v5/report/testDash?timeout=1h")
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, stdtime.Hour)
+		})
+
+		Convey("Invalid timeout parameters should fail", func() {
+			for _, s := range []string{"abc", "10", "-5s", "0s"} {
+				_, err := timeout("/api/v5/report/testDash?timeout=" + s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("The delivery context should not have the deadline", func() {
+			req, cancel, err := withReportTimeout(httptest.NewRequest("GET", "/api/v5/report/testDash", nil))
+			So(err, ShouldBeNil)
+			cancel()
+			So(req.Context().Err(), ShouldNotBeNil)
+			So(deliveryContext(req).Err(), ShouldBeNil)
+		})
+	})
+}
+
+func TestReportTimeout(t *testing.T) {
+	Convey("When a report doesn't finish before its timeout", t, func() {
+		cleaned := false
+		newReport := func(_ grafana.Client, _ string, _ grafana.TimeRange, _ string, opts report.Options) report.Report {
+			return stuckReport{opts.Context, &cleaned}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v5/report/testDash?timeout=50ms", nil))
+
+		Convey("It should be answered with 504 naming the phase that timed out", func() {
+			So(rec.Code, ShouldEqual, http.StatusGatewayTimeout)
+			var body struct {
+				Error   string
+				Phase   string
+				Timeout string
+			}
+			So(json.NewDecoder(rec.Body).Decode(&body), ShouldBeNil)
+			So(body.Phase, ShouldEqual, report.PhaseRendering)
+			So(body.Timeout, ShouldEqual, "50ms")
+			So(body.Error, ShouldContainSubstring, "did not finish within 50ms")
+		})
+
+		Convey("Its files should be removed", func() {
+			So(cleaned, ShouldBeTrue)
+		})
+	})
+
+	Convey("An invalid timeout parameter should be rejected with 400", t, func() {
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{grafana.NewV5Client, nil, nil})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v5/report/testDash?timeout=soon", nil))
+		So(rec.Code, ShouldEqual, http.StatusBadRequest)
+	})
+}
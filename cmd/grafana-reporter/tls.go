@@ -0,0 +1,92 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+var certFile = flag.String("cert", "", "PEM certificate file, with its intermediates, to serve HTTPS with. Needs -key. Reloaded on SIGHUP")
+var keyFile = flag.String("key", "", "PEM private key file of -cert")
+var minTLSVersion = flag.String("min-tls-version", "1.2", "Oldest TLS version accepted with -cert: 1.0, 1.1, 1.2 or 1.3")
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader serves the certificate of a cert and key file, reloading them on demand so rotated certificates
+// are picked up without a restart
+type certReloader struct {
+	certFile, keyFile string
+	mu                sync.RWMutex
+	cert              *tls.Certificate
+}
+
+// newCertReloader loads the certificate of the files, failing if they can't be loaded
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the files again. When that fails, the certificate loaded before is kept.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate %v with key %v: %w", r.certFile, r.keyFile, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reloadOn reloads the certificate whenever a signal arrives on signals, logging failures
+func (r *certReloader) reloadOn(signals <-chan os.Signal) {
+	for sig := range signals {
+		if err := r.reload(); err != nil {
+			log.Printf("Received %v, keeping the current certificate: %v", sig, err)
+			continue
+		}
+		log.Printf("Received %v, reloaded certificate %v", sig, r.certFile)
+	}
+}
+
+// tlsConfig returns the TLS configuration serving the reloader's certificate
+func tlsConfig(r *certReloader, minVersion string) (*tls.Config, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid -min-tls-version %q, expected 1.0, 1.1, 1.2 or 1.3", minVersion)
+	}
+	return &tls.Config{MinVersion: version, GetCertificate: r.getCertificate}, nil
+}
@@ -0,0 +1,150 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	stdtime "time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 with the serial number to certFile and its key to keyFile
+func writeTestCert(certFile string, keyFile string, serial int64) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "reporter"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    stdtime.Now().Add(-stdtime.Hour),
+		NotAfter:     stdtime.Now().Add(stdtime.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+func TestCertReloader(t *testing.T) {
+	Convey("When the reporter serves HTTPS", t, func() {
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+		So(writeTestCert(certFile, keyFile, 1), ShouldBeNil)
+		certs, err := newCertReloader(certFile, keyFile)
+		So(err, ShouldBeNil)
+		serial := func() int64 {
+			cert, err := certs.getCertificate(nil)
+			So(err, ShouldBeNil)
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			So(err, ShouldBeNil)
+			return leaf.SerialNumber.Int64()
+		}
+
+		Convey("A rotated certificate should be served after a reload", func() {
+			So(serial(), ShouldEqual, 1)
+			So(writeTestCert(certFile, keyFile, 2), ShouldBeNil)
+			So(serial(), ShouldEqual, 1)
+			So(certs.reload(), ShouldBeNil)
+			So(serial(), ShouldEqual, 2)
+		})
+
+		Convey("A certificate that can't be loaded should keep the current one", func() {
+			So(ioutil.WriteFile(keyFile, []byte("not a key"), 0600), ShouldBeNil)
+			So(certs.reload(), ShouldNotBeNil)
+			So(serial(), ShouldEqual, 1)
+		})
+
+		Convey("SIGHUP should reload the certificate", func() {
+			hup := make(chan os.Signal)
+			defer close(hup)
+			go certs.reloadOn(hup)
+			So(writeTestCert(certFile, keyFile, 3), ShouldBeNil)
+			hup <- syscall.SIGHUP
+			//the send returns once the reload started, the next send once it finished
+			hup <- syscall.SIGHUP
+			So(serial(), ShouldEqual, 3)
+		})
+
+		Convey("Missing files should fail at startup", func() {
+			_, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Requests should be served with the minimum TLS version", func() {
+			cfg, err := tlsConfig(certs, "1.3")
+			So(err, ShouldBeNil)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			srv := &http.Server{TLSConfig: cfg, Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte("report"))
+			})}
+			stop := make(chan os.Signal, 1)
+			stopped := make(chan error, 1)
+			go func() { stopped <- serveUntil(srv, l, stop, stdtime.Second) }()
+			defer func() {
+				stop <- syscall.SIGTERM
+				<-stopped
+				atomic.StoreInt32(&draining, 0)
+			}()
+			get := func(maxVersion uint16) (*http.Response, error) {
+				client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion}}}
+				return client.Get("https://" + l.Addr().String() + "/")
+			}
+
+			resp, err := get(tls.VersionTLS13)
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldEqual, "report")
+			So(resp.TLS.Version, ShouldEqual, tls.VersionTLS13)
+
+			_, err = get(tls.VersionTLS12)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Unknown TLS versions should fail", func() {
+			_, err := tlsConfig(certs, "1.4")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
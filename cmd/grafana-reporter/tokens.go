@@ -0,0 +1,114 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// tokenProvider chooses the Grafana api token of a request
+type tokenProvider interface {
+	token(r *http.Request) (string, error)
+}
+
+// tokens provides the api tokens of all requests. Set from the -tokens flag at startup.
+var tokens tokenProvider = requestTokens{}
+
+// requestTokens uses the request's apitoken parameter, falling back to the token configured with -api-token
+type requestTokens struct{}
+
+func (requestTokens) token(r *http.Request) (string, error) {
+	return apiToken(r), nil
+}
+
+// namedTokens keeps Grafana api tokens on the server, so that users select them by name with the tokenName parameter
+// instead of knowing them. Requests without tokenName must pass their own apitoken: falling back to the -api-token
+// would let every user around the allowlist.
+type namedTokens struct {
+	Tokens map[string]string   `json:"tokens"` //api tokens by name
+	Users  map[string][]string `json:"users"`  //names of the tokens each user may use
+	//userHeader names the request header with the authenticated user, set by the authenticating proxy in front of the reporter
+	userHeader string
+}
+
+// tokenDeniedError is returned for token names the requesting user may not use
+type tokenDeniedError struct {
+	user string
+	name string
+}
+
+func (e *tokenDeniedError) Error() string {
+	if e.user == "" {
+		return fmt.Sprintf("token %q requires an authenticated user", e.name)
+	}
+	return fmt.Sprintf("user %q may not use token %q", e.user, e.name)
+}
+
+// errTokenRequired is returned for requests with neither tokenName nor apitoken when tokens are selected by name
+var errTokenRequired = errors.New("select a token with tokenName or pass an apitoken")
+
+// loadNamedTokens reads a JSON file of the form {"tokens": {"ops": "glsa_..."}, "users": {"alice": ["ops"]}}
+func loadNamedTokens(path string, userHeader string) (*namedTokens, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tokens file: %v", err)
+	}
+	t := &namedTokens{userHeader: userHeader}
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, fmt.Errorf("error parsing tokens file %v: %v", path, err)
+	}
+	for user, names := range t.Users {
+		for _, name := range names {
+			if _, ok := t.Tokens[name]; !ok {
+				return nil, fmt.Errorf("tokens file %v allows user %q the unknown token %q", path, user, name)
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *namedTokens) token(r *http.Request) (string, error) {
+	name := r.URL.Query().Get("tokenName")
+	if name == "" {
+		if token := r.URL.Query().Get("apitoken"); token != "" {
+			return token, nil
+		}
+		return "", errTokenRequired
+	}
+	user := r.Header.Get(t.userHeader)
+	if user == "" || !t.allowed(user, name) {
+		//unknown names are denied like forbidden ones, so that users can't probe for them
+		return "", &tokenDeniedError{user, name}
+	}
+	log.Printf("Called with token %q of user %q", name, user)
+	return t.Tokens[name], nil
+}
+
+// allowed reports whether user may use the token name
+func (t *namedTokens) allowed(user, name string) bool {
+	for _, allowed := range t.Users[user] {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestTokens(t *testing.T) {
+	Convey("When tokens come with the requests", t, func() {
+		Convey("The apitoken parameter should be used", func() {
+			token, err := requestTokens{}.token(httptest.NewRequest("GET", "/api/v5/report/testDash?apitoken=1234", nil))
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "1234")
+		})
+
+		Convey("The server token should be used without a parameter", func() {
+			*serverAPIToken = "server"
+			defer func() { *serverAPIToken = "" }()
+			token, err := requestTokens{}.token(httptest.NewRequest("GET", "/api/v5/report/testDash", nil))
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "server")
+		})
+	})
+}
+
+func TestNamedTokens(t *testing.T) {
+	Convey("When tokens are selected by name", t, func() {
+		dir, err := ioutil.TempDir("", "tokens")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "tokens.json")
+		So(ioutil.WriteFile(path, []byte(`{"tokens": {"ops": "ops-secret", "finance": "finance-secret"}, "users": {"alice": ["ops", "finance"], "bob": ["ops"]}}`), 0600), ShouldBeNil)
+		provider, err := loadNamedTokens(path, "X-Webauth-User")
+		So(err, ShouldBeNil)
+
+		request := func(query string, user string) *http.Request {
+			req := httptest.NewRequest("GET", "/api/v5/report/testDash"+query, nil)
+			if user != "" {
+				req.Header.Set("X-Webauth-User", user)
+			}
+			return req
+		}
+
+		Convey("Users should get the tokens they are allowed to use", func() {
+			token, err := provider.token(request("?tokenName=finance", "alice"))
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "finance-secret")
+		})
+
+		Convey("Tokens not allowed to the user should be denied", func() {
+			_, err := provider.token(request("?tokenName=finance", "bob"))
+			So(err, ShouldHaveSameTypeAs, &tokenDeniedError{})
+			So(err.Error(), ShouldEqual, `user "bob" may not use token "finance"`)
+		})
+
+		Convey("Unknown token names should be denied like forbidden ones", func() {
+			_, err := provider.token(request("?tokenName=admin", "alice"))
+			So(err, ShouldHaveSameTypeAs, &tokenDeniedError{})
+		})
+
+		Convey("Requests without an authenticated user should be denied", func() {
+			_, err := provider.token(request("?tokenName=ops", ""))
+			So(err, ShouldHaveSameTypeAs, &tokenDeniedError{})
+			So(err.Error(), ShouldContainSubstring, "requires an authenticated user")
+		})
+
+		Convey("Requests without tokenName should use their own token", func() {
+			token, err := provider.token(request("?apitoken=1234", "bob"))
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "1234")
+		})
+
+		Convey("Requests without tokenName or apitoken should not get the server token", func() {
+			defer func(orig string) { *serverAPIToken = orig }(*serverAPIToken)
+			*serverAPIToken = "server-secret"
+			token, err := provider.token(request("", "mallory"))
+			So(err, ShouldEqual, errTokenRequired)
+			So(token, ShouldBeEmpty)
+		})
+
+		Convey("Allowing unknown tokens should fail loading", func() {
+			So(ioutil.WriteFile(path, []byte(`{"tokens": {"ops": "x"}, "users": {"bob": ["opps"]}}`), 0600), ShouldBeNil)
+			_, err := loadNamedTokens(path, "X-Webauth-User")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Malformed and missing files should fail loading", func() {
+			So(ioutil.WriteFile(path, []byte(`tokens: {}`), 0600), ShouldBeNil)
+			_, err := loadNamedTokens(path, "X-Webauth-User")
+			So(err, ShouldNotBeNil)
+			_, err = loadNamedTokens(filepath.Join(dir, "absent.json"), "X-Webauth-User")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The report handler should create its clients with the selected token", func() {
+			defer func(p tokenProvider) { tokens = p }(tokens)
+			tokens = provider
+			var clAPIToken string
+			newGrafanaClient := func(url string, apiToken string, variables url.Values, opts ...grafana.ClientOption) grafana.Client {
+				clAPIToken = apiToken
+				return grafana.NewV5Client(url, apiToken, variables, opts...)
+			}
+			generated := false
+			newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+				generated = true
+				return &mockReport{}
+			}
+			router := mux.NewRouter()
+			RegisterHandlers(router, ServeReportHandler{nil, nil, nil}, ServeReportHandler{newGrafanaClient, newReport, nil})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, request("?tokenName=ops", "bob"))
+			So(clAPIToken, ShouldEqual, "ops-secret")
+
+			Convey("and deny the report for forbidden tokens without creating a client", func() {
+				clAPIToken, generated = "", false
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, request("?tokenName=finance", "bob"))
+				So(rec.Code, ShouldEqual, http.StatusForbidden)
+				So(clAPIToken, ShouldBeEmpty)
+				So(generated, ShouldBeFalse)
+			})
+
+			Convey("and deny requests without tokenName instead of using the server token", func() {
+				defer func(orig string) { *serverAPIToken = orig }(*serverAPIToken)
+				*serverAPIToken = "server-secret"
+				clAPIToken, generated = "", false
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, request("", "mallory"))
+				So(rec.Code, ShouldEqual, http.StatusForbidden)
+				So(clAPIToken, ShouldBeEmpty)
+				So(generated, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/IzakMarais/reporter/report"
+)
+
+// setSignatureHeaders signs the report with report.SigningKey and rewinds it
+func setSignatureHeaders(h http.Header, file io.Reader) error {
+	rs, ok := file.(io.ReadSeeker)
+	if !ok {
+		return errors.New("the report can't be signed, it can't be read twice")
+	}
+	sig, err := report.SignReader(report.SigningKey, rs)
+	if err != nil {
+		return fmt.Errorf("error signing report: %v", err)
+	}
+	h.Set("X-Report-Signature", base64.StdEncoding.EncodeToString(sig))
+	h.Set("X-Report-Signature-Algorithm", report.SignatureAlgorithm(report.SigningKey.Public()))
+	return nil
+}
+
+// runVerify implements grafana-reporter verify -key public.pem [-sig report.pdf.sig] report.pdf.
+// It fails unless the signature of the report matches the public key.
+func runVerify(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(out)
+	keyFile := fs.String("key", "", "PEM file of the public key of the reporter's -signing-key")
+	sigFile := fs.String("sig", "", "File of the detached signature, raw or base64 encoded as in the X-Report-Signature header. The report's name with .sig appended when empty")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: grafana-reporter verify -key public.pem [-sig report.pdf.sig] report.pdf")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("verify needs a -key and the report")
+	}
+	name := fs.Arg(0)
+	if *sigFile == "" {
+		*sigFile = name + ".sig"
+	}
+
+	key, err := report.LoadPublicKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	sig, err := ioutil.ReadFile(*sigFile)
+	if err != nil {
+		return err
+	}
+	//a base64 signature, e.g. copied from the header, is decoded. Raw signatures are practically never valid base64
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err == nil {
+		sig = decoded
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := report.Verify(key, f, sig); err != nil {
+		return fmt.Errorf("%v: %v", name, err)
+	}
+	fmt.Fprintf(out, "%v: signature OK (%v)\n", name, report.SignatureAlgorithm(key))
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	"github.com/IzakMarais/reporter/report"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSignedReports(t *testing.T) {
+	Convey("When a signed report is requested", t, func() {
+		defer func(orig crypto.Signer) { report.SigningKey = orig }(report.SigningKey)
+		dir := t.TempDir()
+		pdfPath := filepath.Join(dir, "report.pdf")
+		So(ioutil.WriteFile(pdfPath, []byte(fakePdf), 0644), ShouldBeNil)
+		newReport := func(grafana.Client, string, grafana.TimeRange, string, report.Options) report.Report {
+			return fileReport{pdfPath}
+		}
+		router := mux.NewRouter()
+		RegisterHandlers(router, ServeReportHandler{grafana.NewV4Client, newReport, nil}, ServeReportHandler{grafana.NewV5Client, newReport, nil})
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v5/report/testDash?sign=true", nil)
+
+		Convey("It should fail without a signing key", func() {
+			report.SigningKey = nil
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+			So(rec.Body.String(), ShouldContainSubstring, "-signing-key")
+		})
+
+		Convey("With a signing key", func() {
+			pub, key, err := ed25519.GenerateKey(rand.Reader)
+			So(err, ShouldBeNil)
+			report.SigningKey = key
+			der, err := x509.MarshalPKIXPublicKey(pub)
+			So(err, ShouldBeNil)
+			pubPath := filepath.Join(dir, "public.pem")
+			So(ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644), ShouldBeNil)
+
+			router.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, fakePdf)
+			So(rec.Header().Get("X-Report-Signature-Algorithm"), ShouldEqual, report.SignatureEd25519)
+			sig := rec.Header().Get("X-Report-Signature")
+			So(sig, ShouldNotBeEmpty)
+
+			Convey("The signature header should verify with grafana-reporter verify", func() {
+				So(ioutil.WriteFile(filepath.Join(dir, "header.sig"), []byte(sig+"\n"), 0644), ShouldBeNil)
+				var out bytes.Buffer
+				So(runVerify([]string{"-key", pubPath, "-sig", filepath.Join(dir, "header.sig"), pdfPath}, &out), ShouldBeNil)
+				So(out.String(), ShouldContainSubstring, "signature OK (Ed25519)")
+			})
+
+			Convey("A raw signature next to the report should verify", func() {
+				raw, err := base64.StdEncoding.DecodeString(sig)
+				So(err, ShouldBeNil)
+				So(ioutil.WriteFile(pdfPath+".sig", raw, 0644), ShouldBeNil)
+				So(runVerify([]string{"-key", pubPath, pdfPath}, ioutil.Discard), ShouldBeNil)
+
+				Convey("But not once the report was changed", func() {
+					So(ioutil.WriteFile(pdfPath, []byte(fakePdf+"%% changed"), 0644), ShouldBeNil)
+					err := runVerify([]string{"-key", pubPath, pdfPath}, ioutil.Discard)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, report.ErrBadSignature.Error())
+				})
+			})
+
+			Convey("Verify should fail without a key", func() {
+				So(runVerify([]string{pdfPath}, ioutil.Discard), ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,140 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultNameTemplate is used by a FileSink without a NameTemplate
+const DefaultNameTemplate = "{dashboard}-{date}.pdf"
+
+// maxCollisions bounds the numeric suffixes tried when a file name is already taken
+const maxCollisions = 1000
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// FileSink writes reports to files in Dir.
+// File names are generated from NameTemplate, which supports the placeholders:
+//
+//	{dashboard}  the dashboard name or uid
+//	{date}       the generation date, e.g. 2018-03-21
+//	{time}       the generation time, e.g. 153005
+//
+// Placeholder values are sanitised so that they cannot introduce path separators.
+// An existing file is not overwritten unless Overwrite is set: a numeric suffix is added instead, e.g. report-1.pdf.
+// With Overwrite, the existing file is only replaced once the new report has been written completely.
+type FileSink struct {
+	Dir          string
+	NameTemplate string
+	Overwrite    bool //replace an existing file of the same name, e.g. the previous run of a cron job
+}
+
+// Deliver writes the pdf to a new file in the sink's directory. The file is removed again if the pdf can't be written completely.
+func (s FileSink) Deliver(ctx context.Context, meta ReportMeta, pdf io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name := s.FileName(meta)
+	path := filepath.Join(s.Dir, name)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating report directory for %v: %v", path, err)
+	}
+
+	var file *os.File
+	if s.Overwrite {
+		//written next to the file it replaces and renamed over it once complete, so a failed delivery keeps the previous report
+		file, err = ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+		if err != nil {
+			return fmt.Errorf("error creating report file %v: %v", path, err)
+		}
+	} else {
+		file, path, err = createUnique(path)
+		if err != nil {
+			return err
+		}
+	}
+	written := file.Name()
+	_, err = io.Copy(file, pdf)
+	if err == nil && s.Overwrite {
+		//temp files are only readable by their owner
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && s.Overwrite {
+		err = os.Rename(written, path)
+	}
+	if err != nil {
+		//a partial report must not be mistaken for a delivered one
+		os.Remove(written)
+		return fmt.Errorf("error writing report to %v: %v", path, err)
+	}
+	log.Println("Report written to", path)
+	return nil
+}
+
+// FileName expands the sink's name template for the given report
+func (s FileSink) FileName(meta ReportMeta) string {
+	tmpl := s.NameTemplate
+	if tmpl == "" {
+		tmpl = DefaultNameTemplate
+	}
+	r := strings.NewReplacer(
+		"{dashboard}", sanitizeFileName(meta.Dashboard),
+		"{date}", meta.GeneratedAt.Format("2006-01-02"),
+		"{time}", meta.GeneratedAt.Format("150405"),
+	)
+	return r.Replace(tmpl)
+}
+
+func sanitizeFileName(s string) string {
+	s = unsafeNameChars.ReplaceAllString(s, "_")
+	s = strings.Trim(s, ".")
+	if s == "" {
+		return "report"
+	}
+	return s
+}
+
+// createUnique exclusively creates the file at path, or at path with a numeric suffix if path is taken
+func createUnique(path string) (*os.File, string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 1; i <= maxCollisions; i++ {
+		file, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			return file, candidate, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", fmt.Errorf("error creating report file %v: %v", candidate, err)
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	return nil, "", fmt.Errorf("error creating report file %v: too many existing files with that name", path)
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileSink(t *testing.T) {
+	Convey("When delivering reports to a file sink", t, func() {
+		dir, err := ioutil.TempDir("", "delivery")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		meta := ReportMeta{
+			Dashboard:   "ops/overview",
+			GeneratedAt: time.Date(2018, time.March, 21, 15, 30, 5, 0, time.UTC),
+		}
+		sink := FileSink{Dir: dir}
+
+		Convey("The default template should name the file by dashboard and date", func() {
+			So(sink.FileName(meta), ShouldEqual, "ops_overview-2018-03-21.pdf")
+		})
+
+		Convey("Custom templates should support the time placeholder", func() {
+			sink.NameTemplate = "{date}/{dashboard}-{time}.pdf"
+			So(sink.FileName(meta), ShouldEqual, "2018-03-21/ops_overview-153005.pdf")
+		})
+
+		Convey("Dashboard names should not be able to escape the directory", func() {
+			meta.Dashboard = "../../etc/passwd"
+			So(sink.FileName(meta), ShouldEqual, "_.._etc_passwd-2018-03-21.pdf")
+
+			meta.Dashboard = ".."
+			So(sink.FileName(meta), ShouldEqual, "report-2018-03-21.pdf")
+		})
+
+		Convey("The pdf should be written to the named file", func() {
+			err := sink.Deliver(context.Background(), meta, bytes.NewBufferString("pdf content"))
+			So(err, ShouldBeNil)
+			content, err := ioutil.ReadFile(filepath.Join(dir, "ops_overview-2018-03-21.pdf"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "pdf content")
+		})
+
+		Convey("Existing files should not be overwritten", func() {
+			for _, content := range []string{"first", "second", "third"} {
+				So(sink.Deliver(context.Background(), meta, bytes.NewBufferString(content)), ShouldBeNil)
+			}
+
+			first, _ := ioutil.ReadFile(filepath.Join(dir, "ops_overview-2018-03-21.pdf"))
+			second, _ := ioutil.ReadFile(filepath.Join(dir, "ops_overview-2018-03-21-1.pdf"))
+			third, _ := ioutil.ReadFile(filepath.Join(dir, "ops_overview-2018-03-21-2.pdf"))
+			So(string(first), ShouldEqual, "first")
+			So(string(second), ShouldEqual, "second")
+			So(string(third), ShouldEqual, "third")
+		})
+
+		Convey("Existing files should be replaced with Overwrite", func() {
+			sink.Overwrite = true
+			So(sink.Deliver(context.Background(), meta, bytes.NewBufferString("first")), ShouldBeNil)
+			So(sink.Deliver(context.Background(), meta, bytes.NewBufferString("second")), ShouldBeNil)
+			content, _ := ioutil.ReadFile(filepath.Join(dir, "ops_overview-2018-03-21.pdf"))
+			So(string(content), ShouldEqual, "second")
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldHaveLength, 1)
+		})
+
+		Convey("A failed delivery with Overwrite should keep the existing file", func() {
+			sink.Overwrite = true
+			So(sink.Deliver(context.Background(), meta, bytes.NewBufferString("first")), ShouldBeNil)
+			err := sink.Deliver(context.Background(), meta, io.MultiReader(bytes.NewBufferString("partial"), iotest.ErrReader(errors.New("connection reset"))))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "connection reset")
+			content, _ := ioutil.ReadFile(filepath.Join(dir, "ops_overview-2018-03-21.pdf"))
+			So(string(content), ShouldEqual, "first")
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldHaveLength, 1)
+		})
+
+		Convey("Replaced files should be readable by others, like newly created ones", func() {
+			sink.Overwrite = true
+			So(sink.Deliver(context.Background(), meta, bytes.NewBufferString("first")), ShouldBeNil)
+			info, err := os.Stat(filepath.Join(dir, "ops_overview-2018-03-21.pdf"))
+			So(err, ShouldBeNil)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0644))
+		})
+
+		Convey("Sub directories in the template should be created", func() {
+			sink.NameTemplate = "{date}/{dashboard}.pdf"
+			So(sink.Deliver(context.Background(), meta, bytes.NewBufferString("pdf")), ShouldBeNil)
+			_, err := os.Stat(filepath.Join(dir, "2018-03-21", "ops_overview.pdf"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("A partially written file should be removed", func() {
+			err := sink.Deliver(context.Background(), meta, io.MultiReader(bytes.NewBufferString("pdf"), iotest.ErrReader(errors.New("connection reset"))))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "connection reset")
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldBeEmpty)
+		})
+
+		Convey("Created directories should not be writable by others", func() {
+			sink.NameTemplate = "{date}/{dashboard}.pdf"
+			So(sink.Deliver(context.Background(), meta, bytes.NewBufferString("pdf")), ShouldBeNil)
+			info, err := os.Stat(filepath.Join(dir, "2018-03-21"))
+			So(err, ShouldBeNil)
+			So(info.Mode().Perm()&0022, ShouldEqual, 0)
+		})
+
+		Convey("A cancelled context should not write a file", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			So(sink.Deliver(ctx, meta, bytes.NewBufferString("pdf")), ShouldEqual, context.Canceled)
+			files, _ := ioutil.ReadDir(dir)
+			So(files, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,38 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package delivery sends generated reports to their destination, e.g. a file, stdout or an HTTP response.
+package delivery
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// Sink delivers a generated report PDF to a destination
+type Sink interface {
+	Deliver(ctx context.Context, meta ReportMeta, pdf io.Reader) error
+}
+
+// ReportMeta describes a generated report. Sinks use it to name and route the report.
+type ReportMeta struct {
+	Dashboard   string //dashboard name (v4) or uid (v5) the report was generated from
+	Time        grafana.TimeRange
+	GeneratedAt time.Time
+}
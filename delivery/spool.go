@@ -0,0 +1,129 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Spool holds a generated report until every sink it is delivered to has read it.
+// Reports up to the memory limit are read into memory and the report's files released at once,
+// larger ones are read from their file, which is only released after the last sink finished.
+type Spool struct {
+	data    []byte //nil when the report is read from path
+	path    string
+	release func()
+	once    sync.Once
+}
+
+// NewSpool spools the report. pdf is read into memory if it has at most memoryLimit bytes or is not an *os.File,
+// otherwise it is reopened from its file for each sink. release, e.g. the report's Clean, is called once
+// the report is no longer needed. pdf is closed in both cases.
+func NewSpool(pdf io.ReadCloser, memoryLimit int64, release func()) (*Spool, error) {
+	s := &Spool{release: release}
+	file, isFile := pdf.(*os.File)
+	if isFile {
+		info, err := file.Stat()
+		if err == nil && info.Size() > memoryLimit {
+			s.path = file.Name()
+			pdf.Close()
+			return s, nil
+		}
+	}
+	data, err := ioutil.ReadAll(pdf)
+	pdf.Close()
+	if err != nil {
+		s.Release()
+		return nil, fmt.Errorf("error spooling report: %v", err)
+	}
+	s.data = data
+	s.Release()
+	return s, nil
+}
+
+// InMemory reports whether the report is held in memory
+func (s *Spool) InMemory() bool {
+	return s.data != nil
+}
+
+// Deliver delivers the report to all sinks concurrently and releases it once all of them finished.
+// It returns the errors of all failed sinks.
+func (s *Spool) Deliver(ctx context.Context, meta ReportMeta, sinks ...Sink) error {
+	defer s.Release()
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	wg.Add(len(sinks))
+	for i, sink := range sinks {
+		go func(i int, sink Sink) {
+			defer wg.Done()
+			pdf, err := s.open()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer pdf.Close()
+			errs[i] = sink.Deliver(ctx, meta, pdf)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 1 {
+		return failed[0]
+	}
+	if len(failed) > 1 {
+		msgs := make([]string, len(failed))
+		for i, err := range failed {
+			msgs[i] = err.Error()
+		}
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// Release releases the report's files, if not done yet
+func (s *Spool) Release() {
+	s.once.Do(func() {
+		if s.release != nil {
+			s.release()
+		}
+	})
+}
+
+func (s *Spool) open() (io.ReadCloser, error) {
+	if s.data != nil {
+		return ioutil.NopCloser(bytes.NewReader(s.data)), nil
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reopening spooled report: %v", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,141 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingSink records the report once released, so tests can observe the spool while delivery is in progress
+type blockingSink struct {
+	started chan bool
+	proceed chan bool
+	mu      *sync.Mutex
+	got     *[]string
+}
+
+func (s blockingSink) Deliver(ctx context.Context, meta ReportMeta, pdf io.Reader) error {
+	s.started <- true
+	<-s.proceed
+	b, err := ioutil.ReadAll(pdf)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	*s.got = append(*s.got, string(b))
+	s.mu.Unlock()
+	return nil
+}
+
+type errSink struct{}
+
+func (errSink) Deliver(ctx context.Context, meta ReportMeta, pdf io.Reader) error {
+	return errors.New("upload failed")
+}
+
+func TestSpool(t *testing.T) {
+	Convey("When spooling a report for delivery", t, func() {
+		dir, err := ioutil.TempDir("", "spool")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "report.pdf")
+		So(ioutil.WriteFile(path, []byte("pdf content"), 0644), ShouldBeNil)
+		released := 0
+		release := func() {
+			released++
+			os.Remove(path)
+		}
+		file, err := os.Open(path)
+		So(err, ShouldBeNil)
+		meta := ReportMeta{Dashboard: "testDash"}
+
+		Convey("A small report should be held in memory and released at once", func() {
+			spool, err := NewSpool(file, 1024, release)
+			So(err, ShouldBeNil)
+			So(spool.InMemory(), ShouldBeTrue)
+			So(released, ShouldEqual, 1)
+
+			var a, b bytes.Buffer
+			So(spool.Deliver(context.Background(), meta, WriterSink{&a}, WriterSink{&b}), ShouldBeNil)
+			So(a.String(), ShouldEqual, "pdf content")
+			So(b.String(), ShouldEqual, "pdf content")
+			So(released, ShouldEqual, 1)
+		})
+
+		Convey("A large report should stay on disk until all sinks finished", func() {
+			spool, err := NewSpool(file, 4, release)
+			So(err, ShouldBeNil)
+			So(spool.InMemory(), ShouldBeFalse)
+			So(released, ShouldEqual, 0)
+
+			var mu sync.Mutex
+			var got []string
+			sinks := []Sink{}
+			var proceeds []chan bool
+			started := make(chan bool)
+			for i := 0; i < 3; i++ {
+				proceed := make(chan bool)
+				proceeds = append(proceeds, proceed)
+				sinks = append(sinks, blockingSink{started, proceed, &mu, &got})
+			}
+			done := make(chan error)
+			go func() { done <- spool.Deliver(context.Background(), meta, sinks...) }()
+			for range sinks {
+				<-started
+			}
+			proceeds[0] <- true
+			proceeds[1] <- true
+			_, err = os.Stat(path)
+			So(err, ShouldBeNil)
+			proceeds[2] <- true
+			So(<-done, ShouldBeNil)
+			So(got, ShouldResemble, []string{"pdf content", "pdf content", "pdf content"})
+			So(released, ShouldEqual, 1)
+		})
+
+		Convey("A failing sink should not keep the others from delivering", func() {
+			spool, err := NewSpool(file, 4, release)
+			So(err, ShouldBeNil)
+			var buf bytes.Buffer
+			err = spool.Deliver(context.Background(), meta, errSink{}, WriterSink{&buf})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "upload failed")
+			So(buf.String(), ShouldEqual, "pdf content")
+			So(released, ShouldEqual, 1)
+		})
+
+		Convey("Reports that are not files should be held in memory", func() {
+			file.Close()
+			spool, err := NewSpool(ioutil.NopCloser(bytes.NewBufferString("streamed")), 4, release)
+			So(err, ShouldBeNil)
+			So(spool.InMemory(), ShouldBeTrue)
+			var buf bytes.Buffer
+			So(spool.Deliver(context.Background(), meta, WriterSink{&buf}), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "streamed")
+		})
+	})
+}
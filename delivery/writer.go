@@ -0,0 +1,46 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// WriterSink delivers reports to an io.Writer, such as stdout or an HTTP response
+type WriterSink struct {
+	W io.Writer
+}
+
+// NewStdoutSink creates a Sink that writes reports to stdout
+func NewStdoutSink() WriterSink {
+	return WriterSink{os.Stdout}
+}
+
+// Deliver copies the pdf to the sink's writer
+func (s WriterSink) Deliver(ctx context.Context, meta ReportMeta, pdf io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := io.Copy(s.W, pdf)
+	if err != nil {
+		return fmt.Errorf("error copying report for %v: %v", meta.Dashboard, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type failingWriter struct{}
+
+func (f failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestWriterSink(t *testing.T) {
+	Convey("When delivering reports to a writer sink", t, func() {
+		meta := ReportMeta{Dashboard: "testDash"}
+
+		Convey("The pdf should be copied to the writer", func() {
+			var buf bytes.Buffer
+			err := WriterSink{&buf}.Deliver(context.Background(), meta, bytes.NewBufferString("pdf content"))
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldEqual, "pdf content")
+		})
+
+		Convey("Write errors should be returned", func() {
+			err := WriterSink{failingWriter{}}.Deliver(context.Background(), meta, bytes.NewBufferString("pdf content"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "connection reset")
+		})
+
+		Convey("The stdout sink should be usable as a Sink", func() {
+			var s Sink = NewStdoutSink()
+			So(s, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package format formats panel values for report readers: scaled to the Grafana unit of the panel and written
+// with the separators of the readers' locale, e.g. 1.234,5 GiB for German readers.
+package format
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Locale holds the separators numbers are written with.
+// The zero Locale writes numbers as Go does, without grouping, e.g. 1234.5
+type Locale struct {
+	Decimal string
+	Group   string //separates groups of three digits
+}
+
+//nbsp groups digits with a space that never breaks the line
+const nbsp = "\u00a0"
+
+// locales by lower-case BCP 47 tag, either a language or a language and region that differs from its language
+var locales = map[string]Locale{
+	"en":    {".", ","},
+	"ja":    {".", ","},
+	"ko":    {".", ","},
+	"zh":    {".", ","},
+	"de":    {",", "."},
+	"da":    {",", "."},
+	"es":    {",", "."},
+	"id":    {",", "."},
+	"it":    {",", "."},
+	"nl":    {",", "."},
+	"pt":    {",", "."},
+	"tr":    {",", "."},
+	"cs":    {",", nbsp},
+	"fi":    {",", nbsp},
+	"fr":    {",", nbsp},
+	"nb":    {",", nbsp},
+	"pl":    {",", nbsp},
+	"ru":    {",", nbsp},
+	"sv":    {",", nbsp},
+	"uk":    {",", nbsp},
+	"de-ch": {".", "'"},
+	"fr-ch": {".", "'"},
+	"it-ch": {".", "'"},
+	"de-at": {",", nbsp},
+}
+
+// ParseLocale returns the locale of a language tag such as de, de-DE or pt_BR.
+// Regions without their own separators use those of their language.
+func ParseLocale(tag string) (Locale, error) {
+	tag = strings.ToLower(strings.Replace(tag, "_", "-", -1))
+	if l, ok := locales[tag]; ok {
+		return l, nil
+	}
+	if i := strings.Index(tag, "-"); i > 0 {
+		if l, ok := locales[tag[:i]]; ok {
+			return l, nil
+		}
+	}
+	return Locale{}, fmt.Errorf("unknown locale %q", tag)
+}
+
+// Number writes v with four significant digits, dropping trailing zeros, e.g. 1,235 or 0,5
+func (l Locale) Number(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	decimals := 0
+	if v != 0 {
+		decimals = 3 - int(math.Floor(math.Log10(math.Abs(v))))
+	}
+	if decimals < 0 {
+		decimals = 0
+	} else if decimals > 6 {
+		decimals = 6
+	}
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "0" {
+		return s
+	}
+
+	whole, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if l.Group != "" {
+		for i := len(whole) - 3; i > 0; i -= 3 {
+			whole = whole[:i] + l.Group + whole[i:]
+		}
+	}
+	if frac != "" {
+		decimal := l.Decimal
+		if decimal == "" {
+			decimal = "."
+		}
+		whole += decimal + frac
+	}
+	if v < 0 {
+		whole = "-" + whole
+	}
+	return whole
+}
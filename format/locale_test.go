@@ -0,0 +1,93 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package format
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseLocale(t *testing.T) {
+	Convey("When parsing locales", t, func() {
+		for _, c := range []struct {
+			tag    string
+			locale Locale
+		}{
+			{"en", Locale{".", ","}},
+			{"en-US", Locale{".", ","}},
+			{"de", Locale{",", "."}},
+			{"de_DE", Locale{",", "."}},
+			{"de-CH", Locale{".", "'"}},
+			{"de-AT", Locale{",", nbsp}},
+			{"fr-FR", Locale{",", nbsp}},
+			{"pt-BR", Locale{",", "."}},
+			{"JA", Locale{".", ","}},
+		} {
+			Convey(c.tag+" should use its separators", func() {
+				l, err := ParseLocale(c.tag)
+				So(err, ShouldBeNil)
+				So(l, ShouldResemble, c.locale)
+			})
+		}
+
+		Convey("Unknown locales should return an error", func() {
+			for _, tag := range []string{"", "xx", "-de", "klingon-KL"} {
+				_, err := ParseLocale(tag)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestNumber(t *testing.T) {
+	en := Locale{".", ","}
+	de := Locale{",", "."}
+	fr := Locale{",", nbsp}
+	Convey("When writing numbers", t, func() {
+		for _, c := range []struct {
+			v      float64
+			locale Locale
+			want   string
+		}{
+			{0, en, "0"},
+			{1, en, "1"},
+			{0.75, en, "0.75"},
+			{1234.56, en, "1,235"},
+			{1234.56, de, "1.235"},
+			{1234567, en, "1,234,567"},
+			{1234567, de, "1.234.567"},
+			{1234567, fr, "1" + nbsp + "234" + nbsp + "567"},
+			{123.456, de, "123,5"},
+			{-9876.6, en, "-9,877"},
+			{-0.5, de, "-0,5"},
+			{1.5, Locale{}, "1.5"},
+			{1234567, Locale{}, "1234567"},
+			{0.000012345, en, "0.000012"},
+			{0.0000001, en, "0"},
+			{100, en, "100"},
+			{math.NaN(), en, "NaN"},
+			{math.Inf(-1), en, "-Inf"},
+		} {
+			Convey(fmt.Sprintf("%v should be written as %v", c.v, c.want), func() {
+				So(c.locale.Number(c.v), ShouldEqual, c.want)
+			})
+		}
+	})
+}
@@ -0,0 +1,196 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package format
+
+import (
+	"math"
+	"strings"
+)
+
+// scale lists the prefixes of a unit's multiples, each base times the previous one
+type scale struct {
+	base     float64
+	prefixes []string
+}
+
+var (
+	si    = scale{1000, []string{"", "k", "M", "G", "T", "P", "E"}}
+	iec   = scale{1024, []string{"", "Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}}
+	short = scale{1000, []string{"", " K", " Mil", " Bil", " Tri", " Quadr", " Quint"}}
+
+	//submultiples of SI units with a base of 1000, e.g. mV
+	siSmall = []string{"m", "µ", "n"}
+)
+
+// unit describes how values of a Grafana unit are written
+type unit struct {
+	symbol string //written after the number and prefix
+	before string //written before the number, e.g. a currency symbol
+	tight  bool   //no space between the number and symbol, e.g. 50%
+	factor float64
+	scale  *scale
+	start  int  //index of the unit's own prefix in scale, e.g. 2 for megabytes
+	small  bool //scale values below 1 with siSmall
+}
+
+// units by Grafana unit id, as set in a panel's field config
+var units = map[string]unit{
+	"none":        {},
+	"short":       {scale: &short, tight: true},
+	"percent":     {symbol: "%", tight: true},
+	"percentunit": {symbol: "%", tight: true, factor: 100},
+
+	"bits":      {symbol: "b", scale: &iec},
+	"bytes":     {symbol: "B", scale: &iec},
+	"kbytes":    {symbol: "B", scale: &iec, start: 1},
+	"mbytes":    {symbol: "B", scale: &iec, start: 2},
+	"gbytes":    {symbol: "B", scale: &iec, start: 3},
+	"tbytes":    {symbol: "B", scale: &iec, start: 4},
+	"decbits":   {symbol: "b", scale: &si},
+	"decbytes":  {symbol: "B", scale: &si},
+	"deckbytes": {symbol: "B", scale: &si, start: 1},
+	"decmbytes": {symbol: "B", scale: &si, start: 2},
+	"decgbytes": {symbol: "B", scale: &si, start: 3},
+	"dectbytes": {symbol: "B", scale: &si, start: 4},
+
+	"bps":    {symbol: "b/s", scale: &si},
+	"Kbits":  {symbol: "b/s", scale: &si, start: 1},
+	"Mbits":  {symbol: "b/s", scale: &si, start: 2},
+	"Gbits":  {symbol: "b/s", scale: &si, start: 3},
+	"Bps":    {symbol: "B/s", scale: &si},
+	"KBs":    {symbol: "B/s", scale: &si, start: 1},
+	"MBs":    {symbol: "B/s", scale: &si, start: 2},
+	"GBs":    {symbol: "B/s", scale: &si, start: 3},
+	"binbps": {symbol: "b/s", scale: &iec},
+	"binBps": {symbol: "B/s", scale: &iec},
+	"pps":    {symbol: "p/s", scale: &si},
+
+	"reqps": {symbol: "req/s"},
+	"rps":   {symbol: "rd/s"},
+	"wps":   {symbol: "wr/s"},
+	"iops":  {symbol: "io/s"},
+	"ops":   {symbol: "ops/s"},
+	"reqpm": {symbol: "req/min"},
+	"opm":   {symbol: "ops/min"},
+
+	"hertz":  {symbol: "Hz", scale: &si},
+	"volt":   {symbol: "V", scale: &si, small: true},
+	"amp":    {symbol: "A", scale: &si, small: true},
+	"watt":   {symbol: "W", scale: &si, small: true},
+	"kwatt":  {symbol: "W", scale: &si, start: 1},
+	"watth":  {symbol: "Wh", scale: &si},
+	"kwatth": {symbol: "Wh", scale: &si, start: 1},
+
+	"celsius":    {symbol: "°C"},
+	"fahrenheit": {symbol: "°F"},
+	"kelvin":     {symbol: "K"},
+
+	"currencyUSD": {before: "$"},
+	"currencyEUR": {before: "€"},
+	"currencyGBP": {before: "£"},
+	"currencyJPY": {before: "¥"},
+}
+
+// durations are the time units, in seconds, that durations are scaled to
+var durations = []struct {
+	symbol  string
+	seconds float64
+}{
+	{"ns", 1e-9},
+	{"µs", 1e-6},
+	{"ms", 1e-3},
+	{"s", 1},
+	{"min", 60},
+	{"hour", 60 * 60},
+	{"day", 24 * 60 * 60},
+	{"week", 7 * 24 * 60 * 60},
+	{"year", 365 * 24 * 60 * 60},
+}
+
+// durationUnits are the Grafana time unit ids, in seconds
+var durationUnits = map[string]float64{
+	"ns": 1e-9,
+	"µs": 1e-6,
+	"us": 1e-6,
+	"ms": 1e-3,
+	"s":  1,
+	"m":  60,
+	"h":  60 * 60,
+	"d":  24 * 60 * 60,
+}
+
+// Value writes v in the Grafana unit id, scaled to a readable multiple, e.g. 1536 bytes as 1.5 KiB or 0.25 s as 250 ms.
+// Custom units are written as Grafana does, "suffix:/day" after the number and "prefix:#" before it.
+// Unknown units are written after the number as they are.
+func Value(v float64, unitID string, l Locale) string {
+	if seconds, ok := durationUnits[unitID]; ok {
+		return duration(v*seconds, l)
+	}
+	u, ok := units[unitID]
+	switch {
+	case ok:
+	case unitID == "":
+	case strings.HasPrefix(unitID, "suffix:"):
+		u = unit{symbol: strings.TrimPrefix(unitID, "suffix:"), tight: true}
+	case strings.HasPrefix(unitID, "prefix:"):
+		u = unit{before: strings.TrimPrefix(unitID, "prefix:")}
+	default:
+		u = unit{symbol: unitID}
+	}
+
+	if u.factor != 0 {
+		v *= u.factor
+	}
+	prefix := ""
+	if u.scale != nil {
+		i := u.start
+		for math.Abs(v) >= u.scale.base && i < len(u.scale.prefixes)-1 {
+			v /= u.scale.base
+			i++
+		}
+		prefix = u.scale.prefixes[i]
+		if u.small && i == 0 {
+			for j := 0; v != 0 && math.Abs(v) < 1 && j < len(siSmall); j++ {
+				v *= 1000
+				prefix = siSmall[j]
+			}
+		}
+	}
+	return join(u.before+l.Number(v), prefix+u.symbol, u.tight)
+}
+
+// duration writes a duration in the largest time unit it reaches, e.g. 90 s as 1.5 min
+func duration(seconds float64, l Locale) string {
+	d := durations[0]
+	for _, larger := range durations[1:] {
+		if math.Abs(seconds) < larger.seconds {
+			break
+		}
+		d = larger
+	}
+	if seconds == 0 {
+		d = durations[3]
+	}
+	return join(l.Number(seconds/d.seconds), d.symbol, false)
+}
+
+func join(number string, symbol string, tight bool) string {
+	if symbol == "" || tight {
+		return number + symbol
+	}
+	return number + " " + symbol
+}
@@ -0,0 +1,88 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package format
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValue(t *testing.T) {
+	en := Locale{".", ","}
+	de := Locale{",", "."}
+	Convey("When writing values in Grafana units", t, func() {
+		for _, c := range []struct {
+			v      float64
+			unit   string
+			locale Locale
+			want   string
+		}{
+			{1234.6, "", en, "1,235"},
+			{1234.6, "none", de, "1.235"},
+			{1500, "short", en, "1.5 K"},
+			{2500000, "short", de, "2,5 Mil"},
+			{42, "short", en, "42"},
+			{50, "percent", en, "50%"},
+			{0.255, "percentunit", de, "25,5%"},
+
+			{512, "bytes", en, "512 B"},
+			{1536, "bytes", en, "1.5 KiB"},
+			{3 << 30, "bytes", de, "3 GiB"},
+			{1536, "kbytes", en, "1.5 MiB"},
+			{2048, "mbytes", en, "2 GiB"},
+			{1500000, "decbytes", en, "1.5 MB"},
+			{1500, "decmbytes", de, "1,5 GB"},
+			{1e30, "bytes", en, "867,361,737,988 EiB"},
+			{9000, "bits", en, "8.789 Kib"},
+			{2500000, "bps", en, "2.5 Mb/s"},
+			{1200, "Bps", en, "1.2 kB/s"},
+			{1024, "binBps", en, "1 KiB/s"},
+			{-2048, "bytes", en, "-2 KiB"},
+
+			{0.25, "s", en, "250 ms"},
+			{0.0015, "s", de, "1,5 ms"},
+			{90, "s", en, "1.5 min"},
+			{7200, "s", en, "2 hour"},
+			{3 * 86400, "s", en, "3 day"},
+			{0, "s", en, "0 s"},
+			{1500, "ms", en, "1.5 s"},
+			{250, "µs", en, "250 µs"},
+			{2500, "us", en, "2.5 ms"},
+			{45, "ns", en, "45 ns"},
+			{90, "m", en, "1.5 hour"},
+			{14, "d", en, "2 week"},
+			{730, "d", en, "2 year"},
+
+			{4, "reqps", en, "4 req/s"},
+			{12345, "ops", de, "12.345 ops/s"},
+			{2400000000, "hertz", en, "2.4 GHz"},
+			{0.012, "volt", en, "12 mV"},
+			{1500, "watt", de, "1,5 kW"},
+			{0, "watt", en, "0 W"},
+			{21.5, "celsius", de, "21,5 °C"},
+			{1234.6, "currencyEUR", de, "€1.235"},
+			{12, "suffix:/day", en, "12/day"},
+			{12, "prefix:#", en, "#12"},
+			{4, "ops_per_sec", en, "4 ops_per_sec"},
+		} {
+			Convey(c.unit+" should write "+c.want, func() {
+				So(Value(c.v, c.unit, c.locale), ShouldEqual, c.want)
+			})
+		}
+	})
+}
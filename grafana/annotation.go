@@ -0,0 +1,95 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ReportTag is added to the annotations of generated reports
+const ReportTag = "report"
+
+// Annotation is an event on a dashboard's timeline, as created by Grafana's annotations API
+type Annotation struct {
+	DashboardUID string   `json:"dashboardUID"`
+	Time         int64    `json:"time"` //unix time in milliseconds
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// NewReportAnnotation creates the annotation marking a report on the dashboard, at the end of the report's time range.
+// Relative ranges are resolved against generatedAt.
+func NewReportAnnotation(dashUID string, t TimeRange, generatedAt time.Time, text string) (Annotation, error) {
+	_, to, err := t.Resolve(generatedAt, t.location())
+	if err != nil {
+		return Annotation{}, err
+	}
+	return Annotation{
+		DashboardUID: dashUID,
+		Time:         to.UnixNano() / int64(time.Millisecond),
+		Tags:         []string{ReportTag},
+		Text:         text,
+	}, nil
+}
+
+// CreateAnnotation adds the annotation to its dashboard, unless an annotation with the same time, text and tags
+// already exists there. Creating the annotation of a retried report delivery is therefore a no-op.
+// Annotating dashboards by uid requires the v5 client
+func (g client) CreateAnnotation(a Annotation) error {
+	if g.annotationEndpoint == "" {
+		return errors.New("annotations are only supported by the Grafana v5 API")
+	}
+	exists, err := g.annotationExists(a)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Printf("Dashboard %v is already annotated at %v, not annotating again", a.DashboardUID, a.Time)
+		return nil
+	}
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("error encoding annotation: %v", err)
+	}
+	var resp struct {
+		ID int64
+	}
+	return g.doJSON("createAnnotation", "POST", g.annotationEndpoint, body, &resp)
+}
+
+// annotationExists looks for an annotation equal to a on a's dashboard
+func (g client) annotationExists(a Annotation) (bool, error) {
+	ms := strconv.FormatInt(a.Time, 10)
+	query := url.Values{"dashboardUID": {a.DashboardUID}, "from": {ms}, "to": {ms}, "type": {"annotation"}, "tags": a.Tags}
+	var existing []Annotation
+	err := g.getJSON("findAnnotations", endpoint(g.annotationEndpoint, query), &existing)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range existing {
+		if e.Time == a.Time && e.Text == a.Text {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,112 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// annotationServer is a fake Grafana annotations API, storing the created annotations
+type annotationServer struct {
+	created []Annotation
+	auth    []string
+}
+
+func (s *annotationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/annotations" {
+		http.NotFound(w, r)
+		return
+	}
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+	switch r.Method {
+	case "GET":
+		q := r.URL.Query()
+		from, _ := strconv.ParseInt(q.Get("from"), 10, 64)
+		to, _ := strconv.ParseInt(q.Get("to"), 10, 64)
+		var found []Annotation
+		for _, a := range s.created {
+			if a.DashboardUID == q.Get("dashboardUID") && a.Time >= from && a.Time <= to {
+				found = append(found, a)
+			}
+		}
+		json.NewEncoder(w).Encode(found)
+	case "POST":
+		var a Annotation
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.created = append(s.created, a)
+		w.Write([]byte(`{"id":1,"message":"Annotation added"}`))
+	}
+}
+
+func TestCreateAnnotation(t *testing.T) {
+	Convey("When annotating a dashboard with a report", t, func() {
+		server := &annotationServer{}
+		ts := httptest.NewServer(server)
+		defer ts.Close()
+		generatedAt := time.Date(2018, 3, 21, 15, 30, 0, 0, time.UTC)
+		a, err := NewReportAnnotation("abc", TimeRange{From: "now-1d/d", To: "now-1d/d"}, generatedAt, "Report abc-2018-03-21.pdf published")
+		So(err, ShouldBeNil)
+
+		Convey("The annotation should be placed at the end of the report's time range", func() {
+			So(a.Time, ShouldEqual, time.Date(2018, 3, 21, 0, 0, 0, 0, time.UTC).UnixNano()/int64(time.Millisecond))
+			So(a.Tags, ShouldResemble, []string{"report"})
+		})
+
+		Convey("The v5 client should post it with its credentials", func() {
+			So(NewV5Client(ts.URL, "1234", url.Values{}).CreateAnnotation(a), ShouldBeNil)
+			So(server.created, ShouldResemble, []Annotation{a})
+			So(server.auth, ShouldContain, "Bearer 1234")
+		})
+
+		Convey("Annotating the same report again should not create a duplicate", func() {
+			c := NewV5Client(ts.URL, "", url.Values{})
+			So(c.CreateAnnotation(a), ShouldBeNil)
+			So(c.CreateAnnotation(a), ShouldBeNil)
+			So(server.created, ShouldHaveLength, 1)
+
+			Convey("But a different report at the same time should be annotated", func() {
+				b := a
+				b.Text = "Another report"
+				So(c.CreateAnnotation(b), ShouldBeNil)
+				So(server.created, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("The v4 client should return an error", func() {
+			So(NewV4Client(ts.URL, "", url.Values{}).CreateAnnotation(a), ShouldNotBeNil)
+			So(server.created, ShouldBeEmpty)
+		})
+
+		Convey("A Grafana error should be returned", func() {
+			ts.Close()
+			So(NewV5Client(ts.URL, "", url.Values{}).CreateAnnotation(a), ShouldNotBeNil)
+		})
+	})
+}
@@ -17,6 +17,10 @@
 package grafana
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -25,6 +29,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -32,110 +37,441 @@ import (
 type Client interface {
 	GetDashboard(dashName string) (Dashboard, error)
 	GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error)
+	GetPlaylist(id string) (Playlist, error)
+	SearchDashboards(query url.Values) ([]DashboardRef, error)
+	GetPanelData(p Panel, t TimeRange) (PanelData, error)
+	CreateAnnotation(a Annotation) error
+	GetHealth() (Health, error)
 }
 
 type client struct {
-	url              string
-	getDashEndpoint  func(dashName string) string
-	getPanelEndpoint func(dashName string, vals url.Values) string
-	apiToken         string
-	variables        url.Values
+	url                 string
+	getDashEndpoint     func(dashName string) string
+	getPanelEndpoint    func(dashName string, vals url.Values) string
+	getPlaylistEndpoint func(id string) string //nil when the API version has no supported playlist endpoint
+	dataEndpoint        string                 //empty when the API version has no supported data source query endpoint
+	apiToken            string
+	variables           url.Values
+	panelVariables      map[int]url.Values
+	headers             http.Header
+	imageFormat         string
+	getVersionEndpoint  func(dashName string, version int) string //nil when dashboard versions are not supported
+	getLibraryEndpoint  func(uid string) string                   //nil when library panels are not supported
+	dashVersion         int                                       //0 for the current version
+	annotationEndpoint  string                                    //empty when annotating dashboards by uid is not supported
+	includeCollapsed    bool
+	skipPanelTypes      map[string]bool //normalized by panelTypeKey
+	resolveDatasources  bool
+	renderScale         float64 //0 for full size
+	renderMode          string  //see RenderMode
+	renderer            *rendererState
+	timeout             time.Duration //of the API requests, 0 for none
+	slugs               *slugRedirects
+	ctx                 context.Context //of the requests, nil for requests that are never canceled
+	datasources         *datasourceNames
+}
+
+// ClientOption configures optional behaviour of a Client
+type ClientOption func(*client)
+
+// Panel image formats for WithImageFormat
+const (
+	ImagePNG  = "png"
+	ImageJPEG = "jpeg"
+)
+
+// WithImageFormat requests panel images in the given format, ImagePNG or ImageJPEG.
+// Renderers without JPEG support still answer with a PNG, so callers should check the type of the returned image.
+func WithImageFormat(format string) ClientOption {
+	return func(g *client) {
+		g.imageFormat = format
+	}
+}
+
+// WithRenderScale scales the size of the rendered panel images, e.g. 0.5 for half the width and height
+func WithRenderScale(scale float64) ClientOption {
+	return func(g *client) {
+		g.renderScale = scale
+	}
+}
+
+// WithTimeout bounds the time of each API request, e.g. for health checks that must not hang with Grafana.
+// Panel image requests are not bounded.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(g *client) {
+		g.timeout = timeout
+	}
+}
+
+// WithContext cancels the client's requests, and the retries of panel renders, once ctx is done,
+// e.g. when the client requesting the report disconnected
+func WithContext(ctx context.Context) ClientOption {
+	return func(g *client) {
+		g.ctx = ctx
+	}
+}
+
+// WithHeaders adds the headers to every request sent to Grafana, e.g. the user headers of Grafana's auth proxy.
+// The header values are treated as credentials and never logged.
+func WithHeaders(headers http.Header) ClientOption {
+	return func(g *client) {
+		for k, v := range headers {
+			g.headers[k] = append([]string(nil), v...)
+		}
+	}
 }
 
 var getPanelRetrySleepTime = time.Duration(10) * time.Second
 
+// ConnectionError is returned when Grafana could not be reached, as opposed to Grafana answering with an error
+type ConnectionError struct {
+	Op  string
+	URL string
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("error executing %s request for %v: %v", e.Op, e.URL, e.Err)
+}
+
+// StatusError is returned when Grafana answered a panel render with an error status
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "Error obtaining render: " + e.Status
+}
+
 // NewV4Client creates a new Grafana 4 Client. If apiToken is the empty string,
 // authorization headers will be omitted from requests.
 // variables are Grafana template variable url values of the form var-{name}={value}, e.g. var-host=dev
-func NewV4Client(grafanaURL string, apiToken string, variables url.Values) Client {
+func NewV4Client(grafanaURL string, apiToken string, variables url.Values, opts ...ClientOption) Client {
+	variables = DedupeVariables(variables)
 	getDashEndpoint := func(dashName string) string {
-		dashURL := grafanaURL + "/api/dashboards/db/" + dashName
-		if len(variables) > 0 {
-			dashURL = dashURL + "?" + variables.Encode()
-		}
-		return dashURL
+		return endpoint(grafanaURL, variables, "api", "dashboards", "db", dashName)
 	}
 
 	getPanelEndpoint := func(dashName string, vals url.Values) string {
-		return fmt.Sprintf("%s/render/dashboard-solo/db/%s?%s", grafanaURL, dashName, vals.Encode())
+		return endpoint(grafanaURL, vals, "render", "dashboard-solo", "db", dashName)
 	}
-	return client{grafanaURL, getDashEndpoint, getPanelEndpoint, apiToken, variables}
+	return newClient(client{
+		url:              grafanaURL,
+		getDashEndpoint:  getDashEndpoint,
+		getPanelEndpoint: getPanelEndpoint,
+		apiToken:         apiToken,
+		variables:        variables,
+	}, opts)
 }
 
 // NewV5Client creates a new Grafana 5 Client. If apiToken is the empty string,
 // authorization headers will be omitted from requests.
 // variables are Grafana template variable url values of the form var-{name}={value}, e.g. var-host=dev
-func NewV5Client(grafanaURL string, apiToken string, variables url.Values) Client {
+func NewV5Client(grafanaURL string, apiToken string, variables url.Values, opts ...ClientOption) Client {
+	variables = DedupeVariables(variables)
 	getDashEndpoint := func(dashName string) string {
-		dashURL := grafanaURL + "/api/dashboards/uid/" + dashName
-		if len(variables) > 0 {
-			dashURL = dashURL + "?" + variables.Encode()
-		}
-		return dashURL
+		return endpoint(grafanaURL, variables, "api", "dashboards", "uid", dashName)
 	}
 
 	getPanelEndpoint := func(dashName string, vals url.Values) string {
-		return fmt.Sprintf("%s/render/d-solo/%s/_?%s", grafanaURL, dashName, vals.Encode())
+		return endpoint(grafanaURL, vals, "render", "d-solo", dashName, "_")
+	}
+
+	getPlaylistEndpoint := func(id string) string {
+		return endpoint(grafanaURL, nil, "api", "playlists", id)
+	}
+
+	getVersionEndpoint := func(dashName string, version int) string {
+		return endpoint(grafanaURL, nil, "api", "dashboards", "uid", dashName, "versions", strconv.Itoa(version))
+	}
+
+	getLibraryEndpoint := func(uid string) string {
+		return endpoint(grafanaURL, nil, "api", "library-elements", uid)
+	}
+	return newClient(client{
+		url:                 grafanaURL,
+		getDashEndpoint:     getDashEndpoint,
+		getPanelEndpoint:    getPanelEndpoint,
+		getPlaylistEndpoint: getPlaylistEndpoint,
+		getVersionEndpoint:  getVersionEndpoint,
+		getLibraryEndpoint:  getLibraryEndpoint,
+		dataEndpoint:        endpoint(grafanaURL, nil, "api", "ds", "query"),
+		annotationEndpoint:  endpoint(grafanaURL, nil, "api", "annotations"),
+		apiToken:            apiToken,
+		variables:           variables,
+	}, opts)
+}
+
+func newClient(g client, opts []ClientOption) client {
+	g.headers = http.Header{}
+	g.datasources = &datasourceNames{}
+	g.skipPanelTypes = panelTypeSet(SkipPanelTypes)
+	g.renderMode = RenderMode
+	g.renderer = &rendererState{}
+	g.slugs = &slugRedirects{}
+	for _, opt := range opts {
+		opt(&g)
+	}
+	return g
+}
+
+// aborted returns the error of the client's context once it is canceled or its deadline passed, else nil
+func (g client) aborted() error {
+	if g.ctx == nil {
+		return nil
+	}
+	return g.ctx.Err()
+}
+
+// newRequest creates a request carrying the client's credentials
+func (g client) newRequest(method string, reqURL string, body io.Reader) (*http.Request, error) {
+	ctx := g.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if g.apiToken != "" {
+		req.Header.Add("Authorization", "Bearer "+g.apiToken)
+	}
+	for k, v := range g.headers {
+		req.Header[k] = append([]string(nil), v...)
 	}
-	return client{grafanaURL, getDashEndpoint, getPanelEndpoint, apiToken, variables}
+	return req, nil
 }
 
 func (g client) GetDashboard(dashName string) (Dashboard, error) {
-	dashURL := g.getDashEndpoint(dashName)
+	dashURL := g.getDashEndpoint(g.slugs.resolve(dashName))
+	if g.dashVersion > 0 {
+		if g.getVersionEndpoint == nil {
+			return Dashboard{}, errors.New("dashboard versions are only supported by the Grafana v5 API")
+		}
+		dashURL = g.getVersionEndpoint(g.slugs.resolve(dashName), g.dashVersion)
+	}
 	log.Println("Connecting to dashboard at", dashURL)
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", dashURL, nil)
+	client := g.httpClient("getDashboard", dashName)
+	req, err := g.newRequest("GET", dashURL, nil)
 	if err != nil {
 		return Dashboard{}, fmt.Errorf("error creating getDashboard request for %v: %v", dashURL, err)
 	}
 
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
+	//large dashboards compress well. Set explicitly, the transport leaves decompressing to responseBody
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	if err != nil {
+		return Dashboard{}, g.requestError("getDashboard", dashURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := responseBody(resp)
+	if err != nil {
+		return Dashboard{}, fmt.Errorf("error reading getDashboard response body from %v: %v", dashURL, err)
+	}
+
+	if resp.StatusCode != 200 {
+		msg, err := ioutil.ReadAll(body)
+		if err != nil {
+			return Dashboard{}, fmt.Errorf("error reading getDashboard response body from %v: %v", dashURL, err)
+		}
+		return Dashboard{}, fmt.Errorf("error obtaining dashboard from %v. Got Status %v, message: %v ", dashURL, resp.Status, string(msg))
+	}
+	if g.dashVersion > 0 {
+		versionJSON, err := ioutil.ReadAll(body)
+		if err != nil {
+			return Dashboard{}, fmt.Errorf("error reading getDashboard response body from %v: %v", dashURL, err)
+		}
+		dashJSON, err := versionedDashboardJSON(versionJSON)
+		if err != nil {
+			return Dashboard{}, fmt.Errorf("error parsing dashboard version from %v: %v", dashURL, err)
+		}
+		body = bytes.NewReader(dashJSON)
+	}
+
+	dash, err := decodeDashboard(body, g.variables, g.includeCollapsed, g.skipPanelTypes)
+	if err != nil {
+		return Dashboard{}, fmt.Errorf("error parsing dashboard from %v: %v", dashURL, err)
+	}
+	if g.getLibraryEndpoint != nil {
+		g.resolveLibraryPanels(&dash)
+	}
+	if g.resolveDatasources {
+		g.resolveDatasourceNames(&dash)
+	}
+	if overrides := getPanelVariablesValues(g.panelVariables); overrides != "" {
+		if dash.VariableValues != "" {
+			dash.VariableValues += ", "
+		}
+		dash.VariableValues += SanitizeLaTexInput(overrides)
+	}
+	return dash, nil
+}
+
+// responseBody returns the body of resp, decompressing it when the server gzipped it
+// for a request that asked for gzip explicitly
+func responseBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp.Body, nil
+	}
+	return gzip.NewReader(resp.Body)
+}
+
+// GetPlaylist fetches a playlist and its items. Playlists are only supported by the v5 client
+func (g client) GetPlaylist(id string) (Playlist, error) {
+	if g.getPlaylistEndpoint == nil {
+		return Playlist{}, errors.New("playlists are only supported by the Grafana v5 API")
+	}
+	var pl Playlist
+	err := g.getJSON("getPlaylist", g.getPlaylistEndpoint(id), &pl)
+	return pl, err
+}
+
+// SearchDashboards queries Grafana's search API, e.g. by tag or by dashboardIds
+func (g client) SearchDashboards(query url.Values) ([]DashboardRef, error) {
+	query = copyValues(query)
+	query.Set("type", "dash-db")
+	var refs []DashboardRef
+	err := g.getJSON("searchDashboards", endpoint(g.url, query, "api", "search"), &refs)
+	return refs, err
+}
+
+// Health is the version information reported by Grafana's health API
+type Health struct {
+	Version string
+	Commit  string
+}
+
+// GetHealth queries Grafana's health API, which needs no authentication
+func (g client) GetHealth() (Health, error) {
+	var h Health
+	err := g.getJSON("getHealth", endpoint(g.url, nil, "api", "health"), &h)
+	return h, err
+}
+
+// GetPanelData queries the data sources of the panel's targets for the time range. It requires Grafana's /api/ds/query
+// endpoint, so it is only supported by the v5 client
+func (g client) GetPanelData(p Panel, t TimeRange) (PanelData, error) {
+	if g.dataEndpoint == "" {
+		return PanelData{}, errors.New("panel data queries are only supported by the Grafana v5 API")
+	}
+	query, err := newDataQuery(p, t, g.variablesFor(p.Id))
+	if err != nil {
+		return PanelData{}, err
+	}
+	var raw json.RawMessage
+	err = g.doJSON("getPanelData", "POST", g.dataEndpoint, query, &raw)
+	if err != nil {
+		return PanelData{}, err
+	}
+	data, err := NewPanelData(raw)
+	if err != nil {
+		return PanelData{}, fmt.Errorf("error parsing data of panel %v: %v", p.Id, err)
+	}
+	return data, nil
+}
+
+// getJSON performs an authorized GET request and decodes the JSON response into v
+func (g client) getJSON(op string, reqURL string, v interface{}) error {
+	return g.doJSON(op, "GET", reqURL, nil, v)
+}
+
+// doJSON performs an authorized request with an optional JSON body and decodes the JSON response into v
+func (g client) doJSON(op string, method string, reqURL string, reqBody []byte, v interface{}) error {
+	log.Println("Connecting to", reqURL)
+
+	client := g.httpClient(op, "")
+	client.Timeout = g.timeout
+	req, err := g.newRequest(method, reqURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("error creating %s request for %v: %v", op, reqURL, err)
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return Dashboard{}, fmt.Errorf("error executing getDashboard request for %v: %v", dashURL, err)
+		return g.requestError(op, reqURL, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return Dashboard{}, fmt.Errorf("error reading getDashboard response body from %v: %v", dashURL, err)
+		return fmt.Errorf("error reading %s response body from %v: %v", op, reqURL, err)
 	}
-
 	if resp.StatusCode != 200 {
-		return Dashboard{}, fmt.Errorf("error obtaining dashboard from %v. Got Status %v, message: %v ", dashURL, resp.Status, string(body))
+		return fmt.Errorf("error executing %s request for %v. Got Status %v, message: %v ", op, reqURL, resp.Status, string(body))
 	}
 
-	return NewDashboard(body, g.variables), nil
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("error parsing %s response from %v: %v", op, reqURL, err)
+	}
+	return nil
+}
+
+func copyValues(vals url.Values) url.Values {
+	c := url.Values{}
+	for k, v := range vals {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
 }
 
+// GetPanelPng returns the image of a panel, rendered by Grafana or drawn from its data depending on RenderMode
 func (g client) GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+	if g.renderMode == RenderData || (g.renderMode == RenderAuto && g.renderer.isUnavailable()) {
+		return g.drawPanelPng(p, t)
+	}
+	img, err := g.renderPanelPng(p, dashName, t)
+	if err != nil && g.renderMode == RenderAuto && g.aborted() == nil {
+		if errors.Is(err, ErrRendererUnavailable) {
+			g.renderer.setUnavailable()
+		}
+		log.Printf("Rendering panel %v failed, drawing it from its data instead: %v", p.Id, err)
+		return g.drawPanelPng(p, t)
+	}
+	return img, err
+}
+
+// drawPanelPng draws a panel from the data of the data source query API, like the panels of public dashboards
+func (g client) drawPanelPng(p Panel, t TimeRange) (io.ReadCloser, error) {
+	width, height := g.panelSize(p)
+	return drawPanelPng(p, t, width, height, g.GetPanelData)
+}
+
+// renderPanelPng gets the image of a panel from Grafana's image renderer
+func (g client) renderPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
 	panelURL := g.getPanelURL(p, dashName, t)
 
-	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
-		return errors.New("Error getting panel png. Redirected to login")
-	}}
-	req, err := http.NewRequest("GET", panelURL, nil)
+	client := g.httpClient("getPanelPng", dashName)
+	req, err := g.newRequest("GET", panelURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating getPanelPng request for %v: %v", panelURL, err)
 	}
-	if g.apiToken != "" {
-		req.Header.Add("Authorization", "Bearer "+g.apiToken)
-	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error executing getPanelPng request for %v: %v", panelURL, err)
+		return nil, g.requestError("getPanelPng", panelURL, err)
 	}
 
 	for retries := 1; retries < 3 && resp.StatusCode != 200; retries++ {
+		if g.renderMode == RenderAuto && rendererUnavailable(resp) {
+			//retrying won't install a renderer
+			resp.Body.Close()
+			return nil, ErrRendererUnavailable
+		}
 		delay := getPanelRetrySleepTime * time.Duration(retries)
 		log.Printf("Error obtaining render for panel %+v, Status: %v, Retrying after %v...", p, resp.StatusCode, delay)
-		time.Sleep(delay)
+		resp.Body.Close()
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, g.requestError("retry getPanelPng", panelURL, req.Context().Err())
+		}
 		resp, err = client.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("error executing retry getPanelPng request for %v: %v", panelURL, err)
+			return nil, g.requestError("retry getPanelPng", panelURL, err)
 		}
 	}
 
@@ -145,33 +481,64 @@ func (g client) GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadClose
 			panic(err)
 		}
 		log.Println("Error obtaining render:", string(body))
-		return nil, errors.New("Error obtaining render: " + resp.Status)
+		return nil, &StatusError{resp.StatusCode, resp.Status}
 	}
 
-	return resp.Body, nil
+	return renderedImage{resp.Body, renderAge(resp.Header, time.Now())}, nil
 }
 
 func (g client) getPanelURL(p Panel, dashName string, t TimeRange) string {
+	values := g.panelValues(p, t)
+	if RenderNonce {
+		values.Add(renderNonceParam, strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+	url := g.getPanelEndpoint(g.slugs.resolve(dashName), values)
+	log.Println("Downloading image ", p.Id, url)
+	return url
+}
+
+// PanelURL returns the render url of a panel image as GetPanelPng requests it, without the nonce and with credentials redacted.
+// It is empty in RenderData mode.
+func (g client) PanelURL(p Panel, dashName string, t TimeRange) string {
+	if g.renderMode == RenderData {
+		return ""
+	}
+	return redactURL(g.getPanelEndpoint(g.slugs.resolve(dashName), g.panelValues(p, t)))
+}
+
+// panelSize returns the width and height in pixels of a panel's image
+func (g client) panelSize(p Panel) (int, int) {
+	width, height := 1000, 500
+	if p.IsSingleStat() {
+		width, height = 300, 150
+	}
+	if g.renderScale > 0 {
+		width, height = int(float64(width)*g.renderScale), int(float64(height)*g.renderScale)
+	}
+	return width, height
+}
+
+// panelValues returns the query values of a panel's render url
+func (g client) panelValues(p Panel, t TimeRange) url.Values {
 	values := url.Values{}
 	values.Add("theme", "light")
 	values.Add("panelId", strconv.Itoa(p.Id))
 	values.Add("from", t.From)
 	values.Add("to", t.To)
-	if p.IsSingleStat() {
-		values.Add("width", "300")
-		values.Add("height", "150")
-	} else {
-		values.Add("width", "1000")
-		values.Add("height", "500")
+	if t.TZ != "" {
+		values.Add("tz", t.TZ)
+	}
+	width, height := g.panelSize(p)
+	values.Add("width", strconv.Itoa(width))
+	values.Add("height", strconv.Itoa(height))
+	if g.imageFormat == ImageJPEG {
+		values.Add("encoding", ImageJPEG)
 	}
 
-	for k, v := range g.variables {
+	for k, v := range g.variablesFor(p.Id) {
 		for _, singleValue := range v {
 			values.Add(k, singleValue)
 		}
 	}
-
-	url := g.getPanelEndpoint(dashName, values)
-	log.Println("Downloading image ", p.Id, url)
-	return url
+	return values
 }
@@ -17,6 +17,8 @@
 package grafana
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -54,6 +56,28 @@ func TestGrafanaClientFetchesDashboard(t *testing.T) {
 			})
 		})
 
+		Convey("When using a client with forwarded headers", func() {
+			var requestHeaders http.Header
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestHeaders = r.Header
+				fmt.Fprintln(w, `{"":""}`)
+			}))
+			defer ts.Close()
+			headers := http.Header{}
+			headers.Set("X-WEBAUTH-USER", "alice")
+			grf := NewV5Client(ts.URL, "", url.Values{}, WithHeaders(headers))
+
+			Convey("The headers should be sent with dashboard requests", func() {
+				grf.GetDashboard("rYy7Paekz")
+				So(requestHeaders.Get("X-Webauth-User"), ShouldEqual, "alice")
+			})
+
+			Convey("The headers should be sent with render requests", func() {
+				grf.GetPanelPng(Panel{Id: 44, Type: "graph", Title: "title"}, "rYy7Paekz", TimeRange{From: "now-1h", To: "now"})
+				So(requestHeaders.Get("X-Webauth-User"), ShouldEqual, "alice")
+			})
+		})
+
 	})
 }
 
@@ -82,7 +106,7 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 		}
 		for clientDesc, cl := range cases {
 			grf := cl.client
-			grf.GetPanelPng(Panel{44, "singlestat", "title"}, "testDash", TimeRange{"now-1h", "now"})
+			grf.GetPanelPng(Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{From: "now-1h", To: "now", TZ: "Europe/Berlin"})
 
 			Convey(fmt.Sprintf("The %s client should use the render endpoint with the dashboard name", clientDesc), func() {
 				So(requestURI, ShouldStartWith, cl.pngEndpoint)
@@ -97,6 +121,10 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 				So(requestURI, ShouldContainSubstring, "to=now")
 			})
 
+			Convey(fmt.Sprintf("The %s client should request the time zone", clientDesc), func() {
+				So(requestURI, ShouldContainSubstring, "tz=Europe%2FBerlin")
+			})
+
 			Convey(fmt.Sprintf("The %s client should render singlestat panels should request a smaller size", clientDesc), func() {
 				So(requestURI, ShouldContainSubstring, "width=300")
 				So(requestURI, ShouldContainSubstring, "height=150")
@@ -112,7 +140,7 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 			})
 
 			Convey(fmt.Sprintf("The %s client should request other panels in a larger size", clientDesc), func() {
-				grf.GetPanelPng(Panel{44, "graph", "title"}, "testDash", TimeRange{"now", "now-1h"})
+				grf.GetPanelPng(Panel{Id: 44, Type: "graph", Title: "title"}, "testDash", TimeRange{From: "now", To: "now-1h"})
 				So(requestURI, ShouldContainSubstring, "width=1000")
 				So(requestURI, ShouldContainSubstring, "height=500")
 			})
@@ -121,6 +149,34 @@ func TestGrafanaClientFetchesPanelPNG(t *testing.T) {
 	})
 }
 
+func TestGrafanaClientImageFormat(t *testing.T) {
+	Convey("When fetching a panel image in a requested format", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+		}))
+		defer ts.Close()
+		panel := Panel{Id: 44, Type: "graph"}
+		tr := TimeRange{From: "now-1h", To: "now"}
+
+		Convey("JPEG should be requested with the encoding parameter", func() {
+			NewV5Client(ts.URL, "", url.Values{}, WithImageFormat(ImageJPEG)).GetPanelPng(panel, "testDash", tr)
+			So(requestURI, ShouldContainSubstring, "encoding=jpeg")
+		})
+
+		Convey("PNG should be requested without the encoding parameter, for older renderers", func() {
+			NewV5Client(ts.URL, "", url.Values{}, WithImageFormat(ImagePNG)).GetPanelPng(panel, "testDash", tr)
+			So(requestURI, ShouldNotContainSubstring, "encoding")
+		})
+
+		Convey("A render scale should reduce the requested size", func() {
+			NewV5Client(ts.URL, "", url.Values{}, WithRenderScale(0.5)).GetPanelPng(panel, "testDash", tr)
+			So(requestURI, ShouldContainSubstring, "width=500")
+			So(requestURI, ShouldContainSubstring, "height=250")
+		})
+	})
+}
+
 func init() {
 	getPanelRetrySleepTime = time.Duration(1) * time.Millisecond //we want our tests to run fast
 }
@@ -140,7 +196,7 @@ func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 
 		grf := NewV4Client(ts.URL, "", url.Values{})
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title"}, "testDash", TimeRange{"now-1h", "now"})
+		_, err := grf.GetPanelPng(Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{From: "now-1h", To: "now"})
 
 		Convey("It should retry a couple of times if it receives errors", func() {
 			So(err, ShouldBeNil)
@@ -155,10 +211,78 @@ func TestGrafanaClientFetchPanelPNGErrorHandling(t *testing.T) {
 
 		grf := NewV4Client(ts.URL, "", url.Values{})
 
-		_, err := grf.GetPanelPng(Panel{44, "singlestat", "title"}, "testDash", TimeRange{"now-1h", "now"})
+		_, err := grf.GetPanelPng(Panel{Id: 44, Type: "singlestat", Title: "title"}, "testDash", TimeRange{From: "now-1h", To: "now"})
 
 		Convey("The Grafana API should return an error", func() {
 			So(err, ShouldNotBeNil)
 		})
 	})
 }
+
+func TestGrafanaClientFetchesHealth(t *testing.T) {
+	Convey("When fetching Grafana's health", t, func() {
+		requestURI := ""
+		status := http.StatusOK
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+			w.WriteHeader(status)
+			fmt.Fprintln(w, `{"commit":"abc123","database":"ok","version":"9.5.2"}`)
+		}))
+		defer ts.Close()
+		grf := NewV5Client(ts.URL, "", url.Values{})
+
+		Convey("It should return the version and commit from the health endpoint", func() {
+			h, err := grf.GetHealth()
+			So(err, ShouldBeNil)
+			So(requestURI, ShouldEqual, "/api/health")
+			So(h, ShouldResemble, Health{Version: "9.5.2", Commit: "abc123"})
+		})
+
+		Convey("It should return an error when the endpoint fails", func() {
+			status = http.StatusNotFound
+			_, err := grf.GetHealth()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCanceledRequests(t *testing.T) {
+	Convey("When the context of a client is canceled", t, func() {
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			http.Error(w, "renderer busy", http.StatusInternalServerError)
+		}))
+		defer ts.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		grf := NewV5Client(ts.URL, "", url.Values{}, WithContext(ctx))
+
+		Convey("Requests should not be sent, and not count as connection failures", func() {
+			cancel()
+			_, err := grf.GetDashboard("testDash")
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+			var connErr *ConnectionError
+			So(errors.As(err, &connErr), ShouldBeFalse)
+			So(requests, ShouldEqual, 0)
+		})
+
+		Convey("Panel render retries should stop waiting", func() {
+			defer func(orig time.Duration) { getPanelRetrySleepTime = orig }(getPanelRetrySleepTime)
+			getPanelRetrySleepTime = time.Hour
+			time.AfterFunc(50*time.Millisecond, cancel)
+			_, err := grf.GetPanelPng(Panel{Id: 1}, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("A passed deadline should not count as a connection failure either", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+			defer cancel()
+			_, err := NewV5Client(ts.URL, "", url.Values{}, WithContext(ctx)).GetDashboard("testDash")
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			var connErr *ConnectionError
+			So(errors.As(err, &connErr), ShouldBeFalse)
+		})
+	})
+}
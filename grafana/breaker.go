@@ -0,0 +1,201 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, without contacting Grafana, while the circuit breaker is open
+var ErrCircuitOpen = errors.New("grafana is unavailable, not sending request while the circuit breaker is open")
+
+// BreakerState is the state of a circuit Breaker
+type BreakerState int
+
+const (
+	// Closed lets all requests through
+	Closed BreakerState = iota
+	// Open rejects all requests until the cool down has passed
+	Open
+	// HalfOpen lets a single probe request through to decide whether to close or re-open the circuit
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker is a circuit breaker guarding a Grafana instance.
+// After threshold consecutive connection failures it opens and new requests fail immediately with ErrCircuitOpen.
+// Once coolDown has passed, the next request is let through as a probe: success closes the circuit, failure re-opens it.
+// A Breaker is safe for concurrent use and is meant to be shared by all clients of the same Grafana instance.
+type Breaker struct {
+	threshold int
+	coolDown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewBreaker creates a closed circuit Breaker
+func NewBreaker(threshold int, coolDown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, coolDown: coolDown, now: time.Now}
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.coolDown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Wrap returns a Client whose requests are guarded by the breaker
+func (b *Breaker) Wrap(c Client) Client {
+	return breakerClient{c, b}
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		return true
+	case Open:
+		if b.now().Sub(b.openedAt) < b.coolDown {
+			return false
+		}
+		log.Println("Grafana circuit breaker half-open, probing Grafana")
+		b.state = HalfOpen
+		return true
+	}
+	//HalfOpen: a probe is already in flight
+	return false
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := err.(*ConnectionError); !ok {
+		//Grafana answered, even if it was with an error status
+		if b.state != Closed {
+			log.Println("Grafana circuit breaker closed")
+		}
+		b.state = Closed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		if b.state != Open {
+			log.Printf("Grafana circuit breaker open after %d consecutive connection failures, last: %v", b.failures, err)
+		}
+		b.state = Open
+		b.openedAt = b.now()
+	}
+}
+
+type breakerClient struct {
+	Client
+	breaker *Breaker
+}
+
+func (c breakerClient) GetDashboard(dashName string) (Dashboard, error) {
+	if !c.breaker.allow() {
+		return Dashboard{}, ErrCircuitOpen
+	}
+	dash, err := c.Client.GetDashboard(dashName)
+	c.breaker.record(err)
+	return dash, err
+}
+
+func (c breakerClient) GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+	if !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	body, err := c.Client.GetPanelPng(p, dashName, t)
+	c.breaker.record(err)
+	return body, err
+}
+
+func (c breakerClient) GetPlaylist(id string) (Playlist, error) {
+	if !c.breaker.allow() {
+		return Playlist{}, ErrCircuitOpen
+	}
+	pl, err := c.Client.GetPlaylist(id)
+	c.breaker.record(err)
+	return pl, err
+}
+
+func (c breakerClient) SearchDashboards(query url.Values) ([]DashboardRef, error) {
+	if !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	refs, err := c.Client.SearchDashboards(query)
+	c.breaker.record(err)
+	return refs, err
+}
+
+func (c breakerClient) GetPanelData(p Panel, t TimeRange) (PanelData, error) {
+	if !c.breaker.allow() {
+		return PanelData{}, ErrCircuitOpen
+	}
+	data, err := c.Client.GetPanelData(p, t)
+	c.breaker.record(err)
+	return data, err
+}
+
+func (c breakerClient) CreateAnnotation(a Annotation) error {
+	if !c.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	err := c.Client.CreateAnnotation(a)
+	c.breaker.record(err)
+	return err
+}
+
+func (c breakerClient) GetHealth() (Health, error) {
+	if !c.breaker.allow() {
+		return Health{}, ErrCircuitOpen
+	}
+	h, err := c.Client.GetHealth()
+	c.breaker.record(err)
+	return h, err
+}
+
+func (c breakerClient) PanelURL(p Panel, dashName string, t TimeRange) string {
+	return PanelURL(c.Client, p, dashName, t)
+}
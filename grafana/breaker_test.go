@@ -0,0 +1,126 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBreaker(t *testing.T) {
+	Convey("When requests to Grafana pass through a circuit breaker", t, func() {
+		//shared with the server's goroutines
+		var down, notFound, requests int32 = 1, 0, 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if atomic.LoadInt32(&notFound) == 1 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if atomic.LoadInt32(&down) == 1 {
+				//drop the connection without answering, like a crashed Grafana
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			fmt.Fprintln(w, `{"Dashboard":{"Title":"up"}}`)
+		}))
+		defer ts.Close()
+
+		clock := time.Date(2018, time.March, 21, 6, 0, 0, 0, time.UTC)
+		breaker := NewBreaker(2, time.Minute)
+		breaker.now = func() time.Time { return clock }
+		grf := breaker.Wrap(NewV5Client(ts.URL, "", url.Values{}))
+
+		Convey("It should start closed", func() {
+			So(breaker.State(), ShouldEqual, Closed)
+		})
+
+		Convey("Connection failures below the threshold should keep it closed", func() {
+			_, err := grf.GetDashboard("testDash")
+			So(err, ShouldHaveSameTypeAs, &ConnectionError{})
+			So(breaker.State(), ShouldEqual, Closed)
+		})
+
+		Convey("Consecutive connection failures reaching the threshold should open it", func() {
+			grf.GetDashboard("testDash")
+			grf.GetDashboard("testDash")
+			So(breaker.State(), ShouldEqual, Open)
+
+			Convey("While open, requests should fail immediately without reaching Grafana", func() {
+				_, err := grf.GetDashboard("testDash")
+				So(err, ShouldEqual, ErrCircuitOpen)
+				_, err = grf.GetPanelPng(Panel{Id: 1}, "testDash", TimeRange{From: "now-1h", To: "now"})
+				So(err, ShouldEqual, ErrCircuitOpen)
+				_, err = grf.GetPlaylist("1")
+				So(err, ShouldEqual, ErrCircuitOpen)
+				_, err = grf.SearchDashboards(url.Values{"tag": {"reports"}})
+				So(err, ShouldEqual, ErrCircuitOpen)
+				So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			})
+
+			Convey("After the cool down it should be half-open", func() {
+				clock = clock.Add(time.Minute)
+				So(breaker.State(), ShouldEqual, HalfOpen)
+
+				Convey("A successful probe should close it", func() {
+					atomic.StoreInt32(&down, 0)
+					dash, err := grf.GetDashboard("testDash")
+					So(err, ShouldBeNil)
+					So(dash.Title, ShouldEqual, "up")
+					So(breaker.State(), ShouldEqual, Closed)
+				})
+
+				Convey("A failed probe should re-open it for another cool down", func() {
+					grf.GetDashboard("testDash")
+					So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+					So(breaker.State(), ShouldEqual, Open)
+					_, err := grf.GetDashboard("testDash")
+					So(err, ShouldEqual, ErrCircuitOpen)
+				})
+
+				Convey("Only one probe should be let through at a time", func() {
+					So(breaker.allow(), ShouldBeTrue)
+					So(breaker.allow(), ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("A success should reset the consecutive failure count", func() {
+			grf.GetDashboard("testDash")
+			atomic.StoreInt32(&down, 0)
+			grf.GetDashboard("testDash")
+			atomic.StoreInt32(&down, 1)
+			grf.GetDashboard("testDash")
+			So(breaker.State(), ShouldEqual, Closed)
+		})
+
+		Convey("Error responses from a reachable Grafana should not count as failures", func() {
+			atomic.StoreInt32(&notFound, 1)
+			grf.GetDashboard("testDash")
+			grf.GetDashboard("testDash")
+			So(breaker.State(), ShouldEqual, Closed)
+		})
+	})
+}
@@ -0,0 +1,185 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// chartColors is Grafana's classic series palette
+var chartColors = []color.RGBA{
+	{0x7e, 0xb2, 0x6d, 0xff},
+	{0xea, 0xb8, 0x39, 0xff},
+	{0x6e, 0xd0, 0xe0, 0xff},
+	{0xef, 0x84, 0x3c, 0xff},
+	{0xe2, 0x4d, 0x42, 0xff},
+	{0x1f, 0x78, 0xc1, 0xff},
+	{0xba, 0x43, 0xa9, 0xff},
+	{0x70, 0x5d, 0xa0, 0xff},
+}
+
+var (
+	chartGrid   = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	chartAxis   = color.RGBA{0x80, 0x80, 0x80, 0xff}
+	placeholder = color.RGBA{0xc0, 0xc0, 0xc0, 0xff}
+)
+
+// isChartable reports whether drawChart can draw the panel type from its data.
+// Stat panels are drawn as the sparkline of their value.
+func isChartable(p Panel) bool {
+	return p.Type == "graph" || p.Type == "timeseries" || p.Type == "stat"
+}
+
+// drawChart draws the numeric series of data as lines over time, scaled to fit the image.
+// Charts have grid lines but no labels or legend. Panels that cannot be charted are drawn as a
+// crossed out placeholder.
+func drawChart(p Panel, data PanelData, width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	if !isChartable(p) {
+		drawLine(img, 0, 0, width-1, height-1, placeholder)
+		drawLine(img, 0, height-1, width-1, 0, placeholder)
+		drawRect(img, img.Bounds(), placeholder)
+		return img
+	}
+
+	const margin = 10
+	plot := image.Rect(margin, margin, width-margin, height-margin)
+	for i := 1; i < 4; i++ {
+		y := plot.Min.Y + i*plot.Dy()/4
+		drawLine(img, plot.Min.X, y, plot.Max.X, y, chartGrid)
+	}
+	drawLine(img, plot.Min.X, plot.Min.Y, plot.Min.X, plot.Max.Y, chartAxis)
+	drawLine(img, plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y, chartAxis)
+
+	series := chartSeries(data)
+	minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, pt := range s {
+			minX, maxX = math.Min(minX, pt[0]), math.Max(maxX, pt[0])
+			minY, maxY = math.Min(minY, pt[1]), math.Max(maxY, pt[1])
+		}
+	}
+	if maxX == minX {
+		maxX++
+	}
+	if maxY == minY {
+		maxY++
+	}
+	scale := func(pt [2]float64) (int, int) {
+		x := plot.Min.X + int(math.Round((pt[0]-minX)/(maxX-minX)*float64(plot.Dx())))
+		y := plot.Max.Y - int(math.Round((pt[1]-minY)/(maxY-minY)*float64(plot.Dy())))
+		return x, y
+	}
+	for i, s := range series {
+		c := chartColors[i%len(chartColors)]
+		for j := 1; j < len(s); j++ {
+			x0, y0 := scale(s[j-1])
+			x1, y1 := scale(s[j])
+			drawLine(img, x0, y0, x1, y1, c)
+		}
+	}
+	return img
+}
+
+// chartSeries returns the points of every numeric field of the frames, with the frame's time field as x.
+// Null values, e.g. gaps in the data, are skipped.
+func chartSeries(data PanelData) [][][2]float64 {
+	var series [][][2]float64
+	for _, f := range data.Frames {
+		var times []interface{}
+		for _, field := range f.Fields {
+			if field.Type == "time" {
+				times = field.Values
+				break
+			}
+		}
+		if times == nil {
+			continue
+		}
+		for _, field := range f.Fields {
+			if !field.IsNumeric() {
+				continue
+			}
+			var s [][2]float64
+			for i, v := range field.Values {
+				x, okX := chartValue(times, i)
+				y, okY := v.(float64)
+				if okX && okY {
+					s = append(s, [2]float64{x, y})
+				}
+			}
+			if len(s) > 0 {
+				series = append(series, s)
+			}
+		}
+	}
+	return series
+}
+
+func chartValue(values []interface{}, i int) (float64, bool) {
+	if i >= len(values) {
+		return 0, false
+	}
+	v, ok := values[i].(float64)
+	return v, ok
+}
+
+// drawLine draws a line with Bresenham's algorithm
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func drawRect(img *image.RGBA, r image.Rectangle, c color.Color) {
+	drawLine(img, r.Min.X, r.Min.Y, r.Max.X-1, r.Min.Y, c)
+	drawLine(img, r.Max.X-1, r.Min.Y, r.Max.X-1, r.Max.Y-1, c)
+	drawLine(img, r.Max.X-1, r.Max.Y-1, r.Min.X, r.Max.Y-1, c)
+	drawLine(img, r.Min.X, r.Max.Y-1, r.Min.X, r.Min.Y, c)
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -17,7 +17,8 @@
 package grafana
 
 import (
-	"encoding/json"
+	"bytes"
+	"io"
 	"log"
 	"net/url"
 	"strings"
@@ -25,17 +26,38 @@ import (
 
 // Panel represents a Grafana dashboard panel
 type Panel struct {
-	Id    int
-	Type  string
-	Title string
+	Id          int
+	Type        string
+	Title       string
+	PlainTitle  string                   `json:"-"` //Not present in the Grafana JSON structure. Title without TeX markup, for PDF bookmarks
+	Datasource  interface{}              //data source name, or a {"uid": ...} reference in newer Grafana versions
+	Targets     []map[string]interface{} //the panel's queries, passed to the data source query API as is
+	FieldConfig struct {
+		Defaults struct {
+			Unit string
+		}
+	}
+	LibraryPanel struct {
+		UID  string
+		Name string
+	} //set when the panel is a stub referencing a shared library panel
+	Repeat        string   //name of the variable the panel is repeated for
+	RepeatPanelId int      //set on panels generated by repeating the panel with this id
+	Collapsed     bool     //set on collapsed rows, which hold their panels in Panels
+	Panels        []Panel  //the panels of a collapsed row
+	Warning       string   `json:"-"` //Not present in the Grafana JSON structure. Enriched caption used by the Tex templating, e.g. about incomplete data
+	Sources       []string `json:"-"` //Not present in the Grafana JSON structure. Names of the queried data sources, set by WithDatasourceNames
+	ThumbnailPath string   `json:"-"` //Not present in the Grafana JSON structure. Image of the panel's thumbnail for contact sheets, set by the report
 }
 
 // Row represents a container for Panels
 type Row struct {
-	Id        int
-	Showtitle bool
-	Title     string
-	Panels    []Panel
+	Id         int
+	Showtitle  bool
+	Collapse   bool
+	Title      string
+	PlainTitle string `json:"-"` //Not present in the Grafana JSON structure. Title without TeX markup, for PDF bookmarks
+	Panels     []Panel
 }
 
 // Dashboard represents a Grafana dashboard
@@ -43,10 +65,20 @@ type Row struct {
 // and then enriched (sanitize fields for TeX consumption and add VarialbeValues)
 type Dashboard struct {
 	Title          string
+	PlainTitle     string //Not present in the Grafana JSON structure. Title without TeX markup, for PDF bookmarks
 	Description    string
-	VariableValues string //Not present in the Grafana JSON structure. Enriched data passed used by the Tex templating
+	Timezone       string              //"browser", "utc" or an IANA zone name. Empty when not set
+	Version        int                 //Incremented by Grafana on every save
+	VariableValues string              //Not present in the Grafana JSON structure. Enriched data passed used by the Tex templating
+	Variables      map[string][]string //Not present in the Grafana JSON structure. Values of the template variables by name, for InterpolateVariables
+	Templating     Templating
+	Timepicker     Timepicker
+	Tags           []string
+	Links          []Link
+	ReportDefaults url.Values //Not present in the Grafana JSON structure. Report parameters declared by report: tags and a reporter:{...} blob, see reportDefaults
 	Rows           []Row
 	Panels         []Panel
+	SkippedPanels  []SkippedPanel //Not present in the Grafana JSON structure. Panels left out for their type, see SkipPanelTypes
 }
 
 type dashContainer struct {
@@ -54,25 +86,54 @@ type dashContainer struct {
 	Meta      struct {
 		Slug string
 	}
+	includeCollapsed bool
+	skipTypes        map[string]bool //normalized by panelTypeKey
 }
 
 // NewDashboard creates Dashboard from Grafana's internal JSON dashboard definition
 func NewDashboard(dashJSON []byte, variables url.Values) Dashboard {
-	var dash dashContainer
-	err := json.Unmarshal(dashJSON, &dash)
+	return newDashboard(dashJSON, variables, false, panelTypeSet(SkipPanelTypes))
+}
+
+// newDashboard creates Dashboard from Grafana's JSON, including the panels of collapsed rows when includeCollapsed is set
+// and leaving out the panels whose types are in skipTypes
+func newDashboard(dashJSON []byte, variables url.Values, includeCollapsed bool, skipTypes map[string]bool) Dashboard {
+	d, err := decodeDashboard(bytes.NewReader(dashJSON), variables, includeCollapsed, skipTypes)
 	if err != nil {
 		panic(err)
 	}
+	return d
+}
+
+// decodeDashboard creates Dashboard from Grafana's JSON as it is read from r, see decodeDashContainer
+func decodeDashboard(r io.Reader, variables url.Values, includeCollapsed bool, skipTypes map[string]bool) (Dashboard, error) {
+	dash, err := decodeDashContainer(r)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	dash.includeCollapsed = includeCollapsed
+	dash.skipTypes = skipTypes
 	d := dash.NewDashboard(variables)
 	log.Printf("Populated dashboard datastructure: %+v\n", d)
-	return d
+	return d, nil
 }
 
 func (dc dashContainer) NewDashboard(variables url.Values) Dashboard {
 	var dash Dashboard
-	dash.Title = sanitizeLaTexInput(dc.Dashboard.Title)
-	dash.Description = sanitizeLaTexInput(dc.Dashboard.Description)
-	dash.VariableValues = sanitizeLaTexInput(getVariablesValues(variables))
+	dash.Variables = variableValues(dc.Dashboard.Templating.defaults(), variables)
+	title := InterpolateVariables(dc.Dashboard.Title, dash.Variables)
+	dash.Title = texTitle(title)
+	dash.PlainTitle = plainTitle(title)
+	var description string
+	dash.ReportDefaults, description = reportDefaults(dc.Dashboard.Tags, dc.Dashboard.Description, dc.Dashboard.Links)
+	dash.Description = SanitizeLaTexInput(InterpolateVariables(description, dash.Variables))
+	dash.Timezone = dc.Dashboard.Timezone
+	dash.Version = dc.Dashboard.Version
+	dash.Templating = dc.Dashboard.Templating
+	dash.Timepicker = dc.Dashboard.Timepicker
+	dash.Tags = dc.Dashboard.Tags
+	dash.Links = dc.Dashboard.Links
+	dash.VariableValues = SanitizeLaTexInput(getVariablesValues(variables))
 
 	if len(dc.Dashboard.Rows) == 0 {
 		return populatePanelsFromV5JSON(dash, dc)
@@ -81,13 +142,34 @@ func (dc dashContainer) NewDashboard(variables url.Values) Dashboard {
 }
 
 func populatePanelsFromV4JSON(dash Dashboard, dc dashContainer) Dashboard {
+	var all []Panel
 	for _, row := range dc.Dashboard.Rows {
-		row.Title = sanitizeLaTexInput(row.Title)
-		for i, p := range row.Panels {
-			p.Title = sanitizeLaTexInput(p.Title)
-			row.Panels[i] = p
+		all = append(all, row.Panels...)
+	}
+	instances := repeatInstances(all)
+	for _, row := range dc.Dashboard.Rows {
+		if row.Collapse && !dc.includeCollapsed {
+			for _, p := range row.Panels {
+				logSkippedPanel(p, "in collapsed row")
+			}
+			continue
+		}
+		rowTitle := InterpolateVariables(row.Title, dash.Variables)
+		row.PlainTitle = plainTitle(rowTitle)
+		row.Title = texTitle(rowTitle)
+		var kept []Panel
+		for _, p := range row.Panels {
+			if reason := skipReason(p, instances, dc.skipTypes); reason != "" {
+				dash.skipPanel(p, reason, dc.skipTypes)
+				continue
+			}
+			title := InterpolateVariables(p.Title, dash.Variables)
+			p.PlainTitle = plainTitle(title)
+			p.Title = texTitle(title)
+			kept = append(kept, p)
 			dash.Panels = append(dash.Panels, p)
 		}
+		row.Panels = kept
 		dash.Rows = append(dash.Rows, row)
 	}
 
@@ -95,16 +177,36 @@ func populatePanelsFromV4JSON(dash Dashboard, dc dashContainer) Dashboard {
 }
 
 func populatePanelsFromV5JSON(dash Dashboard, dc dashContainer) Dashboard {
+	instances := repeatInstances(dc.Dashboard.Panels)
 	for _, p := range dc.Dashboard.Panels {
-		if p.Type == "row" {
+		if p.Type != "row" {
+			dash = appendV5Panel(dash, p, instances, dc.skipTypes)
 			continue
 		}
-		p.Title = sanitizeLaTexInput(p.Title)
-		dash.Panels = append(dash.Panels, p)
+		//collapsed rows hold their panels, expanded rows are followed by them
+		for _, nested := range p.Panels {
+			if !dc.includeCollapsed {
+				logSkippedPanel(nested, "in collapsed row")
+				continue
+			}
+			dash = appendV5Panel(dash, nested, instances, dc.skipTypes)
+		}
 	}
 	return dash
 }
 
+func appendV5Panel(dash Dashboard, p Panel, instances map[int]bool, skipTypes map[string]bool) Dashboard {
+	if reason := skipReason(p, instances, skipTypes); reason != "" {
+		dash.skipPanel(p, reason, skipTypes)
+		return dash
+	}
+	title := InterpolateVariables(p.Title, dash.Variables)
+	p.PlainTitle = plainTitle(title)
+	p.Title = texTitle(title)
+	dash.Panels = append(dash.Panels, p)
+	return dash
+}
+
 func (p Panel) IsSingleStat() bool {
 	if p.Type == "singlestat" {
 		return true
@@ -112,19 +214,50 @@ func (p Panel) IsSingleStat() bool {
 	return false
 }
 
+// Unit returns the panel's configured unit, e.g. "bytes" or "percent". Empty when not set
+func (p Panel) Unit() string {
+	return p.FieldConfig.Defaults.Unit
+}
+
+// DatasourceNames returns the names, or uids of newer Grafana versions, of the data sources queried by the panel
+func (p Panel) DatasourceNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(ds interface{}) {
+		var name string
+		switch v := ds.(type) {
+		case string:
+			name = v
+		case map[string]interface{}:
+			name, _ = v["uid"].(string)
+		}
+		//"-- Mixed --" panels name the data source per target
+		if name != "" && name != "-- Mixed --" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(p.Datasource)
+	for _, t := range p.Targets {
+		add(t["datasource"])
+	}
+	return names
+}
+
 func (r Row) IsVisible() bool {
 	return r.Showtitle
 }
 
 func getVariablesValues(variables url.Values) string {
 	values := []string{}
-	for _, v := range variables {
-		values = append(values, strings.Join(v, ", "))
+	for _, k := range sortedKeys(variables) {
+		values = append(values, strings.Join(variables[k], ", "))
 	}
 	return strings.Join(values, ", ")
 }
 
-func sanitizeLaTexInput(input string) string {
+// SanitizeLaTexInput escapes the characters that have a special meaning in LaTeX
+func SanitizeLaTexInput(input string) string {
 	input = strings.Replace(input, "\\", "\\textbackslash ", -1)
 	input = strings.Replace(input, "&", "\\&", -1)
 	input = strings.Replace(input, "%", "\\%", -1)
@@ -135,5 +268,8 @@ func sanitizeLaTexInput(input string) string {
 	input = strings.Replace(input, "}", "\\}", -1)
 	input = strings.Replace(input, "~", "\\textasciitilde ", -1)
 	input = strings.Replace(input, "^", "\\textasciicircum ", -1)
+	input = strings.Replace(input, "<", "\\textless ", -1)
+	input = strings.Replace(input, ">", "\\textgreater ", -1)
+	input = strings.Replace(input, "|", "\\textbar ", -1)
 	return input
 }
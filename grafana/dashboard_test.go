@@ -83,7 +83,9 @@ func TestV5Dashboard(t *testing.T) {
 			{"Type":"graph", "Id":1},
 			{"Type":"singlestat", "Id":2, "Title":"Panel3Title #"},
 			{"Type":"row", "Id":3}],
-		"Title":"DashTitle #"
+		"Title":"DashTitle #",
+		"timezone":"utc",
+		"version":3
 	},
 
 "Meta":
@@ -111,6 +113,14 @@ func TestV5Dashboard(t *testing.T) {
 		Convey("The Title should be parsed", func() {
 			So(dash.Title, ShouldEqual, "DashTitle \\#")
 		})
+
+		Convey("The time zone should be parsed", func() {
+			So(dash.Timezone, ShouldEqual, "utc")
+		})
+
+		Convey("The version should be parsed", func() {
+			So(dash.Version, ShouldEqual, 3)
+		})
 	})
 }
 
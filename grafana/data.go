@@ -0,0 +1,172 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// PanelData is the result of a panel's queries, as returned by Grafana's data source query API
+type PanelData struct {
+	Frames []Frame
+}
+
+// Frame is a table of named fields of equal length, e.g. one time series
+type Frame struct {
+	Name   string
+	Fields []Field
+}
+
+// Field is a column of a Frame. Values hold float64 for numbers, string for strings and nil for nulls.
+// Time fields hold the unix time in milliseconds.
+type Field struct {
+	Name   string
+	Type   string //"time", "number", "string" or "boolean"
+	Unit   string
+	Values []interface{}
+}
+
+// IsNumeric reports whether the field holds numbers
+func (f Field) IsNumeric() bool {
+	return f.Type == "number"
+}
+
+type dsQueryResponse struct {
+	Results map[string]struct {
+		Error  string
+		Frames []struct {
+			Schema struct {
+				Name   string
+				Fields []struct {
+					Name   string
+					Type   string
+					Config struct {
+						Unit string
+					}
+				}
+			}
+			Data struct {
+				Values [][]interface{}
+			}
+		}
+	}
+}
+
+// NewPanelData creates PanelData from the JSON response of Grafana's /api/ds/query endpoint.
+// Frames are ordered by the refId of the query that returned them.
+func NewPanelData(respJSON []byte) (PanelData, error) {
+	var resp dsQueryResponse
+	err := json.Unmarshal(respJSON, &resp)
+	if err != nil {
+		return PanelData{}, err
+	}
+
+	refIDs := make([]string, 0, len(resp.Results))
+	for refID := range resp.Results {
+		refIDs = append(refIDs, refID)
+	}
+	sort.Strings(refIDs)
+
+	var data PanelData
+	for _, refID := range refIDs {
+		result := resp.Results[refID]
+		if result.Error != "" {
+			return PanelData{}, fmt.Errorf("query %v failed: %v", refID, result.Error)
+		}
+		for _, rf := range result.Frames {
+			f := Frame{Name: rf.Schema.Name}
+			for i, sf := range rf.Schema.Fields {
+				field := Field{Name: sf.Name, Type: sf.Type, Unit: sf.Config.Unit}
+				if i < len(rf.Data.Values) {
+					field.Values = rf.Data.Values[i]
+				}
+				f.Fields = append(f.Fields, field)
+			}
+			data.Frames = append(data.Frames, f)
+		}
+	}
+	return data, nil
+}
+
+// newDataQuery builds the /api/ds/query request body for the panel's targets.
+// Grafana only interpolates template variables in the browser, so $name and ${name} are replaced here.
+func newDataQuery(p Panel, t TimeRange, variables url.Values) ([]byte, error) {
+	if len(p.Targets) == 0 {
+		return nil, fmt.Errorf("panel %v has no queries", p.Id)
+	}
+	queries := make([]interface{}, 0, len(p.Targets))
+	for i, target := range p.Targets {
+		q := map[string]interface{}{}
+		for k, v := range target {
+			q[k] = interpolateVariables(v, variables)
+		}
+		if q["datasource"] == nil {
+			q["datasource"] = p.Datasource
+		}
+		if q["refId"] == nil {
+			q["refId"] = string(rune('A' + i))
+		}
+		queries = append(queries, q)
+	}
+	return json.Marshal(map[string]interface{}{
+		"from":    t.From,
+		"to":      t.To,
+		"queries": queries,
+	})
+}
+
+func interpolateVariables(v interface{}, variables url.Values) interface{} {
+	switch val := v.(type) {
+	case string:
+		return variableReplacer(variables).Replace(val)
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k, e := range val {
+			out[k] = interpolateVariables(e, variables)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, e := range val {
+			out[i] = interpolateVariables(e, variables)
+		}
+		return out
+	}
+	return v
+}
+
+func variableReplacer(variables url.Values) *strings.Replacer {
+	names := []string{}
+	for k := range variables {
+		if strings.HasPrefix(k, "var-") {
+			names = append(names, strings.TrimPrefix(k, "var-"))
+		}
+	}
+	//longest names first, so $hostname is not replaced as $host followed by "name"
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	oldnew := []string{}
+	for _, name := range names {
+		value := strings.Join(variables["var-"+name], ",")
+		oldnew = append(oldnew, "${"+name+"}", value, "$"+name, value)
+	}
+	return strings.NewReplacer(oldnew...)
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const promDataJSON = `
+{"results":{"A":{"frames":[{
+	"schema":{"name":"up","refId":"A","fields":[
+		{"name":"Time","type":"time","typeInfo":{"frame":"time.Time"}},
+		{"name":"Value","type":"number","labels":{"job":"node"},"config":{"unit":"percent"}}]},
+	"data":{"values":[[1600000000000,1600000060000],[0.5,1]]}}]}}}`
+
+func TestNewPanelData(t *testing.T) {
+	Convey("When parsing a data source query response", t, func() {
+		data, err := NewPanelData([]byte(promDataJSON))
+		So(err, ShouldBeNil)
+
+		Convey("It should contain the frame's fields with their types, units and values", func() {
+			So(data.Frames, ShouldHaveLength, 1)
+			So(data.Frames[0].Name, ShouldEqual, "up")
+			fields := data.Frames[0].Fields
+			So(fields, ShouldHaveLength, 2)
+			So(fields[0].IsNumeric(), ShouldBeFalse)
+			So(fields[1].IsNumeric(), ShouldBeTrue)
+			So(fields[1].Unit, ShouldEqual, "percent")
+			So(fields[1].Values, ShouldResemble, []interface{}{0.5, 1.0})
+		})
+
+		Convey("A failed query should return an error", func() {
+			_, err := NewPanelData([]byte(`{"results":{"A":{"error":"parse error"}}}`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "parse error")
+		})
+	})
+}
+
+func TestGrafanaClientFetchesPanelData(t *testing.T) {
+	Convey("When fetching panel data", t, func() {
+		var requestPath, requestMethod string
+		var query map[string]interface{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestPath, requestMethod = r.URL.Path, r.Method
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &query)
+			fmt.Fprint(w, promDataJSON)
+		}))
+		defer ts.Close()
+
+		variables := url.Values{}
+		variables.Add("var-host", "dev")
+		variables.Add("var-hostname", "dev.example.com")
+		p := Panel{
+			Id:         4,
+			Datasource: "Prometheus",
+			Targets:    []map[string]interface{}{{"expr": `up{host="$host",name="${hostname}"}`}},
+		}
+
+		Convey("The v5 client should post the panel's queries to the data source query API", func() {
+			data, err := NewV5Client(ts.URL, "", variables).GetPanelData(p, TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldBeNil)
+			So(data.Frames, ShouldHaveLength, 1)
+			So(requestMethod, ShouldEqual, "POST")
+			So(requestPath, ShouldEqual, "/api/ds/query")
+			So(query["from"], ShouldEqual, "now-1h")
+			So(query["to"], ShouldEqual, "now")
+
+			q := query["queries"].([]interface{})[0].(map[string]interface{})
+			So(q["refId"], ShouldEqual, "A")
+			So(q["datasource"], ShouldEqual, "Prometheus")
+
+			Convey("Template variables should be interpolated", func() {
+				So(q["expr"], ShouldEqual, `up{host="dev",name="dev.example.com"}`)
+			})
+		})
+
+		Convey("Panels without queries should return an error", func() {
+			_, err := NewV5Client(ts.URL, "", variables).GetPanelData(Panel{Id: 4}, TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The v4 client should return an error", func() {
+			_, err := NewV4Client(ts.URL, "", variables).GetPanelData(p, TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
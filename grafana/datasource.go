@@ -0,0 +1,92 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"log"
+	"sync"
+)
+
+// datasourceNames caches Grafana's data sources for the lifetime of a client, e.g. a multi-dashboard report
+type datasourceNames struct {
+	once        sync.Once
+	byUID       map[string]string
+	defaultName string
+}
+
+// WithDatasourceNames sets each panel's Sources to the names of the data sources it queries,
+// resolving uids with Grafana's data source API
+func WithDatasourceNames(enabled bool) ClientOption {
+	return func(g *client) {
+		g.resolveDatasources = enabled
+	}
+}
+
+// resolveDatasourceNames sets the Sources of the dashboard's panels. Data sources that cannot be resolved keep their reference.
+func (g client) resolveDatasourceNames(dash *Dashboard) {
+	for i, p := range dash.Panels {
+		refs := p.DatasourceNames()
+		var sources []string
+		seen := map[string]bool{}
+		for _, ref := range refs {
+			name := g.datasourceName(InterpolateVariables(ref, dash.Variables))
+			if !seen[name] {
+				seen[name] = true
+				sources = append(sources, SanitizeLaTexInput(name))
+			}
+		}
+		//panels without a data source reference query the default data source
+		if len(refs) == 0 && len(p.Targets) > 0 {
+			if name := g.datasourceName(""); name != "" {
+				sources = append(sources, SanitizeLaTexInput(name))
+			}
+		}
+		dash.Panels[i].Sources = sources
+	}
+}
+
+// datasourceName returns the name of the data source with the uid, the default data source for an empty uid.
+// Older Grafana versions reference data sources by name, which is returned as is.
+func (g client) datasourceName(uid string) string {
+	g.datasources.once.Do(func() {
+		var list []struct {
+			Uid       string
+			Name      string
+			IsDefault bool
+		}
+		err := g.getJSON("getDatasources", endpoint(g.url, nil, "api", "datasources"), &list)
+		if err != nil {
+			log.Println("Error fetching data source names, using their references instead:", err)
+		}
+		g.datasources.byUID = map[string]string{}
+		for _, ds := range list {
+			if ds.Uid != "" {
+				g.datasources.byUID[ds.Uid] = ds.Name
+			}
+			if ds.IsDefault {
+				g.datasources.defaultName = ds.Name
+			}
+		}
+	})
+	if uid == "" {
+		return g.datasources.defaultName
+	}
+	if name, ok := g.datasources.byUID[uid]; ok {
+		return name
+	}
+	return uid
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const datasourceDashJSON = `
+{"dashboard":{"title":"Sources","templating":{"list":[{"name":"ds","current":{"value":"loki-uid"}}]},"panels":[
+	{"type":"graph","id":1,"datasource":"Prometheus-prod","targets":[{"expr":"up"}]},
+	{"type":"timeseries","id":2,"datasource":{"type":"prometheus","uid":"prom-uid"},"targets":[{"expr":"up"}]},
+	{"type":"timeseries","id":3,"datasource":{"type":"datasource","uid":"-- Mixed --"},"targets":[
+		{"datasource":{"uid":"prom-uid"},"expr":"up"},
+		{"datasource":{"uid":"${ds}"},"expr":"{job=\"app\"}"},
+		{"datasource":{"uid":"prom-uid"},"expr":"down"}]},
+	{"type":"timeseries","id":4,"datasource":{"uid":"unknown-uid"},"targets":[{"expr":"up"}]},
+	{"type":"graph","id":5,"targets":[{"expr":"up"}]},
+	{"type":"text","id":6,"title":"Notes"},
+	{"type":"graph","id":7,"datasource":"elastic_logs","targets":[{"query":"*"}]}]}}`
+
+const datasourcesJSON = `[
+	{"id":1,"uid":"prom-uid","name":"Prometheus-prod","type":"prometheus","isDefault":true},
+	{"id":2,"uid":"loki-uid","name":"Loki","type":"loki"}]`
+
+func TestDatasourceNames(t *testing.T) {
+	Convey("When fetching a dashboard with datasource names requested", t, func() {
+		datasourceRequests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/dashboards/uid/sources":
+				fmt.Fprint(w, datasourceDashJSON)
+			case "/api/datasources":
+				datasourceRequests++
+				fmt.Fprint(w, datasourcesJSON)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer ts.Close()
+		g := NewV5Client(ts.URL, "", url.Values{}, WithDatasourceNames(true))
+		dash, err := g.GetDashboard("sources")
+		So(err, ShouldBeNil)
+		sources := map[int][]string{}
+		for _, p := range dash.Panels {
+			sources[p.Id] = p.Sources
+		}
+
+		Convey("A data source referenced by name should be kept", func() {
+			So(sources[1], ShouldResemble, []string{"Prometheus-prod"})
+		})
+
+		Convey("A data source referenced by uid should be resolved to its name", func() {
+			So(sources[2], ShouldResemble, []string{"Prometheus-prod"})
+		})
+
+		Convey("A mixed panel should list the data sources of its targets once, resolving variables", func() {
+			So(sources[3], ShouldResemble, []string{"Prometheus-prod", "Loki"})
+		})
+
+		Convey("An unknown uid should be kept", func() {
+			So(sources[4], ShouldResemble, []string{"unknown-uid"})
+		})
+
+		Convey("A panel without data source should use the default data source", func() {
+			So(sources[5], ShouldResemble, []string{"Prometheus-prod"})
+		})
+
+		Convey("A panel without queries should have no data source", func() {
+			So(sources[6], ShouldBeEmpty)
+		})
+
+		Convey("Names should be escaped for LaTeX", func() {
+			So(sources[7], ShouldResemble, []string{`elastic\_logs`})
+		})
+
+		Convey("The data sources should be fetched once per client", func() {
+			_, err := g.GetDashboard("sources")
+			So(err, ShouldBeNil)
+			So(datasourceRequests, ShouldEqual, 1)
+		})
+	})
+
+	Convey("When the data sources cannot be fetched", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/dashboards/uid/sources" {
+				fmt.Fprint(w, datasourceDashJSON)
+				return
+			}
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}))
+		defer ts.Close()
+		dash, err := NewV5Client(ts.URL, "", url.Values{}, WithDatasourceNames(true)).GetDashboard("sources")
+
+		Convey("The dashboard should be returned with the data source references", func() {
+			So(err, ShouldBeNil)
+			So(dash.Panels[1].Sources, ShouldResemble, []string{"prom-uid"})
+			So(dash.Panels[4].Sources, ShouldBeEmpty)
+		})
+	})
+
+	Convey("When datasource names are not requested", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, datasourceDashJSON)
+		}))
+		defer ts.Close()
+		dash, err := NewV5Client(ts.URL, "", url.Values{}).GetDashboard("sources")
+
+		Convey("No sources should be set", func() {
+			So(err, ShouldBeNil)
+			So(dash.Panels[1].Sources, ShouldBeNil)
+		})
+	})
+}
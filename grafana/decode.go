@@ -0,0 +1,160 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeDashContainer reads Grafana's dashboard JSON as it streams in. Only the fields of dashContainer are decoded:
+// panels and rows one at a time, and everything else, e.g. large field overrides or JSON models
+// of other plugins, is skipped token by token rather than held in memory.
+// The result equals that of json.Unmarshal, which matches field names case-insensitively as well.
+func decodeDashContainer(r io.Reader) (dashContainer, error) {
+	var dc dashContainer
+	dec := json.NewDecoder(r)
+	err := decodeObject(dec, func(key string) error {
+		switch strings.ToLower(key) {
+		case "dashboard":
+			return decodeDashboardFields(dec, &dc.Dashboard)
+		case "meta":
+			return dec.Decode(&dc.Meta)
+		}
+		return skipValue(dec)
+	})
+	return dc, err
+}
+
+// decodeDashboardFields decodes the fields of Dashboard from the dashboard object
+func decodeDashboardFields(dec *json.Decoder, dash *Dashboard) error {
+	return decodeObject(dec, func(key string) error {
+		switch strings.ToLower(key) {
+		case "title":
+			return dec.Decode(&dash.Title)
+		case "description":
+			return dec.Decode(&dash.Description)
+		case "timezone":
+			return dec.Decode(&dash.Timezone)
+		case "version":
+			return dec.Decode(&dash.Version)
+		case "templating":
+			return dec.Decode(&dash.Templating)
+		case "timepicker":
+			return dec.Decode(&dash.Timepicker)
+		case "tags":
+			return dec.Decode(&dash.Tags)
+		case "links":
+			return dec.Decode(&dash.Links)
+		case "rows":
+			dash.Rows = []Row{}
+			isNull, err := decodeArray(dec, func() error {
+				var row Row
+				err := dec.Decode(&row)
+				dash.Rows = append(dash.Rows, row)
+				return err
+			})
+			if isNull {
+				dash.Rows = nil
+			}
+			return err
+		case "panels":
+			dash.Panels = []Panel{}
+			isNull, err := decodeArray(dec, func() error {
+				var p Panel
+				err := dec.Decode(&p)
+				dash.Panels = append(dash.Panels, p)
+				return err
+			})
+			if isNull {
+				dash.Panels = nil
+			}
+			return err
+		}
+		return skipValue(dec)
+	})
+}
+
+// decodeObject calls field for each key of the JSON object at the decoder's position, which must decode or skip the value.
+// null is treated like an empty object.
+func decodeObject(dec *json.Decoder, field func(key string) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return nil
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object, got %v", t)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := field(t.(string)); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// decodeArray calls elem for each element of the JSON array at the decoder's position, which must decode the element.
+// It reports whether the array was null instead, which json.Unmarshal decodes as a nil slice.
+func decodeArray(dec *json.Decoder, elem func() error) (isNull bool, err error) {
+	t, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	if t == nil {
+		return true, nil
+	}
+	if t != json.Delim('[') {
+		return false, fmt.Errorf("expected a JSON array, got %v", t)
+	}
+	for dec.More() {
+		if err := elem(); err != nil {
+			return false, err
+		}
+	}
+	_, err = dec.Token()
+	return false, err
+}
+
+// skipValue consumes the JSON value at the decoder's position without decoding it
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
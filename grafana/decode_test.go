@@ -0,0 +1,211 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// largeDashboardJSON generates a dashboard with the given number of panels, each carrying
+// field overrides and plugin options that the reporter does not need
+func largeDashboardJSON(panels int) []byte {
+	var list []map[string]interface{}
+	for i := 1; i <= panels; i++ {
+		var overrides []map[string]interface{}
+		for j := 0; j < 20; j++ {
+			overrides = append(overrides, map[string]interface{}{
+				"matcher":    map[string]interface{}{"id": "byName", "options": fmt.Sprintf("series-%d", j)},
+				"properties": []map[string]interface{}{{"id": "color", "value": map[string]string{"mode": "fixed", "fixedColor": "#73BF69"}}},
+			})
+		}
+		list = append(list, map[string]interface{}{
+			"id":         i,
+			"type":       "timeseries",
+			"title":      fmt.Sprintf("Panel %d", i),
+			"datasource": map[string]string{"type": "prometheus", "uid": "prom"},
+			"targets":    []map[string]interface{}{{"refId": "A", "expr": fmt.Sprintf(`rate(http_requests_total{instance="$host",panel="%d"}[5m])`, i)}},
+			"gridPos":    map[string]int{"h": 8, "w": 12, "x": 0, "y": i * 8},
+			"fieldConfig": map[string]interface{}{
+				"defaults":  map[string]interface{}{"unit": "reqps", "custom": map[string]interface{}{"lineWidth": 1, "fillOpacity": 10}},
+				"overrides": overrides,
+			},
+			"options": map[string]interface{}{"legend": map[string]interface{}{"displayMode": "table", "calcs": []string{"mean", "max", "last"}}},
+		})
+	}
+	dash := map[string]interface{}{
+		"dashboard": map[string]interface{}{
+			"title":       "Large dashboard",
+			"description": "Generated",
+			"version":     7,
+			"timezone":    "utc",
+			"tags":        []string{"network"},
+			"links":       []map[string]string{{"title": "Runbook", "url": "http://runbook"}},
+			"templating":  map[string]interface{}{"list": []map[string]interface{}{{"name": "host", "current": map[string]string{"value": "db1"}}}},
+			"annotations": map[string]interface{}{"list": []map[string]interface{}{{"name": "Deploys", "enable": true}}},
+			"panels":      list,
+		},
+		"meta": map[string]interface{}{"slug": "large-dashboard", "canEdit": true, "created": "2026-01-01T00:00:00Z"},
+	}
+	b, err := json.Marshal(dash)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestDecodeDashboard(t *testing.T) {
+	Convey("When decoding dashboard JSON as it streams in", t, func() {
+		fixtures := map[string]string{
+			"v4":             `{"Dashboard":{"Rows":[{"Panels":[{"Type":"singlestat","Id":1}],"Title":"RowTitle #","Collapse":true}],"title":"DashTitle #"},"Meta":{"Slug":"testDash"}}`,
+			"v5":             `{"dashboard":{"panels":[{"type":"graph","id":1,"gridPos":{"x":0}},{"type":"row","id":2,"collapsed":true,"panels":[{"type":"graph","id":3}]}],"title":"Dash","version":3,"timezone":"browser"}}`,
+			"null panels":    `{"dashboard":{"title":"Empty","panels":null,"rows":null}}`,
+			"empty panels":   `{"dashboard":{"title":"Empty","panels":[],"rows":[]}}`,
+			"unknown fields": `{"other":[1,{"a":[null,"x"]}],"dashboard":{"schemaVersion":36,"title":"T","panels":[{"id":1,"options":{"deep":[[{"x":1}]]}}]},"meta":{"slug":"t","extra":{"k":[1,2]}}}`,
+			"no dashboard":   `{}`,
+			"large":          string(largeDashboardJSON(50)),
+		}
+		for name, fixture := range fixtures {
+			Convey("It should give the same result as json.Unmarshal for "+name, func() {
+				var want dashContainer
+				So(json.Unmarshal([]byte(fixture), &want), ShouldBeNil)
+				got, err := decodeDashContainer(strings.NewReader(fixture))
+				So(err, ShouldBeNil)
+				So(got, ShouldResemble, want)
+			})
+		}
+
+		Convey("Fields filled in by the reporter should not be read from the JSON", func() {
+			fixture := `{"dashboard":{"title":"T","rows":[{"title":"R","plainTitle":"\\input{/etc/passwd}","panels":[{"id":1,"warning":"\\input{/etc/passwd}","sources":["\\input{/etc/passwd}"],"thumbnailPath":"/etc/passwd"}]}],` +
+				`"panels":[{"id":2,"title":"P","plainTitle":"\\input{/etc/passwd}","warning":"\\input{/etc/passwd}","sources":["\\input{/etc/passwd}"],"thumbnailPath":"/etc/passwd"}]}}`
+			got, err := decodeDashContainer(strings.NewReader(fixture))
+			So(err, ShouldBeNil)
+			So(got.Dashboard.Rows[0].PlainTitle, ShouldBeEmpty)
+			So(got.Dashboard.Rows[0].Panels[0].Warning, ShouldBeEmpty)
+			So(got.Dashboard.Rows[0].Panels[0].Sources, ShouldBeEmpty)
+			So(got.Dashboard.Rows[0].Panels[0].ThumbnailPath, ShouldBeEmpty)
+			So(got.Dashboard.Panels[0].PlainTitle, ShouldBeEmpty)
+			So(got.Dashboard.Panels[0].Warning, ShouldBeEmpty)
+			So(got.Dashboard.Panels[0].Sources, ShouldBeEmpty)
+			So(got.Dashboard.Panels[0].ThumbnailPath, ShouldBeEmpty)
+
+			dash := NewDashboard([]byte(fixture), url.Values{})
+			So(dash.Rows[0].PlainTitle, ShouldEqual, "R")
+			So(dash.Panels[0].Warning, ShouldBeEmpty)
+			So(dash.Panels[0].Sources, ShouldBeEmpty)
+			So(dash.Panels[0].ThumbnailPath, ShouldBeEmpty)
+		})
+
+		Convey("Malformed JSON should give an error", func() {
+			for _, fixture := range []string{`{"dashboard":{"title":"T","panels":[{"id":1}`, `[]`, `{"dashboard":[]}`, `{"dashboard":{"panels":{}}}`, `{"dashboard":{"title":1}}`} {
+				_, err := decodeDashContainer(strings.NewReader(fixture))
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestGrafanaClientGzip(t *testing.T) {
+	Convey("When fetching a dashboard from a server compressing its responses", t, func() {
+		var acceptEncoding string
+		status := http.StatusOK
+		dashJSON := largeDashboardJSON(20)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(status)
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			if status != http.StatusOK {
+				fmt.Fprint(gz, "dashboard not found")
+				return
+			}
+			gz.Write(dashJSON)
+		}))
+		defer ts.Close()
+		grf := NewV5Client(ts.URL, "", url.Values{})
+
+		Convey("It should ask for gzip and decode the response", func() {
+			dash, err := grf.GetDashboard("large")
+			So(err, ShouldBeNil)
+			So(acceptEncoding, ShouldEqual, "gzip")
+			So(dash.Title, ShouldEqual, "Large dashboard")
+			So(dash.Panels, ShouldHaveLength, 20)
+			So(dash.Panels[19].FieldConfig.Defaults.Unit, ShouldEqual, "reqps")
+		})
+
+		Convey("Error messages should be decoded", func() {
+			status = http.StatusNotFound
+			_, err := grf.GetDashboard("large")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "dashboard not found")
+		})
+	})
+
+	Convey("When a dashboard response is not valid JSON", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"dashboard":{"title":`)
+		}))
+		defer ts.Close()
+
+		Convey("GetDashboard should return an error", func() {
+			_, err := NewV5Client(ts.URL, "", url.Values{}).GetDashboard("broken")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "error parsing dashboard")
+		})
+	})
+}
+
+// BenchmarkDashboardParse compares reading a large dashboard whole and unmarshalling it, as GetDashboard used to,
+// with decoding it as it streams in
+func BenchmarkDashboardParse(b *testing.B) {
+	dashJSON := largeDashboardJSON(2000)
+	b.Logf("dashboard JSON: %d bytes", len(dashJSON))
+
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body, err := ioutil.ReadAll(bytes.NewReader(dashJSON))
+			if err != nil {
+				b.Fatal(err)
+			}
+			var dc dashContainer
+			if err := json.Unmarshal(body, &dc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeDashContainer(bytes.NewReader(dashJSON)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"log"
+	"net/url"
+	"strings"
+)
+
+// endpoint returns the url of base with the path segments appended and the query set.
+// Each segment is escaped, so dashboard names and uids with spaces, unicode or slashes stay a single segment.
+func endpoint(base string, query url.Values, segments ...string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		//Grafana's url comes from the -proto and -ip flags, so this is a configuration error
+		log.Printf("Error parsing Grafana url %v: %v", base, err)
+		u = &url.URL{Path: base}
+	}
+	if len(segments) > 0 {
+		escaped := make([]string, len(segments))
+		for i, s := range segments {
+			escaped[i] = url.PathEscape(s)
+		}
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + strings.Join(escaped, "/")
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.Join(segments, "/")
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
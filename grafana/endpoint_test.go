@@ -0,0 +1,109 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEndpoint(t *testing.T) {
+	Convey("When building Grafana urls", t, func() {
+		Convey("Segments should be escaped", func() {
+			So(endpoint("http://grafana:3000", nil, "api", "dashboards", "db", "täglicher überblick"), ShouldEqual, "http://grafana:3000/api/dashboards/db/t%C3%A4glicher%20%C3%BCberblick")
+			So(endpoint("http://grafana:3000", nil, "api", "dashboards", "uid", "a/b+c"), ShouldEqual, "http://grafana:3000/api/dashboards/uid/a%2Fb+c")
+		})
+
+		Convey("The base url's path should be kept", func() {
+			So(endpoint("http://host/grafana/", nil, "api", "search"), ShouldEqual, "http://host/grafana/api/search")
+			So(endpoint("http://host/my%20grafana", nil, "api", "search"), ShouldEqual, "http://host/my%20grafana/api/search")
+		})
+
+		Convey("The query should be encoded", func() {
+			So(endpoint("http://grafana:3000", url.Values{"var-q": {"a&b=c"}}, "api", "search"), ShouldEqual, "http://grafana:3000/api/search?var-q=a%26b%3Dc")
+			So(endpoint("http://grafana:3000/api/annotations", url.Values{"tags": {"report"}}), ShouldEqual, "http://grafana:3000/api/annotations?tags=report")
+		})
+
+		Convey("Query values should be encoded exactly once", func() {
+			So(endpoint("http://grafana:3000", url.Values{"var-path": {"/api/v1/%7Bid%7D"}}, "api", "search"), ShouldEqual, "http://grafana:3000/api/search?var-path=%2Fapi%2Fv1%2F%257Bid%257D")
+			So(endpoint("http://grafana:3000", url.Values{"var-q": {"a+b c"}}, "api", "search"), ShouldEqual, "http://grafana:3000/api/search?var-q=a%2Bb+c")
+		})
+	})
+}
+
+func TestClientURLRoundTrip(t *testing.T) {
+	Convey("When the clients request dashboards and panels with special characters", t, func() {
+		var paths []string
+		var queries []url.Values
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.EscapedPath())
+			queries = append(queries, r.URL.Query())
+			w.Write([]byte(`{"dashboard":{"title":"x"}}`))
+		}))
+		defer ts.Close()
+		variables := url.Values{"var-host": {"web 1", "a&b", "c+d", "ü/x", "/api/v1/%7Bid%7D", "50%", "a=b;c", "日本"}}
+		names := []string{"Täglicher Überblick", "with/slash", "plus+sign", "space name"}
+		cases := map[string]struct {
+			client   Client
+			dashPath string
+			pngPath  string
+		}{
+			"v4": {NewV4Client(ts.URL, "", variables), "/api/dashboards/db/", "/render/dashboard-solo/db/"},
+			"v5": {NewV5Client(ts.URL, "", variables), "/api/dashboards/uid/", "/render/d-solo/"},
+		}
+		for desc, c := range cases {
+			for _, name := range names {
+				paths, queries = nil, nil
+				c.client.GetDashboard(name)
+				body, err := c.client.GetPanelPng(Panel{Id: 1}, name, TimeRange{From: "now-1h", To: "now"})
+				if err == nil {
+					body.Close()
+				}
+
+				Convey("The "+desc+" client should keep "+name+" a single path segment", func() {
+					So(paths, ShouldHaveLength, 2)
+					for i, prefix := range []string{c.dashPath, c.pngPath} {
+						u, err := url.Parse(ts.URL + paths[i])
+						So(err, ShouldBeNil)
+						So(u.EscapedPath(), ShouldStartWith, prefix+url.PathEscape(name))
+						So(u.Path, ShouldStartWith, prefix+name)
+					}
+				})
+
+				Convey("The "+desc+" client should pass the variable values of "+name+" unchanged", func() {
+					So(queries[0]["var-host"], ShouldResemble, variables["var-host"])
+					So(queries[1]["var-host"], ShouldResemble, variables["var-host"])
+				})
+			}
+		}
+
+		Convey("Panel specific values should reach the renderer unchanged", func() {
+			paths, queries = nil, nil
+			panelVars := url.Values{"var-path": {"/a/%2F/b", "100%", "x+y z"}}
+			g := NewV5Client(ts.URL, "", variables, WithPanelVariables(map[int]url.Values{4: panelVars}))
+			body, err := g.GetPanelPng(Panel{Id: 4}, "dash", TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldBeNil)
+			body.Close()
+			So(queries[0]["var-path"], ShouldResemble, panelVars["var-path"])
+			So(queries[0]["var-host"], ShouldResemble, variables["var-host"])
+		})
+	})
+}
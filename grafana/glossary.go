@@ -0,0 +1,116 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// GlossaryEntry describes a template variable to the readers of a report, as passed to the TeX templates.
+// Its fields are escaped for TeX.
+type GlossaryEntry struct {
+	Name        string
+	Label       string
+	Type        string
+	Description string
+	Value       string //the values used for the report, comma separated
+	Definition  string //where the variable's values come from, e.g. its data source query
+}
+
+// Glossary describes the dashboard's template variables in dashboard order, with the values used for the report
+func (d Dashboard) Glossary() []GlossaryEntry {
+	var entries []GlossaryEntry
+	for _, v := range d.Templating.List {
+		entries = append(entries, GlossaryEntry{
+			Name:        SanitizeLaTexInput(v.Name),
+			Label:       SanitizeLaTexInput(v.Label),
+			Type:        SanitizeLaTexInput(v.Type),
+			Description: SanitizeLaTexInput(v.Description),
+			Value:       SanitizeLaTexInput(glossaryValue(v, d.Variables[v.Name])),
+			Definition:  SanitizeLaTexInput(v.definition()),
+		})
+	}
+	return entries
+}
+
+// glossaryValue lists the values of the variable, naming Grafana's special values as the variable editor does
+func glossaryValue(v TemplateVariable, values []string) string {
+	if len(values) == 0 {
+		return strings.Join(stringValues(v.Current.Text), ", ")
+	}
+	var names []string
+	for _, value := range values {
+		switch {
+		case value == "$__all":
+			names = append(names, "All")
+		case strings.HasPrefix(value, "$__auto_interval"):
+			names = append(names, "auto")
+		default:
+			names = append(names, value)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// definition describes where the variable's values come from
+func (v TemplateVariable) definition() string {
+	query := queryText(v.Query)
+	switch v.Type {
+	case "datasource":
+		def := "data sources of type " + query
+		if v.Regex != "" {
+			def += " matching " + v.Regex
+		}
+		return def
+	case "interval":
+		if v.Auto {
+			return "auto, " + query
+		}
+		return query
+	case "adhoc":
+		return "ad hoc filters"
+	}
+	def := v.Definition
+	if def == "" {
+		def = query
+	}
+	if v.Regex != "" {
+		def += ", filtered by " + v.Regex
+	}
+	return def
+}
+
+// queryText returns the query of a variable: a string, the "query" field of newer data sources' query objects,
+// or the query object's JSON
+func queryText(query interface{}) string {
+	switch q := query.(type) {
+	case nil:
+		return ""
+	case string:
+		return q
+	case map[string]interface{}:
+		if s, ok := q["query"].(string); ok {
+			return s
+		}
+	}
+	b, err := json.Marshal(query)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const glossaryDashJSON = `
+{"dashboard":{"title":"Glossary","templating":{"list":[
+	{"name":"host","label":"Host","type":"query","description":"Database host_name",
+		"query":"label_values(up, host)","regex":"/db.*/","datasource":"Prometheus","multi":true,"includeAll":true,
+		"current":{"text":"All","value":["$__all"]}},
+	{"name":"table","type":"query","definition":"SHOW TABLES","query":{"query":"SHOW TABLES","refId":"A"},
+		"current":{"text":"users","value":"users"}},
+	{"name":"env","type":"custom","query":"dev,prod","current":{"text":"prod","value":"prod"}},
+	{"name":"ds","label":"Data source","type":"datasource","query":"prometheus","regex":"/^prod/",
+		"current":{"text":"prod-eu","value":"prod-eu"}},
+	{"name":"step","type":"interval","query":"1m,10m,1h","auto":true,
+		"current":{"text":"auto","value":"$__auto_interval_step"}},
+	{"name":"every","type":"interval","query":"1m,10m","current":{"text":"10m","value":"10m"}},
+	{"name":"team","type":"constant","query":"sre","current":{"value":"sre"}},
+	{"name":"filter","type":"textbox","query":"","current":{"text":"","value":""}},
+	{"name":"Filters","type":"adhoc","datasource":{"uid":"prom"}}
+]},"panels":[]}}`
+
+func TestGlossary(t *testing.T) {
+	Convey("When describing the template variables of a dashboard", t, func() {
+		dash := NewDashboard([]byte(glossaryDashJSON), url.Values{"var-env": {"dev"}})
+		glossary := dash.Glossary()
+
+		Convey("Every variable should be described in dashboard order", func() {
+			So(glossary, ShouldHaveLength, 9)
+			So(glossary[0].Name, ShouldEqual, "host")
+			So(glossary[8].Name, ShouldEqual, "Filters")
+		})
+
+		Convey("The label, type and description should be escaped for TeX", func() {
+			So(glossary[0].Label, ShouldEqual, "Host")
+			So(glossary[0].Type, ShouldEqual, "query")
+			So(glossary[0].Description, ShouldEqual, `Database host\_name`)
+		})
+
+		Convey("Query variables should be defined by their query and regex", func() {
+			So(glossary[0].Definition, ShouldEqual, `label\_values(up, host), filtered by /db.*/`)
+		})
+
+		Convey("Query objects of newer data sources should be defined by the editor's definition", func() {
+			So(glossary[1].Definition, ShouldEqual, "SHOW TABLES")
+			dash.Templating.List[1].Definition = ""
+			So(dash.Glossary()[1].Definition, ShouldEqual, "SHOW TABLES")
+		})
+
+		Convey("The values used for the report should be given, naming special values", func() {
+			So(glossary[0].Value, ShouldEqual, "All")
+			So(glossary[2].Value, ShouldEqual, "dev")
+			So(glossary[4].Value, ShouldEqual, "auto")
+			So(glossary[5].Value, ShouldEqual, "10m")
+			So(glossary[6].Value, ShouldEqual, "sre")
+			So(glossary[7].Value, ShouldEqual, "")
+		})
+
+		Convey("Datasource variables should be defined by the type of data source they offer", func() {
+			So(glossary[3].Label, ShouldEqual, "Data source")
+			So(glossary[3].Value, ShouldEqual, "prod-eu")
+			So(glossary[3].Definition, ShouldEqual, `data sources of type prometheus matching /\textasciicircum prod/`)
+		})
+
+		Convey("Interval variables should be defined by their intervals", func() {
+			So(glossary[4].Definition, ShouldEqual, "auto, 1m,10m,1h")
+			So(glossary[5].Definition, ShouldEqual, "1m,10m")
+		})
+
+		Convey("Other variable types should be described by their type", func() {
+			So(glossary[2].Definition, ShouldEqual, "dev,prod")
+			So(glossary[6].Definition, ShouldEqual, "sre")
+			So(glossary[7].Definition, ShouldEqual, "")
+			So(glossary[8].Definition, ShouldEqual, "ad hoc filters")
+		})
+
+		Convey("The full variable definitions should be available", func() {
+			So(dash.Templating.List[0].Multi, ShouldBeTrue)
+			So(dash.Templating.List[0].Datasource, ShouldEqual, "Prometheus")
+			So(dash.Templating.List[4].Auto, ShouldBeTrue)
+		})
+	})
+
+	Convey("A dashboard without variables should have no glossary", t, func() {
+		So(NewDashboard([]byte(`{"dashboard":{"title":"Empty","panels":[]}}`), url.Values{}).Glossary(), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,127 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// variableRegExp matches Grafana's variable syntax: $var, ${var} and ${var:format}
+var variableRegExp = regexp.MustCompile(`\$\{(\w+)(?::(\w+))?\}|\$(\w+)`)
+
+// allValue is the value Grafana uses for the "All" option of a variable
+const allValue = "$__all"
+
+// variableValues merges the request's var-{name} values over the dashboard defaults
+func variableValues(defaults map[string][]string, variables url.Values) map[string][]string {
+	values := map[string][]string{}
+	for k, v := range defaults {
+		values[k] = v
+	}
+	for k, v := range variables {
+		if strings.HasPrefix(k, "var-") {
+			values[strings.TrimPrefix(k, "var-")] = v
+		}
+	}
+	return values
+}
+
+// InterpolateVariables replaces Grafana variable references in text, i.e. $var, ${var} and ${var:format},
+// with the variables' values. Multiple values are joined as Grafana joins them for display, unless a
+// format such as csv, pipe, json, regex, glob, lucene, singlequote, doublequote, sqlstring or queryparam is given.
+// References to unknown variables are left as they are.
+func InterpolateVariables(text string, values map[string][]string) string {
+	return variableRegExp.ReplaceAllStringFunc(text, func(ref string) string {
+		m := variableRegExp.FindStringSubmatch(ref)
+		name, format := m[1], m[2]
+		if name == "" {
+			name = m[3]
+		}
+		vals, ok := values[name]
+		if !ok {
+			log.Printf("Not interpolating %v in %q: unknown variable", ref, text)
+			return ref
+		}
+		return formatValues(name, vals, format)
+	})
+}
+
+func formatValues(name string, vals []string, format string) string {
+	if len(vals) == 1 && vals[0] == allValue {
+		if format == "" || format == "text" {
+			return "All"
+		}
+		vals = []string{"*"}
+	}
+	quote := func(q string, escape func(string) string) string {
+		quoted := make([]string, len(vals))
+		for i, v := range vals {
+			quoted[i] = q + escape(v) + q
+		}
+		return strings.Join(quoted, ",")
+	}
+	switch format {
+	case "csv", "raw":
+		return strings.Join(vals, ",")
+	case "pipe":
+		return strings.Join(vals, "|")
+	case "json":
+		b, _ := json.Marshal(vals)
+		return string(b)
+	case "regex":
+		escaped := make([]string, len(vals))
+		for i, v := range vals {
+			escaped[i] = regexp.QuoteMeta(v)
+		}
+		if len(escaped) == 1 {
+			return escaped[0]
+		}
+		return "(" + strings.Join(escaped, "|") + ")"
+	case "glob":
+		if len(vals) == 1 {
+			return vals[0]
+		}
+		return "{" + strings.Join(vals, ",") + "}"
+	case "lucene":
+		quoted := make([]string, len(vals))
+		for i, v := range vals {
+			quoted[i] = `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+		}
+		if len(quoted) == 1 {
+			return quoted[0]
+		}
+		return "(" + strings.Join(quoted, " OR ") + ")"
+	case "singlequote":
+		return quote("'", func(v string) string { return strings.Replace(v, "'", `\'`, -1) })
+	case "doublequote":
+		return quote(`"`, func(v string) string { return strings.Replace(v, `"`, `\"`, -1) })
+	case "sqlstring":
+		return quote("'", func(v string) string { return strings.Replace(v, "'", "''", -1) })
+	case "queryparam":
+		params := make([]string, len(vals))
+		for i, v := range vals {
+			params[i] = "var-" + url.QueryEscape(name) + "=" + url.QueryEscape(v)
+		}
+		return strings.Join(params, "&")
+	default:
+		return strings.Join(vals, " + ")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInterpolateVariables(t *testing.T) {
+	Convey("When interpolating variables", t, func() {
+		values := map[string][]string{
+			"host": {"web1"},
+			"env":  {"prod", "staging"},
+			"all":  {"$__all"},
+			"name": {"O'Brien"},
+		}
+		cases := []struct {
+			text, expected string
+		}{
+			{"CPU on $host", "CPU on web1"},
+			{"CPU on ${host}", "CPU on web1"},
+			{"${host}-disk", "web1-disk"},
+			{"$host$host", "web1web1"},
+			{"Envs: $env", "Envs: prod + staging"},
+			{"${env:csv}", "prod,staging"},
+			{"${env:raw}", "prod,staging"},
+			{"${env:pipe}", "prod|staging"},
+			{"${env:json}", `["prod","staging"]`},
+			{"${env:regex}", "(prod|staging)"},
+			{"${host:regex}", "web1"},
+			{"${env:glob}", "{prod,staging}"},
+			{"${env:lucene}", `("prod" OR "staging")`},
+			{"${env:singlequote}", "'prod','staging'"},
+			{"${env:doublequote}", `"prod","staging"`},
+			{"${name:sqlstring}", "'O''Brien'"},
+			{"${env:queryparam}", "var-env=prod&var-env=staging"},
+			{"${env:text}", "prod + staging"},
+			{"Hosts: $all", "Hosts: All"},
+			{"${all:csv}", "*"},
+			{"Unknown $missing and ${missing:csv}", "Unknown $missing and ${missing:csv}"},
+			{"Costs $5", "Costs $5"},
+			{"No variables", "No variables"},
+		}
+		for _, c := range cases {
+			So(InterpolateVariables(c.text, values), ShouldEqual, c.expected)
+		}
+	})
+}
+
+const templatedDashJSON = `
+{"dashboard":{"title":"Servers in $env","description":"Hosts: ${host:csv}",
+	"templating":{"list":[
+		{"name":"host","current":{"value":["web1","web2"]}},
+		{"name":"env","current":{"value":"prod"}}]},
+	"rows":[{"title":"Row for $env","panels":[{"type":"graph","id":1,"title":"CPU_usage on $host"}]}]}}`
+
+func TestDashboardInterpolation(t *testing.T) {
+	Convey("When creating a dashboard with variables in its titles", t, func() {
+		Convey("The dashboard defaults should be used without request variables", func() {
+			dash := NewDashboard([]byte(templatedDashJSON), url.Values{})
+			So(dash.Title, ShouldEqual, "Servers in prod")
+			So(dash.Description, ShouldEqual, "Hosts: web1,web2")
+			So(dash.Rows[0].Title, ShouldEqual, "Row for prod")
+			So(dash.Panels[0].Title, ShouldEqual, `CPU\_usage on web1 + web2`)
+			So(dash.Panels[0].PlainTitle, ShouldEqual, "CPU usage on web1 + web2")
+		})
+
+		Convey("Request variables should override the defaults", func() {
+			dash := NewDashboard([]byte(templatedDashJSON), url.Values{"var-env": {"dev"}, "var-host": {"db$1"}})
+			So(dash.Title, ShouldEqual, "Servers in dev")
+			So(dash.Panels[0].Title, ShouldEqual, `CPU\_usage on db\$1`)
+			So(dash.Variables["host"], ShouldResemble, []string{"db$1"})
+		})
+	})
+}
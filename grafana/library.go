@@ -0,0 +1,89 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// IsLibraryPanel reports whether the panel is a stub referencing a library panel
+func (p Panel) IsLibraryPanel() bool {
+	return p.LibraryPanel.UID != ""
+}
+
+// resolveLibraryPanels replaces the library panel stubs of dash with the shared panel definitions.
+// Stubs that cannot be resolved are kept, with a warning caption, rather than failing the report.
+func (g client) resolveLibraryPanels(dash *Dashboard) {
+	for i, p := range dash.Panels {
+		if !p.IsLibraryPanel() {
+			continue
+		}
+		model, err := g.getLibraryPanel(p.LibraryPanel.UID)
+		if err != nil {
+			log.Printf("Error resolving library panel %v of panel %v: %v", p.LibraryPanel.UID, p.Id, err)
+			p.Warning = SanitizeLaTexInput(fmt.Sprintf("Library panel %v could not be loaded.", libraryPanelName(p)))
+			dash.Panels[i] = p
+			continue
+		}
+		dash.Panels[i] = mergeLibraryPanel(p, model, dash.Variables)
+	}
+}
+
+func (g client) getLibraryPanel(uid string) (Panel, error) {
+	var resp struct {
+		Result struct {
+			Model json.RawMessage
+		}
+	}
+	err := g.getJSON("getLibraryPanel", g.getLibraryEndpoint(uid), &resp)
+	if err != nil {
+		return Panel{}, err
+	}
+	var model Panel
+	err = json.Unmarshal(resp.Result.Model, &model)
+	if err != nil {
+		return Panel{}, fmt.Errorf("error parsing library panel %v: %v", uid, err)
+	}
+	return model, nil
+}
+
+// mergeLibraryPanel returns the library panel model placed as the dashboard's stub:
+// the id, and the title and type if the stub sets them, are taken from the stub.
+// The library panel's own title is interpolated with the dashboard's variable values.
+func mergeLibraryPanel(stub Panel, model Panel, variables map[string][]string) Panel {
+	model.Id = stub.Id
+	model.LibraryPanel = stub.LibraryPanel
+	if stub.PlainTitle != "" {
+		model.Title, model.PlainTitle = stub.Title, stub.PlainTitle
+	} else {
+		title := InterpolateVariables(model.Title, variables)
+		model.Title, model.PlainTitle = texTitle(title), plainTitle(title)
+	}
+	if stub.Type != "" {
+		model.Type = stub.Type
+	}
+	return model
+}
+
+func libraryPanelName(p Panel) string {
+	if p.LibraryPanel.Name != "" {
+		return p.LibraryPanel.Name
+	}
+	return p.LibraryPanel.UID
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const libraryDashJSON = `
+{"dashboard":{"title":"Shared panels","panels":[
+	{"type":"graph","id":1,"title":"Local panel"},
+	{"id":2,"gridPos":{"h":8,"w":12,"x":0,"y":8},"libraryPanel":{"uid":"cpu-lib","name":"CPU usage"}},
+	{"id":3,"title":"Memory on this host","gridPos":{"h":8,"w":12,"x":12,"y":8},"libraryPanel":{"uid":"mem-lib","name":"Memory usage"}},
+	{"id":4,"gridPos":{"h":8,"w":12,"x":0,"y":16},"libraryPanel":{"uid":"gone-lib","name":"Deleted panel"}}]}}`
+
+const cpuLibraryPanelJSON = `
+{"result":{"uid":"cpu-lib","name":"CPU usage","model":{
+	"id":17,"type":"timeseries","title":"CPU_usage",
+	"datasource":{"uid":"prom"},"targets":[{"expr":"rate(cpu[5m])"}],
+	"fieldConfig":{"defaults":{"unit":"percent"}}}}}`
+
+const memLibraryPanelJSON = `
+{"result":{"uid":"mem-lib","name":"Memory usage","model":{"id":18,"type":"timeseries","title":"Memory usage"}}}`
+
+func TestLibraryPanels(t *testing.T) {
+	Convey("When fetching a dashboard with library panels", t, func() {
+		var requests []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path)
+			switch r.URL.Path {
+			case "/api/dashboards/uid/shared", "/api/dashboards/db/shared":
+				fmt.Fprint(w, libraryDashJSON)
+			case "/api/library-elements/cpu-lib":
+				fmt.Fprint(w, cpuLibraryPanelJSON)
+			case "/api/library-elements/mem-lib":
+				fmt.Fprint(w, memLibraryPanelJSON)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer ts.Close()
+
+		dash, err := NewV5Client(ts.URL, "", url.Values{}).GetDashboard("shared")
+		So(err, ShouldBeNil)
+		So(dash.Panels, ShouldHaveLength, 4)
+
+		Convey("Local panels should be unchanged", func() {
+			So(dash.Panels[0].Title, ShouldEqual, "Local panel")
+			So(dash.Panels[0].IsLibraryPanel(), ShouldBeFalse)
+		})
+
+		Convey("A library panel should be resolved into a normal panel with the dashboard's id", func() {
+			p := dash.Panels[1]
+			So(p.Id, ShouldEqual, 2)
+			So(p.Type, ShouldEqual, "timeseries")
+			So(p.Title, ShouldEqual, `CPU\_usage`)
+			So(p.PlainTitle, ShouldEqual, plainTitle("CPU_usage"))
+			So(p.Unit(), ShouldEqual, "percent")
+			So(p.DatasourceNames(), ShouldResemble, []string{"prom"})
+			So(p.Targets, ShouldHaveLength, 1)
+			So(p.Warning, ShouldBeEmpty)
+		})
+
+		Convey("A title set on the dashboard should take precedence over the library panel's", func() {
+			So(dash.Panels[2].Title, ShouldEqual, "Memory on this host")
+			So(dash.Panels[2].Type, ShouldEqual, "timeseries")
+		})
+
+		Convey("An unresolvable library panel should be kept with a warning", func() {
+			p := dash.Panels[3]
+			So(p.Id, ShouldEqual, 4)
+			So(p.Warning, ShouldContainSubstring, "Deleted panel could not be loaded")
+		})
+
+		Convey("The v4 client should not resolve library panels", func() {
+			requests = nil
+			_, err := NewV4Client(ts.URL, "", url.Values{}).GetDashboard("shared")
+			So(err, ShouldBeNil)
+			So(requests, ShouldResemble, []string{"/api/dashboards/db/shared"})
+		})
+	})
+}
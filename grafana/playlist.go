@@ -0,0 +1,105 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// Playlist item types, as used by Grafana's playlist API
+const (
+	PlaylistItemByID  = "dashboard_by_id"
+	PlaylistItemByUID = "dashboard_by_uid"
+	PlaylistItemByTag = "dashboard_by_tag"
+)
+
+// Playlist represents a Grafana playlist
+type Playlist struct {
+	Id    int
+	Name  string
+	Items []PlaylistItem
+}
+
+// PlaylistItem is a single playlist entry, referring to a dashboard by id or uid, or to all dashboards with a tag
+type PlaylistItem struct {
+	Type  string
+	Value string
+	Order int
+	Title string
+}
+
+// DashboardRef is a dashboard search result
+type DashboardRef struct {
+	Id    int
+	Uid   string
+	Title string
+	Tags  []string
+}
+
+// ResolvePlaylist resolves the playlist's items, in playlist order, to the uids of the dashboards they refer to.
+// A dashboard referred to by several items is only included once.
+// Items that do not resolve to any dashboard are described in missing rather than failing the resolution.
+func ResolvePlaylist(c Client, pl Playlist) (dashUIDs []string, missing []string, err error) {
+	items := append([]PlaylistItem(nil), pl.Items...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Order < items[j].Order })
+
+	seen := map[string]bool{}
+	add := func(uid string) {
+		if !seen[uid] {
+			seen[uid] = true
+			dashUIDs = append(dashUIDs, uid)
+		}
+	}
+
+	for _, item := range items {
+		switch item.Type {
+		case PlaylistItemByUID:
+			add(item.Value)
+		case PlaylistItemByID:
+			refs, err := c.SearchDashboards(url.Values{"dashboardIds": {item.Value}})
+			if err != nil {
+				return nil, nil, fmt.Errorf("error resolving playlist dashboard id %v: %w", item.Value, err)
+			}
+			if len(refs) == 0 {
+				desc := "dashboard id " + item.Value
+				if item.Title != "" {
+					desc += " (" + item.Title + ")"
+				}
+				missing = append(missing, desc+": not found")
+				continue
+			}
+			add(refs[0].Uid)
+		case PlaylistItemByTag:
+			refs, err := c.SearchDashboards(url.Values{"tag": {item.Value}})
+			if err != nil {
+				return nil, nil, fmt.Errorf("error resolving playlist dashboard tag %v: %w", item.Value, err)
+			}
+			if len(refs) == 0 {
+				missing = append(missing, fmt.Sprintf("dashboards tagged %s: none found", item.Value))
+				continue
+			}
+			for _, ref := range refs {
+				add(ref.Uid)
+			}
+		default:
+			missing = append(missing, fmt.Sprintf("%s %s: unsupported playlist item type", item.Type, item.Value))
+		}
+	}
+	return dashUIDs, missing, nil
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const playlistJSON = `{
+	"id": 1,
+	"name": "NOC screens",
+	"interval": "5m",
+	"items": [
+		{"id": 4, "playlistId": 1, "type": "dashboard_by_tag", "value": "network", "order": 3},
+		{"id": 1, "playlistId": 1, "type": "dashboard_by_id", "value": "3", "order": 1, "title": "Overview"},
+		{"id": 2, "playlistId": 1, "type": "dashboard_by_uid", "value": "uidB", "order": 2},
+		{"id": 3, "playlistId": 1, "type": "dashboard_by_id", "value": "99", "order": 4, "title": "Deleted"},
+		{"id": 5, "playlistId": 1, "type": "dashboard_by_tag", "value": "unused", "order": 5}
+	]
+}`
+
+func playlistServer(requestURIs *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requestURIs = append(*requestURIs, r.URL.RequestURI())
+		switch {
+		case r.URL.Path == "/api/playlists/1":
+			fmt.Fprint(w, playlistJSON)
+		case r.URL.Path == "/api/search" && r.URL.Query().Get("dashboardIds") == "3":
+			fmt.Fprint(w, `[{"id":3,"uid":"uidA","title":"Overview"}]`)
+		case r.URL.Path == "/api/search" && r.URL.Query().Get("tag") == "network":
+			fmt.Fprint(w, `[{"id":5,"uid":"uidB","title":"Links"},{"id":6,"uid":"uidC","title":"Routers"}]`)
+		case r.URL.Path == "/api/search":
+			fmt.Fprint(w, `[]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetPlaylist(t *testing.T) {
+	Convey("When fetching a playlist", t, func() {
+		var requestURIs []string
+		ts := playlistServer(&requestURIs)
+		defer ts.Close()
+
+		Convey("The v5 client should use the playlists endpoint and parse the items", func() {
+			pl, err := NewV5Client(ts.URL, "", url.Values{}).GetPlaylist("1")
+			So(err, ShouldBeNil)
+			So(requestURIs, ShouldResemble, []string{"/api/playlists/1"})
+			So(pl.Name, ShouldEqual, "NOC screens")
+			So(pl.Items, ShouldHaveLength, 5)
+			So(pl.Items[1], ShouldResemble, PlaylistItem{Type: PlaylistItemByID, Value: "3", Order: 1, Title: "Overview"})
+		})
+
+		Convey("The v5 client should return an error for unknown playlists", func() {
+			_, err := NewV5Client(ts.URL, "", url.Values{}).GetPlaylist("2")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The v4 client should not support playlists", func() {
+			_, err := NewV4Client(ts.URL, "", url.Values{}).GetPlaylist("1")
+			So(err, ShouldNotBeNil)
+			So(requestURIs, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestResolvePlaylist(t *testing.T) {
+	Convey("When resolving a playlist to dashboards", t, func() {
+		var requestURIs []string
+		ts := playlistServer(&requestURIs)
+		defer ts.Close()
+		grf := NewV5Client(ts.URL, "", url.Values{})
+		pl, err := grf.GetPlaylist("1")
+		So(err, ShouldBeNil)
+
+		uids, missing, err := ResolvePlaylist(grf, pl)
+		So(err, ShouldBeNil)
+
+		Convey("Dashboards should be resolved by id, uid and tag in playlist order, without duplicates", func() {
+			So(uids, ShouldResemble, []string{"uidA", "uidB", "uidC"})
+		})
+
+		Convey("Dashboard searches should be restricted to dashboards", func() {
+			So(requestURIs, ShouldContain, "/api/search?dashboardIds=3&type=dash-db")
+			So(requestURIs, ShouldContain, "/api/search?tag=network&type=dash-db")
+		})
+
+		Convey("Entries that resolve to nothing should be reported as missing", func() {
+			So(missing, ShouldResemble, []string{
+				"dashboard id 99 (Deleted): not found",
+				"dashboards tagged unused: none found",
+			})
+		})
+
+		Convey("Unsupported entry types should be reported as missing", func() {
+			pl := Playlist{Items: []PlaylistItem{{Type: "dashboard_by_magic", Value: "x"}}}
+			uids, missing, err := ResolvePlaylist(grf, pl)
+			So(err, ShouldBeNil)
+			So(uids, ShouldBeEmpty)
+			So(missing, ShouldHaveLength, 1)
+		})
+
+		Convey("Search failures should fail the resolution", func() {
+			ts.Close()
+			_, _, err := ResolvePlaylist(grf, pl)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
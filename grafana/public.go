@@ -0,0 +1,101 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// publicClient reads Grafana public dashboards, which are shared by access token and need no authentication.
+// Grafana's render endpoint does not serve public dashboards, so panel images are drawn from the panel
+// data instead, see drawChart. Only time series and stat panels ("graph", "timeseries" and "stat") are drawn, other panel
+// types are shown as a crossed out placeholder.
+type publicClient struct {
+	client
+	accessToken string
+}
+
+// NewPublicClient creates a client for the Grafana public dashboard with the given access token.
+// grafanaURL is the Grafana base url, e.g. http://localhost:3000
+func NewPublicClient(grafanaURL string, accessToken string, opts ...ClientOption) Client {
+	dashURL := endpoint(grafanaURL, nil, "api", "public", "dashboards", accessToken)
+	return publicClient{
+		client: newClient(client{
+			url:             grafanaURL,
+			getDashEndpoint: func(string) string { return dashURL },
+			variables:       url.Values{},
+		}, opts),
+		accessToken: accessToken,
+	}
+}
+
+// GetPlaylist is not supported, playlists cannot be made public
+func (g publicClient) GetPlaylist(id string) (Playlist, error) {
+	return Playlist{}, errors.New("playlists are not supported for public dashboards")
+}
+
+// SearchDashboards is not supported, public dashboards cannot be searched
+func (g publicClient) SearchDashboards(query url.Values) ([]DashboardRef, error) {
+	return nil, errors.New("search is not supported for public dashboards")
+}
+
+// CreateAnnotation is not supported, public dashboards are read only
+func (g publicClient) CreateAnnotation(a Annotation) error {
+	return errors.New("annotations are not supported for public dashboards")
+}
+
+// GetPanelData queries the panel through the public dashboard's query endpoint.
+// Public dashboards run the queries stored with the dashboard, so template variables cannot be changed.
+func (g publicClient) GetPanelData(p Panel, t TimeRange) (PanelData, error) {
+	query, err := json.Marshal(map[string]interface{}{
+		"timeRange": map[string]string{
+			"from":     t.From,
+			"to":       t.To,
+			"timezone": t.TZ,
+		},
+	})
+	if err != nil {
+		return PanelData{}, err
+	}
+	queryURL := endpoint(g.url, nil, "api", "public", "dashboards", g.accessToken, "panels", strconv.Itoa(p.Id), "query")
+	var raw json.RawMessage
+	err = g.doJSON("getPublicPanelData", "POST", queryURL, query, &raw)
+	if err != nil {
+		return PanelData{}, err
+	}
+	data, err := NewPanelData(raw)
+	if err != nil {
+		return PanelData{}, fmt.Errorf("error parsing data of panel %v: %v", p.Id, err)
+	}
+	return data, nil
+}
+
+// PanelURL is empty, public panels are drawn from their data instead of rendered by Grafana
+func (g publicClient) PanelURL(p Panel, dashName string, t TimeRange) string {
+	return ""
+}
+
+// GetPanelPng draws the panel's data as a PNG chart
+func (g publicClient) GetPanelPng(p Panel, dashName string, t TimeRange) (io.ReadCloser, error) {
+	width, height := g.panelSize(p)
+	return drawPanelPng(p, t, width, height, g.GetPanelData)
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const publicDashJSON = `
+{"dashboard":{"title":"Public dashboard","panels":[
+	{"type":"timeseries","id":2,"title":"Requests"},
+	{"type":"table","id":3,"title":"Top hosts"}]},
+"meta":{"slug":"public-dashboard"}}`
+
+func TestPublicClient(t *testing.T) {
+	Convey("When reading a public dashboard", t, func() {
+		var paths []string
+		var auth string
+		var query map[string]interface{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.Method+" "+r.URL.Path)
+			auth = r.Header.Get("Authorization")
+			switch r.URL.Path {
+			case "/api/public/dashboards/abc123":
+				fmt.Fprint(w, publicDashJSON)
+			case "/api/public/dashboards/abc123/panels/2/query":
+				body, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(body, &query)
+				fmt.Fprint(w, promDataJSON)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer ts.Close()
+		g := NewPublicClient(ts.URL, "abc123")
+		tr := TimeRange{From: "now-1h", To: "now", TZ: "UTC"}
+
+		Convey("The dashboard should be fetched by access token without authentication", func() {
+			dash, err := g.GetDashboard("ignored")
+			So(err, ShouldBeNil)
+			So(paths, ShouldResemble, []string{"GET /api/public/dashboards/abc123"})
+			So(auth, ShouldEqual, "")
+			So(dash.Title, ShouldEqual, "Public dashboard")
+			So(dash.Panels, ShouldHaveLength, 2)
+		})
+
+		Convey("Panel data should be queried through the public query endpoint", func() {
+			data, err := g.GetPanelData(Panel{Id: 2, Type: "timeseries"}, tr)
+			So(err, ShouldBeNil)
+			So(paths, ShouldResemble, []string{"POST /api/public/dashboards/abc123/panels/2/query"})
+			So(query["timeRange"], ShouldResemble, map[string]interface{}{"from": "now-1h", "to": "now", "timezone": "UTC"})
+			So(data.Frames, ShouldHaveLength, 1)
+		})
+
+		Convey("Time series panels should be drawn from their data", func() {
+			body, err := g.GetPanelPng(Panel{Id: 2, Type: "timeseries"}, "", tr)
+			So(err, ShouldBeNil)
+			defer body.Close()
+			img, err := png.Decode(body)
+			So(err, ShouldBeNil)
+			So(img.Bounds(), ShouldResemble, image.Rect(0, 0, 1000, 500))
+			So(paths, ShouldResemble, []string{"POST /api/public/dashboards/abc123/panels/2/query"})
+		})
+
+		Convey("Other panels should be drawn as a placeholder without querying data", func() {
+			body, err := g.GetPanelPng(Panel{Id: 3, Type: "table"}, "", tr)
+			So(err, ShouldBeNil)
+			body.Close()
+			So(paths, ShouldBeEmpty)
+		})
+
+		Convey("A failing data query should return an error", func() {
+			_, err := g.GetPanelPng(Panel{Id: 4, Type: "graph"}, "", tr)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Playlists and search should not be supported", func() {
+			_, err := g.GetPlaylist("1")
+			So(err, ShouldNotBeNil)
+			_, err = g.SearchDashboards(url.Values{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDrawChart(t *testing.T) {
+	Convey("When drawing a chart from panel data", t, func() {
+		data, _ := NewPanelData([]byte(promDataJSON))
+		img := drawChart(Panel{Type: "graph"}, data, 100, 50)
+
+		Convey("The series should be drawn from the bottom left to the top right", func() {
+			//the only series rises from 0.5 to 1, so it spans the whole plot area
+			So(img.At(10, 40), ShouldResemble, chartColors[0])
+			So(img.At(90, 10), ShouldResemble, chartColors[0])
+		})
+
+		Convey("Null values should be skipped", func() {
+			data.Frames[0].Fields[1].Values = []interface{}{nil, 1.0}
+			So(chartSeries(data), ShouldResemble, [][][2]float64{{{1600000060000, 1}}})
+		})
+
+		Convey("Frames without a time field should be skipped", func() {
+			data.Frames[0].Fields = data.Frames[0].Fields[1:]
+			So(chartSeries(data), ShouldBeEmpty)
+		})
+	})
+}
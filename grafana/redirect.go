@@ -0,0 +1,139 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// maxRedirects is the number of redirects followed for a request to Grafana, e.g. from a dashboard's old slug
+const maxRedirects = 5
+
+// ErrLoginRedirect is returned when Grafana redirects a request to its login page, which it does for missing or invalid API tokens
+var ErrLoginRedirect = errors.New("Grafana redirected to its login page, check the API token")
+
+// RedirectError is returned when a redirect from Grafana was not followed
+type RedirectError struct {
+	Op  string
+	URL string
+	Err error
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("error executing %s request for %v: %v", e.Op, e.URL, e.Err)
+}
+
+func (e *RedirectError) Unwrap() error {
+	return e.Err
+}
+
+// slugRedirects are the dashboard names Grafana redirected to, e.g. after a dashboard was renamed,
+// so that later requests for the same dashboard use the canonical name at once
+type slugRedirects struct {
+	mu    sync.Mutex
+	slugs map[string]string
+}
+
+func (s *slugRedirects) resolve(dashName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slug, ok := s.slugs[dashName]; ok {
+		return slug
+	}
+	return dashName
+}
+
+func (s *slugRedirects) set(dashName string, slug string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slugs == nil {
+		s.slugs = map[string]string{}
+	}
+	s.slugs[dashName] = slug
+}
+
+// httpClient returns an http client for the op's requests about the named dashboard, "" for other requests.
+// It refuses redirects to the login page and to other hosts, follows up to maxRedirects others,
+// and records the new name when Grafana redirects to the dashboard under another name.
+func (g client) httpClient(op string, dashName string) *http.Client {
+	return &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		refuse := func(err error) error {
+			return &RedirectError{op, via[0].URL.String(), err}
+		}
+		if path.Base(req.URL.Path) == "login" {
+			return refuse(ErrLoginRedirect)
+		}
+		if req.URL.Host != via[0].URL.Host {
+			return refuse(fmt.Errorf("refusing redirect to another host: %v", redactURL(req.URL.String())))
+		}
+		if len(via) >= maxRedirects {
+			return refuse(fmt.Errorf("stopped after %d redirects", len(via)))
+		}
+		if dashName == "" {
+			return nil
+		}
+		prev := via[len(via)-1]
+		if slug, ok := renamedSegment(prev.URL.Path, req.URL.Path, g.slugs.resolve(dashName)); ok {
+			log.Printf("Grafana redirected dashboard %v to %v, using the new name", dashName, slug)
+			g.slugs.set(dashName, slug)
+		}
+		return nil
+	}}
+}
+
+// renamedSegment returns the segment replacing name when the paths differ only in that segment
+func renamedSegment(oldPath string, newPath string, name string) (string, bool) {
+	oldSegments, newSegments := strings.Split(oldPath, "/"), strings.Split(newPath, "/")
+	if len(oldSegments) != len(newSegments) {
+		return "", false
+	}
+	renamed := ""
+	for i := range oldSegments {
+		if oldSegments[i] == newSegments[i] {
+			continue
+		}
+		if oldSegments[i] != name || renamed != "" {
+			return "", false
+		}
+		renamed = newSegments[i]
+	}
+	return renamed, renamed != ""
+}
+
+// requestError wraps the error of an http request: a refused redirect is an answer of Grafana, a request canceled or
+// timed out by the client's context was given up by the reporter, other errors mean Grafana could not be reached
+func (g client) requestError(op string, reqURL string, err error) error {
+	var redirectErr *RedirectError
+	if errors.As(err, &redirectErr) {
+		return redirectErr
+	}
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%s request for %v canceled: %w", op, reqURL, context.Canceled)
+	}
+	//the client's own timeout is a DeadlineExceeded too, but means Grafana is too slow
+	if ctxErr := g.aborted(); ctxErr != nil {
+		return fmt.Errorf("%s request for %v aborted: %w", op, reqURL, ctxErr)
+	}
+	return &ConnectionError{op, reqURL, err}
+}
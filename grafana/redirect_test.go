@@ -0,0 +1,94 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedirects(t *testing.T) {
+	Convey("When Grafana answers with redirects", t, func() {
+		var paths []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			switch r.URL.Path {
+			case "/api/dashboards/db/old-name":
+				http.Redirect(w, r, "/api/dashboards/db/new-name?"+r.URL.RawQuery, http.StatusMovedPermanently)
+			case "/api/dashboards/db/new-name":
+				fmt.Fprintln(w, `{"Dashboard":{"Title":"Renamed"}}`)
+			case "/render/dashboard-solo/db/new-name":
+				w.Write([]byte("png"))
+			case "/api/dashboards/uid/private", "/render/d-solo/private/_":
+				http.Redirect(w, r, "/login", http.StatusFound)
+			case "/api/dashboards/uid/loop":
+				http.Redirect(w, r, r.URL.Path, http.StatusFound)
+			case "/api/dashboards/uid/elsewhere":
+				http.Redirect(w, r, "http://elsewhere.invalid/api/dashboards/uid/elsewhere", http.StatusFound)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer ts.Close()
+
+		Convey("A redirect to a dashboard's new slug should be followed and used for its panels", func() {
+			g := NewV4Client(ts.URL, "", url.Values{})
+			dash, err := g.GetDashboard("old-name")
+			So(err, ShouldBeNil)
+			So(dash.Title, ShouldEqual, "Renamed")
+
+			img, err := g.GetPanelPng(Panel{Id: 1}, "old-name", TimeRange{From: "now-1h", To: "now"})
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(img)
+			img.Close()
+			So(string(body), ShouldEqual, "png")
+			So(paths, ShouldResemble, []string{"/api/dashboards/db/old-name", "/api/dashboards/db/new-name", "/render/dashboard-solo/db/new-name"})
+		})
+
+		Convey("A redirect to the login page should be an authentication error", func() {
+			g := NewV5Client(ts.URL, "expired", url.Values{})
+			_, err := g.GetDashboard("private")
+			So(errors.Is(err, ErrLoginRedirect), ShouldBeTrue)
+			So(err, ShouldHaveSameTypeAs, &RedirectError{})
+
+			_, err = g.GetPanelPng(Panel{Id: 1}, "private", TimeRange{From: "now-1h", To: "now"})
+			So(errors.Is(err, ErrLoginRedirect), ShouldBeTrue)
+		})
+
+		Convey("A redirect loop should be given up", func() {
+			g := NewV5Client(ts.URL, "", url.Values{})
+			_, err := g.GetDashboard("loop")
+			So(err, ShouldHaveSameTypeAs, &RedirectError{})
+			So(err.Error(), ShouldContainSubstring, "stopped after 5 redirects")
+			So(len(paths), ShouldEqual, maxRedirects)
+		})
+
+		Convey("A redirect to another host should not be followed", func() {
+			g := NewV5Client(ts.URL, "secret", url.Values{})
+			_, err := g.GetDashboard("elsewhere")
+			So(err, ShouldHaveSameTypeAs, &RedirectError{})
+			So(err.Error(), ShouldContainSubstring, "another host")
+		})
+	})
+}
@@ -0,0 +1,163 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RenderNonce adds a unique parameter to every render url, so that caches in front of the image renderer
+// can't answer with an image rendered for an earlier request
+var RenderNonce = true
+
+// renderNonceParam is ignored by Grafana and only makes the render urls unique
+const renderNonceParam = "reporterNonce"
+
+// Render modes of panel images, see RenderMode
+const (
+	RenderImages = "images" //rendered by Grafana's image renderer
+	RenderData   = "data"   //drawn from the panel's data, for Grafana installations without an image renderer
+	RenderAuto   = "auto"   //rendered by the image renderer, drawn from the data where rendering fails
+)
+
+// RenderMode sets how the panel images of Grafana clients are made: RenderImages, RenderData or RenderAuto.
+// Drawing from the data supports time series and stat panels, other panels are drawn as a placeholder, see drawChart.
+var RenderMode = RenderImages
+
+// ErrRendererUnavailable is returned for panel images when Grafana has no image renderer
+var ErrRendererUnavailable = errors.New("Grafana has no image renderer")
+
+// rendererState remembers, for the copies of a client in RenderAuto mode, that the image renderer is unavailable,
+// so that only the first panel waits for it
+type rendererState struct {
+	unavailable int32 //accessed atomically
+}
+
+func (s *rendererState) isUnavailable() bool {
+	return atomic.LoadInt32(&s.unavailable) == 1
+}
+
+func (s *rendererState) setUnavailable() {
+	atomic.StoreInt32(&s.unavailable, 1)
+}
+
+// rendererUnavailable reports whether a failed render response means Grafana has no image renderer, rather than a
+// failure of this panel: Grafana answers 500 "No image renderer available/installed", or 404 where the render route is missing.
+// The response body is kept readable.
+func rendererUnavailable(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusNotFound {
+		return true
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return strings.Contains(strings.ToLower(string(body)), "image renderer")
+}
+
+// drawPanelPng draws a panel from its data, fetched with getData, as a PNG of the panel's size
+func drawPanelPng(p Panel, t TimeRange, width, height int, getData func(Panel, TimeRange) (PanelData, error)) (io.ReadCloser, error) {
+	var data PanelData
+	if isChartable(p) {
+		var err error
+		data, err = getData(p, t)
+		if err != nil {
+			return nil, fmt.Errorf("error getting data of panel %v: %w", p.Id, err)
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, drawChart(p, data, width, height))
+	if err != nil {
+		return nil, fmt.Errorf("error encoding chart of panel %v: %v", p.Id, err)
+	}
+	return ioutil.NopCloser(&buf), nil
+}
+
+// RenderAger is implemented by panel images that know how long ago they were rendered
+type RenderAger interface {
+	RenderAge() time.Duration
+}
+
+// PanelURLer is implemented by clients that get panel images from Grafana's render endpoint.
+// Users can open the url, with their own login, to compare a panel with its image in a report.
+type PanelURLer interface {
+	PanelURL(p Panel, dashName string, t TimeRange) string
+}
+
+// PanelURL returns the render url of a panel, or the empty string if g does not render panels with Grafana
+func PanelURL(g Client, p Panel, dashName string, t TimeRange) string {
+	if u, ok := g.(PanelURLer); ok {
+		return u.PanelURL(p, dashName, t)
+	}
+	return ""
+}
+
+// secretParams are url parameters Grafana accepts credentials in
+var secretParams = []string{"auth_token", "api_key", "apiKey"}
+
+// redactURL replaces the password and the values of secretParams in rawURL with xxxxx
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	query := u.Query()
+	redacted := false
+	for _, name := range secretParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, "xxxxx")
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// renderedImage is a panel image with the age of the render response
+type renderedImage struct {
+	io.ReadCloser
+	age time.Duration
+}
+
+func (r renderedImage) RenderAge() time.Duration {
+	return r.age
+}
+
+// renderAge returns how old a render response was at now: the larger of its Age header, set by caches,
+// and the time since its Date header. It is 0 without either header.
+func renderAge(h http.Header, now time.Time) time.Duration {
+	var age time.Duration
+	if s, err := strconv.Atoi(h.Get("Age")); err == nil && s > 0 {
+		age = time.Duration(s) * time.Second
+	}
+	if date, err := http.ParseTime(h.Get("Date")); err == nil && now.Sub(date) > age {
+		age = now.Sub(date)
+	}
+	return age
+}
@@ -0,0 +1,149 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// reportDefaultsTag prefixes dashboard tags declaring a report parameter, e.g. report:trim or report:style=compact
+	reportDefaultsTag = "report:"
+	// reportDefaultsBlob prefixes a JSON object of report parameters in the dashboard's description or a link's title or tooltip,
+	// e.g. reporter:{"style":"compact","summary":[4,7]}
+	reportDefaultsBlob = "reporter:"
+)
+
+// Link represents a link in the dashboard's header
+type Link struct {
+	Title   string
+	Tooltip string
+	Url     string
+}
+
+// reportDefaults collects the report parameters declared by the dashboard's tags, description and links.
+// Tags without a value, like report:trim, set the parameter to true. Values of JSON blobs take precedence over tags.
+// Malformed blobs are logged and ignored. The returned description has its blob removed.
+func reportDefaults(tags []string, description string, links []Link) (url.Values, string) {
+	defaults := url.Values{}
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, reportDefaultsTag) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(tag, reportDefaultsTag), "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			continue
+		}
+		value := "true"
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		defaults.Set(key, value)
+	}
+
+	var blob string
+	blob, description = cutReportDefaultsBlob(description)
+	texts := []string{blob}
+	for _, l := range links {
+		b, _ := cutReportDefaultsBlob(l.Title)
+		texts = append(texts, b)
+		b, _ = cutReportDefaultsBlob(l.Tooltip)
+		texts = append(texts, b)
+	}
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		values, err := parseReportDefaults(text)
+		if err != nil {
+			log.Printf("Warning: ignoring malformed report defaults %q: %v", text, err)
+			continue
+		}
+		for key, value := range values {
+			defaults.Set(key, value)
+		}
+	}
+	if len(defaults) == 0 {
+		return nil, description
+	}
+	return defaults, description
+}
+
+// cutReportDefaultsBlob returns the JSON object following the reporter: prefix in s, and s without it.
+// The blob extends to the end of the JSON object, or to the end of s when the object is malformed.
+func cutReportDefaultsBlob(s string) (blob string, rest string) {
+	i := strings.Index(s, reportDefaultsBlob)
+	if i < 0 {
+		return "", s
+	}
+	tail := s[i+len(reportDefaultsBlob):]
+	end := len(tail)
+	dec := json.NewDecoder(strings.NewReader(tail))
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err == nil {
+		end = int(dec.InputOffset())
+	}
+	return strings.TrimSpace(tail[:end]), strings.TrimSpace(s[:i] + tail[end:])
+}
+
+// parseReportDefaults parses a JSON object of report parameters. Strings, numbers and booleans are used as is,
+// lists are joined with commas, e.g. {"summary":[4,7]} gives summary=4,7
+func parseReportDefaults(blob string) (map[string]string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(blob), &fields); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(fields))
+	for key, field := range fields {
+		if list, ok := field.([]interface{}); ok {
+			var items []string
+			for _, item := range list {
+				s, err := reportDefaultValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("%v: %v", key, err)
+				}
+				items = append(items, s)
+			}
+			values[key] = strings.Join(items, ",")
+			continue
+		}
+		s, err := reportDefaultValue(field)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", key, err)
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+func reportDefaultValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,91 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReportDefaults(t *testing.T) {
+	Convey("When creating a dashboard declaring report defaults", t, func() {
+		const dashJSON = `
+{"dashboard":
+	{
+		"title": "Routers",
+		"tags": ["network", "report:trim", "report:style=compact", "report:summary=1"],
+		"description": "Traffic per router. reporter:{\"style\":\"plain\",\"summary\":[4,7],\"noDataBadge\":true,\"panelsPerPage\":2}",
+		"links": [{"title": "Runbook", "tooltip": "reporter:{\"showDatasource\":\"true\"}"}],
+		"panels": [{"type": "graph", "id": 4}]
+	}
+}`
+		dash := NewDashboard([]byte(dashJSON), url.Values{})
+
+		Convey("Tags should declare parameters, set to true when they have no value", func() {
+			So(dash.ReportDefaults.Get("trim"), ShouldEqual, "true")
+		})
+
+		Convey("The description's blob should take precedence over tags", func() {
+			So(dash.ReportDefaults.Get("style"), ShouldEqual, "plain")
+			So(dash.ReportDefaults.Get("summary"), ShouldEqual, "4,7")
+			So(dash.ReportDefaults.Get("noDataBadge"), ShouldEqual, "true")
+			So(dash.ReportDefaults.Get("panelsPerPage"), ShouldEqual, "2")
+		})
+
+		Convey("Blobs in links should be read", func() {
+			So(dash.ReportDefaults.Get("showDatasource"), ShouldEqual, "true")
+		})
+
+		Convey("The blob should be removed from the description", func() {
+			So(dash.Description, ShouldEqual, "Traffic per router.")
+		})
+	})
+
+	Convey("When creating a dashboard without report defaults", t, func() {
+		dash := NewDashboard([]byte(`{"dashboard":{"title":"Routers","tags":["network"],"description":"Traffic"}}`), url.Values{})
+
+		Convey("It should have none", func() {
+			So(dash.ReportDefaults, ShouldBeNil)
+			So(dash.Description, ShouldEqual, "Traffic")
+		})
+	})
+
+	Convey("When a dashboard's report defaults are malformed", t, func() {
+		Convey("A blob that is not valid JSON should be ignored", func() {
+			defaults, description := reportDefaults([]string{"report:trim"}, `About reporter:{"style": plain`, nil)
+			So(defaults, ShouldResemble, url.Values{"trim": {"true"}})
+			So(description, ShouldEqual, "About")
+		})
+
+		Convey("A blob with unsupported values should be ignored", func() {
+			defaults, _ := reportDefaults(nil, `reporter:{"style":"plain","summary":{"id":4}}`, nil)
+			So(defaults, ShouldBeNil)
+		})
+
+		Convey("A blob that is not an object should be ignored", func() {
+			defaults, _ := reportDefaults(nil, `reporter:[1,2]`, nil)
+			So(defaults, ShouldBeNil)
+		})
+
+		Convey("Tags without a name should be ignored", func() {
+			defaults, _ := reportDefaults([]string{"report:", "report:=x"}, "", nil)
+			So(defaults, ShouldBeNil)
+		})
+	})
+}
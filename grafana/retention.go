@@ -0,0 +1,104 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention maps data source names or uids to how long the data source keeps data
+type Retention map[string]time.Duration
+
+// ParseRetention parses a comma separated list of {datasource}={duration} pairs, e.g. "prom=30d,loki=7d".
+// Durations use Go's duration syntax, extended with d for days and w for weeks.
+func ParseRetention(s string) (Retention, error) {
+	r := Retention{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid retention %q, expected {datasource}={duration}", pair)
+		}
+		d, err := parseRetentionDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention duration for data source %v: %v", kv[0], err)
+		}
+		r[kv[0]] = d
+	}
+	return r, nil
+}
+
+func parseRetentionDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// Exceeded returns the data sources of panel p, sorted by name, whose retention ends after the start of tr.
+// Their data is incomplete for the report. A range that can't be parsed is never reported as exceeded.
+func (r Retention) Exceeded(p Panel, tr TimeRange, now time.Time) []string {
+	if len(r) == 0 {
+		return nil
+	}
+	from, err := tr.fromTime(now)
+	if err != nil {
+		return nil
+	}
+	exceeded := []string{}
+	for _, ds := range p.DatasourceNames() {
+		if d, ok := r[ds]; ok && from.Before(now.Add(-d)) {
+			exceeded = append(exceeded, ds)
+		}
+	}
+	sort.Strings(exceeded)
+	return exceeded
+}
+
+// FormatRetention formats a retention duration in the largest whole unit of days or hours, e.g. 30d
+func FormatRetention(d time.Duration) string {
+	day := 24 * time.Hour
+	if d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return d.String()
+}
+
+// fromTime resolves the start of the range relative to t
+func (tr TimeRange) fromTime(t time.Time) (time.Time, error) {
+	return nowIn(t, tr.location()).parse(tr.From, From)
+}
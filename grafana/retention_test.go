@@ -0,0 +1,92 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseRetention(t *testing.T) {
+	Convey("When parsing data source retention periods", t, func() {
+		Convey("Days, weeks and Go durations should be supported", func() {
+			r, err := ParseRetention("prom=30d, loki=2w,graphite=36h")
+			So(err, ShouldBeNil)
+			So(r, ShouldResemble, Retention{"prom": 30 * 24 * time.Hour, "loki": 14 * 24 * time.Hour, "graphite": 36 * time.Hour})
+		})
+
+		Convey("An empty list should give no retention", func() {
+			r, err := ParseRetention("")
+			So(err, ShouldBeNil)
+			So(r, ShouldBeEmpty)
+		})
+
+		Convey("Invalid entries should return an error", func() {
+			for _, s := range []string{"prom", "=30d", "prom=", "prom=-3d", "prom=30x", "prom=0h"} {
+				_, err := ParseRetention(s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Retentions should be formatted in whole days or hours", func() {
+			So(FormatRetention(30*24*time.Hour), ShouldEqual, "30d")
+			So(FormatRetention(36*time.Hour), ShouldEqual, "36h")
+			So(FormatRetention(90*time.Minute), ShouldEqual, "1h30m0s")
+		})
+	})
+}
+
+func TestRetentionExceeded(t *testing.T) {
+	Convey("When checking a panel's data sources against their retention", t, func() {
+		now := time.Date(2018, 3, 15, 12, 0, 0, 0, time.UTC)
+		r := Retention{"prom": 30 * 24 * time.Hour, "loki-uid": 7 * 24 * time.Hour}
+		prom := Panel{Datasource: "prom"}
+		mixed := Panel{
+			Datasource: map[string]interface{}{"uid": "-- Mixed --"},
+			Targets: []map[string]interface{}{
+				{"datasource": map[string]interface{}{"type": "loki", "uid": "loki-uid"}},
+				{"datasource": "prom"},
+			},
+		}
+
+		cases := []struct {
+			desc     string
+			panel    Panel
+			from     string
+			exceeded []string
+		}{
+			{"a range within retention", prom, "now-7d", []string{}},
+			{"a range exceeding retention", prom, "now-90d", []string{"prom"}},
+			{"an absolute range exceeding retention", prom, "1500000000000", []string{"prom"}},
+			{"a range exceeding only one of the panel's data sources", mixed, "now-10d", []string{"loki-uid"}},
+			{"a range exceeding all of the panel's data sources", mixed, "now-1y", []string{"loki-uid", "prom"}},
+			{"a data source without retention", Panel{Datasource: "graphite"}, "now-1y", []string{}},
+			{"an invalid range", prom, "yesterday", nil},
+		}
+		for _, c := range cases {
+			Convey("It should handle "+c.desc, func() {
+				So(r.Exceeded(c.panel, TimeRange{From: c.from, To: "now"}, now), ShouldResemble, c.exceeded)
+			})
+		}
+
+		Convey("Data source names should be taken from the panel and its targets", func() {
+			So(mixed.DatasourceNames(), ShouldResemble, []string{"loki-uid", "prom"})
+		})
+	})
+}
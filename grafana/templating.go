@@ -0,0 +1,97 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+// Templating holds the template variables defined by a dashboard
+type Templating struct {
+	List []TemplateVariable
+}
+
+// TemplateVariable is the definition of a template variable in the dashboard JSON. The meaning of Query depends on Type:
+// the data source query of query variables, a string or an object with a "query" field for newer data sources;
+// the comma separated values of custom and interval variables; the value of constant and the default of textbox variables;
+// and the plugin type of the data sources offered by datasource variables.
+type TemplateVariable struct {
+	Name        string
+	Label       string
+	Type        string //query, custom, constant, textbox, interval, datasource or adhoc
+	Description string
+	Query       interface{}
+	Definition  string //the query as shown by Grafana's variable editor, only set by newer Grafana versions
+	Regex       string //filters the values of query variables, or the data sources of datasource variables
+	Datasource  interface{}
+	Multi       bool
+	IncludeAll  bool
+	Auto        bool //interval variables offer an auto interval
+	Current     struct {
+		Text  interface{}
+		Value interface{} //a string, or a list of strings for multi-value variables
+	}
+	Options []struct {
+		Text  interface{}
+		Value interface{}
+	}
+}
+
+// Variable describes a dashboard template variable, e.g. to offer it in a form
+type Variable struct {
+	Name    string   `json:"name"`
+	Label   string   `json:"label,omitempty"`
+	Current []string `json:"current"`
+	Options []string `json:"options"`
+}
+
+// Variables lists the dashboard's template variables with their current values and options, in dashboard order
+func (t Templating) Variables() []Variable {
+	vars := []Variable{}
+	for _, v := range t.List {
+		variable := Variable{Name: v.Name, Label: v.Label, Current: stringValues(v.Current.Value), Options: []string{}}
+		for _, o := range v.Options {
+			variable.Options = append(variable.Options, stringValues(o.Value)...)
+		}
+		vars = append(vars, variable)
+	}
+	return vars
+}
+
+// defaults returns the current values of the dashboard's variables, keyed by variable name
+func (t Templating) defaults() map[string][]string {
+	values := map[string][]string{}
+	for _, v := range t.List {
+		if current := stringValues(v.Current.Value); len(current) > 0 {
+			values[v.Name] = current
+		}
+	}
+	return values
+}
+
+// stringValues converts a variable value from the dashboard JSON, a string or a list of strings, to a list
+func stringValues(value interface{}) []string {
+	switch val := value.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		var values []string
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
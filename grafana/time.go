@@ -17,16 +17,19 @@
 package grafana
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type TimeRange struct {
 	From string
 	To   string
+	TZ   string //IANA time zone used to render and print the report, e.g. Europe/Berlin
 }
 
 // Used to parse grafana time specifications. These can take various forms:
@@ -38,6 +41,7 @@ type TimeRange struct {
 //			To:  "now-1d/d" -> end of yesterday
 //			When used as boundary, the same string will evaluate to a different time if used in 'From' or 'To'
 //	 * absolute unix time: "142321234"
+//	 * chained operations: "now-1d/d+8h" -> 8 am yesterday
 //
 // The required behaviour is clearly documented in the unit tests, time_test.go.
 type now time.Time
@@ -49,10 +53,8 @@ const (
 	To
 )
 
-const (
-	relTimeRegExp      = "^now(-[0-9]+)([mhdwMy])$"
-	boundaryTimeRegExp = "^(.*?)/([dwMy])$"
-)
+// DefaultTimezone is used when neither the request nor the dashboard specify a usable time zone
+const DefaultTimezone = "UTC"
 
 func init() {
 	log.SetOutput(ioutil.Discard)
@@ -65,23 +67,188 @@ func NewTimeRange(from, to string) TimeRange {
 	if to == "" {
 		to = "now"
 	}
-	return TimeRange{from, to}
+	return TimeRange{From: from, To: to}
+}
+
+// ResolveTimezone returns the first usable time zone in zones, which are given in order of precedence.
+// Empty values and Grafana's "browser" setting defer to the next candidate, as do unknown zone names.
+// Grafana's "utc" setting is normalised to "UTC". If no candidate is usable, DefaultTimezone is returned.
+func ResolveTimezone(zones ...string) string {
+	for _, z := range zones {
+		switch z {
+		case "", "browser":
+			continue
+		case "utc":
+			return "UTC"
+		}
+		if _, err := time.LoadLocation(z); err != nil {
+			log.Printf("Ignoring unknown time zone %q: %v", z, err)
+			continue
+		}
+		return z
+	}
+	return DefaultTimezone
+}
+
+// ValidTimezone reports whether ResolveTimezone accepts zone rather than skipping it as unknown
+func ValidTimezone(zone string) bool {
+	switch zone {
+	case "", "browser", "utc":
+		return true
+	}
+	_, err := time.LoadLocation(zone)
+	return err == nil
 }
 
-// Formats Grafana 'From' time spec into absolute printable UTC time
+// Formats Grafana 'From' time spec into absolute printable time in the report's time zone
 func (tr TimeRange) FromFormatted() string {
-	n := newNow()
-	return n.parseFrom(tr.From).UTC().Format(time.UnixDate)
+	loc := tr.location()
+	n := newNow(loc)
+	return n.parseFrom(tr.From).In(loc).Format(time.UnixDate)
 }
 
-// Formats Grafana 'To' time spec into absolute printable UTC time
+// Formats Grafana 'To' time spec into absolute printable time in the report's time zone
 func (tr TimeRange) ToFormatted() string {
-	n := newNow()
-	return n.parseTo(tr.To).UTC().Format(time.UnixDate)
+	loc := tr.location()
+	n := newNow(loc)
+	return n.parseTo(tr.To).In(loc).Format(time.UnixDate)
+}
+
+// TimezoneFormatted returns the name of the report's time zone, ready for TeX consumption
+func (tr TimeRange) TimezoneFormatted() string {
+	return SanitizeLaTexInput(tr.location().String())
+}
+
+// IsAbsolute reports whether both ends of the range are fixed points in time, independent of the current time
+func (tr TimeRange) IsAbsolute() bool {
+	return !strings.HasPrefix(tr.From, "now") && !strings.HasPrefix(tr.To, "now")
+}
+
+// Resolve returns the absolute start and end of the range, evaluating relative expressions like "now-7d" or
+// "now/M" against now. Boundaries snap to days, weeks, months and years in loc, which defaults to UTC when nil.
+func (tr TimeRange) Resolve(now time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	return tr.resolve(nowIn(now, loc))
+}
+
+// Timepicker holds the dashboard's time picker settings
+type Timepicker struct {
+	NowDelay string //leaves out the most recent, possibly incomplete data of ranges ending now, e.g. "1m"
+}
+
+// ApplyNowDelay returns the range ending nowDelay earlier, e.g. at "now-1m", and whether it was changed.
+// Only ranges ending at "now" are changed; absolute ranges, an empty nowDelay and invalid delays leave the range as is.
+func (tr TimeRange) ApplyNowDelay(nowDelay string) (TimeRange, bool) {
+	if tr.To != "now" || nowDelay == "" {
+		return tr, false
+	}
+	d, err := parseRetentionDuration(nowDelay)
+	if err != nil {
+		log.Printf("Ignoring the dashboard's now delay: %v", err)
+		return tr, false
+	}
+	tr.To = shiftExpr(tr.To, -d)
+	return tr, true
+}
+
+// Shift moves both ends of the range by d, e.g. by -7 * 24h for last week's range in a comparison report.
+// Relative expressions stay relative, so "now-1h" shifted by -24h becomes "now-1h-24h".
+func (tr TimeRange) Shift(d time.Duration) TimeRange {
+	return TimeRange{From: shiftExpr(tr.From, d), To: shiftExpr(tr.To, d), TZ: tr.TZ}
+}
+
+// Duration returns the length of the range, resolved against the current time in the range's time zone.
+// The duration of relative ranges can depend on the current time, e.g. for "now/M".
+func (tr TimeRange) Duration() (time.Duration, error) {
+	from, to, err := tr.Resolve(time.Now(), tr.location())
+	if err != nil {
+		return 0, err
+	}
+	return to.Sub(from), nil
+}
+
+// Split divides the range, resolved against the current time, into n consecutive ranges of equal length.
+// The resulting ranges use absolute times. Split returns nil if n < 1 or the range can't be resolved.
+func (tr TimeRange) Split(n int) []TimeRange {
+	if n < 1 {
+		return nil
+	}
+	from, to, err := tr.Resolve(time.Now(), tr.location())
+	if err != nil {
+		return nil
+	}
+	step := to.Sub(from) / time.Duration(n)
+	buckets := make([]TimeRange, n)
+	for i := range buckets {
+		end := from.Add(time.Duration(i+1) * step)
+		if i == n-1 {
+			end = to
+		}
+		buckets[i] = TimeRange{From: unixMillis(from.Add(time.Duration(i) * step)), To: unixMillis(end), TZ: tr.TZ}
+	}
+	return buckets
+}
+
+func (tr TimeRange) resolve(n now) (from, to time.Time, err error) {
+	from, err = n.parse(tr.From, From)
+	if err != nil {
+		return
+	}
+	to, err = n.parse(tr.To, To)
+	if err != nil {
+		return
+	}
+	if to.Before(from) {
+		err = fmt.Errorf("time range %v to %v ends before it starts", tr.From, tr.To)
+	}
+	return
+}
+
+func shiftExpr(s string, d time.Duration) string {
+	if d == 0 {
+		return s
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return strconv.FormatInt(ms+int64(d/time.Millisecond), 10)
+	}
+	sign := "+"
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%s%s%dh", s, sign, d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%s%s%dm", s, sign, d/time.Minute)
+	}
+	return fmt.Sprintf("%s%s%ds", s, sign, d/time.Second)
+}
+
+func unixMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// location returns the report's time zone. An empty or unknown TZ is treated as UTC.
+func (tr TimeRange) location() *time.Location {
+	if tr.TZ == "" || tr.TZ == "utc" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tr.TZ)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
 
-func newNow() now {
-	return now(time.Now())
+// newNow returns the current time in loc, so that boundaries like "now/d" snap to loc's midnight
+func newNow(loc *time.Location) now {
+	return nowIn(time.Now(), loc)
+}
+
+func nowIn(t time.Time, loc *time.Location) now {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return now(t.In(loc))
 }
 
 func (n now) asTime() time.Time {
@@ -89,31 +256,124 @@ func (n now) asTime() time.Time {
 }
 
 func (n now) parseFrom(s string) time.Time {
-	return n.parseHumanFriendlyBoundary(s, From)
+	return n.mustParse(s, From)
 }
 
 func (n now) parseTo(s string) time.Time {
-	return n.parseHumanFriendlyBoundary(s, To)
+	return n.mustParse(s, To)
 }
 
-func (n now) parseHumanFriendlyBoundary(s string, b boundary) time.Time {
-	if !isHumanFriendlyBoundray(s) {
-		return n.parseMoment(s)
-	} else {
-		moment, boundaryUnit := n.parseMomentAndBoundaryUnit(s)
-		return roundMomentToBoundary(moment, b, boundaryUnit)
+func (n now) mustParse(s string, b boundary) time.Time {
+	t, err := n.parse(s, b)
+	if err != nil {
+		panic(err.Error())
 	}
+	return t
 }
 
-func (n now) parseMomentAndBoundaryUnit(s string) (time.Time, string) {
-	re := regexp.MustCompile(boundaryTimeRegExp)
-	matches := re.FindStringSubmatch(s)
-	if len(matches) != 3 {
-		panic(unrecognized(s))
+// parse evaluates Grafana's date math: an anchor, "now" or unix milliseconds, followed by any number of
+// operations. "+1h" and "-2d" add or subtract a number of units, "/d" rounds to the start of the unit for
+// From and to the start of the next unit for To. Units are s, m, h, d, w, M and y.
+func (n now) parse(s string, b boundary) (time.Time, error) {
+	t, ops, err := n.parseAnchor(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ops != "" {
+		//operations on absolute anchors work in the time zone of now
+		t = t.In(n.asTime().Location())
 	}
-	moment := n.parseMoment(matches[1])
-	boundaryUnit := matches[2]
-	return moment, boundaryUnit
+	for ops != "" {
+		op := ops[0]
+		ops = ops[1:]
+		switch op {
+		case '/':
+			if ops == "" || !strings.ContainsRune("dwMy", rune(ops[0])) {
+				return time.Time{}, errors.New(unrecognized(s))
+			}
+			t = roundMomentToBoundary(t, b, ops[:1])
+			ops = ops[1:]
+		case '+', '-':
+			digits := 0
+			for digits < len(ops) && ops[digits] >= '0' && ops[digits] <= '9' {
+				digits++
+			}
+			num := 1
+			if digits > 0 {
+				num, err = strconv.Atoi(ops[:digits])
+				if err != nil {
+					return time.Time{}, errors.New(unrecognized(s))
+				}
+			}
+			if digits == len(ops) {
+				return time.Time{}, errors.New(unrecognized(s))
+			}
+			if op == '-' {
+				num = -num
+			}
+			t, err = addUnits(t, num, ops[digits])
+			if err != nil {
+				return time.Time{}, errors.New(unrecognized(s))
+			}
+			ops = ops[digits+1:]
+		default:
+			return time.Time{}, errors.New(unrecognized(s))
+		}
+	}
+	return t, nil
+}
+
+func (n now) parseAnchor(s string) (t time.Time, ops string, err error) {
+	if strings.HasPrefix(s, "now") {
+		return n.asTime(), s[len("now"):], nil
+	}
+	end := strings.IndexAny(s, "+-/")
+	if end == 0 {
+		return time.Time{}, "", errors.New(unrecognized(s))
+	}
+	if end < 0 {
+		end = len(s)
+	}
+	timeInMs, err := strconv.ParseInt(s[:end], 10, 64)
+	if err != nil {
+		return time.Time{}, "", errors.New(unrecognized(s))
+	}
+	return time.Unix(timeInMs/1000, 0), s[end:], nil
+}
+
+// addUnits adds num units to t. Days and longer keep the wall clock time across daylight saving changes,
+// and months and years are clamped to the end of shorter months, e.g. Mar 31 - 1M is Feb 28 or 29.
+func addUnits(t time.Time, num int, unit byte) (time.Time, error) {
+	switch unit {
+	case 's':
+		return t.Add(time.Duration(num) * time.Second), nil
+	case 'm':
+		return t.Add(time.Duration(num) * time.Minute), nil
+	case 'h':
+		return t.Add(time.Duration(num) * time.Hour), nil
+	case 'd':
+		return t.AddDate(0, 0, num), nil
+	case 'w':
+		return t.AddDate(0, 0, num*7), nil
+	case 'M':
+		return addMonths(t, num), nil
+	case 'y':
+		return addMonths(t, num*12), nil
+	}
+	return t, fmt.Errorf("unknown unit %q", unit)
+}
+
+func addMonths(t time.Time, months int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := daysIn(first); d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
 }
 
 func roundMomentToBoundary(moment time.Time, b boundary, boundaryUnit string) time.Time {
@@ -155,71 +415,6 @@ func daysToWeekBoundary(wd time.Weekday, b boundary) int {
 	}
 }
 
-func (n now) parseMoment(s string) time.Time {
-	if s == "now" {
-		return n.asTime()
-	} else if isRelativeTime(s) {
-		return n.parseRelativeTime(s)
-	} else {
-		return parseAbsTime(s)
-	}
-}
-
-func (n now) parseRelativeTime(s string) time.Time {
-	re := regexp.MustCompile(relTimeRegExp)
-
-	matches := re.FindStringSubmatch(s)
-	if len(matches) != 3 {
-		panic(unrecognized(s))
-	}
-	unit := matches[2]
-	number := matches[1]
-
-	i, err := strconv.Atoi(number)
-	stopIf(err)
-
-	switch unit {
-	case "m", "h":
-		d, err := time.ParseDuration(number + unit)
-		stopIf(err)
-		return n.asTime().Add(d)
-	case "d":
-		return n.asTime().AddDate(0, 0, i)
-	case "w":
-		return n.asTime().AddDate(0, 0, i*7)
-	case "M":
-		return n.asTime().AddDate(0, i, 0)
-	case "y":
-		return n.asTime().AddDate(i, 0, 0)
-	}
-
-	return n.asTime()
-}
-
-func parseAbsTime(s string) time.Time {
-	if timeInMs, err := strconv.ParseInt(s, 10, 64); err == nil {
-		return time.Unix(int64(timeInMs)/1000, 0)
-	}
-
-	panic(unrecognized(s))
-}
-
 func unrecognized(s string) string {
 	return s + " is not a recognised time format"
 }
-
-func stopIf(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
-
-func isRelativeTime(s string) bool {
-	matched, _ := regexp.MatchString(relTimeRegExp, s)
-	return matched
-}
-
-func isHumanFriendlyBoundray(s string) bool {
-	matched, _ := regexp.MatchString(boundaryTimeRegExp, s)
-	return matched
-}
@@ -195,3 +195,243 @@ func TestTimeParsing(tst *testing.T) {
 
 	})
 }
+
+func TestResolveTimezone(t *testing.T) {
+	Convey("When resolving the report time zone", t, func() {
+		request, flag, dashboard := "America/New_York", "Europe/Berlin", "Asia/Tokyo"
+
+		Convey("The request parameter should take precedence", func() {
+			So(ResolveTimezone(request, flag, dashboard), ShouldEqual, request)
+		})
+
+		Convey("The flag should be used when the request does not specify a time zone", func() {
+			So(ResolveTimezone("", flag, dashboard), ShouldEqual, flag)
+		})
+
+		Convey("The dashboard time zone should be used when neither request nor flag specify one", func() {
+			So(ResolveTimezone("", "", dashboard), ShouldEqual, dashboard)
+		})
+
+		Convey("The server default should be used when nothing specifies a time zone", func() {
+			So(ResolveTimezone("", "", ""), ShouldEqual, DefaultTimezone)
+		})
+
+		Convey("Grafana's browser setting should defer to the server default", func() {
+			So(ResolveTimezone("", "", "browser"), ShouldEqual, DefaultTimezone)
+		})
+
+		Convey("Grafana's utc setting should be normalised", func() {
+			So(ResolveTimezone("", "", "utc"), ShouldEqual, "UTC")
+		})
+
+		Convey("Unknown time zones should be skipped", func() {
+			So(ResolveTimezone("Not/AZone", "", dashboard), ShouldEqual, dashboard)
+		})
+	})
+}
+
+func TestValidTimezone(t *testing.T) {
+	Convey("When validating time zones", t, func() {
+		Convey("Zone names and Grafana's settings should be valid", func() {
+			for _, z := range []string{"Europe/Berlin", "UTC", "utc", "browser", ""} {
+				So(ValidTimezone(z), ShouldBeTrue)
+			}
+		})
+
+		Convey("Unknown zones should be invalid", func() {
+			So(ValidTimezone("Not/AZone"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestTimeRangeFormattingInTimezone(t *testing.T) {
+	Convey("When formatting a time range with a time zone", t, func() {
+		tr := TimeRange{From: "1453206447000", To: "1453213647000", TZ: "Europe/Berlin"}
+
+		Convey("Times should be printed in that time zone", func() {
+			So(tr.FromFormatted(), ShouldEqual, "Tue Jan 19 13:27:27 CET 2016")
+			So(tr.ToFormatted(), ShouldEqual, "Tue Jan 19 15:27:27 CET 2016")
+		})
+
+		Convey("The time zone name should be sanitised for TeX", func() {
+			tr.TZ = "America/New_York"
+			So(tr.TimezoneFormatted(), ShouldEqual, "America/New\\_York")
+		})
+
+		Convey("Without a time zone, times should be printed in UTC", func() {
+			tr.TZ = ""
+			So(tr.FromFormatted(), ShouldEqual, "Tue Jan 19 12:27:27 UTC 2016")
+			So(tr.TimezoneFormatted(), ShouldEqual, "UTC")
+		})
+	})
+}
+
+func TestDateMath(tst *testing.T) {
+	Convey("When evaluating Grafana date math", tst, func() {
+		berlin, _ := time.LoadLocation("Europe/Berlin")
+		at := func(s string, loc *time.Location) now {
+			t, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+			So(err, ShouldBeNil)
+			return now(t)
+		}
+		date := func(s string, loc *time.Location) time.Time {
+			return time.Time(at(s, loc))
+		}
+
+		cases := []struct {
+			desc, now, expr string
+			b               boundary
+			loc             *time.Location
+			expected        string
+		}{
+			{"chained operations", "2016-01-06 16:34", "now-1d/d+8h", From, time.UTC, "2016-01-05 08:00"},
+			{"additions", "2016-01-06 16:34", "now+2h", To, time.UTC, "2016-01-06 18:34"},
+			{"seconds", "2016-01-06 16:34", "now-120s", To, time.UTC, "2016-01-06 16:32"},
+			{"units without a number", "2016-01-06 16:34", "now-d", To, time.UTC, "2016-01-05 16:34"},
+			{"operations on absolute times", "2016-01-06 16:34", "1452096000000+1h", To, time.UTC, "2016-01-06 17:00"},
+			{"months shorter than the current day", "2016-03-31 12:00", "now-1M", From, time.UTC, "2016-02-29 12:00"},
+			{"months shorter than the current day in non leap years", "2015-03-31 12:00", "now-1M", From, time.UTC, "2015-02-28 12:00"},
+			{"adding months to the end of a month", "2016-01-31 12:00", "now+1M", From, time.UTC, "2016-02-29 12:00"},
+			{"years from a leap day", "2016-02-29 12:00", "now-1y", From, time.UTC, "2015-02-28 12:00"},
+			{"months across years", "2016-01-15 12:00", "now-13M", From, time.UTC, "2014-12-15 12:00"},
+			{"month boundaries of 30 day months", "2016-04-30 12:00", "now/M", To, time.UTC, "2016-05-01 00:00"},
+			{"days across the start of daylight saving time", "2018-03-26 12:00", "now-1d", From, berlin, "2018-03-25 12:00"},
+			{"hours across the start of daylight saving time", "2018-03-25 04:00", "now-3h", From, berlin, "2018-03-25 00:00"},
+			{"day boundaries on the day daylight saving time starts", "2018-03-25 12:00", "now/d", To, berlin, "2018-03-26 00:00"},
+			{"week boundaries across the end of daylight saving time", "2018-10-30 12:00", "now/w", From, berlin, "2018-10-28 00:00"},
+		}
+		for _, c := range cases {
+			Convey("It should support "+c.desc, func() {
+				t, err := at(c.now, c.loc).parse(c.expr, c.b)
+				So(err, ShouldBeNil)
+				So(t.Equal(date(c.expected, c.loc)), ShouldBeTrue)
+			})
+		}
+
+		Convey("It should return an error for unrecognised expressions", func() {
+			for _, expr := range []string{"", "now-", "now-1", "now-1k", "now/h", "now/", "now*2d", "-1d", "yesterday", "1235032k"} {
+				_, err := at("2016-01-06 16:34", time.UTC).parse(expr, From)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestTimeRangeManipulation(tst *testing.T) {
+	Convey("When manipulating time ranges", tst, func() {
+		berlin, _ := time.LoadLocation("Europe/Berlin")
+		ref := time.Date(2018, 3, 25, 12, 0, 0, 0, berlin)
+
+		Convey("Only ranges without now should be absolute", func() {
+			So(TimeRange{From: "1453206447000", To: "1453213647000"}.IsAbsolute(), ShouldBeTrue)
+			So(TimeRange{From: "1453206447000", To: "now"}.IsAbsolute(), ShouldBeFalse)
+			So(TimeRange{From: "now-1d/d", To: "1453213647000"}.IsAbsolute(), ShouldBeFalse)
+		})
+
+		Convey("Resolve should evaluate both ends in the given time zone", func() {
+			from, to, err := TimeRange{From: "now/d", To: "now/d"}.Resolve(ref, berlin)
+			So(err, ShouldBeNil)
+			So(from.Equal(time.Date(2018, 3, 25, 0, 0, 0, 0, berlin)), ShouldBeTrue)
+			So(to.Equal(time.Date(2018, 3, 26, 0, 0, 0, 0, berlin)), ShouldBeTrue)
+
+			Convey("A day with a daylight saving change should be 23 hours long", func() {
+				So(to.Sub(from), ShouldEqual, 23*time.Hour)
+			})
+		})
+
+		Convey("Resolve should snap to UTC without a time zone", func() {
+			from, _, err := TimeRange{From: "now/d", To: "now"}.Resolve(ref, nil)
+			So(err, ShouldBeNil)
+			So(from.Equal(time.Date(2018, 3, 25, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		})
+
+		Convey("Resolve should support whole months of different lengths", func() {
+			for month, days := range map[time.Month]int{time.February: 28, time.March: 31, time.April: 30} {
+				from, to, err := TimeRange{From: "now/M", To: "now/M"}.Resolve(time.Date(2018, month, 10, 0, 0, 0, 0, time.UTC), time.UTC)
+				So(err, ShouldBeNil)
+				So(to.Sub(from), ShouldEqual, time.Duration(days)*24*time.Hour)
+			}
+		})
+
+		Convey("Resolve should return an error for invalid or inverted ranges", func() {
+			_, _, err := TimeRange{From: "now-1x", To: "now"}.Resolve(ref, berlin)
+			So(err, ShouldNotBeNil)
+			_, _, err = TimeRange{From: "now", To: "not-a-time"}.Resolve(ref, berlin)
+			So(err, ShouldNotBeNil)
+			_, _, err = TimeRange{From: "now", To: "now-1h"}.Resolve(ref, berlin)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("ApplyNowDelay should only end ranges ending now earlier", func() {
+			delayed, ok := TimeRange{From: "now-6h", To: "now", TZ: "UTC"}.ApplyNowDelay("1m")
+			So(ok, ShouldBeTrue)
+			So(delayed, ShouldResemble, TimeRange{From: "now-6h", To: "now-1m", TZ: "UTC"})
+
+			absolute := TimeRange{From: "1453206447000", To: "1453213647000"}
+			unchanged, ok := absolute.ApplyNowDelay("1m")
+			So(ok, ShouldBeFalse)
+			So(unchanged, ShouldResemble, absolute)
+
+			for _, delay := range []string{"", "soon", "-5m"} {
+				_, ok = TimeRange{From: "now-6h", To: "now"}.ApplyNowDelay(delay)
+				So(ok, ShouldBeFalse)
+			}
+			_, ok = TimeRange{From: "now-1d/d", To: "now-1d/d"}.ApplyNowDelay("1m")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Shift should keep relative expressions relative", func() {
+			shifted := TimeRange{From: "now-1h", To: "now", TZ: "Europe/Berlin"}.Shift(-24 * time.Hour)
+			So(shifted, ShouldResemble, TimeRange{From: "now-1h-24h", To: "now-24h", TZ: "Europe/Berlin"})
+
+			from, to, err := shifted.Resolve(ref, berlin)
+			So(err, ShouldBeNil)
+			So(from.Equal(ref.Add(-25*time.Hour)), ShouldBeTrue)
+			So(to.Equal(ref.Add(-24*time.Hour)), ShouldBeTrue)
+		})
+
+		Convey("Shift should use the largest whole unit", func() {
+			So(TimeRange{From: "now/d", To: "now/d"}.Shift(90*time.Minute), ShouldResemble, TimeRange{From: "now/d+90m", To: "now/d+90m"})
+			So(TimeRange{From: "now", To: "now"}.Shift(-90*time.Second), ShouldResemble, TimeRange{From: "now-90s", To: "now-90s"})
+			So(TimeRange{From: "now", To: "now"}.Shift(0), ShouldResemble, TimeRange{From: "now", To: "now"})
+		})
+
+		Convey("Shift should move absolute times", func() {
+			So(TimeRange{From: "1453206447000", To: "1453213647000"}.Shift(time.Hour), ShouldResemble, TimeRange{From: "1453210047000", To: "1453217247000"})
+		})
+
+		Convey("Duration should return the length of the range", func() {
+			d, err := TimeRange{From: "1453206447000", To: "1453213647000"}.Duration()
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 2*time.Hour)
+
+			d, err = TimeRange{From: "now-7d", To: "now", TZ: "UTC"}.Duration()
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 7*24*time.Hour)
+
+			_, err = TimeRange{From: "bad", To: "now"}.Duration()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Split should divide the range into equal absolute ranges", func() {
+			buckets := TimeRange{From: "1453206000000", To: "1453213200000", TZ: "UTC"}.Split(4)
+			So(buckets, ShouldResemble, []TimeRange{
+				{From: "1453206000000", To: "1453207800000", TZ: "UTC"},
+				{From: "1453207800000", To: "1453209600000", TZ: "UTC"},
+				{From: "1453209600000", To: "1453211400000", TZ: "UTC"},
+				{From: "1453211400000", To: "1453213200000", TZ: "UTC"},
+			})
+		})
+
+		Convey("Split should end the last range exactly at the end of the range", func() {
+			buckets := TimeRange{From: "1453206000000", To: "1453206010000"}.Split(3)
+			So(buckets, ShouldHaveLength, 3)
+			So(buckets[2].To, ShouldEqual, "1453206010000")
+		})
+
+		Convey("Split should return nil for invalid input", func() {
+			So(TimeRange{From: "now-1h", To: "now"}.Split(0), ShouldBeNil)
+			So(TimeRange{From: "bad", To: "now"}.Split(2), ShouldBeNil)
+		})
+	})
+}
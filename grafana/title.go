@@ -0,0 +1,62 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaxTitleLength is the number of characters after which dashboard, row and panel titles are truncated with an ellipsis.
+// 0 disables truncation.
+var MaxTitleLength = 200
+
+// texTitle truncates and sanitizes a title for TeX consumption
+func texTitle(title string) string {
+	short, truncated := truncate(title, MaxTitleLength)
+	short = SanitizeLaTexInput(short)
+	if truncated {
+		short += "\\ldots{}"
+	}
+	return short
+}
+
+// plainTitle truncates a title and strips all characters with a special meaning in TeX, leaving text
+// that is safe to use in PDF bookmarks, e.g. as the second argument of \texorpdfstring
+func plainTitle(title string) string {
+	short, truncated := truncate(title, MaxTitleLength)
+	short = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\{}$&#^_~%<>|`, r) || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, short)
+	short = strings.Join(strings.Fields(short), " ")
+	if truncated {
+		short += "..."
+	}
+	return short
+}
+
+// truncate shortens s to max characters, including one for the ellipsis the caller appends when truncated is true
+func truncate(s string, max int) (short string, truncated bool) {
+	runes := []rune(s)
+	if max <= 0 || len(runes) <= max {
+		return s, false
+	}
+	return strings.TrimRightFunc(string(runes[:max-1]), unicode.IsSpace), true
+}
@@ -0,0 +1,58 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTitles(t *testing.T) {
+	Convey("When preparing pathological titles for TeX", t, func() {
+		long := strings.Repeat("team=core;service=api;", 20)
+		cases := []struct {
+			desc, title, tex, plain string
+		}{
+			{"short titles are only sanitized", "CPU #1", `CPU \#1`, "CPU 1"},
+			{"math mode characters are neutralized", "x_1^2 $5 < y > z | w",
+				`x\_1\textasciicircum 2 \$5 \textless  y \textgreater  z \textbar  w`, "x 1 2 5 y z w"},
+			{"TeX commands are neutralized", `\input{/etc/passwd} & 100%`,
+				`\textbackslash input\{/etc/passwd\} \& 100\%`, "input /etc/passwd 100"},
+			{"long titles are truncated with an ellipsis", long,
+				SanitizeLaTexInput(long[:199]) + `\ldots{}`, long[:199] + "..."},
+			{"truncation does not split multibyte characters", strings.Repeat("é", 250),
+				strings.Repeat("é", 199) + `\ldots{}`, strings.Repeat("é", 199) + "..."},
+			{"truncation does not split escapes", strings.Repeat("a", 198) + "#b#c",
+				strings.Repeat("a", 198) + `\#\ldots{}`, strings.Repeat("a", 198) + "..."},
+			{"control characters are dropped from bookmarks", "a\tb\nc", "a\tb\nc", "a b c"},
+		}
+		for _, c := range cases {
+			Convey("Then "+c.desc, func() {
+				So(texTitle(c.title), ShouldEqual, c.tex)
+				So(plainTitle(c.title), ShouldEqual, c.plain)
+			})
+		}
+
+		Convey("Then truncation can be disabled", func() {
+			MaxTitleLength = 0
+			defer func() { MaxTitleLength = 200 }()
+			So(plainTitle(long), ShouldEqual, long)
+		})
+	})
+}
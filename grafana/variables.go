@@ -0,0 +1,120 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SplitPanelVariables separates panel specific template variables of the form var-{name}.{panelId}={value}
+// from the variables that apply to the whole dashboard. The panel variables are keyed by panel id and
+// use the plain var-{name} form.
+func SplitPanelVariables(variables url.Values) (global url.Values, panels map[int]url.Values) {
+	global = url.Values{}
+	panels = map[int]url.Values{}
+	for k, v := range variables {
+		i := strings.LastIndex(k, ".")
+		if i < 0 {
+			global[k] = v
+			continue
+		}
+		id, err := strconv.Atoi(k[i+1:])
+		if err != nil {
+			global[k] = v
+			continue
+		}
+		if panels[id] == nil {
+			panels[id] = url.Values{}
+		}
+		panels[id][k[:i]] = v
+	}
+	for id, vars := range panels {
+		panels[id] = DedupeVariables(vars)
+	}
+	return DedupeVariables(global), panels
+}
+
+// WithPanelVariables sets template variables that override the dashboard wide variables for single panels,
+// keyed by panel id
+func WithPanelVariables(panels map[int]url.Values) ClientOption {
+	return func(g *client) {
+		g.panelVariables = panels
+	}
+}
+
+// variablesFor returns the template variables for rendering or querying a panel.
+// Panel specific values take precedence over the dashboard wide ones.
+func (g client) variablesFor(panelID int) url.Values {
+	vars := copyValues(g.variables)
+	for k, v := range g.panelVariables[panelID] {
+		vars[k] = append([]string(nil), v...)
+	}
+	return vars
+}
+
+// DedupeVariables returns a copy of variables without repeated identical values of a variable,
+// keeping the order of the distinct values. Repeated values would make Grafana render repeated panels
+// several times.
+func DedupeVariables(variables url.Values) url.Values {
+	out := make(url.Values, len(variables))
+	for k, v := range variables {
+		seen := make(map[string]bool, len(v))
+		distinct := make([]string, 0, len(v))
+		for _, singleV := range v {
+			if !seen[singleV] {
+				seen[singleV] = true
+				distinct = append(distinct, singleV)
+			}
+		}
+		if len(distinct) < len(v) {
+			log.Printf("Removed %d duplicate values of variable %v", len(v)-len(distinct), k)
+		}
+		out[k] = distinct
+	}
+	return out
+}
+
+// sortedKeys returns the names of the variables in alphabetical order
+func sortedKeys(variables url.Values) []string {
+	keys := make([]string, 0, len(variables))
+	for k := range variables {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getPanelVariablesValues describes the panel specific variables for the cover page, e.g. "99 (panel 4)"
+func getPanelVariablesValues(panels map[int]url.Values) string {
+	ids := make([]int, 0, len(panels))
+	for id := range panels {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	values := []string{}
+	for _, id := range ids {
+		for _, k := range sortedKeys(panels[id]) {
+			values = append(values, strings.Join(panels[id][k], ", ")+" (panel "+strconv.Itoa(id)+")")
+		}
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSplitPanelVariables(t *testing.T) {
+	Convey("When splitting panel specific variables from the request variables", t, func() {
+		vars := url.Values{}
+		vars.Add("var-host", "dev")
+		vars.Add("var-percentile", "50")
+		vars.Add("var-percentile.4", "99")
+		vars.Add("var-percentile.7", "90")
+		vars.Add("var-region.4", "eu")
+		vars.Add("var-file.ext", "pdf")
+		global, panels := SplitPanelVariables(vars)
+
+		Convey("Variables without a panel id should apply to the whole dashboard", func() {
+			So(global, ShouldResemble, url.Values{"var-host": {"dev"}, "var-percentile": {"50"}, "var-file.ext": {"pdf"}})
+		})
+
+		Convey("Variables with a panel id should be keyed by panel", func() {
+			So(panels, ShouldResemble, map[int]url.Values{
+				4: {"var-percentile": {"99"}, "var-region": {"eu"}},
+				7: {"var-percentile": {"90"}},
+			})
+		})
+
+		Convey("Panel values should take precedence over dashboard wide values", func() {
+			g := client{variables: global, panelVariables: panels}
+			So(g.variablesFor(4).Get("var-percentile"), ShouldEqual, "99")
+			So(g.variablesFor(4).Get("var-host"), ShouldEqual, "dev")
+			So(g.variablesFor(5).Get("var-percentile"), ShouldEqual, "50")
+			So(g.variablesFor(5).Get("var-region"), ShouldEqual, "")
+			So(g.variables.Get("var-percentile"), ShouldEqual, "50")
+		})
+	})
+}
+
+func TestPanelVariableOverrides(t *testing.T) {
+	Convey("When a client has panel specific variables", t, func() {
+		requestURI := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestURI = r.RequestURI
+			fmt.Fprint(w, `{"dashboard":{"title":"test"}}`)
+		}))
+		defer ts.Close()
+		vars := url.Values{}
+		vars.Add("var-percentile", "50")
+		grf := NewV5Client(ts.URL, "", vars, WithPanelVariables(map[int]url.Values{4: {"var-percentile": {"99"}}}))
+
+		Convey("The overridden panel should be rendered with its own value", func() {
+			grf.GetPanelPng(Panel{Id: 4, Type: "graph"}, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(requestURI, ShouldContainSubstring, "var-percentile=99")
+			So(requestURI, ShouldNotContainSubstring, "var-percentile=50")
+		})
+
+		Convey("Other panels should be rendered with the dashboard wide value", func() {
+			grf.GetPanelPng(Panel{Id: 5, Type: "graph"}, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(requestURI, ShouldContainSubstring, "var-percentile=50")
+		})
+
+		Convey("The cover page variables should note the override", func() {
+			dash, err := grf.GetDashboard("testDash")
+			So(err, ShouldBeNil)
+			So(dash.VariableValues, ShouldEqual, "50, 99 (panel 4)")
+			So(requestURI, ShouldNotContainSubstring, "99")
+		})
+	})
+}
+
+func TestDedupeVariables(t *testing.T) {
+	Convey("When removing repeated variable values", t, func() {
+		vars := url.Values{"var-host": {"b", "a", "b", "b"}, "var-env": {"prod"}}
+		deduped := DedupeVariables(vars)
+
+		Convey("Distinct values should be kept in their order", func() {
+			So(deduped, ShouldResemble, url.Values{"var-host": {"b", "a"}, "var-env": {"prod"}})
+		})
+
+		Convey("The input should not be modified", func() {
+			So(vars["var-host"], ShouldHaveLength, 4)
+		})
+
+		Convey("Panel specific variables should be deduplicated too", func() {
+			_, panels := SplitPanelVariables(url.Values{"var-host.4": {"a", "a"}})
+			So(panels[4], ShouldResemble, url.Values{"var-host": {"a"}})
+		})
+
+		Convey("The client should render each value once", func() {
+			var requestURI string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requestURI = r.RequestURI
+			}))
+			defer ts.Close()
+			NewV5Client(ts.URL, "", vars).GetPanelPng(Panel{Id: 1}, "testDash", TimeRange{From: "now-1h", To: "now"})
+			So(requestURI, ShouldContainSubstring, "var-host=b&var-host=a&")
+		})
+	})
+
+	Convey("When describing the variables on the cover", t, func() {
+		Convey("Variables should be listed in the same order for any parameter order", func() {
+			vars := url.Values{"var-b": {"2"}, "var-a": {"1"}, "var-c": {"3"}}
+			for i := 0; i < 10; i++ {
+				So(getVariablesValues(vars), ShouldEqual, "1, 2, 3")
+			}
+		})
+	})
+}
@@ -0,0 +1,49 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// WithDashboardVersion fetches the given historical version of the dashboard instead of the current one.
+// Only the dashboard structure is pinned: Grafana always renders panel images from the current version.
+func WithDashboardVersion(version int) ClientOption {
+	return func(g *client) {
+		g.dashVersion = version
+	}
+}
+
+// versionedDashboardJSON converts a response of Grafana's dashboard versions API into the
+// format of the dashboard API, understood by NewDashboard
+func versionedDashboardJSON(versionJSON []byte) ([]byte, error) {
+	var v struct {
+		Version int
+		Data    json.RawMessage
+	}
+	err := json.Unmarshal(versionJSON, &v)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.Data) == 0 {
+		return nil, fmt.Errorf("version %d has no dashboard data", v.Version)
+	}
+	return json.Marshal(struct {
+		Dashboard json.RawMessage `json:"dashboard"`
+	}{v.Data})
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDashboardVersion(t *testing.T) {
+	Convey("When fetching a pinned dashboard version", t, func() {
+		requestPath := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestPath = r.URL.Path
+			fmt.Fprint(w, `{"id":7,"dashboardId":1,"version":3,"data":{"title":"Old title","version":3,"panels":[{"type":"graph","id":1}]}}`)
+		}))
+		defer ts.Close()
+
+		Convey("The v5 client should use the dashboard versions API", func() {
+			dash, err := NewV5Client(ts.URL, "", url.Values{}, WithDashboardVersion(3)).GetDashboard("rYy7Paekz")
+			So(err, ShouldBeNil)
+			So(requestPath, ShouldEqual, "/api/dashboards/uid/rYy7Paekz/versions/3")
+			So(dash.Title, ShouldEqual, "Old title")
+			So(dash.Version, ShouldEqual, 3)
+			So(dash.Panels, ShouldHaveLength, 1)
+		})
+
+		Convey("The v4 client should not support versions", func() {
+			_, err := NewV4Client(ts.URL, "", url.Values{}, WithDashboardVersion(3)).GetDashboard("testDash")
+			So(err, ShouldNotBeNil)
+			So(requestPath, ShouldEqual, "")
+		})
+	})
+
+	Convey("When converting a dashboard version response", t, func() {
+		Convey("A response without dashboard data should return an error", func() {
+			_, err := versionedDashboardJSON([]byte(`{"version":3}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
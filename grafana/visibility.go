@@ -0,0 +1,112 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"log"
+	"strings"
+)
+
+// SkipUnderscorePanels leaves out panels whose title starts with an underscore, the convention for
+// internal panels, e.g. for debugging, that should not appear in reports
+var SkipUnderscorePanels = false
+
+// DefaultSkipPanelTypes are the panel types left out of reports by default: panels showing Grafana itself rather than data,
+// e.g. lists of dashboards, annotations or alerts, news and the welcome panels, whose screenshots only pad reports
+var DefaultSkipPanelTypes = []string{"dashlist", "news", "annolist", "alertlist", "welcome", "gettingstarted", "pluginlist"}
+
+// SkipPanelTypes are the panel types left out of all reports, unless a client is created WithSkipPanelTypes
+var SkipPanelTypes = DefaultSkipPanelTypes
+
+// WithSkipPanelTypes leaves out panels of these types instead of SkipPanelTypes. An empty list keeps panels of all types
+func WithSkipPanelTypes(types []string) ClientOption {
+	return func(g *client) {
+		g.skipPanelTypes = panelTypeSet(types)
+	}
+}
+
+func panelTypeSet(types []string) map[string]bool {
+	set := map[string]bool{}
+	for _, t := range types {
+		if key := panelTypeKey(t); key != "" {
+			set[key] = true
+		}
+	}
+	return set
+}
+
+// panelTypeKey normalizes a panel type id, so that the spellings of older Grafana versions and plugins,
+// e.g. alert-list or Dashlist, match the current ids
+func panelTypeKey(t string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(t))
+}
+
+// WithCollapsedRows includes the panels of collapsed rows, which are left out of reports by default
+func WithCollapsedRows(include bool) ClientOption {
+	return func(g *client) {
+		g.includeCollapsed = include
+	}
+}
+
+// skipReason returns why the panel is left out of the report, or "" to keep it.
+// instances are the ids of repeat prototypes that Grafana generated repeated panels for.
+func skipReason(p Panel, instances map[int]bool, skipTypes map[string]bool) string {
+	if skipTypes[panelTypeKey(p.Type)] {
+		return "panel type " + p.Type + " is skipped"
+	}
+	if p.Repeat != "" && instances[p.Id] {
+		return "repeat prototype with generated instances"
+	}
+	if SkipUnderscorePanels && strings.HasPrefix(p.Title, "_") {
+		return "title starts with an underscore"
+	}
+	return ""
+}
+
+// repeatInstances collects the ids of the repeat prototypes referenced by the panels
+func repeatInstances(panels []Panel) map[int]bool {
+	ids := map[int]bool{}
+	for _, p := range panels {
+		if p.RepeatPanelId != 0 {
+			ids[p.RepeatPanelId] = true
+		}
+		for _, nested := range p.Panels {
+			if nested.RepeatPanelId != 0 {
+				ids[nested.RepeatPanelId] = true
+			}
+		}
+	}
+	return ids
+}
+
+func logSkippedPanel(p Panel, reason string) {
+	log.Printf("Skipping panel %v %q: %v", p.Id, p.Title, reason)
+}
+
+// SkippedPanel is a panel left out of the report for its type, for noting it in the report. Strings are escaped for TeX
+type SkippedPanel struct {
+	Title string
+	Type  string
+}
+
+// skipPanel logs why the panel is left out, noting it in the dashboard's SkippedPanels when its type is skipped
+func (dash *Dashboard) skipPanel(p Panel, reason string, skipTypes map[string]bool) {
+	logSkippedPanel(p, reason)
+	if skipTypes[panelTypeKey(p.Type)] {
+		dash.SkippedPanels = append(dash.SkippedPanels, SkippedPanel{texTitle(InterpolateVariables(p.Title, dash.Variables)), SanitizeLaTexInput(p.Type)})
+	}
+}
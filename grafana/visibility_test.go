@@ -0,0 +1,183 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const visibilityV5DashJSON = `
+{"dashboard":{"title":"Visibility","panels":[
+	{"type":"graph","id":1,"title":"CPU on $host","repeat":"host"},
+	{"type":"graph","id":2,"title":"CPU on $host","repeatPanelId":1},
+	{"type":"graph","id":3,"title":"Memory on $host","repeat":"host"},
+	{"type":"graph","id":4,"title":"_debug queries"},
+	{"type":"row","id":5,"title":"Expanded row","collapsed":false,"panels":[]},
+	{"type":"graph","id":6,"title":"Disk"},
+	{"type":"row","id":7,"title":"Collapsed row","collapsed":true,"panels":[
+		{"type":"graph","id":8,"title":"Network"},
+		{"type":"graph","id":9,"title":"_internal network"}]}]}}`
+
+const visibilityV4DashJSON = `
+{"dashboard":{"title":"Visibility","rows":[
+	{"title":"Open","panels":[{"type":"graph","id":1,"title":"CPU"},{"type":"graph","id":2,"title":"_debug"}]},
+	{"title":"Closed","collapse":true,"panels":[{"type":"graph","id":3,"title":"Network"}]}]}}`
+
+func panelIds(dash Dashboard) []int {
+	var ids []int
+	for _, p := range dash.Panels {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+func TestPanelVisibility(t *testing.T) {
+	Convey("When fetching a dashboard with hidden panels", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/dashboards/uid/vis":
+				fmt.Fprint(w, visibilityV5DashJSON)
+			case "/api/dashboards/db/vis":
+				fmt.Fprint(w, visibilityV4DashJSON)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer ts.Close()
+
+		Convey("Repeat prototypes with generated instances and collapsed rows should be skipped", func() {
+			dash, err := NewV5Client(ts.URL, "", url.Values{}).GetDashboard("vis")
+			So(err, ShouldBeNil)
+			So(panelIds(dash), ShouldResemble, []int{2, 3, 4, 6})
+		})
+
+		Convey("Collapsed rows should be included when requested", func() {
+			dash, err := NewV5Client(ts.URL, "", url.Values{}, WithCollapsedRows(true)).GetDashboard("vis")
+			So(err, ShouldBeNil)
+			So(panelIds(dash), ShouldResemble, []int{2, 3, 4, 6, 8, 9})
+		})
+
+		Convey("Panels with an underscore title should be skipped when configured", func() {
+			SkipUnderscorePanels = true
+			defer func() { SkipUnderscorePanels = false }()
+			dash, err := NewV5Client(ts.URL, "", url.Values{}, WithCollapsedRows(true)).GetDashboard("vis")
+			So(err, ShouldBeNil)
+			So(panelIds(dash), ShouldResemble, []int{2, 3, 6, 8})
+		})
+
+		Convey("Collapsed v4 rows should be skipped", func() {
+			dash, err := NewV4Client(ts.URL, "", url.Values{}).GetDashboard("vis")
+			So(err, ShouldBeNil)
+			So(panelIds(dash), ShouldResemble, []int{1, 2})
+			So(dash.Rows, ShouldHaveLength, 1)
+
+			Convey("Unless requested", func() {
+				dash, err := NewV4Client(ts.URL, "", url.Values{}, WithCollapsedRows(true)).GetDashboard("vis")
+				So(err, ShouldBeNil)
+				So(panelIds(dash), ShouldResemble, []int{1, 2, 3})
+			})
+		})
+
+		Convey("Underscore panels should be removed from v4 rows", func() {
+			SkipUnderscorePanels = true
+			defer func() { SkipUnderscorePanels = false }()
+			dash, err := NewV4Client(ts.URL, "", url.Values{}).GetDashboard("vis")
+			So(err, ShouldBeNil)
+			So(panelIds(dash), ShouldResemble, []int{1})
+			So(dash.Rows[0].Panels, ShouldHaveLength, 1)
+		})
+	})
+}
+
+const metaPanelsV5DashJSON = `
+{"dashboard":{"title":"Home","panels":[
+	{"type":"dashlist","id":1,"title":"Starred dashboards"},
+	{"type":"graph","id":2,"title":"CPU"},
+	{"type":"news","id":3,"title":"Latest from the blog"},
+	{"type":"annolist","id":4,"title":"Deployments on $host"},
+	{"type":"alertlist","id":5,"title":"Firing alerts"},
+	{"type":"welcome","id":6},
+	{"type":"gettingstarted","id":7},
+	{"type":"pluginlist","id":8,"title":"Plugins"},
+	{"type":"Dash-List","id":9,"title":"Legacy list"},
+	{"type":"row","id":10,"title":"Collapsed row","collapsed":true,"panels":[
+		{"type":"dashlist","id":11,"title":"Recent dashboards"}]}]}}`
+
+const metaPanelsV4DashJSON = `
+{"dashboard":{"title":"Home","rows":[
+	{"title":"Start","panels":[{"type":"dashlist","id":1,"title":"Starred"},{"type":"graph","id":2,"title":"CPU"},{"type":"alert_list","id":3,"title":"Alerts"}]}]}}`
+
+func TestMetaPanelTypes(t *testing.T) {
+	Convey("When a dashboard has panels showing Grafana itself rather than data", t, func() {
+		vars := url.Values{"var-host": {"db1"}}
+
+		Convey("Each default meta panel type should be skipped, also in legacy spellings", func() {
+			dash := NewDashboard([]byte(metaPanelsV5DashJSON), vars)
+			So(panelIds(dash), ShouldResemble, []int{2})
+		})
+
+		Convey("Meta panels of v4 rows should be skipped", func() {
+			dash := NewDashboard([]byte(metaPanelsV4DashJSON), vars)
+			So(panelIds(dash), ShouldResemble, []int{2})
+			So(dash.Rows[0].Panels, ShouldHaveLength, 1)
+		})
+
+		Convey("Skipped panels should be noted with their interpolated title and type", func() {
+			dash := NewDashboard([]byte(metaPanelsV5DashJSON), vars)
+			So(dash.SkippedPanels, ShouldHaveLength, 8)
+			So(dash.SkippedPanels[0], ShouldResemble, SkippedPanel{"Starred dashboards", "dashlist"})
+			So(dash.SkippedPanels[2], ShouldResemble, SkippedPanel{"Deployments on db1", "annolist"})
+			So(dash.SkippedPanels[7], ShouldResemble, SkippedPanel{"Legacy list", "Dash-List"})
+		})
+
+		Convey("Meta panels of included collapsed rows should be skipped and noted too", func() {
+			dash := newDashboard([]byte(metaPanelsV5DashJSON), vars, true, panelTypeSet(DefaultSkipPanelTypes))
+			So(panelIds(dash), ShouldResemble, []int{2})
+			So(dash.SkippedPanels, ShouldHaveLength, 9)
+		})
+
+		Convey("The skipped types should be configurable", func() {
+			defer func(orig []string) { SkipPanelTypes = orig }(SkipPanelTypes)
+			SkipPanelTypes = []string{"news", "graph"}
+			dash := NewDashboard([]byte(metaPanelsV5DashJSON), vars)
+			So(panelIds(dash), ShouldResemble, []int{1, 4, 5, 6, 7, 8, 9})
+		})
+
+		Convey("Clients should override the configured types", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, metaPanelsV5DashJSON)
+			}))
+			defer ts.Close()
+			dash, err := NewV5Client(ts.URL, "", vars, WithSkipPanelTypes([]string{"alertlist"})).GetDashboard("home")
+			So(err, ShouldBeNil)
+			So(panelIds(dash), ShouldResemble, []int{1, 2, 3, 4, 6, 7, 8, 9})
+
+			Convey("An empty list should keep panels of all types", func() {
+				dash, err := NewV5Client(ts.URL, "", vars, WithSkipPanelTypes(nil)).GetDashboard("home")
+				So(err, ShouldBeNil)
+				So(panelIds(dash), ShouldResemble, []int{1, 2, 3, 4, 5, 6, 7, 8, 9})
+				So(dash.SkippedPanels, ShouldBeEmpty)
+			})
+		})
+	})
+}
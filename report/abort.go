@@ -0,0 +1,53 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"fmt"
+)
+
+// Phases of generating a report, named by an AbortedError
+const (
+	PhaseDashboard = "dashboard"
+	PhaseRendering = "panel rendering"
+	PhaseTemplate  = "template"
+	PhaseLaTeX     = "LaTeX"
+)
+
+// AbortedError is returned when the Context of the report's Options ends while it is generated,
+// e.g. because the client disconnected or a deadline passed. Phase is the phase that was running.
+type AbortedError struct {
+	Phase string
+	Err   error
+}
+
+func (e *AbortedError) Error() string {
+	return fmt.Sprintf("report generation aborted during %v: %v", e.Phase, e.Err)
+}
+
+func (e *AbortedError) Unwrap() error {
+	return e.Err
+}
+
+// abortedIn returns err as an AbortedError in phase when ctx ended, else unchanged
+func abortedIn(ctx context.Context, phase string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return &AbortedError{phase, err}
+}
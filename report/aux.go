@@ -0,0 +1,77 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MaxAuxBytes limits the total size of the aux files written by the draft LaTeX pass. A template
+// writing to them in a loop would otherwise grow them without bound. 0 disables the check
+var MaxAuxBytes int64 = 5 << 20
+
+// auxExtensions are the files pdflatex writes next to the pdf to carry state from one pass to the next
+var auxExtensions = []string{".aux", ".out", ".toc", ".lof", ".lot", ".log"}
+
+// draftOnlyExtensions are written by the draft pass, but not read by the final pass
+var draftOnlyExtensions = []string{".log"}
+
+// removeAuxFiles deletes the job's files with the given extensions from dir, ignoring missing files
+func removeAuxFiles(dir string, job string, extensions []string) error {
+	for _, ext := range extensions {
+		err := os.Remove(filepath.Join(dir, job+ext))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing LaTeX aux file: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkAuxFiles checks the size of the job's aux files in dir against MaxAuxBytes
+func checkAuxFiles(dir string, job string) error {
+	if MaxAuxBytes <= 0 {
+		return nil
+	}
+	var total int64
+	for _, ext := range auxExtensions {
+		info, err := os.Stat(filepath.Join(dir, job+ext))
+		if err == nil {
+			total += info.Size()
+		}
+	}
+	if total > MaxAuxBytes {
+		return &TeXLimitError{"LaTeX aux file size", total, MaxAuxBytes}
+	}
+	return nil
+}
+
+// dirSize returns the total size of the files below dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,122 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stubPdfLaTeX replaces pdflatex with pass, called with the arguments of each pass
+func stubPdfLaTeX(pass func(dir string, args []string) error) (restore func()) {
+	orig := runPdfLaTeX
+	runPdfLaTeX = func(ctx context.Context, dir string, args ...string) ([]byte, error) {
+		return nil, pass(dir, args)
+	}
+	return func() { runPdfLaTeX = orig }
+}
+
+func isDraftPass(args []string) bool {
+	for _, a := range args {
+		if a == "-draftmode" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompileTeXAuxFiles(t *testing.T) {
+	Convey("When compiling a TeX file", t, func() {
+		dir, err := ioutil.TempDir("", "aux")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		auxPath := filepath.Join(dir, "report.aux")
+
+		Convey("Stale aux files of the job should be removed before the first pass", func() {
+			So(ioutil.WriteFile(auxPath, []byte("stale"), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "report.toc"), []byte("stale"), 0644), ShouldBeNil)
+			var seen []string
+			defer stubPdfLaTeX(func(dir string, args []string) error {
+				if isDraftPass(args) {
+					_, err := os.Stat(auxPath)
+					seen = append(seen, "aux exists: "+strconv.FormatBool(err == nil))
+					return ioutil.WriteFile(auxPath, []byte("fresh"), 0644)
+				}
+				b, err := ioutil.ReadFile(auxPath)
+				seen = append(seen, "final reads: "+string(b))
+				return err
+			})()
+
+			_, err := compileTeX(context.Background(), dir, "report.tex")
+			So(err, ShouldBeNil)
+			So(seen, ShouldResemble, []string{"aux exists: false", "final reads: fresh"})
+		})
+
+		Convey("The draft log should be removed before the final pass", func() {
+			logPath := filepath.Join(dir, "report.log")
+			defer stubPdfLaTeX(func(dir string, args []string) error {
+				if isDraftPass(args) {
+					return ioutil.WriteFile(logPath, []byte("draft log"), 0644)
+				}
+				if _, err := os.Stat(logPath); err == nil {
+					return errors.New("draft log still present")
+				}
+				return nil
+			})()
+
+			_, err := compileTeX(context.Background(), dir, "report.tex")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Aux files larger than the limit should return a TeXLimitError", func() {
+			defer func(orig int64) { MaxAuxBytes = orig }(MaxAuxBytes)
+			MaxAuxBytes = 100
+			finalRun := false
+			defer stubPdfLaTeX(func(dir string, args []string) error {
+				if isDraftPass(args) {
+					return ioutil.WriteFile(auxPath, []byte(strings.Repeat("x", 101)), 0644)
+				}
+				finalRun = true
+				return nil
+			})()
+
+			_, err := compileTeX(context.Background(), dir, "report.tex")
+			So(err, ShouldHaveSameTypeAs, &TeXLimitError{})
+			So(err.Error(), ShouldContainSubstring, "aux file size")
+			So(finalRun, ShouldBeFalse)
+		})
+
+		Convey("A limit of 0 should disable the aux size check", func() {
+			defer func(orig int64) { MaxAuxBytes = orig }(MaxAuxBytes)
+			MaxAuxBytes = 0
+			defer stubPdfLaTeX(func(dir string, args []string) error {
+				return ioutil.WriteFile(auxPath, []byte(strings.Repeat("x", 101)), 0644)
+			})()
+
+			_, err := compileTeX(context.Background(), dir, "report.tex")
+			So(err, ShouldBeNil)
+		})
+	})
+}
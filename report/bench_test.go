@@ -0,0 +1,102 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// Run the benchmarks with make bench, or e.g. go test -run '^$' -bench . ./report/...
+
+// panelsDashboardJSON generates a dashboard with the given number of graph panels in rows of four
+func panelsDashboardJSON(panels int) []byte {
+	list := make([]string, panels)
+	for i := range list {
+		list[i] = fmt.Sprintf(`{"type":"graph","id":%d,"title":"Panel %d","gridPos":{"h":8,"w":6,"x":%d,"y":%d}}`, i+1, i+1, i%4*6, i/4*8)
+	}
+	return []byte(`{"dashboard":{"title":"Benchmark","panels":[` + strings.Join(list, ",") + `]}}`)
+}
+
+// latencyClient serves a dashboard of panels, each taking delay to render
+type latencyClient struct {
+	mockGrafanaClient
+	panels int
+	delay  time.Duration
+}
+
+func (c *latencyClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
+	return grafana.NewDashboard(panelsDashboardJSON(c.panels), nil), nil
+}
+
+func (c *latencyClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	time.Sleep(c.delay)
+	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
+}
+
+// BenchmarkRenderPanels measures the worker pool fetching 20 panel images at several Grafana latencies
+func BenchmarkRenderPanels(b *testing.B) {
+	for _, delay := range []time.Duration{0, time.Millisecond, 10 * time.Millisecond} {
+		b.Run(delay.String(), func(b *testing.B) {
+			g := &latencyClient{panels: 20, delay: delay}
+			dash, _ := g.GetDashboard("")
+			for i := 0; i < b.N; i++ {
+				rep := new(g, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+				if err := rep.renderPNGsParallel(dash); err != nil {
+					b.Fatal(err)
+				}
+				rep.Clean()
+			}
+		})
+	}
+}
+
+// BenchmarkGenerateTeX measures executing each built-in style for a dashboard of 200 panels
+func BenchmarkGenerateTeX(b *testing.B) {
+	g := &latencyClient{panels: 200}
+	dash, _ := g.GetDashboard("")
+	for _, style := range Styles() {
+		b.Run(style, func(b *testing.B) {
+			b.ReportAllocs()
+			rep := new(g, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Style: style})
+			defer rep.Clean()
+			for i := 0; i < b.N; i++ {
+				if err := rep.generateTeXFile(dash, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTrimImage measures cropping the borders of a panel image of the default render size
+func BenchmarkTrimImage(b *testing.B) {
+	img := borderedImage(1000, 500, image.Rect(100, 50, 900, 450))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trimImage(img)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import "context"
+
+// MaxConcurrentDashboards is the number of dashboards of a combined report whose panels are rendered at once.
+// Each dashboard renders with the report's workers, so SetMaxConcurrentRenders should bound the total.
+var MaxConcurrentDashboards = 2
+
+// renderBudget is a semaphore bounding the panels rendered at once by all reports of the process together.
+// Each panel render holds one slot. A budget without slots has no bound.
+type renderBudget struct {
+	slots chan struct{}
+}
+
+// acquire waits for a slot. It gives up with ctx's error when ctx is done first, e.g. when the client disconnected.
+func (b *renderBudget) acquire(ctx context.Context) error {
+	if b.slots == nil {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *renderBudget) release() {
+	if b.slots != nil {
+		<-b.slots
+	}
+}
+
+// renders is shared by all reports, see SetMaxConcurrentRenders
+var renders = &renderBudget{}
+
+// SetMaxConcurrentRenders bounds the panels rendered at once by all reports together, however many reports
+// and dashboards are generated concurrently. 0 removes the bound. Call it before generating reports.
+func SetMaxConcurrentRenders(n int) {
+	if n <= 0 {
+		renders = &renderBudget{}
+		return
+	}
+	renders = &renderBudget{make(chan struct{}, n)}
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// loadClient serves several dashboards, recording the largest number of panels rendered at once across all reports using it
+type loadClient struct {
+	*multiDashClient
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (c *loadClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.peak {
+		c.peak = c.inFlight
+	}
+	c.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return c.multiDashClient.GetPanelPng(p, dashName, t)
+}
+
+func TestRenderBudget(t *testing.T) {
+	Convey("When rendering the dashboards of combined reports", t, func() {
+		dashboards := map[string]string{}
+		var names []string
+		for i := 0; i < 4; i++ {
+			name := fmt.Sprintf("uid%d", i)
+			names = append(names, name)
+			dashboards[name] = `{"Dashboard":{"Title":"dash","Panels":[{"Type":"graph","Id":1},{"Type":"graph","Id":2},{"Type":"graph","Id":3},{"Type":"graph","Id":4}]}}`
+		}
+		gClient := &loadClient{multiDashClient: &multiDashClient{dashboards: dashboards}}
+		defer func(orig int) { MaxConcurrentDashboards = orig }(MaxConcurrentDashboards)
+		defer SetMaxConcurrentRenders(0)
+		//render generates the panels of the given number of combined reports concurrently
+		render := func(reports int, opts Options) {
+			errs := make([]error, reports)
+			var wg sync.WaitGroup
+			for r := 0; r < reports; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+					rep := newMulti(gClient, "combined", names, nil, grafana.TimeRange{From: "now-1h", To: "now"}, "", opts)
+					defer rep.Clean()
+					sections, _, err := rep.fetchDashboards()
+					if err == nil {
+						err = rep.renderSections(sections)
+					}
+					errs[r] = err
+				}(r)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				So(err, ShouldBeNil)
+			}
+		}
+
+		Convey("Dashboards should be rendered concurrently", func() {
+			MaxConcurrentDashboards = 4
+			render(1, Options{Workers: 1})
+			So(gClient.peak, ShouldBeGreaterThan, 1)
+			So(gClient.peak, ShouldBeLessThanOrEqualTo, 4)
+			So(gClient.renderedDash, ShouldHaveLength, 16)
+		})
+
+		Convey("One dashboard at a time should keep to the report's workers", func() {
+			MaxConcurrentDashboards = 1
+			render(1, Options{Workers: 2})
+			So(gClient.peak, ShouldBeLessThanOrEqualTo, 2)
+		})
+
+		Convey("The global cap should hold across dashboards and reports", func() {
+			MaxConcurrentDashboards = 4
+			SetMaxConcurrentRenders(3)
+			render(3, Options{Workers: 5})
+			So(gClient.peak, ShouldEqual, 3)
+			So(gClient.renderedDash, ShouldHaveLength, 48)
+		})
+	})
+
+	Convey("When the render budget is used up", t, func() {
+		budget := &renderBudget{make(chan struct{}, 1)}
+		So(budget.acquire(context.Background()), ShouldBeNil)
+
+		Convey("A canceled report should stop waiting for a slot", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- budget.acquire(ctx) }()
+			cancel()
+			select {
+			case err := <-done:
+				So(err, ShouldEqual, context.Canceled)
+			case <-time.After(time.Second):
+				t.Fatal("acquire did not return after the context was canceled")
+			}
+			So(budget.slots, ShouldHaveLength, 1)
+		})
+
+		Convey("A released slot should be acquired", func() {
+			budget.release()
+			So(budget.acquire(context.Background()), ShouldBeNil)
+		})
+	})
+}
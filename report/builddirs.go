@@ -0,0 +1,67 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// buildDirSet holds the build directories of the reports that were not cleaned yet
+type buildDirSet struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+var buildDirs = &buildDirSet{dirs: map[string]bool{}}
+
+func (s *buildDirSet) add(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirs[dir] = true
+}
+
+func (s *buildDirSet) remove(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dirs, dir)
+}
+
+// ActiveBuildDirs returns the number of reports whose build directory was not cleaned yet
+func ActiveBuildDirs() int {
+	buildDirs.mu.Lock()
+	defer buildDirs.mu.Unlock()
+	return len(buildDirs.dirs)
+}
+
+// RemoveBuildDirs removes the build directories of all reports that were not cleaned yet, e.g. when the reporter
+// shuts down, and returns how many it removed. Reports still being generated or delivered fail.
+func RemoveBuildDirs() int {
+	buildDirs.mu.Lock()
+	defer buildDirs.mu.Unlock()
+	removed := 0
+	for dir := range buildDirs.dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Println("Error cleaning up tmp dir:", err)
+			continue
+		}
+		delete(buildDirs.dirs, dir)
+		removed++
+	}
+	return removed
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBuildDirs(t *testing.T) {
+	Convey("When reports are generated", t, func() {
+		defer func(orig string) { TmpRoot = orig }(TmpRoot)
+		TmpRoot = filepath.Join(t.TempDir(), "tmp")
+		defer stubCompiler(1)()
+		generate := func() Report {
+			rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			pdf.Close()
+			return rep
+		}
+		before := ActiveBuildDirs()
+
+		Convey("Their build directories should be tracked until they are cleaned", func() {
+			rep := generate()
+			So(ActiveBuildDirs(), ShouldEqual, before+1)
+			rep.Clean()
+			So(ActiveBuildDirs(), ShouldEqual, before)
+		})
+
+		Convey("Build directories left behind should be removed on shutdown", func() {
+			generate()
+			generate()
+			So(RemoveBuildDirs(), ShouldEqual, before+2)
+			So(ActiveBuildDirs(), ShouldEqual, 0)
+			left, err := os.ReadDir(TmpRoot)
+			So(err, ShouldBeNil)
+			So(left, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,64 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// DefaultContactSheetColumns is the number of thumbnails in a row of the contact sheet when Options.ContactSheetColumns is not set
+const DefaultContactSheetColumns = 4
+
+// maxContactSheetColumns keeps the thumbnails and their titles legible
+const maxContactSheetColumns = 8
+
+// thumbnailWidth is the width in pixels the panel images are scaled down to for the contact sheet
+const thumbnailWidth = 300
+
+// thumbnailName returns the name of a panel's thumbnail in the images directory, without extension like the panel images in the templates
+func thumbnailName(p grafana.Panel) string {
+	return fmt.Sprintf("thumb%d", p.Id)
+}
+
+func (rep *report) thumbnailPath(p grafana.Panel) string {
+	return filepath.Join(rep.imgDirPath(), thumbnailName(p)+".png")
+}
+
+// setThumbnails sets the ThumbnailPath of the panels whose thumbnail was created while rendering
+func (rep *report) setThumbnails(dash *grafana.Dashboard) {
+	for i, p := range dash.Panels {
+		if _, err := os.Stat(rep.thumbnailPath(p)); err == nil {
+			dash.Panels[i].ThumbnailPath = thumbnailName(p)
+		}
+	}
+}
+
+// contactSheetColumns returns the number of thumbnails in a row of the contact sheet, between 1 and maxContactSheetColumns
+func (rep *report) contactSheetColumns() int {
+	columns := rep.options.ContactSheetColumns
+	if columns <= 0 {
+		return DefaultContactSheetColumns
+	}
+	if columns > maxContactSheetColumns {
+		return maxContactSheetColumns
+	}
+	return columns
+}
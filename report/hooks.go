@@ -0,0 +1,50 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// Hooks are called where reports do their work, e.g. to record metrics. Nil hooks are skipped.
+type Hooks struct {
+	// PanelRendered is called after each panel image was fetched and stored, with the time it took and its error
+	PanelRendered func(dashName string, p grafana.Panel, d time.Duration, err error)
+	// LaTeXCompiled is called after each LaTeX compile of a report, section or volume, with the time both passes took
+	LaTeXCompiled func(d time.Duration, err error)
+}
+
+var hooks Hooks
+
+// SetHooks sets the hooks of all reports. Call it before generating reports.
+func SetHooks(h Hooks) {
+	hooks = h
+}
+
+func (h Hooks) panelRendered(dashName string, p grafana.Panel, d time.Duration, err error) {
+	if h.PanelRendered != nil {
+		h.PanelRendered(dashName, p, d, err)
+	}
+}
+
+func (h Hooks) latexCompiled(d time.Duration, err error) {
+	if h.LaTeXCompiled != nil {
+		h.LaTeXCompiled(d, err)
+	}
+}
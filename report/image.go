@@ -0,0 +1,193 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+)
+
+// imageExtension returns the file extension for a rendered panel image, based on its first bytes
+func imageExtension(head []byte) string {
+	if http.DetectContentType(head) == "image/jpeg" {
+		return "jpg"
+	}
+	return "png"
+}
+
+// trimImageFile crops the uniform-colour border from the PNG or JPEG image at path and
+// overwrites the file with the result, in the same format. Files without a border are left untouched.
+func trimImageFile(path string) error {
+	img, format, err := decodeImageFile(path)
+	if err != nil {
+		return err
+	}
+
+	trimmed := trimImage(img)
+	if trimmed.Bounds() == img.Bounds() {
+		return nil
+	}
+	return encodeImageFile(path, trimmed, format)
+}
+
+// thumbnailImageFile writes a copy of the PNG or JPEG image at path, scaled down to width, as a PNG to thumbPath.
+// Images no wider than width are copied at their size.
+func thumbnailImageFile(path string, thumbPath string, width int) error {
+	img, _, err := decodeImageFile(path)
+	if err != nil {
+		return err
+	}
+	return encodeImageFile(thumbPath, scaleImage(img, width), "png")
+}
+
+// decodeImageFile reads the PNG or JPEG image at path, returning its format as image.Decode does
+func decodeImageFile(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening image %v: %v", path, err)
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding image %v: %v", path, err)
+	}
+	return img, format, nil
+}
+
+// encodeImageFile writes img to path as a JPEG for the "jpeg" format and as a PNG otherwise
+func encodeImageFile(path string, img image.Image, format string) error {
+	out, err := createTmpFile(path)
+	if err != nil {
+		return fmt.Errorf("error creating image %v: %v", path, err)
+	}
+	defer out.Close()
+	if format == "jpeg" {
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(out, img)
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding image %v: %v", path, err)
+	}
+	return nil
+}
+
+// scaleImage returns img scaled down to width, keeping its aspect ratio. Each pixel is the average of the
+// pixels it covers, so thin lines of charts fade rather than disappear. Images no wider than width are returned unchanged.
+func scaleImage(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width || width <= 0 || b.Empty() {
+		return img
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		top, bottom := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			left, right := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			var r, g, bl, a, n uint64
+			for sy := top; sy < bottom; sy++ {
+				for sx := left; sx < right; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// trimImage returns the smallest sub image that contains every pixel differing
+// from the border colour. The border colour is taken from the top left pixel.
+// A completely uniform image is returned unchanged.
+func trimImage(img image.Image) image.Image {
+	b := img.Bounds()
+	if b.Empty() {
+		return img
+	}
+	bg := img.At(b.Min.X, b.Min.Y)
+
+	rowIsBorder := func(y int) bool {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !sameColor(img.At(x, y), bg) {
+				return false
+			}
+		}
+		return true
+	}
+	colIsBorder := func(x, top, bottom int) bool {
+		for y := top; y < bottom; y++ {
+			if !sameColor(img.At(x, y), bg) {
+				return false
+			}
+		}
+		return true
+	}
+
+	top := b.Min.Y
+	for top < b.Max.Y && rowIsBorder(top) {
+		top++
+	}
+	if top == b.Max.Y {
+		return img
+	}
+	bottom := b.Max.Y
+	for bottom > top && rowIsBorder(bottom-1) {
+		bottom--
+	}
+	left := b.Min.X
+	for left < b.Max.X && colIsBorder(left, top, bottom) {
+		left++
+	}
+	right := b.Max.X
+	for right > left && colIsBorder(right-1, top, bottom) {
+		right--
+	}
+
+	crop := image.Rect(left, top, right, bottom)
+	if crop == b {
+		return img
+	}
+	return subImage(img, crop)
+}
+
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+func sameColor(a, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
@@ -0,0 +1,257 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// borderedImage creates a w x h white image with a red rectangle at content
+func borderedImage(w, h int, content image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	for x := content.Min.X; x < content.Max.X; x++ {
+		for y := content.Min.Y; y < content.Max.Y; y++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	return img
+}
+
+func TestImageExtension(t *testing.T) {
+	Convey("When choosing the extension of a rendered image", t, func() {
+		Convey("JPEG content should get a jpg extension", func() {
+			var buf bytes.Buffer
+			jpeg.Encode(&buf, borderedImage(4, 4, image.Rectangle{}), nil)
+			So(imageExtension(buf.Bytes()), ShouldEqual, "jpg")
+		})
+
+		Convey("PNG content should get a png extension", func() {
+			var buf bytes.Buffer
+			png.Encode(&buf, borderedImage(4, 4, image.Rectangle{}))
+			So(imageExtension(buf.Bytes()), ShouldEqual, "png")
+		})
+
+		Convey("Unknown content should default to png", func() {
+			So(imageExtension([]byte("Not actually a png")), ShouldEqual, "png")
+		})
+	})
+}
+
+func TestTrimImage(t *testing.T) {
+	Convey("When trimming an image", t, func() {
+		Convey("A uniform border on all sides should be removed", func() {
+			content := image.Rect(10, 20, 60, 50)
+			trimmed := trimImage(borderedImage(100, 80, content))
+			So(trimmed.Bounds(), ShouldResemble, content)
+		})
+
+		Convey("A border on only one side should be removed", func() {
+			content := image.Rect(0, 30, 100, 80)
+			trimmed := trimImage(borderedImage(100, 80, content))
+			So(trimmed.Bounds(), ShouldResemble, content)
+		})
+
+		Convey("A single content pixel should be kept", func() {
+			content := image.Rect(42, 17, 43, 18)
+			trimmed := trimImage(borderedImage(100, 80, content))
+			So(trimmed.Bounds(), ShouldResemble, content)
+		})
+
+		Convey("An image without a border should be unchanged", func() {
+			img := borderedImage(100, 80, image.Rect(0, 0, 100, 80))
+			So(trimImage(img).Bounds(), ShouldResemble, img.Bounds())
+		})
+
+		Convey("A completely uniform image should be unchanged", func() {
+			img := borderedImage(100, 80, image.Rectangle{})
+			So(trimImage(img).Bounds(), ShouldResemble, img.Bounds())
+		})
+	})
+}
+
+func TestTrimImageFile(t *testing.T) {
+	Convey("When trimming an image file", t, func() {
+		dir, err := ioutil.TempDir("", "trim")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "image1.png")
+
+		f, err := os.Create(path)
+		So(err, ShouldBeNil)
+		So(png.Encode(f, borderedImage(100, 80, image.Rect(10, 20, 60, 50))), ShouldBeNil)
+		f.Close()
+
+		So(trimImageFile(path), ShouldBeNil)
+
+		Convey("The file should be overwritten with the trimmed image", func() {
+			f, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			img, err := png.Decode(f)
+			So(err, ShouldBeNil)
+			So(img.Bounds().Dx(), ShouldEqual, 50)
+			So(img.Bounds().Dy(), ShouldEqual, 30)
+		})
+
+		Convey("A JPEG file should be trimmed and stay a JPEG", func() {
+			f, err := os.Create(path)
+			So(err, ShouldBeNil)
+			So(jpeg.Encode(f, borderedImage(100, 80, image.Rect(10, 20, 60, 50)), &jpeg.Options{Quality: 100}), ShouldBeNil)
+			f.Close()
+
+			So(trimImageFile(path), ShouldBeNil)
+			f, err = os.Open(path)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			_, format, err := image.Decode(f)
+			So(err, ShouldBeNil)
+			So(format, ShouldEqual, "jpeg")
+		})
+
+		Convey("A file that is not an image should return an error", func() {
+			ioutil.WriteFile(path, []byte("Not actually a png"), 0644)
+			So(trimImageFile(path), ShouldNotBeNil)
+		})
+	})
+}
+
+// jpegClient renders panel 22 as a JPEG and, like a renderer without JPEG support, all others as PNG
+type jpegClient struct {
+	mockGrafanaClient
+}
+
+func (m *jpegClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	if p.Id == 22 {
+		jpeg.Encode(&buf, borderedImage(10, 10, image.Rectangle{}), nil)
+	} else {
+		png.Encode(&buf, borderedImage(10, 10, image.Rectangle{}))
+	}
+	return ioutil.NopCloser(&buf), nil
+}
+
+func TestMixedImageFormats(t *testing.T) {
+	Convey("When the renderer returns both JPEG and PNG images", t, func() {
+		gClient := &jpegClient{}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Trim: true})
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard("")
+		So(rep.renderPNGsParallel(dashboard), ShouldBeNil)
+
+		Convey("Each image should be stored with the extension of its content", func() {
+			_, err := os.Stat(filepath.Join(rep.imgDirPath(), "image22.jpg"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(rep.imgDirPath(), "image1.png"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("The TeX file should include the images without extension", func() {
+			So(rep.generateTeXFile(dashboard, nil, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, "{image22}")
+			So(string(tex), ShouldNotContainSubstring, "image22.")
+		})
+	})
+}
+
+func TestScaleImage(t *testing.T) {
+	Convey("When scaling an image down", t, func() {
+		Convey("It should get the width and keep the aspect ratio", func() {
+			img := scaleImage(borderedImage(1000, 500, image.Rect(0, 0, 500, 500)), 300)
+			So(img.Bounds().Dx(), ShouldEqual, 300)
+			So(img.Bounds().Dy(), ShouldEqual, 150)
+			r, g, b, _ := img.At(10, 10).RGBA()
+			So([]uint32{r >> 8, g >> 8, b >> 8}, ShouldResemble, []uint32{255, 0, 0})
+			r, g, b, _ = img.At(290, 10).RGBA()
+			So([]uint32{r >> 8, g >> 8, b >> 8}, ShouldResemble, []uint32{255, 255, 255})
+		})
+
+		Convey("Pixels should average the pixels they cover", func() {
+			img := scaleImage(borderedImage(4, 2, image.Rect(0, 0, 1, 2)), 2)
+			_, g, _, _ := img.At(0, 0).RGBA()
+			So(g>>8, ShouldEqual, 127)
+		})
+
+		Convey("Narrower images should be unchanged", func() {
+			img := borderedImage(200, 100, image.Rectangle{})
+			So(scaleImage(img, 300), ShouldEqual, img)
+		})
+	})
+}
+
+func TestContactSheet(t *testing.T) {
+	Convey("When generating a report with a contact sheet", t, func() {
+		gClient := &jpegClient{}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{ContactSheet: true, ContactSheetColumns: 3})
+		defer rep.Clean()
+		dashboard, _ := gClient.GetDashboard("")
+		So(rep.renderPNGsParallel(dashboard), ShouldBeNil)
+		So(rep.generateTeXFile(dashboard, nil, nil), ShouldBeNil)
+		tex, err := ioutil.ReadFile(rep.texPath())
+		So(err, ShouldBeNil)
+
+		Convey("Each panel should get a PNG thumbnail, also of JPEG images", func() {
+			for _, p := range dashboard.Panels {
+				f, err := os.Open(filepath.Join(rep.imgDirPath(), fmt.Sprintf("thumb%d.png", p.Id)))
+				So(err, ShouldBeNil)
+				_, format, err := image.Decode(f)
+				f.Close()
+				So(err, ShouldBeNil)
+				So(format, ShouldEqual, "png")
+			}
+		})
+
+		Convey("The TeX file should show the thumbnails in a grid ahead of the panels", func() {
+			So(string(tex), ShouldContainSubstring, `\section*{Contact sheet}`)
+			So(string(tex), ShouldContainSubstring, "{thumb22}")
+			So(string(tex), ShouldContainSubstring, "{0.300\\textwidth}")
+			So(strings.Index(string(tex), "{thumb22}"), ShouldBeLessThan, strings.Index(string(tex), "{image22}"))
+		})
+
+		Convey("Without the option there should be neither thumbnails nor contact sheet", func() {
+			plain := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			defer plain.Clean()
+			So(plain.renderPNGsParallel(dashboard), ShouldBeNil)
+			So(plain.generateTeXFile(dashboard, nil, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(plain.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldNotContainSubstring, "Contact sheet")
+			_, err = os.Stat(filepath.Join(plain.imgDirPath(), "thumb22.png"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,236 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/IzakMarais/reporter/format"
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// TeX template helpers come in two tiers.
+//
+// The safe helpers of safeTemplateFuncs only format the report's data. They are available to every template,
+// including templates supplied with a request, and are the only helpers such templates may ever be given.
+//
+// The privileged helpers read files or insert TeX unescaped. They are only added for trusted templates, see (rep *report) trusted:
+//   - includeFile returns a file from the directory of the report's template, for shared preambles or logos
+//   - unsafeRawExtra returns an extra value unescaped
+//
+// All helpers fail once TemplateTimeout has passed, which together with deadlineWriter ends long-running templates.
+func safeTemplateFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"chunk": func(size int, items interface{}) ([]interface{}, error) {
+			if err := templateDeadline(ctx); err != nil {
+				return nil, err
+			}
+			return chunk(size, items)
+		},
+		"interpolate": interpolate,
+		"join":        strings.Join,
+	}
+}
+
+// TemplateTimeout bounds executing the TeX template of a report
+var TemplateTimeout = time.Minute
+
+// templateDeadline returns an error once the template execution bounded by ctx ran too long
+func templateDeadline(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("template execution exceeded the %v timeout: %w", TemplateTimeout, ctx.Err())
+	}
+	return nil
+}
+
+// deadlineWriter fails writes of the template output once its execution ran too long, ending ranges over large data
+type deadlineWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	if err := templateDeadline(d.ctx); err != nil {
+		return 0, err
+	}
+	return d.w.Write(p)
+}
+
+// ValidateTemplate parses a TeX template with the helpers it would get in a report with opts, without executing it
+func ValidateTemplate(texTemplate string, opts Options) error {
+	rep := new(nil, "", grafana.TimeRange{}, texTemplate, opts)
+	_, err := template.New("report").Delims("[[", "]]").Funcs(rep.templateFuncs(context.Background())).Option("missingkey=zero").Parse(rep.texTemplate)
+	return err
+}
+
+// trusted reports whether the report's template was provisioned by the admin: a built-in style, or a file from Options.TemplateDir.
+// Only trusted templates get the privileged helpers.
+func (rep *report) trusted() bool {
+	return rep.builtinTemplate || rep.options.TemplateDir != ""
+}
+
+// templateFuncs returns the helper functions of the report's template: the safe helpers, plus the privileged ones for trusted templates
+func (rep *report) templateFuncs(ctx context.Context) template.FuncMap {
+	funcs := safeTemplateFuncs(ctx)
+	//[[formatValue 1536 "bytes"]] writes 1.5 KiB, with the separators of the report's locale
+	funcs["formatValue"] = func(v float64, unit string) string {
+		return grafana.SanitizeLaTexInput(format.Value(v, unit, rep.options.Locale))
+	}
+	if !rep.trusted() {
+		return funcs
+	}
+	//[[with dashboard "uid"]][[.Title]][[end]] reads another dashboard, e.g. to reference it. The template data holds
+	//no Grafana client, so this read-only lookup is the only way templates reach Grafana.
+	funcs["dashboard"] = func(uid string) (grafana.Dashboard, error) {
+		if err := templateDeadline(ctx); err != nil {
+			return grafana.Dashboard{}, err
+		}
+		return rep.gClient.GetDashboard(uid)
+	}
+	funcs["includeFile"] = func(name string) (string, error) {
+		if err := templateDeadline(ctx); err != nil {
+			return "", err
+		}
+		return includeFile(rep.options.TemplateDir, name)
+	}
+	//DANGEROUS: the value is inserted as TeX without escaping, so whoever requests the report controls the
+	//document and, depending on the TeX installation, may read files or run commands. Only for trusted requesters.
+	funcs["unsafeRawExtra"] = func(key string) string {
+		return rep.options.Extras[key]
+	}
+	return funcs
+}
+
+// includeFile returns the content of the file name within dir, e.g. [[includeFile "preamble.tex"]].
+// Names leaving dir, also by symbolic links, are rejected. Built-in styles have no directory to include from.
+func includeFile(dir string, name string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("includeFile %q: the template has no directory to include files from", name)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("includeFile %q: %v", name, err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", fmt.Errorf("includeFile %q: %v", name, err)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("includeFile %q: not within the templates directory", name)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("includeFile %q: %v", name, err)
+	}
+	normalized, err := NormalizeTemplate(content)
+	if err != nil {
+		return "", fmt.Errorf("includeFile %q: %w", name, err)
+	}
+	return normalized, nil
+}
+
+// extras returns the request's extra values escaped for TeX
+func (rep *report) extras() map[string]string {
+	extras := map[string]string{}
+	for k, v := range rep.options.Extras {
+		extras[k] = grafana.SanitizeLaTexInput(v)
+	}
+	return extras
+}
+
+// interpolate replaces Grafana variable references in text with their values and escapes the result for TeX,
+// e.g. [[interpolate .Variables "Servers: ${host:csv}"]]
+func interpolate(values map[string][]string, text string) string {
+	return grafana.SanitizeLaTexInput(grafana.InterpolateVariables(text, values))
+}
+
+// chunk splits the slice or array items into consecutive slices of at most size elements,
+// e.g. [[range chunk 2 .Panels]] ranges over the panels in pairs. A size of 0 or less gives one chunk.
+func chunk(size int, items interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("chunk: can not split %T, expected a slice", items)
+	}
+	n := v.Len()
+	if n == 0 {
+		return nil, nil
+	}
+	if size <= 0 {
+		size = n
+	}
+	var chunks []interface{}
+	for i := 0; i < n; i += size {
+		end := i + size
+		if end > n {
+			end = n
+		}
+		chunks = append(chunks, v.Slice(i, end).Interface())
+	}
+	return chunks, nil
+}
+
+// PanelGroup is a dashboard row, as passed to the TeX templates. Dashboards without rows have a single untitled group.
+type PanelGroup struct {
+	Title     string
+	Showtitle bool
+	Panels    []grafana.Panel
+}
+
+// panelGroups groups the dashboard's panels by row. The panels are taken from dash.Panels,
+// which unlike the row panels carry the report's captions.
+func panelGroups(dash grafana.Dashboard) []PanelGroup {
+	if len(dash.Rows) == 0 {
+		if len(dash.Panels) == 0 {
+			return nil
+		}
+		return []PanelGroup{{Panels: dash.Panels}}
+	}
+	byID := map[int]grafana.Panel{}
+	for _, p := range dash.Panels {
+		byID[p.Id] = p
+	}
+	var groups []PanelGroup
+	for _, r := range dash.Rows {
+		g := PanelGroup{Title: r.Title, Showtitle: r.Showtitle}
+		for _, rp := range r.Panels {
+			if p, ok := byID[rp.Id]; ok {
+				g.Panels = append(g.Panels, p)
+			}
+		}
+		if len(g.Panels) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// panelHeight is the fraction of the text height available to each of perPage panels
+func panelHeight(perPage int) float64 {
+	if perPage <= 0 {
+		return 0
+	}
+	return 0.85 / float64(perPage)
+}
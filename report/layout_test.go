@@ -0,0 +1,316 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/format"
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChunk(t *testing.T) {
+	Convey("When chunking a slice", t, func() {
+		Convey("It should split it into slices of the given size", func() {
+			chunks, err := chunk(2, []int{1, 2, 3, 4, 5})
+			So(err, ShouldBeNil)
+			So(chunks, ShouldResemble, []interface{}{[]int{1, 2}, []int{3, 4}, []int{5}})
+		})
+
+		Convey("A size of 0 should give a single chunk", func() {
+			chunks, err := chunk(0, []string{"a", "b"})
+			So(err, ShouldBeNil)
+			So(chunks, ShouldResemble, []interface{}{[]string{"a", "b"}})
+		})
+
+		Convey("An empty slice should give no chunks", func() {
+			chunks, err := chunk(3, []int{})
+			So(err, ShouldBeNil)
+			So(chunks, ShouldBeEmpty)
+		})
+
+		Convey("A value that is not a slice should return an error", func() {
+			_, err := chunk(2, 42)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// texPages splits the TeX body into the content of each page, i.e. the text between \clearpage commands
+func texPages(tex string) []string {
+	body := tex[strings.Index(tex, `\begin{center}`):]
+	pages := strings.Split(body, `\clearpage`)
+	return pages[:len(pages)-1]
+}
+
+var includeRegExp = regexp.MustCompile(`\\includegraphics\[[^]]*\]\{image(\d+)\}`)
+
+func includedImages(page string) []string {
+	var ids []string
+	for _, m := range includeRegExp.FindAllStringSubmatch(page, -1) {
+		ids = append(ids, m[1])
+	}
+	return ids
+}
+
+func TestPanelsPerPage(t *testing.T) {
+	Convey("When generating the TeX file with a number of panels per page", t, func() {
+		gClient := &mockGrafanaClient{}
+		dash, _ := gClient.GetDashboard("")
+		dash.Rows[1].Showtitle = true
+		dash.Rows[1].Title = "Second row"
+
+		texFor := func(style string, perPage int) string {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Style: style, PanelsPerPage: perPage})
+			defer rep.Clean()
+			So(rep.generateTeXFile(dash, nil, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			return string(tex)
+		}
+
+		Convey("1 panel per page should put every panel on its own page", func() {
+			pages := texPages(texFor("classic", 1))
+			So(pages, ShouldHaveLength, 9)
+			for _, p := range pages {
+				So(includedImages(p), ShouldHaveLength, 1)
+			}
+			So(pages[2], ShouldContainSubstring, `\section*{Second row}`)
+		})
+
+		Convey("2 panels per page should pair the panels within each row", func() {
+			pages := texPages(texFor("classic", 2))
+			var ids [][]string
+			for _, p := range pages {
+				ids = append(ids, includedImages(p))
+			}
+			So(ids, ShouldResemble, [][]string{{"1", "22"}, {"33", "44"}, {"55", "66"}, {"77", "88"}, {"99"}})
+			So(pages[1], ShouldContainSubstring, `\section*{Second row}`)
+			So(pages[1], ShouldContainSubstring, `height=0.42\textheight`)
+		})
+
+		Convey("4 panels per page should start each row on a new page with its title", func() {
+			pages := texPages(texFor("classic", 4))
+			var ids [][]string
+			for _, p := range pages {
+				ids = append(ids, includedImages(p))
+			}
+			So(ids, ShouldResemble, [][]string{{"1", "22"}, {"33", "44", "55", "66"}, {"77", "88", "99"}})
+			So(strings.Index(pages[1], `\section*{Second row}`), ShouldBeLessThan, strings.Index(pages[1], `image33`))
+			So(pages[2], ShouldNotContainSubstring, `Second row`)
+		})
+
+		Convey("The grid layout should size its panels for two columns", func() {
+			tex := texFor("compact", 4)
+			So(texPages(tex), ShouldHaveLength, 3)
+			So(tex, ShouldContainSubstring, `height=0.42\textheight`)
+		})
+
+		Convey("0 should keep the layout without page breaks", func() {
+			tex := texFor("classic", 0)
+			So(tex, ShouldNotContainSubstring, `\clearpage`)
+			So(includedImages(tex), ShouldHaveLength, 9)
+		})
+	})
+}
+
+func TestInterpolateTemplateFunc(t *testing.T) {
+	Convey("When interpolating variables in a template", t, func() {
+		values := map[string][]string{"host": {"web_1", "web_2"}}
+		Convey("The result should be escaped for TeX", func() {
+			So(interpolate(values, "Hosts: ${host:csv}"), ShouldEqual, `Hosts: web\_1,web\_2`)
+		})
+	})
+}
+
+func TestDatasourceCaptions(t *testing.T) {
+	Convey("When generating the TeX file for panels with data sources", t, func() {
+		gClient := &mockGrafanaClient{}
+		dash, _ := gClient.GetDashboard("")
+		dash.Panels[1].Sources = []string{"Prometheus-prod", "Loki"}
+
+		for _, style := range Styles() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Style: style})
+			So(rep.generateTeXFile(dash, nil, nil), ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			rep.Clean()
+			So(err, ShouldBeNil)
+
+			Convey("The "+style+" style should caption them", func() {
+				So(strings.Count(string(tex), "Source: "), ShouldEqual, 1)
+				So(string(tex), ShouldContainSubstring, "Source: Prometheus-prod, Loki}")
+			})
+		}
+	})
+}
+
+func TestExtrasTemplateData(t *testing.T) {
+	Convey("When generating the TeX file with extras", t, func() {
+		gClient := &mockGrafanaClient{}
+		dash, _ := gClient.GetDashboard("")
+		texFor := func(tmpl string, extras map[string]string) (string, error) {
+			return texWithOptions(gClient, dash, tmpl, Options{Extras: extras})
+		}
+		extras := map[string]string{"preparedFor": `ACME & Sons_{1}`}
+
+		Convey("Values should be escaped for TeX", func() {
+			tex, err := texFor(`Prepared for: [[.Extras.preparedFor]]`, extras)
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, `Prepared for: ACME \& Sons\_\{1\}`)
+		})
+
+		Convey("Missing keys should give an empty string", func() {
+			tex, err := texFor(`Reviewer: [[.Extras.reviewer]].`, extras)
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, `Reviewer: .`)
+
+			tex, err = texFor(`Reviewer: [[.Extras.reviewer]].`, nil)
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, `Reviewer: .`)
+		})
+
+		Convey("unsafeRawExtra should insert the value unescaped in trusted templates", func() {
+			tex, err := texWithOptions(gClient, dash, `[[unsafeRawExtra "preparedFor"]]`, Options{Extras: extras, TemplateDir: os.TempDir()})
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, `ACME & Sons_{1}`)
+		})
+	})
+}
+
+// texWithOptions generates the TeX file of a report of dash with the template tmpl and returns it
+func texWithOptions(gClient grafana.Client, dash grafana.Dashboard, tmpl string, opts Options) (string, error) {
+	rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, tmpl, opts)
+	defer rep.Clean()
+	if err := rep.generateTeXFile(dash, nil, nil); err != nil {
+		return "", err
+	}
+	tex, err := ioutil.ReadFile(rep.texPath())
+	return string(tex), err
+}
+
+func TestTemplateTiers(t *testing.T) {
+	Convey("When generating the TeX file of templates from different sources", t, func() {
+		gClient := &mockGrafanaClient{}
+		dash, _ := gClient.GetDashboard("")
+		dir, err := ioutil.TempDir("", "templates")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(ioutil.WriteFile(filepath.Join(dir, "preamble.tex"), []byte(`\usepackage{graphicx}`), 0644), ShouldBeNil)
+		outside := filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"-secret.txt")
+		So(ioutil.WriteFile(outside, []byte("secret"), 0644), ShouldBeNil)
+		defer os.Remove(outside)
+
+		Convey("Templates supplied with a request should not reach the privileged helpers", func() {
+			for _, tmpl := range []string{`[[includeFile "preamble.tex"]]`, `[[unsafeRawExtra "key"]]`, `[[dashboard "other"]]`} {
+				_, err := texWithOptions(gClient, dash, tmpl, Options{Extras: map[string]string{"key": "value"}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "not defined")
+			}
+		})
+
+		Convey("No template should reach the Grafana client through its data", func() {
+			for _, opts := range []Options{{}, {TemplateDir: dir}} {
+				for _, tmpl := range []string{`[[.GetDashboard "other"]]`, `[[.CreateAnnotation .Annotation]]`, `[[.SearchDashboards nil]]`} {
+					_, err := texWithOptions(gClient, dash, tmpl, opts)
+					So(err, ShouldNotBeNil)
+				}
+			}
+		})
+
+		Convey("Trusted templates should look up other dashboards read-only", func() {
+			tex, err := texWithOptions(gClient, dash, `[[with dashboard "other"]][[.Title]][[end]]`, Options{TemplateDir: dir})
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, dash.Title)
+		})
+
+		Convey("They should still get the safe helpers", func() {
+			tex, err := texWithOptions(gClient, dash, `[[range chunk 2 .Panels]][[range .]][[join .Sources ","]]x[[end]][[end]]`, Options{})
+			So(err, ShouldBeNil)
+			So(tex, ShouldNotBeEmpty)
+
+			Convey("Including formatValue, for the report's locale", func() {
+				tex, err := texWithOptions(gClient, dash, `[[formatValue 1536 "bytes"]] [[formatValue 0.255 "percentunit"]]`, Options{Locale: format.Locale{Decimal: ",", Group: "."}})
+				So(err, ShouldBeNil)
+				So(tex, ShouldEqual, `1,5 KiB 25,5\%`)
+			})
+		})
+
+		Convey("Templates from the templates directory should include files from it", func() {
+			tex, err := texWithOptions(gClient, dash, `[[includeFile "preamble.tex"]]`, Options{TemplateDir: dir})
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, `\usepackage{graphicx}`)
+
+			Convey("But not from outside of it", func() {
+				_, err := texWithOptions(gClient, dash, `[[includeFile "../`+filepath.Base(outside)+`"]]`, Options{TemplateDir: dir})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "not within the templates directory")
+
+				_, err = texWithOptions(gClient, dash, `[[includeFile "/etc/passwd"]]`, Options{TemplateDir: dir})
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Nor by a symbolic link leaving it", func() {
+				So(os.Symlink(outside, filepath.Join(dir, "link.tex")), ShouldBeNil)
+				_, err := texWithOptions(gClient, dash, `[[includeFile "link.tex"]]`, Options{TemplateDir: dir})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "not within the templates directory")
+			})
+		})
+
+		Convey("Built-in styles should be trusted, but have no directory to include from", func() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			So(rep.trusted(), ShouldBeTrue)
+			_, err := includeFile("", "preamble.tex")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Templates running longer than the timeout should fail", func() {
+			defer func(orig time.Duration) { TemplateTimeout = orig }(TemplateTimeout)
+			TemplateTimeout = 0
+			_, err := texWithOptions(gClient, dash, `[[range .Panels]]x[[end]]`, Options{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "timeout")
+		})
+	})
+}
+
+func TestValidateTemplate(t *testing.T) {
+	Convey("When validating templates", t, func() {
+		Convey("The built-in styles should be valid", func() {
+			for _, style := range Styles() {
+				So(ValidateTemplate("", Options{Style: style}), ShouldBeNil)
+			}
+		})
+
+		Convey("Syntax errors should be reported", func() {
+			So(ValidateTemplate(`[[range .Panels]]`, Options{}), ShouldNotBeNil)
+		})
+
+		Convey("Privileged helpers should only be valid in provisioned templates", func() {
+			tex := `[[includeFile "preamble.tex"]]`
+			So(ValidateTemplate(tex, Options{}), ShouldNotBeNil)
+			So(ValidateTemplate(tex, Options{TemplateDir: "templates"}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,66 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// A template looping over the wrong data can generate a TeX file that keeps pdflatex busy for hours.
+// The generated file is checked against these limits before it is compiled.
+const (
+	includesPerPanel = 2  //\includegraphics directives allowed per rendered panel
+	extraIncludes    = 10 //\includegraphics directives allowed on top, e.g. for logos
+)
+
+// MaxTeXBytes rejects generated TeX files larger than this many bytes. 0 disables the check
+var MaxTeXBytes int64 = 10 << 20
+
+// TeXLimitError is returned when the generated TeX file exceeds a sanity limit
+type TeXLimitError struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *TeXLimitError) Error() string {
+	return fmt.Sprintf("generated TeX file exceeds the %s limit (%d > %d), check the template for loops over the wrong data", e.Limit, e.Value, e.Max)
+}
+
+// checkTeXLimits checks the size of the TeX file at path against MaxTeXBytes and its number of
+// \includegraphics directives against the number of rendered panels
+func checkTeXLimits(path string, panels int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error reading tex file at %v: %v", path, err)
+	}
+	if MaxTeXBytes > 0 && info.Size() > MaxTeXBytes {
+		return &TeXLimitError{"max-tex-bytes", info.Size(), MaxTeXBytes}
+	}
+	tex, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading tex file at %v: %v", path, err)
+	}
+	includes := int64(strings.Count(string(tex), `\includegraphics`))
+	if max := int64(includesPerPanel*panels + extraIncludes); includes > max {
+		return &TeXLimitError{"includegraphics per panel", includes, max}
+	}
+	return nil
+}
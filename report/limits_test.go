@@ -0,0 +1,96 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTeXLimits(t *testing.T) {
+	Convey("When generating a TeX file from a template", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		dashboard, _ := gClient.GetDashboard("")
+		newRep := func(template string) *report {
+			return new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, template, Options{})
+		}
+
+		Convey("The default template should be within the limits", func() {
+			rep := newRep("")
+			defer rep.Clean()
+			So(rep.generateTeXFile(dashboard, nil, nil), ShouldBeNil)
+		})
+
+		Convey("A template including far more images than panels should be rejected", func() {
+			//loops over the panels once per panel
+			rep := newRep(`[[$all := .Panels]][[range .Panels]][[range $all]]\includegraphics{image[[.Id]]}
+[[end]][[end]]`)
+			defer rep.Clean()
+			err := rep.generateTeXFile(dashboard, nil, nil)
+			var limitErr *TeXLimitError
+			So(errors.As(err, &limitErr), ShouldBeTrue)
+			So(limitErr.Limit, ShouldEqual, "includegraphics per panel")
+			So(limitErr.Value, ShouldEqual, 81)
+			So(limitErr.Max, ShouldEqual, 28)
+		})
+
+		Convey("A TeX file above the size limit should be rejected", func() {
+			defer func(orig int64) { MaxTeXBytes = orig }(MaxTeXBytes)
+			MaxTeXBytes = 1000
+			rep := newRep(strings.Repeat("%padding\n", 200))
+			defer rep.Clean()
+			err := rep.generateTeXFile(dashboard, nil, nil)
+			var limitErr *TeXLimitError
+			So(errors.As(err, &limitErr), ShouldBeTrue)
+			So(limitErr.Limit, ShouldEqual, "max-tex-bytes")
+			So(err.Error(), ShouldContainSubstring, "1800 > 1000")
+		})
+
+		Convey("The size check should be disabled by 0", func() {
+			defer func(orig int64) { MaxTeXBytes = orig }(MaxTeXBytes)
+			MaxTeXBytes = 0
+			rep := newRep(strings.Repeat("%padding\n", 200))
+			defer rep.Clean()
+			So(rep.generateTeXFile(dashboard, nil, nil), ShouldBeNil)
+		})
+
+		Convey("A rejected report should not be compiled", func() {
+			orig := compileTeX
+			defer func() { compileTeX = orig }()
+			compiled := false
+			compileTeX = func(_ context.Context, dir string, texFile string) ([]byte, error) {
+				compiled = true
+				return nil, nil
+			}
+			rep := newRep(strings.Repeat(`\includegraphics{x}`, 100))
+			defer rep.Clean()
+			_, err := rep.Generate()
+			var limitErr *TeXLimitError
+			So(errors.As(err, &limitErr), ShouldBeTrue)
+			So(compiled, ShouldBeFalse)
+			_, statErr := os.Stat(rep.pdfPath())
+			So(os.IsNotExist(statErr), ShouldBeTrue)
+		})
+	})
+}
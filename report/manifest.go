@@ -0,0 +1,113 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/zip"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ManifestName is the name of the manifest entry in zip outputs
+const ManifestName = "manifest.json"
+
+// Manifest lists the files of a zip output with their checksums, so receivers can verify them.
+// It is stored as the last entry of the zip, named ManifestName.
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+	// SignatureAlgorithm names the algorithm of the files' signatures, empty when they are not signed
+	SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"`
+}
+
+// ManifestFile describes one file of a zip output and the report it was generated from
+type ManifestFile struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	Sha256      string    `json:"sha256"`
+	Dashboard   string    `json:"dashboard"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Signature   string    `json:"signature,omitempty"` //base64, also stored as the entry Name.sig
+}
+
+// zipManifestWriter writes files to a zip, recording them in its manifest
+type zipManifestWriter struct {
+	zw       *zip.Writer
+	manifest Manifest
+	key      crypto.Signer //signs the files, nil for unsigned zips
+}
+
+// addFile adds the file at path to the zip as name, describing it with entry
+func (w *zipManifestWriter) addFile(name string, path string, entry ManifestFile) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %v: %v", path, err)
+	}
+	defer src.Close()
+	dst, err := w.zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("error adding %v to zip: %w", name, err)
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, h), src)
+	if err != nil {
+		return fmt.Errorf("error adding %v to zip: %w", name, err)
+	}
+	entry.Name = name
+	entry.Size = size
+	entry.Sha256 = hex.EncodeToString(h.Sum(nil))
+	if w.key != nil {
+		sig, err := signFile(w.key, path)
+		if err != nil {
+			return fmt.Errorf("error signing %v: %v", name, err)
+		}
+		dst, err := w.zw.Create(name + ".sig")
+		if err == nil {
+			_, err = dst.Write(sig)
+		}
+		if err != nil {
+			return fmt.Errorf("error adding the signature of %v to zip: %w", name, err)
+		}
+		entry.Signature = base64.StdEncoding.EncodeToString(sig)
+		w.manifest.SignatureAlgorithm = SignatureAlgorithm(w.key.Public())
+	}
+	w.manifest.Files = append(w.manifest.Files, entry)
+	return nil
+}
+
+// close adds the manifest and finishes the zip
+func (w *zipManifestWriter) close() error {
+	dst, err := w.zw.Create(ManifestName)
+	if err != nil {
+		return fmt.Errorf("error adding %v to zip: %w", ManifestName, err)
+	}
+	enc := json.NewEncoder(dst)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(w.manifest)
+	if err != nil {
+		return fmt.Errorf("error writing %v: %w", ManifestName, err)
+	}
+	return w.zw.Close()
+}
@@ -0,0 +1,329 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// multiReport combines several dashboards into a single report.
+// It reuses the single dashboard report for its build directory, rendering and LaTeX steps.
+type multiReport struct {
+	*report
+	title     string
+	dashNames []string
+	missing   []string
+}
+
+// dashSection is a dashboard within a multi-dashboard report
+type dashSection struct {
+	grafana.Dashboard
+	ImageDir string   //relative to the TeX file
+	Warnings []string //shown at the start of the section, like the warnings on the cover of a single dashboard report
+	NowDelay string   //the dashboard's now delay the section's range was shortened by, empty when it was not
+	dashName string
+}
+
+// NewMulti creates a new Report combining several dashboards, in the given order, into one document.
+// missing describes sources that could not be resolved to a dashboard. They are listed in the report's appendix,
+// as are dashboards that cannot be fetched. texTemplate is the content of a LaTex template file.
+// If empty, a default multi-dashboard tex template is used.
+// The options apply to each dashboard, except Summary, whose panel ids would be ambiguous, and the layout options of the
+// built-in styles, which the multi-dashboard template doesn't use. Longer reports are split into volumes of whole dashboards.
+func NewMulti(g grafana.Client, title string, dashNames []string, missing []string, time grafana.TimeRange, texTemplate string, opts Options) Report {
+	return newMulti(g, title, dashNames, missing, time, texTemplate, opts)
+}
+
+func newMulti(g grafana.Client, title string, dashNames []string, missing []string, time grafana.TimeRange, texTemplate string, opts Options) *multiReport {
+	builtin := texTemplate == ""
+	if builtin {
+		texTemplate = defaultMultiTemplate
+	}
+	rep := new(g, "", time, texTemplate, opts)
+	rep.builtinTemplate = builtin
+	return &multiReport{rep, title, dashNames, missing}
+}
+
+// Generate returns the combined report.pdf file. After reading this file it should be Closed()
+// After closing the file, call report.Clean() to delete the file as well the temporary build files
+func (rep *multiReport) Generate() (pdf io.ReadCloser, err error) {
+	start := time.Now()
+	phase := PhaseDashboard
+	defer func() { err = abortedIn(rep.options.context(), phase, err) }()
+	sections, missing, err := rep.fetchDashboards()
+	if err != nil {
+		return
+	}
+	rep.time.TZ = grafana.ResolveTimezone(rep.time.TZ, sections[0].Timezone)
+	log.Println("Using time zone:", rep.time.TZ)
+
+	phase = PhaseRendering
+	err = rep.renderSections(sections)
+	if err != nil {
+		return
+	}
+	rep.trace = rep.newTrace(time.Now())
+	phase = PhaseTemplate
+	err = rep.generateTeXFile(sections, missing)
+	if err != nil {
+		err = fmt.Errorf("error generating TeX file for %v: %w", rep.title, err)
+		return
+	}
+	panels := 0
+	for _, s := range sections {
+		panels += len(s.Panels)
+	}
+	if rep.options.Source {
+		return rep.generateSource(panels, start)
+	}
+	phase = PhaseLaTeX
+	file, pages, err := rep.runLaTeX()
+	if err != nil {
+		return
+	}
+	if max := rep.options.MaxPagesPerVolume; max > 0 && pages > max {
+		file.Close()
+		log.Printf("Report has %d pages, splitting it into volumes of at most %d pages", pages, max)
+		zipped, err := rep.generateVolumes(sections, missing, pages)
+		if err != nil {
+			return nil, err
+		}
+		rep.recordStats(zipped, pages, panels, start)
+		return zipped, nil
+	}
+	rep.recordStats(file, pages, panels, start)
+	return file, nil
+}
+
+// generateVolumes compiles the sections as several volumes of whole dashboards, and returns them zipped.
+// A dashboard longer than MaxPagesPerVolume makes a volume of its own. The missing dashboards are listed in the last volume.
+func (rep *multiReport) generateVolumes(sections []dashSection, missing []string, pages int) (*os.File, error) {
+	max := rep.options.MaxPagesPerVolume
+	plan := planSectionVolumes(sections, (pages+max-1)/max)
+	var pdfs []string
+	for i, volSections := range plan {
+		vol := *rep.report
+		vol.jobName = fmt.Sprintf("volume%d", i+1)
+		vol.volume = i + 1
+		vol.volumes = len(plan)
+		volRep := &multiReport{&vol, rep.title, rep.dashNames, rep.missing}
+
+		var volMissing []string
+		if i == len(plan)-1 {
+			volMissing = missing
+		}
+		if err := volRep.generateTeXFile(volSections, volMissing); err != nil {
+			return nil, fmt.Errorf("error generating TeX file for volume %d: %w", i+1, err)
+		}
+		pdf, _, err := volRep.runLaTeX()
+		if err != nil {
+			return nil, fmt.Errorf("error compiling volume %d: %w", i+1, err)
+		}
+		pdf.Close()
+		pdfs = append(pdfs, volRep.pdfPath())
+	}
+	return rep.zipVolumes(pdfs, rep.title)
+}
+
+// planSectionVolumes partitions the sections into about n volumes of similar panel counts, keeping each dashboard in one volume
+func planSectionVolumes(sections []dashSection, n int) [][]dashSection {
+	total := 0
+	for _, s := range sections {
+		total += len(s.Panels)
+	}
+	if n < 1 {
+		n = 1
+	}
+	size := (total + n - 1) / n
+
+	var volumes [][]dashSection
+	var cur []dashSection
+	panels := 0
+	for _, s := range sections {
+		if len(cur) > 0 && panels+len(s.Panels) > size {
+			volumes = append(volumes, cur)
+			cur, panels = nil, 0
+		}
+		cur = append(cur, s)
+		panels += len(s.Panels)
+	}
+	if len(cur) > 0 {
+		volumes = append(volumes, cur)
+	}
+	return volumes
+}
+
+// fetchDashboards fetches all dashboards, noting the ones that cannot be fetched in missing.
+// It only fails if Grafana is unreachable or none of the dashboards could be fetched.
+func (rep *multiReport) fetchDashboards() (sections []dashSection, missing []string, err error) {
+	missing = append(missing, rep.missing...)
+	for i, dashName := range rep.dashNames {
+		dash, err := rep.gClient.GetDashboard(dashName)
+		if err != nil {
+			var connErr *grafana.ConnectionError
+			if errors.As(err, &connErr) || errors.Is(err, grafana.ErrCircuitOpen) || rep.options.context().Err() != nil {
+				return nil, nil, fmt.Errorf("error fetching dashboard %v: %w", dashName, err)
+			}
+			log.Printf("Leaving dashboard %v out of the report: %v", dashName, err)
+			missing = append(missing, "dashboard "+dashName+": could not be fetched")
+			continue
+		}
+		sections = append(sections, dashSection{Dashboard: dash, ImageDir: filepath.ToSlash(filepath.Join(fmt.Sprintf("dash%d", i), imgDir)), dashName: dashName})
+	}
+	if len(sections) == 0 {
+		return nil, nil, fmt.Errorf("error generating report %v: none of its %d dashboards could be fetched", rep.title, len(rep.dashNames))
+	}
+	return sections, missing, nil
+}
+
+// renderSections renders the panels of up to MaxConcurrentDashboards sections at once.
+// It returns the error of the first failing section in report order.
+func (rep *multiReport) renderSections(sections []dashSection) error {
+	err := rep.makeTmpDir()
+	if err != nil {
+		return err
+	}
+	for _, s := range sections {
+		dir := rep.section(s).tmpDir
+		if err := makeDir(dir); err != nil {
+			return err
+		}
+		if err := makeDir(filepath.Join(dir, imgDir)); err != nil {
+			return err
+		}
+	}
+
+	concurrent := MaxConcurrentDashboards
+	if concurrent <= 0 {
+		concurrent = 1
+	}
+	indexes := make(chan int, len(sections))
+	for i := range sections {
+		indexes <- i
+	}
+	close(indexes)
+
+	errs := make([]error, len(sections))
+	var wg sync.WaitGroup
+	for w := 0; w < concurrent && w < len(sections); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				s := &sections[i]
+				section := rep.section(*s)
+				section.applyNowDelay(s.Dashboard)
+				err := section.renderPNGsParallel(s.Dashboard)
+				if err != nil {
+					errs[i] = fmt.Errorf("error rendering PNGs in parralel for dash %v: %w", s.dashName, err)
+					continue
+				}
+				s.NowDelay = grafana.SanitizeLaTexInput(section.nowDelay)
+				s.Warnings = section.retentionWarnings(&s.Dashboard, time.Now())
+				s.Warnings = append(s.Warnings, section.staleWarnings(&s.Dashboard)...)
+				if rep.options.NoDataBadge {
+					section.markNoData(&s.Dashboard)
+				}
+				if rep.options.CheckVersion || rep.options.StrictVersion {
+					warning, err := section.versionWarning(s.Dashboard)
+					if err != nil {
+						errs[i] = err
+						continue
+					}
+					if warning != "" {
+						s.Warnings = append(s.Warnings, warning)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// section returns a report used to render the section's panels into the section's image directory, created by renderSections
+func (rep *multiReport) section(s dashSection) *report {
+	return &report{
+		gClient:  rep.gClient,
+		time:     rep.time,
+		dashName: s.dashName,
+		tmpDir:   filepath.Join(rep.tmpDir, filepath.Dir(filepath.FromSlash(s.ImageDir))),
+		options:  rep.options,
+		stale:    &staleImages{},
+	}
+}
+
+func (rep *multiReport) generateTeXFile(sections []dashSection, missing []string) error {
+	type multiTemplData struct {
+		Title      string
+		Dashboards []dashSection
+		Missing    []string
+		grafana.TimeRange
+		Extras  map[string]string
+		Trace   *Trace
+		Volume  int //1-based volume number, 0 when the report is not split into volumes
+		Volumes int
+	}
+
+	err := rep.makeTmpDir()
+	if err != nil {
+		return err
+	}
+	file, err := createTmpFile(rep.texPath())
+	if err != nil {
+		return fmt.Errorf("error creating tex file at %v : %w", rep.texPath(), err)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(rep.options.context(), TemplateTimeout)
+	defer cancel()
+	tmpl, err := template.New("report").Delims("[[", "]]").Funcs(rep.templateFuncs(ctx)).Option("missingkey=zero").Parse(rep.texTemplate)
+	if err != nil {
+		return fmt.Errorf("error parsing template '%s': %v", rep.texTemplate, err)
+	}
+	sanitizedMissing := make([]string, len(missing))
+	for i, m := range missing {
+		sanitizedMissing[i] = grafana.SanitizeLaTexInput(m)
+	}
+	data := multiTemplData{grafana.SanitizeLaTexInput(rep.title), sections, sanitizedMissing, rep.time, rep.extras(), rep.trace, rep.volume, rep.volumes}
+	err = tmpl.Execute(deadlineWriter{ctx, file}, data)
+	if err != nil {
+		return fmt.Errorf("error executing tex template:%w", err)
+	}
+	panels := 0
+	for _, s := range sections {
+		panels += len(s.Panels)
+	}
+	return checkTeXLimits(rep.texPath(), panels)
+}
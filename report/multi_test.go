@@ -0,0 +1,209 @@
+/*
+   Copyright 2018 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// multiDashClient serves dashboards from a map of dashboard name to dashboard JSON
+type multiDashClient struct {
+	dashboards   map[string]string
+	dashErr      error
+	mu           sync.Mutex
+	renderedDash []string
+}
+
+func (m *multiDashClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
+	j, ok := m.dashboards[dashName]
+	if !ok {
+		return grafana.Dashboard{}, m.dashErr
+	}
+	return grafana.NewDashboard([]byte(j), url.Values{}), nil
+}
+
+func (m *multiDashClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	m.mu.Lock()
+	m.renderedDash = append(m.renderedDash, dashName)
+	m.mu.Unlock()
+	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
+}
+
+func (m *multiDashClient) GetPlaylist(id string) (grafana.Playlist, error) {
+	return grafana.Playlist{}, nil
+}
+
+func (m *multiDashClient) SearchDashboards(query url.Values) ([]grafana.DashboardRef, error) {
+	return nil, nil
+}
+
+func (m *multiDashClient) GetPanelData(p grafana.Panel, t grafana.TimeRange) (grafana.PanelData, error) {
+	return grafana.PanelData{}, nil
+}
+
+func (m *multiDashClient) CreateAnnotation(a grafana.Annotation) error {
+	return nil
+}
+
+func (m *multiDashClient) GetHealth() (grafana.Health, error) {
+	return grafana.Health{}, nil
+}
+
+func TestMultiReport(t *testing.T) {
+	Convey("When generating a report combining several dashboards", t, func() {
+		gClient := &multiDashClient{
+			dashboards: map[string]string{
+				"uidA": `{"Dashboard":{"Title":"First dash","Panels":[{"Type":"graph","Id":1}]}}`,
+				"uidB": `{"Dashboard":{"Title":"Second dash","Panels":[{"Type":"graph","Id":1},{"Type":"singlestat","Id":2}]}}`,
+			},
+			dashErr: errors.New("Got Status 404 Not Found"),
+		}
+		rep := newMulti(gClient, "NOC_screens", []string{"uidA", "gone", "uidB"}, []string{"dashboards tagged unused: none found"},
+			grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", Options{})
+		defer rep.Clean()
+
+		//LaTeX is not necessarily available, so only the steps up to the TeX file are checked
+		rep.Generate()
+
+		Convey("Each dashboard's panels should be rendered into its own image directory", func() {
+			_, err := os.Stat(filepath.Join(rep.tmpDir, "dash0", "images", "image1.png"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(rep.tmpDir, "dash2", "images", "image1.png"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(rep.tmpDir, "dash2", "images", "image2.png"))
+			So(err, ShouldBeNil)
+			//dashboards are rendered concurrently, in no particular order
+			sort.Strings(gClient.renderedDash)
+			So(gClient.renderedDash, ShouldResemble, []string{"uidA", "uidB", "uidB"})
+		})
+
+		Convey("The TeX file should contain the dashboards in order", func() {
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			s := string(tex)
+			So(s, ShouldContainSubstring, "NOC\\_screens")
+			So(bytes.Index(tex, []byte("First dash")), ShouldBeLessThan, bytes.Index(tex, []byte("Second dash")))
+			So(s, ShouldContainSubstring, "dash0/images/image1")
+			So(s, ShouldContainSubstring, "dash2/images/image2")
+		})
+
+		Convey("The TeX file should list missing entries and dashboards that could not be fetched", func() {
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, "dashboards tagged unused: none found")
+			So(string(tex), ShouldContainSubstring, "dashboard gone: could not be fetched")
+		})
+	})
+
+	Convey("When a combined report is longer than a volume", t, func() {
+		defer stubCompiler(10)()
+		gClient := &multiDashClient{
+			dashboards: map[string]string{
+				"uidA": `{"Dashboard":{"Title":"First dash","Panels":[{"Type":"graph","Id":1}]}}`,
+				"uidB": `{"Dashboard":{"Title":"Second dash","Panels":[{"Type":"graph","Id":1},{"Type":"graph","Id":2}]}}`,
+			},
+		}
+		rep := newMulti(gClient, "NOC_screens", []string{"uidA", "uidB"}, []string{"dashboards tagged unused: none found"},
+			grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", Options{MaxPagesPerVolume: 20})
+		defer rep.Clean()
+		pdf, err := rep.Generate()
+		So(err, ShouldBeNil)
+		pdf.Close()
+
+		z, err := zip.OpenReader(filepath.Join(rep.tmpDir, "report.zip"))
+		So(err, ShouldBeNil)
+		defer z.Close()
+		read := func(f *zip.File) string {
+			r, err := f.Open()
+			So(err, ShouldBeNil)
+			defer r.Close()
+			b, _ := ioutil.ReadAll(r)
+			return string(b)
+		}
+
+		Convey("It should be split into volumes of whole dashboards", func() {
+			So(z.File, ShouldHaveLength, 3)
+			So(z.File[0].Name, ShouldEqual, "volume-1-of-2.pdf")
+			first, second := read(z.File[0]), read(z.File[1])
+			So(first, ShouldContainSubstring, "First dash")
+			So(first, ShouldNotContainSubstring, "Second dash")
+			So(second, ShouldContainSubstring, "Second dash")
+			So(second, ShouldContainSubstring, "Volume 2 of 2")
+		})
+
+		Convey("The missing entries should be listed in the last volume", func() {
+			So(read(z.File[0]), ShouldNotContainSubstring, "none found")
+			So(read(z.File[1]), ShouldContainSubstring, "none found")
+		})
+	})
+
+	Convey("When a dashboard of a combined report delays now", t, func() {
+		gClient := &multiDashClient{
+			dashboards: map[string]string{
+				"uidA": `{"Dashboard":{"Title":"First dash","Timepicker":{"nowDelay":"5m"},"Panels":[{"Type":"graph","Id":1}]}}`,
+				"uidB": `{"Dashboard":{"Title":"Second dash","Panels":[{"Type":"graph","Id":1}]}}`,
+			},
+		}
+		rep := newMulti(gClient, "NOC_screens", []string{"uidA", "uidB"}, nil, grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+		defer rep.Clean()
+		rep.Generate()
+
+		Convey("Only its section should exclude the delayed data", func() {
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(strings.Count(string(tex), "Excluding the last 5m of possibly incomplete data"), ShouldEqual, 1)
+			So(bytes.Index(tex, []byte("Excluding the last")), ShouldBeLessThan, bytes.Index(tex, []byte("Second dash")))
+		})
+	})
+
+	Convey("When none of the dashboards can be fetched", t, func() {
+		gClient := &multiDashClient{dashErr: errors.New("Got Status 404 Not Found")}
+		rep := newMulti(gClient, "empty", []string{"gone"}, nil, grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+		defer rep.Clean()
+		_, err := rep.Generate()
+
+		Convey("Generate should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("When Grafana is unreachable", t, func() {
+		gClient := &multiDashClient{dashErr: &grafana.ConnectionError{Op: "getDashboard", URL: "http://grafana", Err: errors.New("connection refused")}}
+		rep := newMulti(gClient, "down", []string{"uidA"}, nil, grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+		defer rep.Clean()
+		_, err := rep.Generate()
+
+		Convey("Generate should fail rather than report the dashboards as missing", func() {
+			var connErr *grafana.ConnectionError
+			So(errors.As(err, &connErr), ShouldBeTrue)
+		})
+	})
+}
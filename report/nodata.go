@@ -0,0 +1,116 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// NoDataThreshold is the share of an image's pixels that must have the background colour for the image to count as blank.
+// Close to 1, so that sparse graphs with only axes and a thin line still count as having data.
+var NoDataThreshold = 0.995
+
+const noDataCaption = "No data for selected range."
+
+// markNoData captions the panels of dash that show no data: panels whose query returned no values or,
+// if the panel data can't be fetched, whose rendered image is blank
+func (rep *report) markNoData(dash *grafana.Dashboard) {
+	for i, p := range dash.Panels {
+		empty, err := rep.panelDataEmpty(p)
+		if err != nil {
+			empty, err = rep.panelImageBlank(p)
+		}
+		if err != nil {
+			log.Printf("Error checking panel %v for data: %v", p.Id, err)
+			continue
+		}
+		if !empty {
+			continue
+		}
+		log.Printf("Panel %v shows no data", p.Id)
+		if p.Warning != "" {
+			dash.Panels[i].Warning += " "
+		}
+		dash.Panels[i].Warning += noDataCaption
+	}
+}
+
+// panelDataEmpty reports whether the panel's queries returned no values
+func (rep *report) panelDataEmpty(p grafana.Panel) (bool, error) {
+	if len(p.Targets) == 0 {
+		return false, fmt.Errorf("panel has no queries")
+	}
+	data, err := rep.gClient.GetPanelData(p, rep.time)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range data.Frames {
+		for _, field := range f.Fields {
+			if len(field.Values) > 0 {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// panelImageBlank reports whether the rendered image of the panel is blank
+func (rep *report) panelImageBlank(p grafana.Panel) (bool, error) {
+	paths, err := filepath.Glob(filepath.Join(rep.imgDirPath(), fmt.Sprintf("image%d.*", p.Id)))
+	if err != nil || len(paths) == 0 {
+		return false, fmt.Errorf("no rendered image")
+	}
+	f, err := os.Open(paths[0])
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return false, fmt.Errorf("error decoding image %v: %v", paths[0], err)
+	}
+	return isBlankImage(img, NoDataThreshold), nil
+}
+
+// isBlankImage reports whether at least threshold of the image's pixels have its most common colour.
+// Colours are compared at 3 bits per channel, so JPEG artifacts and anti-aliasing don't count as content.
+func isBlankImage(img image.Image, threshold float64) bool {
+	b := img.Bounds()
+	total := b.Dx() * b.Dy()
+	if total == 0 {
+		return true
+	}
+	histogram := map[uint32]int{}
+	most := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			bucket := r>>13<<6 | g>>13<<3 | bl>>13
+			histogram[bucket]++
+			if histogram[bucket] > most {
+				most = histogram[bucket]
+			}
+		}
+	}
+	return float64(most)/float64(total) >= threshold
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// panelImage creates a 1000 x 500 panel image in the background colour with the given rectangles drawn in fg
+func panelImage(bg color.Color, fg color.Color, content ...image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	fill := func(r image.Rectangle, c color.Color) {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				img.Set(x, y, c)
+			}
+		}
+	}
+	fill(img.Bounds(), bg)
+	for _, r := range content {
+		fill(r, fg)
+	}
+	return img
+}
+
+// noDataText approximates Grafana's centred "No data" text
+var noDataText = image.Rect(470, 240, 530, 256)
+
+// sparseGraph approximates a graph with axes, tick labels and a single thin series line
+func sparseGraph() []image.Rectangle {
+	rects := []image.Rectangle{image.Rect(50, 20, 51, 470), image.Rect(50, 470, 980, 471)}
+	for x := 60; x < 980; x += 100 {
+		rects = append(rects, image.Rect(x, 476, x+24, 484)) //tick labels
+	}
+	for x := 51; x < 980; x++ {
+		y := 300 + x%40
+		rects = append(rects, image.Rect(x, y, x+1, y+1))
+	}
+	return rects
+}
+
+func TestIsBlankImage(t *testing.T) {
+	Convey("When checking panel images for content", t, func() {
+		white, dark := color.RGBA{255, 255, 255, 255}, color.RGBA{17, 18, 23, 255}
+		grey := color.RGBA{70, 70, 70, 255}
+
+		Convey("A uniform image should be blank", func() {
+			So(isBlankImage(panelImage(white, grey), NoDataThreshold), ShouldBeTrue)
+		})
+
+		Convey("A panel showing only the no data text should be blank", func() {
+			So(isBlankImage(panelImage(white, grey, noDataText), NoDataThreshold), ShouldBeTrue)
+			So(isBlankImage(panelImage(dark, white, noDataText), NoDataThreshold), ShouldBeTrue)
+		})
+
+		Convey("A sparse graph should not be blank", func() {
+			So(isBlankImage(panelImage(white, grey, sparseGraph()...), NoDataThreshold), ShouldBeFalse)
+		})
+
+		Convey("JPEG artifacts and noise should not count as content", func() {
+			img := panelImage(color.RGBA{245, 245, 245, 255}, grey, noDataText)
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 20000; i++ {
+				x, y := rnd.Intn(1000), rnd.Intn(500)
+				v := uint8(240 + rnd.Intn(10))
+				img.Set(x, y, color.RGBA{v, v, v, 255})
+			}
+			So(isBlankImage(img, NoDataThreshold), ShouldBeTrue)
+		})
+
+		Convey("The threshold should be configurable", func() {
+			So(isBlankImage(panelImage(white, grey, sparseGraph()...), 0.9), ShouldBeTrue)
+			So(isBlankImage(panelImage(white, grey), 1), ShouldBeTrue)
+		})
+	})
+}
+
+func TestMarkNoData(t *testing.T) {
+	Convey("When captioning panels without data", t, func() {
+		empty := `{"results":{"A":{"frames":[]}}}`
+		emptySeries := `{"results":{"A":{"frames":[{"schema":{"fields":[{"name":"Time","type":"time"},{"name":"Value","type":"number"}]},"data":{"values":[[],[]]}}]}}}`
+		gClient := &panelDataClient{mockGrafanaClient{0, url.Values{}}, map[int]string{1: promSingleSeries, 2: empty, 3: emptySeries}}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{NoDataBadge: true})
+		defer rep.Clean()
+		So(rep.makeTmpDir(), ShouldBeNil)
+		So(os.MkdirAll(rep.imgDirPath(), 0777), ShouldBeNil)
+		writeImage := func(name string, img image.Image) {
+			f, err := os.Create(filepath.Join(rep.imgDirPath(), name))
+			So(err, ShouldBeNil)
+			defer f.Close()
+			if filepath.Ext(name) == ".jpg" {
+				So(jpeg.Encode(f, img, nil), ShouldBeNil)
+			} else {
+				So(png.Encode(f, img), ShouldBeNil)
+			}
+		}
+		white, grey := color.RGBA{255, 255, 255, 255}, color.RGBA{70, 70, 70, 255}
+		writeImage("image1.png", panelImage(white, grey, noDataText))
+		writeImage("image4.jpg", panelImage(white, grey, noDataText))
+		writeImage("image5.png", panelImage(white, grey, sparseGraph()...))
+
+		target := []map[string]interface{}{{"expr": "up"}}
+		dash := grafana.Dashboard{Panels: []grafana.Panel{
+			{Id: 1, Targets: target},
+			{Id: 2, Targets: target, Warning: "Incomplete data."},
+			{Id: 3, Targets: target},
+			{Id: 4, Targets: target},
+			{Id: 5},
+			{Id: 6},
+		}}
+		rep.markNoData(&dash)
+
+		Convey("Panels whose query returned values should not be captioned, whatever the image", func() {
+			So(dash.Panels[0].Warning, ShouldEqual, "")
+		})
+
+		Convey("Panels whose query returned no frames or no values should be captioned", func() {
+			So(dash.Panels[1].Warning, ShouldEqual, "Incomplete data. "+noDataCaption)
+			So(dash.Panels[2].Warning, ShouldEqual, noDataCaption)
+		})
+
+		Convey("Panels whose data can't be fetched should be checked by their image", func() {
+			So(dash.Panels[3].Warning, ShouldEqual, noDataCaption)
+			So(dash.Panels[4].Warning, ShouldEqual, "")
+		})
+
+		Convey("Panels without image should be left alone", func() {
+			So(dash.Panels[5].Warning, ShouldEqual, "")
+		})
+	})
+}
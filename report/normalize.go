@@ -0,0 +1,49 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// utf8BOM is written at the start of UTF-8 files by some Windows editors. pdflatex fails on it with an invalid character error.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// EncodingError is returned for template files that are not valid UTF-8, e.g. saved as Latin-1
+type EncodingError struct {
+	Offset int //of the first invalid byte in the file
+}
+
+func (e *EncodingError) Error() string {
+	return fmt.Sprintf("not valid UTF-8 at byte offset %d, save the file as UTF-8", e.Offset)
+}
+
+// NormalizeTemplate returns the content of a template file without a leading byte order mark and with
+// Windows line endings replaced by newlines. It fails with an EncodingError for content that is not UTF-8.
+func NormalizeTemplate(content []byte) (string, error) {
+	for offset := 0; offset < len(content); {
+		r, size := utf8.DecodeRune(content[offset:])
+		if r == utf8.RuneError && size <= 1 {
+			return "", &EncodingError{offset}
+		}
+		offset += size
+	}
+	content = bytes.TrimPrefix(content, utf8BOM)
+	return string(bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)), nil
+}
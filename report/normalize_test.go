@@ -0,0 +1,69 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizeTemplate(t *testing.T) {
+	Convey("When normalizing template files", t, func() {
+		Convey("A leading byte order mark should be removed", func() {
+			tex, err := NormalizeTemplate([]byte("\xef\xbb\xbf\\documentclass{article}\n"))
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, "\\documentclass{article}\n")
+		})
+
+		Convey("Windows line endings should become newlines", func() {
+			tex, err := NormalizeTemplate([]byte("\xef\xbb\xbf\\begin{document}\r\n[[.Title]]\r\n\\end{document}\r\n"))
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, "\\begin{document}\n[[.Title]]\n\\end{document}\n")
+		})
+
+		Convey("UTF-8 content should be kept", func() {
+			tex, err := NormalizeTemplate([]byte("Größe: [[.Title]]\n"))
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, "Größe: [[.Title]]\n")
+		})
+
+		Convey("Latin-1 content should fail with the offset of the first invalid byte", func() {
+			_, err := NormalizeTemplate([]byte("Gr\xf6\xdfe: [[.Title]]\n"))
+			So(err, ShouldResemble, &EncodingError{2})
+			So(err.Error(), ShouldContainSubstring, "byte offset 2")
+		})
+
+		Convey("Included files should be normalized too", func() {
+			dir, err := ioutil.TempDir("", "templates")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(ioutil.WriteFile(filepath.Join(dir, "preamble.tex"), []byte("\xef\xbb\xbf\\usepackage{xcolor}\r\n"), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "latin1.tex"), []byte("\\title{Gr\xf6\xdfe}"), 0644), ShouldBeNil)
+
+			tex, err := includeFile(dir, "preamble.tex")
+			So(err, ShouldBeNil)
+			So(tex, ShouldEqual, "\\usepackage{xcolor}\n")
+			_, err = includeFile(dir, "latin1.tex")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "byte offset 9")
+		})
+	})
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTmpPermissions(t *testing.T) {
+	Convey("When writing the build files of a report", t, func() {
+		root, err := ioutil.TempDir("", "tmproot")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		defer func(orig string) { TmpRoot = orig }(TmpRoot)
+		TmpRoot = filepath.Join(root, "tmp")
+		defer func(orig os.FileMode) { TmpPerm = orig }(TmpPerm)
+		defer syscall.Umask(syscall.Umask(0))
+
+		modes := func() map[string]os.FileMode {
+			gClient := &mockGrafanaClient{0, nil}
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			dash, _ := gClient.GetDashboard("")
+			So(rep.renderPNGsParallel(dash), ShouldBeNil)
+			So(rep.generateTeXFile(dash, nil, nil), ShouldBeNil)
+			defer rep.Clean()
+			modes := map[string]os.FileMode{}
+			for name, path := range map[string]string{"root": TmpRoot, "dir": rep.tmpDir, "images": rep.imgDirPath(),
+				"image": filepath.Join(rep.imgDirPath(), "image1.png"), "tex": rep.texPath()} {
+				info, err := os.Stat(path)
+				So(err, ShouldBeNil)
+				modes[name] = info.Mode().Perm()
+			}
+			return modes
+		}
+
+		Convey("Files and directories should not be accessible to others by default", func() {
+			So(modes(), ShouldResemble, map[string]os.FileMode{"root": 0750, "dir": 0700, "images": 0750, "image": 0640, "tex": 0640})
+		})
+
+		Convey("The configured permissions should be used", func() {
+			TmpPerm = 0600
+			So(modes(), ShouldResemble, map[string]os.FileMode{"root": 0700, "dir": 0700, "images": 0700, "image": 0600, "tex": 0600})
+		})
+
+		Convey("A restrictive umask should still apply", func() {
+			syscall.Umask(077)
+			So(modes(), ShouldResemble, map[string]os.FileMode{"root": 0700, "dir": 0700, "images": 0700, "image": 0600, "tex": 0600})
+		})
+
+		Convey("LaTeX should write its caches to the root", func() {
+			abs, _ := filepath.Abs(TmpRoot)
+			So(latexEnv(), ShouldContain, "TEXMFVAR="+filepath.Join(abs, "texmf-var"))
+			So(latexEnv(), ShouldContain, "TEXMFCONFIG="+filepath.Join(abs, "texmf-config"))
+		})
+	})
+}
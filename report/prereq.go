@@ -0,0 +1,192 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LaTeXReason classifies why a LaTeX run failed, separating deployment and resource problems from template problems
+type LaTeXReason int
+
+// LaTeX run failure reasons
+const (
+	LaTeXCompileFailed LaTeXReason = iota //pdflatex exited with an error, usually a template or content problem
+	LaTeXNotInstalled                     //the pdflatex binary was not found
+	LaTeXKilled                           //pdflatex was killed by a signal or the LaTeX timeout
+	LaTeXNoPDF                            //pdflatex succeeded, but the pdf was not written
+)
+
+// String returns the reason as a short label, e.g. for logs
+func (r LaTeXReason) String() string {
+	switch r {
+	case LaTeXNotInstalled:
+		return "not_installed"
+	case LaTeXKilled:
+		return "killed"
+	case LaTeXNoPDF:
+		return "missing_pdf"
+	default:
+		return "compile_error"
+	}
+}
+
+// LaTeXError is returned when pdflatex fails. Guidance explains how to install the
+// prerequisites found missing in the output, if any.
+type LaTeXError struct {
+	Stage    string //"preprocessing" or "" for the final pass
+	Err      error
+	Output   string
+	Guidance []string
+	Reason   LaTeXReason
+}
+
+func (e *LaTeXError) Error() string {
+	stage := ""
+	if e.Stage != "" {
+		stage = " " + e.Stage
+	}
+	msg := fmt.Sprintf("error calling LaTeX%s: %q.", stage, e.Err)
+	if len(e.Guidance) > 0 {
+		msg += " Missing LaTeX prerequisites: " + strings.Join(e.Guidance, "; ") + "."
+	}
+	return msg + fmt.Sprintf(" Latex%s failed with output: %s ", stage, e.Output)
+}
+
+func (e *LaTeXError) Unwrap() error {
+	return e.Err
+}
+
+func newLaTeXError(stage string, err error, output []byte) *LaTeXError {
+	guidance := prerequisiteGuidance(output)
+	reason := LaTeXCompileFailed
+	var exitErr *exec.ExitError
+	if errors.Is(err, exec.ErrNotFound) {
+		reason = LaTeXNotInstalled
+		guidance = append([]string{"pdflatex is not installed: install texlive-latex-base (Debian/Ubuntu) or texlive-latex (Fedora)"}, guidance...)
+	} else if errors.As(err, &exitErr) && exitErr.ExitCode() == -1 {
+		//-1 is the exit code of processes terminated by a signal, e.g. by the OOM killer
+		reason = LaTeXKilled
+	}
+	return &LaTeXError{stage, err, string(output), guidance, reason}
+}
+
+// missingFilePatterns match the messages pdflatex prints when a file it needs is not installed.
+// The first group is the name of the missing file.
+var missingFilePatterns = []*regexp.Regexp{
+	regexp.MustCompile("! LaTeX Error: File `([^']+)' not found"),
+	regexp.MustCompile(`! Font \S+=(\S+) at \S+ not loadable: Metric \(TFM\) file not found`),
+}
+
+// prerequisiteHints map missing files to installation advice, first match wins.
+// Files without a hint, such as missing panel images, are not prerequisites and are ignored.
+var prerequisiteHints = []struct {
+	file *regexp.Regexp
+	hint string
+}{
+	{regexp.MustCompile(`^(graphicx|graphics|trig|keyval)\.sty$|^graphics\.cfg$`), "install texlive-latex-base (Debian/Ubuntu) or texlive-graphics (Fedora)"},
+	{regexp.MustCompile(`^geometry\.sty$`), "install texlive-latex-recommended (Debian/Ubuntu) or texlive-geometry (Fedora)"},
+	{regexp.MustCompile(`^(hyperref|url|kvoptions|pdftexcmds)\.sty$`), "install texlive-latex-recommended (Debian/Ubuntu) or texlive-hyperref (Fedora)"},
+	{regexp.MustCompile(`^(article|report|book)\.cls$|^size1[012]\.clo$`), "install texlive-latex-base (Debian/Ubuntu) or texlive-latex (Fedora)"},
+	{regexp.MustCompile(`^ec[a-z]{2}\d{4}$`), "install texlive-fonts-recommended (Debian/Ubuntu) or texlive-ec (Fedora)"},
+	{regexp.MustCompile(`\.(sty|cls|clo|cfg|def|fd)$`), "find the package providing it with `tlmgr search --global --file /%s`"},
+}
+
+// prerequisiteGuidance returns advice for every missing prerequisite reported in the LaTeX output
+func prerequisiteGuidance(output []byte) []string {
+	var guidance []string
+	seen := map[string]bool{}
+	for _, p := range missingFilePatterns {
+		for _, m := range p.FindAllSubmatch(output, -1) {
+			file := string(m[1])
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			for _, h := range prerequisiteHints {
+				if h.file.MatchString(file) {
+					hint := h.hint
+					if strings.Contains(hint, "%s") {
+						hint = fmt.Sprintf(hint, file)
+					}
+					guidance = append(guidance, file+" not found, "+hint)
+					break
+				}
+			}
+		}
+	}
+	return guidance
+}
+
+var usePackageRegExp = regexp.MustCompile(`\\usepackage(?:\[[^\]]*\])?\{([^}]+)\}`)
+
+// probeDocument returns a minimal document loading every package used by the built-in templates.
+// Package options are dropped, as different templates use conflicting ones.
+func probeDocument() string {
+	templates := []string{defaultMultiTemplate}
+	for _, s := range Styles() {
+		templates = append(templates, styleTemplate(s))
+	}
+	seen := map[string]bool{}
+	var pkgs []string
+	for _, t := range templates {
+		for _, m := range usePackageRegExp.FindAllStringSubmatch(t, -1) {
+			for _, p := range strings.Split(m[1], ",") {
+				p = strings.TrimSpace(p)
+				if !seen[p] {
+					seen[p] = true
+					pkgs = append(pkgs, p)
+				}
+			}
+		}
+	}
+	sort.Strings(pkgs)
+	doc := "\\documentclass{article}\n"
+	for _, p := range pkgs {
+		doc += "\\usepackage{" + p + "}\n"
+	}
+	return doc + "\\begin{document}\nprobe\n\\end{document}\n"
+}
+
+// CheckPrerequisites compiles a probe document that uses the packages needed by the built-in templates.
+// A *LaTeXError with guidance is returned when pdflatex or any of the packages is missing.
+func CheckPrerequisites() error {
+	err := makeTmpRoot()
+	if err != nil {
+		return err
+	}
+	dir, err := mkdirTemp(TmpRoot, "probe-")
+	if err != nil {
+		return fmt.Errorf("error creating probe directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "probe.tex"), []byte(probeDocument()), TmpPerm)
+	if err != nil {
+		return fmt.Errorf("error writing probe document: %v", err)
+	}
+	_, err = compileTeX(context.Background(), dir, "probe.tex")
+	return err
+}
@@ -0,0 +1,245 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// missingGraphicxLog was captured from pdflatex on a Debian host without texlive-latex-base's graphics bundle
+const missingGraphicxLog = `This is pdfTeX, Version 3.141592653-2.6-1.40.22 (TeX Live 2022/dev/Debian) (preloaded format=pdflatex)
+ restricted \write18 enabled.
+entering extended mode
+(./report.tex
+LaTeX2e <2021-11-15> patch level 1
+L3 programming layer <2022-01-21>
+(/usr/share/texlive/texmf-dist/tex/latex/base/article.cls
+Document Class: article 2021/10/04 v1.4n Standard LaTeX document class
+(/usr/share/texlive/texmf-dist/tex/latex/base/size10.clo))
+
+! LaTeX Error: File ` + "`graphicx.sty'" + ` not found.
+
+Type X to quit or <RETURN> to proceed,
+or enter new name. (Default extension: sty)
+
+Enter file name: 
+! Emergency stop.
+<read *> 
+         
+l.4 \usepackage
+               [margin=1in]{geometry}^^M
+!  ==> Fatal error occurred, no output PDF file produced!
+Transcript written on report.log.
+`
+
+// missingFontLog was captured from pdflatex without the EC fonts installed
+const missingFontLog = `(/usr/share/texlive/texmf-dist/tex/latex/base/t1enc.def)
+kpathsea: Running mktextfm ecrm1000
+/usr/share/texlive/texmf-dist/web2c/mktexnam: Could not map source abbreviation  for ecrm1000.
+mktextfm: Can't find sources for ecrm1000.
+! Font T1/cmr/m/n/10=ecrm1000 at 10.0pt not loadable: Metric (TFM) file not found.
+<to be read again> 
+                   relax 
+l.100 \fontencoding\encodingdefault\selectfont
+`
+
+// missingImageLog has a missing panel image, which is not a prerequisite
+const missingImageLog = `
+! LaTeX Error: File ` + "`image22'" + ` not found.
+
+See the LaTeX manual or LaTeX Companion for explanation.
+`
+
+func TestPrerequisiteGuidance(t *testing.T) {
+	Convey("When reading LaTeX output for missing prerequisites", t, func() {
+		Convey("A missing graphicx package should name the packages to install", func() {
+			g := prerequisiteGuidance([]byte(missingGraphicxLog))
+			So(g, ShouldHaveLength, 1)
+			So(g[0], ShouldStartWith, "graphicx.sty not found")
+			So(g[0], ShouldContainSubstring, "texlive-latex-base")
+		})
+
+		Convey("A missing font should name the font package", func() {
+			g := prerequisiteGuidance([]byte(missingFontLog))
+			So(g, ShouldHaveLength, 1)
+			So(g[0], ShouldContainSubstring, "texlive-fonts-recommended")
+		})
+
+		Convey("An unknown package should get a generic search hint", func() {
+			g := prerequisiteGuidance([]byte("! LaTeX Error: File `fancyhdr.sty' not found."))
+			So(g, ShouldResemble, []string{"fancyhdr.sty not found, find the package providing it with `tlmgr search --global --file /fancyhdr.sty`"})
+		})
+
+		Convey("Files reported several times should be listed once", func() {
+			g := prerequisiteGuidance([]byte(missingGraphicxLog + missingGraphicxLog))
+			So(g, ShouldHaveLength, 1)
+		})
+
+		Convey("Missing panel images should be ignored", func() {
+			So(prerequisiteGuidance([]byte(missingImageLog)), ShouldBeEmpty)
+		})
+	})
+
+	Convey("When LaTeX fails", t, func() {
+		Convey("The error should include the guidance and the output", func() {
+			err := newLaTeXError("preprocessing", errors.New("exit status 1"), []byte(missingGraphicxLog))
+			So(err.Error(), ShouldContainSubstring, "Missing LaTeX prerequisites: graphicx.sty not found")
+			So(err.Error(), ShouldContainSubstring, "Fatal error occurred")
+		})
+
+		Convey("A missing pdflatex binary should be explained", func() {
+			err := newLaTeXError("", &exec.Error{Name: "pdflatex", Err: exec.ErrNotFound}, nil)
+			So(err.Guidance, ShouldHaveLength, 1)
+			So(err.Guidance[0], ShouldStartWith, "pdflatex is not installed")
+		})
+	})
+}
+
+func TestCheckPrerequisites(t *testing.T) {
+	Convey("When checking the LaTeX prerequisites", t, func() {
+		orig := compileTeX
+		defer func() { compileTeX = orig }()
+		var probe string
+
+		Convey("The probe should load every package of the built-in templates without options", func() {
+			doc := probeDocument()
+			for _, p := range []string{"graphicx", "geometry", "hyperref"} {
+				So(doc, ShouldContainSubstring, fmt.Sprintf(`\usepackage{%s}`, p))
+			}
+			So(strings.Count(doc, `\usepackage{geometry}`), ShouldEqual, 1)
+		})
+
+		Convey("A failing probe should return the guidance", func() {
+			compileTeX = func(_ context.Context, dir string, texFile string) ([]byte, error) {
+				probe = texFile
+				return nil, newLaTeXError("", errors.New("exit status 1"), []byte(missingGraphicxLog))
+			}
+			err := CheckPrerequisites()
+			So(probe, ShouldEqual, "probe.tex")
+			var latexErr *LaTeXError
+			So(errors.As(err, &latexErr), ShouldBeTrue)
+			So(latexErr.Guidance, ShouldHaveLength, 1)
+		})
+
+		Convey("A compiling probe should pass", func() {
+			compileTeX = func(_ context.Context, dir string, texFile string) ([]byte, error) {
+				return nil, nil
+			}
+			So(CheckPrerequisites(), ShouldBeNil)
+		})
+	})
+}
+
+// stubCommand replaces pdflatex with the shell script, ignoring the pdflatex arguments
+func stubCommand(script string) (restore func()) {
+	orig := runPdfLaTeX
+	runPdfLaTeX = func(ctx context.Context, dir string, args ...string) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, "sh", "-c", script)
+		cmd.Dir = dir
+		return cmd.CombinedOutput()
+	}
+	return func() { runPdfLaTeX = orig }
+}
+
+func TestLaTeXRunOutcomes(t *testing.T) {
+	Convey("When a LaTeX run fails", t, func() {
+		gClient := &mockGrafanaClient{}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+		defer rep.Clean()
+		So(rep.makeTmpDir(), ShouldBeNil)
+		reason := func(err error) LaTeXReason {
+			var latexErr *LaTeXError
+			So(errors.As(err, &latexErr), ShouldBeTrue)
+			return latexErr.Reason
+		}
+
+		Convey("A missing binary should be classified as not installed", func() {
+			orig := runPdfLaTeX
+			defer func() { runPdfLaTeX = orig }()
+			runPdfLaTeX = func(ctx context.Context, dir string, args ...string) ([]byte, error) {
+				return exec.CommandContext(ctx, "pdflatex-not-installed-here").CombinedOutput()
+			}
+			_, _, err := rep.runLaTeX()
+			So(reason(err), ShouldEqual, LaTeXNotInstalled)
+		})
+
+		Convey("A non-zero exit should be classified as a compile error, keeping the log", func() {
+			defer stubCommand("echo '! Undefined control sequence.'; exit 1")()
+			_, _, err := rep.runLaTeX()
+			So(reason(err), ShouldEqual, LaTeXCompileFailed)
+			So(err.Error(), ShouldContainSubstring, "Undefined control sequence")
+		})
+
+		Convey("A run killed by a signal should be classified as killed", func() {
+			defer stubCommand("kill -9 $$")()
+			_, _, err := rep.runLaTeX()
+			So(reason(err), ShouldEqual, LaTeXKilled)
+		})
+
+		Convey("A run exceeding the timeout should be classified as killed", func() {
+			defer func(orig time.Duration) { LaTeXTimeout = orig }(LaTeXTimeout)
+			LaTeXTimeout = 50 * time.Millisecond
+			defer stubCommand("exec sleep 5")()
+			_, _, err := rep.runLaTeX()
+			So(reason(err), ShouldEqual, LaTeXKilled)
+			So(err.Error(), ShouldContainSubstring, "not finished within 50ms")
+		})
+
+		Convey("A successful run without a pdf should be classified as a missing pdf", func() {
+			defer stubCommand("echo 'Output written on report.pdf (1 page, 100 bytes).'")()
+			pdf, _, err := rep.runLaTeX()
+			So(pdf, ShouldBeNil)
+			So(reason(err), ShouldEqual, LaTeXNoPDF)
+			So(err.Error(), ShouldContainSubstring, "no pdf in build directory "+rep.tmpDir)
+			So(errors.Is(err, os.ErrNotExist), ShouldBeTrue)
+		})
+
+		Convey("A successful run with a pdf should return it open for reading", func() {
+			defer stubCommand("echo pdf > report.pdf; echo 'Output written on report.pdf (1 page, 100 bytes).'")()
+			pdf, pages, err := rep.runLaTeX()
+			So(err, ShouldBeNil)
+			So(pdf, ShouldNotBeNil)
+			content, err := ioutil.ReadAll(pdf)
+			pdf.Close()
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "pdf\n")
+			So(pages, ShouldEqual, 1)
+		})
+
+		Convey("Generate should return the pdf of a successful run", func() {
+			defer stubCommand("echo pdf > report.pdf; echo 'Output written on report.pdf (1 page, 100 bytes).'")()
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			So(pdf, ShouldNotBeNil)
+			content, err := ioutil.ReadAll(pdf)
+			pdf.Close()
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "pdf\n")
+		})
+	})
+}
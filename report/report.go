@@ -17,17 +17,22 @@
 package report
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"text/template"
+	"time"
 
+	"github.com/IzakMarais/reporter/format"
 	"github.com/IzakMarais/reporter/grafana"
-	"github.com/pborman/uuid"
 )
 
 // Report groups functions related to genrating the report.
@@ -42,72 +47,319 @@ type report struct {
 	time        grafana.TimeRange
 	texTemplate string
 	dashName    string
-	tmpDir      string
+	tmpDir      string //build directory, set by makeTmpDir once it was created
+	options     Options
+	jobName     string //base name of the TeX and PDF files, "report" when empty
+	volume      int    //1-based volume number when the report is split into volumes
+	volumes     int
+	stats       Stats
+	stale       *staleImages
+	nowDelay    string //the dashboard's now delay the report's range was shortened by, empty when it was not
+	trace       *Trace //nil when TraceFooter is disabled
+	//set when texTemplate is a built-in style, trusted like the templates in Options.TemplateDir
+	builtinTemplate bool
+}
+
+// Options are optional report settings supplied per request.
+// The zero value gives the default report.
+type Options struct {
+	// Trim crops uniform-colour borders from the rendered panel images
+	Trim bool
+	// Summary lists the ids of panels whose first series is summarized in the report
+	Summary []int
+	// Retention of the data sources, used to warn about ranges reaching back further than the stored data
+	Retention grafana.Retention
+	// CheckVersion re-fetches the dashboard after rendering and warns when its version changed meanwhile
+	CheckVersion bool
+	// StrictVersion fails the report with a VersionChangedError instead of warning. Implies CheckVersion
+	StrictVersion bool
+	// IgnoreNowDelay renders ranges ending now up to now, even for dashboards whose time picker sets a nowDelay
+	IgnoreNowDelay bool
+	// Style selects a built-in template, see Styles(). Ignored when a custom template is given
+	Style string
+	// MaxPagesPerVolume splits longer reports into volumes, returned as a zip. 0 disables splitting
+	MaxPagesPerVolume int
+	// Workers is the number of panels rendered concurrently. 0 uses DefaultWorkers
+	Workers int
+	// Degraded notes on the cover why the report was generated in reduced-quality mode. Empty for a full quality report
+	Degraded string
+	// NoDataBadge captions panels that show no data in the report's time range
+	NoDataBadge bool
+	// Extras are free-text values supplied per request, available to templates as .Extras.key, escaped for TeX
+	Extras map[string]string
+	// TemplateDir is the directory of the admin-provisioned template file passed as texTemplate. Such templates are trusted
+	// with the privileged template helpers, e.g. includeFile from this directory. Leave empty for templates supplied with a request
+	TemplateDir string
+	// RequestedBy names the host that requested the report, printed in the trace footer, see TraceFooter
+	RequestedBy string
+	// PanelsPerPage starts a new page after this many panels, never separating a row title from its first panel. 0 lets LaTeX fill the pages
+	PanelsPerPage int
+	// Glossary appends a description of the dashboard's template variables and their values to the built-in styles.
+	// Custom templates can list them with .Glossary regardless
+	Glossary bool
+	// Locale gives the separators summary statistics and formatValue write numbers with. The zero Locale doesn't group digits
+	Locale format.Locale
+	// NoteSkippedPanels lists the panels left out for their type, see grafana.SkipPanelTypes, at the end of the built-in styles
+	NoteSkippedPanels bool
+	// Source returns the TeX source with its images as a tar.gz instead of the pdf, e.g. for archiving. LaTeX is not run
+	Source bool
+	// ContactSheet makes a thumbnail of every panel image, shown in a grid at the front of the classic style
+	ContactSheet bool
+	// ContactSheetColumns is the number of thumbnails in a row of the contact sheet. 0 uses DefaultContactSheetColumns
+	ContactSheetColumns int
+	// Sign adds a detached signature made with SigningKey of each pdf in zip outputs, as a .sig entry and in the manifest.
+	// The signature of the report itself is left to its delivery
+	Sign bool
+	// Dashboard was already fetched by the caller with the report's client, e.g. for its ETag, and is used instead of
+	// fetching it again. Nil fetches the dashboard. Multi-dashboard reports fetch their dashboards regardless
+	Dashboard *grafana.Dashboard
+	// Context cancels the report once it is done, e.g. when the client requesting it disconnected:
+	// no further panels are rendered and LaTeX is killed. Nil for reports that are never canceled
+	Context context.Context
+}
+
+// context returns the Context of the options, never nil
+func (o Options) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
 }
 
 const (
-	imgDir        = "images"
-	reportTexFile = "report.tex"
-	reportPdf     = "report.pdf"
+	imgDir    = "images"
+	reportJob = "report"
 )
 
 // New creates a new Report.
-// texTemplate is the content of a LaTex template file. If empty, a default tex template is used.
-func New(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string) Report {
-	return new(g, dashName, time, texTemplate)
+// texTemplate is the content of a LaTex template file. If empty, the built-in template of opts.Style is used,
+// or the file loaded by LoadDefaultTemplate when no style is selected.
+func New(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string, opts Options) Report {
+	return new(g, dashName, time, texTemplate, opts)
+}
+
+// ResolveTemplate returns the content of the template New makes the report with: texTemplate when given,
+// else the built-in template of style, or the file loaded by LoadDefaultTemplate when no style is selected.
+func ResolveTemplate(texTemplate string, style string) string {
+	switch {
+	case texTemplate != "":
+		return texTemplate
+	case style == "" && defaultTemplate != "":
+		return defaultTemplate
+	}
+	return styleTemplate(style)
+}
+
+func new(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string, opts Options) *report {
+	builtin := texTemplate == ""
+	if builtin && opts.Style == "" && defaultTemplate != "" && opts.TemplateDir == "" {
+		opts.TemplateDir = defaultTemplateDir
+	}
+	texTemplate = ResolveTemplate(texTemplate, opts.Style)
+	return &report{gClient: g, time: time, texTemplate: texTemplate, dashName: dashName, options: opts, builtinTemplate: builtin, stale: &staleImages{}}
 }
 
-func new(g grafana.Client, dashName string, time grafana.TimeRange, texTemplate string) *report {
-	if texTemplate == "" {
-		texTemplate = defaultTemplate
+// dashboard returns a copy of Options.Dashboard, or fetches the dashboard when the caller didn't.
+// The panels are copied as well, since generating the report captions them.
+func (rep *report) dashboard() (grafana.Dashboard, error) {
+	if rep.options.Dashboard == nil {
+		return rep.gClient.GetDashboard(rep.dashName)
 	}
-	tmpDir := filepath.Join("tmp", uuid.New())
-	return &report{g, time, texTemplate, dashName, tmpDir}
+	dash := *rep.options.Dashboard
+	dash.Panels = append([]grafana.Panel(nil), dash.Panels...)
+	return dash, nil
 }
 
-// Generate returns the report.pdf file.  After reading this file it should be Closed()
+// Generate returns the report.pdf file, or a zip of its volumes when it exceeds MaxPagesPerVolume, or its source with Options.Source.
+// After reading this file it should be Closed()
 // After closing the file, call report.Clean() to delete the file as well the temporary build files
 func (rep *report) Generate() (pdf io.ReadCloser, err error) {
-	dash, err := rep.gClient.GetDashboard(rep.dashName)
+	start := time.Now()
+	phase := PhaseDashboard
+	defer func() { err = abortedIn(rep.options.context(), phase, err) }()
+	dash, err := rep.dashboard()
 	if err != nil {
-		err = fmt.Errorf("error fetching dashboard %v: %v", rep.dashName, err)
+		err = fmt.Errorf("error fetching dashboard %v: %w", rep.dashName, err)
 		return
 	}
+	rep.time.TZ = grafana.ResolveTimezone(rep.time.TZ, dash.Timezone)
+	log.Println("Using time zone:", rep.time.TZ)
+	rep.applyNowDelay(dash)
+	phase = PhaseRendering
 	err = rep.renderPNGsParallel(dash)
 	if err != nil {
-		err = fmt.Errorf("error rendering PNGs in parralel for dash %+v: %v", dash, err)
+		err = fmt.Errorf("error rendering PNGs in parralel for dash %+v: %w", dash, err)
+		return
+	}
+	warnings := rep.retentionWarnings(&dash, time.Now())
+	warnings = append(warnings, rep.staleWarnings(&dash)...)
+	if rep.options.NoDataBadge {
+		rep.markNoData(&dash)
+	}
+	if rep.options.Degraded != "" {
+		warnings = append(warnings, grafana.SanitizeLaTexInput(rep.options.Degraded))
+	}
+	if rep.options.CheckVersion || rep.options.StrictVersion {
+		var warning string
+		warning, err = rep.versionWarning(dash)
+		if err != nil {
+			return
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	summaries := rep.summaries(dash)
+	rep.trace = rep.newTrace(time.Now())
+	phase = PhaseTemplate
+	err = rep.generateTeXFile(dash, summaries, warnings)
+	if err != nil {
+		err = fmt.Errorf("error generating TeX file for dash %+v: %w", dash, err)
 		return
 	}
-	err = rep.generateTeXFile(dash)
+	if rep.options.Source {
+		return rep.generateSource(len(dash.Panels), start)
+	}
+	//volumes are generated from templates too, but compiling them takes longest
+	phase = PhaseLaTeX
+	file, pages, err := rep.runLaTeX()
 	if err != nil {
-		err = fmt.Errorf("error generating TeX file for dash %+v: %v", dash, err)
 		return
 	}
-	pdf, err = rep.runLaTeX()
-	return
+	if max := rep.options.MaxPagesPerVolume; max > 0 && pages > max {
+		file.Close()
+		log.Printf("Report has %d pages, splitting it into volumes of at most %d pages", pages, max)
+		zipped, err := rep.generateVolumes(dash, summaries, warnings, pages)
+		if err != nil {
+			return nil, err
+		}
+		rep.recordStats(zipped, pages, len(dash.Panels), start)
+		return zipped, nil
+	}
+	rep.recordStats(file, pages, len(dash.Panels), start)
+	return file, nil
+}
+
+// TmpRoot is the directory holding the build directories of the reports being generated
+var TmpRoot = "tmp"
+
+// TmpPerm is the permission of the files written to TmpRoot.
+// Directories get it plus the execute bit for everyone with the read bit, e.g. 0750 for 0640,
+// except the build directory of each report, which is only accessible to its owner.
+var TmpPerm os.FileMode = 0640
+
+// tmpDirPerm returns the permission of the directories in TmpRoot
+func tmpDirPerm() os.FileMode {
+	return TmpPerm | (TmpPerm&0444)>>2
+}
+
+// createTmpFile creates or truncates a file in TmpRoot with TmpPerm
+func createTmpFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, TmpPerm)
+}
+
+// makeTmpRoot creates TmpRoot if it doesn't exist
+func makeTmpRoot() error {
+	err := os.MkdirAll(TmpRoot, tmpDirPerm())
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory root %v: %w", TmpRoot, err)
+	}
+	return nil
+}
+
+// mkdirTemp creates a new, uniquely named directory. A variable, so tests can simulate a name that is already taken
+var mkdirTemp = os.MkdirTemp
+
+// makeTmpDir creates the report's build directory in TmpRoot, unless the report has one already.
+// The kernel guarantees the directory is new, so concurrent reports never share build files.
+func (rep *report) makeTmpDir() error {
+	if rep.tmpDir != "" {
+		return nil
+	}
+	err := makeTmpRoot()
+	if err != nil {
+		return err
+	}
+	dir, err := mkdirTemp(TmpRoot, "report-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory in %v: %w", TmpRoot, err)
+	}
+	err = makeDir(filepath.Join(dir, imgDir))
+	if err != nil {
+		return err
+	}
+	buildDirs.add(dir)
+	rep.tmpDir = dir
+	return nil
+}
+
+// makeDir creates a directory of a report's build tree. It fails if the directory exists, which means
+// another report is using it, rather than mixing the build files of both.
+func makeDir(path string) error {
+	err := os.Mkdir(path, tmpDirPerm())
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("temporary directory %v already exists, it may be in use by another report: %w", path, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory %v: %w", path, err)
+	}
+	return nil
 }
 
 // Clean deletes the temporary directory used during report generation
 func (rep *report) Clean() {
+	if rep.tmpDir == "" {
+		return
+	}
 	err := os.RemoveAll(rep.tmpDir)
 	if err != nil {
 		log.Println("Error cleaning up tmp dir:", err)
+		return
 	}
+	buildDirs.remove(rep.tmpDir)
 }
 
 func (rep *report) imgDirPath() string {
 	return filepath.Join(rep.tmpDir, imgDir)
 }
 
+func (rep *report) job() string {
+	if rep.jobName == "" {
+		return reportJob
+	}
+	return rep.jobName
+}
+
 func (rep *report) pdfPath() string {
-	return filepath.Join(rep.tmpDir, reportPdf)
+	return filepath.Join(rep.tmpDir, rep.job()+".pdf")
 }
 
 func (rep *report) texPath() string {
-	return filepath.Join(rep.tmpDir, reportTexFile)
+	return filepath.Join(rep.tmpDir, rep.job()+".tex")
+}
+
+// DefaultWorkers is the number of panels rendered concurrently when Options.Workers is not set
+const DefaultWorkers = 5
+
+// applyNowDelay ends a range ending now by the dashboard's time picker nowDelay earlier, like Grafana does,
+// so that the most recent and possibly incomplete data does not show as a dip at the right of every graph
+func (rep *report) applyNowDelay(dash grafana.Dashboard) {
+	if rep.options.IgnoreNowDelay {
+		return
+	}
+	if t, ok := rep.time.ApplyNowDelay(dash.Timepicker.NowDelay); ok {
+		log.Printf("Applying the dashboard's now delay of %v, the report ends at %v", dash.Timepicker.NowDelay, t.To)
+		rep.time = t
+		rep.nowDelay = dash.Timepicker.NowDelay
+	}
 }
 
 func (rep *report) renderPNGsParallel(dash grafana.Dashboard) error {
+	err := rep.makeTmpDir()
+	if err != nil {
+		return err
+	}
+
 	//buffer all panels on a channel
 	panels := make(chan grafana.Panel, len(dash.Panels))
 	for _, p := range dash.Panels {
@@ -119,13 +371,21 @@ func (rep *report) renderPNGsParallel(dash grafana.Dashboard) error {
 	//limit concurrency using a worker pool to avoid overwhelming grafana
 	//for dashboards with many panels.
 	var wg sync.WaitGroup
-	workers := 5
+	workers := rep.options.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
 	wg.Add(workers)
 	errs := make(chan error, len(dash.Panels)) //routines can return errors on a channel
 	for i := 0; i < workers; i++ {
 		go func(panels <-chan grafana.Panel, errs chan<- error) {
 			defer wg.Done()
 			for p := range panels {
+				if err := rep.options.context().Err(); err != nil {
+					//the remaining panels are not rendered
+					errs <- err
+					return
+				}
 				err := rep.renderPNG(p)
 				if err != nil {
 					log.Printf("Error creating image for panel: %v", err)
@@ -145,77 +405,199 @@ func (rep *report) renderPNGsParallel(dash grafana.Dashboard) error {
 	return nil
 }
 
-func (rep *report) renderPNG(p grafana.Panel) error {
+func (rep *report) renderPNG(p grafana.Panel) (err error) {
+	if err = renders.acquire(rep.options.context()); err != nil {
+		return
+	}
+	defer renders.release()
+	start := time.Now()
+	defer func() { hooks.panelRendered(rep.dashName, p, time.Since(start), err) }()
 	body, err := rep.gClient.GetPanelPng(p, rep.dashName, rep.time)
 	if err != nil {
-		return fmt.Errorf("error getting panel %+v: %v", p, err)
+		return fmt.Errorf("error getting panel %+v: %w", p, err)
 	}
 	defer body.Close()
+	rep.noteRenderAge(p, body)
 
-	err = os.MkdirAll(rep.imgDirPath(), 0777)
+	//the renderer may ignore a requested JPEG format, so the extension follows the actual content.
+	//Templates include images without extension, letting LaTeX find either.
+	img := bufio.NewReader(body)
+	head, _ := img.Peek(512)
+	imgFileName := fmt.Sprintf("image%d.%s", p.Id, imageExtension(head))
+	imgPath := filepath.Join(rep.imgDirPath(), imgFileName)
+	file, err := createTmpFile(imgPath)
 	if err != nil {
-		return fmt.Errorf("error creating img directory:%v", err)
+		return fmt.Errorf("error creating image file:%w", err)
 	}
-	imgFileName := fmt.Sprintf("image%d.png", p.Id)
-	file, err := os.Create(filepath.Join(rep.imgDirPath(), imgFileName))
+
+	_, err = io.Copy(file, img)
+	file.Close()
 	if err != nil {
-		return fmt.Errorf("error creating image file:%v", err)
+		return fmt.Errorf("error copying body to file:%w", err)
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, body)
-	if err != nil {
-		return fmt.Errorf("error copying body to file:%v", err)
+	if rep.options.Trim {
+		//a failed trim is cosmetic, so keep the untrimmed image rather than failing the report
+		if err := trimImageFile(imgPath); err != nil {
+			log.Printf("Error trimming image for panel %v, using untrimmed image: %v", p.Id, err)
+		}
+	}
+	if rep.options.ContactSheet {
+		//likewise a missing thumbnail only leaves a gap in the contact sheet
+		if err := thumbnailImageFile(imgPath, rep.thumbnailPath(p), thumbnailWidth); err != nil {
+			log.Printf("Error creating thumbnail for panel %v: %v", p.Id, err)
+		}
 	}
 	return nil
 }
 
-func (rep *report) generateTeXFile(dash grafana.Dashboard) error {
+func (rep *report) generateTeXFile(dash grafana.Dashboard, summaries []Summary, warnings []string) error {
 	type templData struct {
 		grafana.Dashboard
 		grafana.TimeRange
-		grafana.Client
+		Summaries     []Summary
+		Warnings      []string
+		Volume        int //1-based volume number, 0 when the report is not split into volumes
+		Volumes       int
+		PanelsPerPage int     //0 lets LaTeX fill the pages
+		PanelHeight   float64 //fraction of \textheight for each panel when PanelsPerPage is set
+		GridHeight    float64 //PanelHeight for a two column grid
+		PanelGroups   []PanelGroup
+		Extras        map[string]string
+		Trace         *Trace  //nil when TraceFooter is disabled
+		ShowGlossary  bool    //the built-in styles append the dashboard's Glossary
+		NoteSkipped   bool    //the built-in styles append the dashboard's SkippedPanels
+		NowDelay      string  //the dashboard's now delay the range ends earlier by, empty when not applied
+		ContactSheet  bool    //the classic style starts with a grid of the panels' thumbnails
+		SheetColumns  int     //thumbnails per row of the contact sheet
+		SheetWidth    float64 //fraction of \textwidth for each thumbnail
 	}
 
-	err := os.MkdirAll(rep.tmpDir, 0777)
+	err := rep.makeTmpDir()
 	if err != nil {
-		return fmt.Errorf("error creating temporary directory at %v: %v", rep.tmpDir, err)
+		return err
 	}
-	file, err := os.Create(rep.texPath())
+	file, err := createTmpFile(rep.texPath())
 	if err != nil {
-		return fmt.Errorf("error creating tex file at %v : %v", rep.texPath(), err)
+		return fmt.Errorf("error creating tex file at %v : %w", rep.texPath(), err)
 	}
 	defer file.Close()
 
-	tmpl, err := template.New("report").Delims("[[", "]]").Parse(rep.texTemplate)
+	ctx, cancel := context.WithTimeout(rep.options.context(), TemplateTimeout)
+	defer cancel()
+	tmpl, err := template.New("report").Delims("[[", "]]").Funcs(rep.templateFuncs(ctx)).Option("missingkey=zero").Parse(rep.texTemplate)
 	if err != nil {
 		return fmt.Errorf("error parsing template '%s': %v", rep.texTemplate, err)
 	}
-	data := templData{dash, rep.time, rep.gClient}
-	err = tmpl.Execute(file, data)
+	perPage := rep.options.PanelsPerPage
+	if rep.options.ContactSheet {
+		rep.setThumbnails(&dash)
+	}
+	columns := rep.contactSheetColumns()
+	data := templData{dash, rep.time, summaries, warnings, rep.volume, rep.volumes, perPage, panelHeight(perPage), panelHeight((perPage + 1) / 2), panelGroups(dash), rep.extras(), rep.trace, rep.options.Glossary, rep.options.NoteSkippedPanels, grafana.SanitizeLaTexInput(rep.nowDelay),
+		rep.options.ContactSheet, columns, 0.9 / float64(columns)}
+	err = tmpl.Execute(deadlineWriter{ctx, file}, data)
 	if err != nil {
-		return fmt.Errorf("error executing tex template:%v", err)
+		return fmt.Errorf("error executing tex template:%w", err)
 	}
-	return nil
+	return checkTeXLimits(rep.texPath(), len(dash.Panels))
+}
+
+// LaTeXTimeout bounds the time both pdflatex passes of a report may take together
+var LaTeXTimeout = 10 * time.Minute
+
+// runPdfLaTeX runs a single pdflatex pass with args in dir. A variable, so tests can stub pdflatex.
+var runPdfLaTeX = func(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "pdflatex", args...)
+	cmd.Dir = dir
+	cmd.Env = latexEnv()
+	return cmd.CombinedOutput()
 }
 
-func (rep *report) runLaTeX() (pdf *os.File, err error) {
-	cmdPre := exec.Command("pdflatex", "-halt-on-error", "-draftmode", reportTexFile)
-	cmdPre.Dir = rep.tmpDir
-	outBytesPre, errPre := cmdPre.CombinedOutput()
+// latexEnv returns the environment of pdflatex. TeX writes its caches, e.g. generated fonts, to TEXMFVAR and
+// TEXMFCONFIG, by default in the home directory. They are moved into TmpRoot, which works on a read-only file system.
+func latexEnv() []string {
+	root, err := filepath.Abs(TmpRoot)
+	if err != nil {
+		root = TmpRoot
+	}
+	return append(os.Environ(), "TEXMFVAR="+filepath.Join(root, "texmf-var"), "TEXMFCONFIG="+filepath.Join(root, "texmf-config"))
+}
+
+// compileTeX runs pdflatex on texFile in dir and returns the output of the final pass.
+// The draft pass writes the aux files, e.g. the table of contents, that the final pass reads.
+// A variable, so tests can stub the compiler.
+var compileTeX = func(ctx context.Context, dir string, texFile string) ([]byte, error) {
+	job := strings.TrimSuffix(texFile, ".tex")
+	//aux files left by an earlier compile of the same job, e.g. a re-planned volume, would give stale references
+	err := removeAuxFiles(dir, job, auxExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	//the report's own deadline is not a LaTeX timeout, so ctx is checked before latexCtx
+	latexCtx, cancel := context.WithTimeout(ctx, LaTeXTimeout)
+	defer cancel()
 	log.Println("Calling LaTeX - preprocessing")
+	outBytesPre, errPre := runPdfLaTeX(latexCtx, dir, "-halt-on-error", "-draftmode", texFile)
+	if ctx.Err() == context.Canceled {
+		return nil, fmt.Errorf("LaTeX preprocessing canceled: %w", ctx.Err())
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("LaTeX preprocessing aborted: %w", ctx.Err())
+	}
+	if latexCtx.Err() == context.DeadlineExceeded {
+		return nil, &LaTeXError{Stage: "preprocessing", Err: fmt.Errorf("not finished within %v", LaTeXTimeout), Output: string(outBytesPre), Reason: LaTeXKilled}
+	}
 	if errPre != nil {
-		err = fmt.Errorf("error calling LaTeX preprocessing: %q. Latex preprocessing failed with output: %s ", errPre, string(outBytesPre))
-		return
+		return nil, newLaTeXError("preprocessing", errPre, outBytesPre)
 	}
-	cmd := exec.Command("pdflatex", "-halt-on-error", reportTexFile)
-	cmd.Dir = rep.tmpDir
-	outBytes, err := cmd.CombinedOutput()
+	err = checkAuxFiles(dir, job)
+	if err != nil {
+		return nil, err
+	}
+	err = removeAuxFiles(dir, job, draftOnlyExtensions)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Println("Calling LaTeX and building PDF")
+	outBytes, err := runPdfLaTeX(latexCtx, dir, "-halt-on-error", texFile)
+	if ctx.Err() == context.Canceled {
+		return nil, fmt.Errorf("LaTeX canceled: %w", ctx.Err())
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("LaTeX aborted: %w", ctx.Err())
+	}
+	if latexCtx.Err() == context.DeadlineExceeded {
+		return nil, &LaTeXError{Err: fmt.Errorf("not finished within %v", LaTeXTimeout), Output: string(outBytes), Reason: LaTeXKilled}
+	}
+	if err != nil {
+		return nil, newLaTeXError("", err, outBytes)
+	}
+	return outBytes, nil
+}
+
+// runLaTeX compiles the report's TeX file and returns the pdf and its page count
+func (rep *report) runLaTeX() (pdf *os.File, pages int, err error) {
+	start := time.Now()
+	out, err := compileTeX(rep.options.context(), rep.tmpDir, rep.job()+".tex")
+	hooks.latexCompiled(time.Since(start), err)
+	var latexErr *LaTeXError
+	if errors.As(err, &latexErr) {
+		log.Printf("LaTeX run for %v failed: %v", rep.job(), latexErr.Reason)
+	}
 	if err != nil {
-		err = fmt.Errorf("error calling LaTeX: %q. Latex failed with output: %s ", err, string(outBytes))
 		return
 	}
+	pages, err = pageCount(out)
+	if err != nil {
+		log.Println("Error reading page count:", err)
+		err = nil
+	}
 	pdf, err = os.Open(rep.pdfPath())
+	if os.IsNotExist(err) {
+		err = &LaTeXError{Err: fmt.Errorf("no pdf in build directory %v: %w", rep.tmpDir, err), Output: string(out), Reason: LaTeXNoPDF}
+	}
 	return
 }
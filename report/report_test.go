@@ -18,12 +18,21 @@ package report
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/IzakMarais/reporter/grafana"
 	. "github.com/smartystreets/goconvey/convey"
@@ -55,7 +64,7 @@ const dashJSON = `
 }`
 
 type mockGrafanaClient struct {
-	getPanelCallCount int
+	getPanelCallCount int32 //atomic, the panels are fetched concurrently
 	variables         url.Values
 }
 
@@ -64,16 +73,36 @@ func (m *mockGrafanaClient) GetDashboard(dashName string) (grafana.Dashboard, er
 }
 
 func (m *mockGrafanaClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
-	m.getPanelCallCount++
+	atomic.AddInt32(&m.getPanelCallCount, 1)
 	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
 }
 
+func (m *mockGrafanaClient) GetPlaylist(id string) (grafana.Playlist, error) {
+	return grafana.Playlist{}, nil
+}
+
+func (m *mockGrafanaClient) SearchDashboards(query url.Values) ([]grafana.DashboardRef, error) {
+	return nil, nil
+}
+
+func (m *mockGrafanaClient) GetPanelData(p grafana.Panel, t grafana.TimeRange) (grafana.PanelData, error) {
+	return grafana.PanelData{}, nil
+}
+
+func (m *mockGrafanaClient) CreateAnnotation(a grafana.Annotation) error {
+	return nil
+}
+
+func (m *mockGrafanaClient) GetHealth() (grafana.Health, error) {
+	return grafana.Health{Version: "9.5.2", Commit: "abc123"}, nil
+}
+
 func TestReport(t *testing.T) {
 	Convey("When generating a report", t, func() {
 		variables := url.Values{}
 		variables.Add("var-test", "testvarvalue")
 		gClient := &mockGrafanaClient{0, variables}
-		rep := new(gClient, "testDash", grafana.TimeRange{"1453206447000", "1453213647000"}, "")
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", Options{})
 		defer rep.Clean()
 
 		Convey("When rendering images", func() {
@@ -91,7 +120,7 @@ func TestReport(t *testing.T) {
 			})
 
 			Convey("It shoud call getPanelPng once per panel", func() {
-				So(gClient.getPanelCallCount, ShouldEqual, 9)
+				So(atomic.LoadInt32(&gClient.getPanelCallCount), ShouldEqual, 9)
 			})
 
 			Convey("It should create one file per panel", func() {
@@ -105,7 +134,7 @@ func TestReport(t *testing.T) {
 
 		Convey("When genereting the Tex file", func() {
 			dashboard, _ := gClient.GetDashboard("")
-			rep.generateTeXFile(dashboard)
+			rep.generateTeXFile(dashboard, nil, nil)
 			f, err := os.Open(rep.texPath())
 			defer f.Close()
 
@@ -173,11 +202,31 @@ func (e *errClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.Time
 	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
 }
 
+func (e *errClient) GetPlaylist(id string) (grafana.Playlist, error) {
+	return grafana.Playlist{}, nil
+}
+
+func (e *errClient) SearchDashboards(query url.Values) ([]grafana.DashboardRef, error) {
+	return nil, nil
+}
+
+func (e *errClient) GetPanelData(p grafana.Panel, t grafana.TimeRange) (grafana.PanelData, error) {
+	return grafana.PanelData{}, nil
+}
+
+func (e *errClient) CreateAnnotation(a grafana.Annotation) error {
+	return nil
+}
+
+func (e *errClient) GetHealth() (grafana.Health, error) {
+	return grafana.Health{}, errors.New("health check failed")
+}
+
 func TestReportErrorHandling(t *testing.T) {
 	Convey("When generating a report where one panels gives an error", t, func() {
 		variables := url.Values{}
 		gClient := &errClient{0, variables}
-		rep := new(gClient, "testDash", grafana.TimeRange{"1453206447000", "1453213647000"}, "")
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", Options{})
 		defer rep.Clean()
 
 		Convey("When rendering images", func() {
@@ -211,3 +260,375 @@ func TestReportErrorHandling(t *testing.T) {
 	})
 
 }
+
+func TestPathologicalTitlesCompile(t *testing.T) {
+	if _, err := exec.LookPath("pdflatex"); err != nil {
+		t.Skip("pdflatex not installed")
+	}
+	Convey("When panel titles are pathological", t, func() {
+		titles := []string{
+			strings.Repeat("team=core;service=api;", 20),
+			`x_1^2 $5 < y > z | w`,
+			`\input{/etc/passwd} & 100% #1 ~`,
+		}
+		const titleTemplate = `\documentclass{article}
+\usepackage[hidelinks]{hyperref}
+\begin{document}
+[[range .Panels]]\section{\texorpdfstring{[[.Title]]}{[[.PlainTitle]]}}
+[[end]]\end{document}
+`
+		for i, title := range titles {
+			Convey(fmt.Sprintf("The report with title %d should compile", i), func() {
+				titledJSON, _ := json.Marshal(map[string]interface{}{
+					"Dashboard": map[string]interface{}{"Title": title, "Panels": []map[string]interface{}{{"Id": 1, "Title": title}}},
+				})
+				rep := new(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, titleTemplate, Options{})
+				defer rep.Clean()
+				So(rep.generateTeXFile(grafana.NewDashboard(titledJSON, url.Values{}), nil, nil), ShouldBeNil)
+				pdf, _, err := rep.runLaTeX()
+				So(err, ShouldBeNil)
+				pdf.Close()
+			})
+		}
+	})
+}
+
+// concurrencyClient records the largest number of concurrent panel renders
+type concurrencyClient struct {
+	mockGrafanaClient
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (c *concurrencyClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	c.mu.Lock()
+	c.current++
+	if c.current > c.max {
+		c.max = c.current
+	}
+	c.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	c.current--
+	c.mu.Unlock()
+	return ioutil.NopCloser(bytes.NewBuffer([]byte("Not actually a png"))), nil
+}
+
+func TestRenderWorkers(t *testing.T) {
+	Convey("When rendering the panels with a number of workers", t, func() {
+		gClient := &concurrencyClient{}
+		dashboard, _ := gClient.GetDashboard("")
+
+		Convey("A single worker should render one panel at a time", func() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Workers: 1})
+			defer rep.Clean()
+			So(rep.renderPNGsParallel(dashboard), ShouldBeNil)
+			So(gClient.max, ShouldEqual, 1)
+		})
+
+		Convey("The default should render up to DefaultWorkers panels at a time", func() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			defer rep.Clean()
+			So(rep.renderPNGsParallel(dashboard), ShouldBeNil)
+			So(gClient.max, ShouldBeBetweenOrEqual, 2, DefaultWorkers)
+		})
+	})
+}
+
+// cancelingClient cancels the report once the first panel is requested, like a client disconnecting during rendering
+type cancelingClient struct {
+	mockGrafanaClient
+	cancel context.CancelFunc
+}
+
+func (c *cancelingClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	c.cancel()
+	return c.mockGrafanaClient.GetPanelPng(p, dashName, t)
+}
+
+func TestCanceledReport(t *testing.T) {
+	Convey("When a report is canceled", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		gClient := &cancelingClient{cancel: cancel}
+
+		Convey("No further panels should be requested", func() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Workers: 1, Context: ctx})
+			defer rep.Clean()
+			_, err := rep.Generate()
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+			So(atomic.LoadInt32(&gClient.getPanelCallCount), ShouldEqual, 1)
+			var abortedErr *AbortedError
+			So(errors.As(err, &abortedErr), ShouldBeTrue)
+			So(abortedErr.Phase, ShouldEqual, PhaseRendering)
+		})
+
+		Convey("LaTeX should be given up without its final pass", func() {
+			cancel()
+			passes := 0
+			defer stubPdfLaTeX(func(dir string, args []string) error {
+				passes++
+				return errors.New("signal: killed")
+			})()
+			_, err := compileTeX(ctx, t.TempDir(), "report.tex")
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+			So(passes, ShouldEqual, 1)
+		})
+
+		Convey("A passed deadline should abort LaTeX without being reported as a LaTeX timeout", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+			defer cancel()
+			defer stubPdfLaTeX(func(dir string, args []string) error {
+				return errors.New("signal: killed")
+			})()
+			_, err := compileTeX(ctx, t.TempDir(), "report.tex")
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			var latexErr *LaTeXError
+			So(errors.As(err, &latexErr), ShouldBeFalse)
+		})
+	})
+}
+
+func TestRenderSpeedup(t *testing.T) {
+	Convey("When rendering 20 panels that each take 100ms with 5 workers", t, func() {
+		g := &latencyClient{panels: 20, delay: 100 * time.Millisecond}
+		dash, _ := g.GetDashboard("")
+		rep := new(g, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Workers: 5})
+		defer rep.Clean()
+		start := time.Now()
+		So(rep.renderPNGsParallel(dash), ShouldBeNil)
+
+		Convey("They should render in parallel, well under the 2s of rendering one after the other", func() {
+			//ideally 400ms, the bound leaves room for slow test machines
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+}
+
+func TestDegradedReport(t *testing.T) {
+	Convey("When generating a reduced-quality report", t, func() {
+		defer stubCompiler(1)()
+		rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Degraded: "Reduced quality because of 100% load."})
+		defer rep.Clean()
+		pdf, err := rep.Generate()
+		So(err, ShouldBeNil)
+		defer pdf.Close()
+
+		Convey("The cover should note why", func() {
+			b, _ := ioutil.ReadAll(pdf)
+			So(string(b), ShouldContainSubstring, `\textbf{Warning:} Reduced quality because of 100\% load.`)
+		})
+	})
+}
+
+// unreachableDashClient fails to fetch dashboards
+type unreachableDashClient struct {
+	mockGrafanaClient
+}
+
+func (m *unreachableDashClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
+	return grafana.Dashboard{}, errors.New("dashboard fetched again")
+}
+
+func TestFetchedDashboard(t *testing.T) {
+	Convey("When generating a report from a dashboard the caller already fetched", t, func() {
+		defer stubCompiler(1)()
+		dash := grafana.NewDashboard([]byte(dashJSON), url.Values{})
+		dash.Title = "Fetched by the caller"
+		rep := new(&unreachableDashClient{}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Dashboard: &dash})
+		defer rep.Clean()
+		pdf, err := rep.Generate()
+		So(err, ShouldBeNil)
+		defer pdf.Close()
+
+		Convey("The report should use it instead of fetching the dashboard", func() {
+			b, _ := ioutil.ReadAll(pdf)
+			So(string(b), ShouldContainSubstring, "Fetched by the caller")
+		})
+	})
+}
+
+func TestTmpDir(t *testing.T) {
+	Convey("When creating the build directory of reports", t, func() {
+		root, err := ioutil.TempDir("", "tmproot")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		defer func(orig string) { TmpRoot = orig }(TmpRoot)
+		TmpRoot = filepath.Join(root, "tmp")
+		gClient := &mockGrafanaClient{}
+
+		Convey("Each report should get its own directory under the root, recorded once created", func() {
+			a := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			b := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			So(a.tmpDir, ShouldBeEmpty)
+			So(a.makeTmpDir(), ShouldBeNil)
+			So(b.makeTmpDir(), ShouldBeNil)
+			So(a.tmpDir, ShouldNotEqual, b.tmpDir)
+			So(filepath.Dir(a.tmpDir), ShouldEqual, TmpRoot)
+			_, err := os.Stat(a.imgDirPath())
+			So(err, ShouldBeNil)
+
+			Convey("Creating it again should keep the directory", func() {
+				dir := a.tmpDir
+				So(a.makeTmpDir(), ShouldBeNil)
+				So(a.tmpDir, ShouldEqual, dir)
+			})
+
+			Convey("Clean should remove only the report's directory", func() {
+				a.Clean()
+				_, err := os.Stat(a.tmpDir)
+				So(os.IsNotExist(err), ShouldBeTrue)
+				_, err = os.Stat(b.tmpDir)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("Cleaning a report without a directory should do nothing", func() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			rep.Clean()
+			_, err := os.Stat(root)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("A directory that already exists should fail the report rather than be shared", func() {
+			other := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			So(other.makeTmpDir(), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(other.imgDirPath(), "image1.png"), []byte("other report"), 0644), ShouldBeNil)
+			defer func(orig func(string, string) (string, error)) { mkdirTemp = orig }(mkdirTemp)
+			mkdirTemp = func(string, string) (string, error) { return other.tmpDir, nil }
+
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			dash, _ := gClient.GetDashboard("")
+			err := rep.renderPNGsParallel(dash)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "already exists")
+			So(rep.tmpDir, ShouldBeEmpty)
+			content, err := ioutil.ReadFile(filepath.Join(other.imgDirPath(), "image1.png"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "other report")
+		})
+
+		Convey("A section directory that already exists should fail a combined report", func() {
+			multiClient := &multiDashClient{dashboards: map[string]string{"uidA": `{"Dashboard":{"Title":"First dash","Panels":[{"Type":"graph","Id":1}]}}`}}
+			rep := newMulti(multiClient, "combined", []string{"uidA"}, nil, grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			defer rep.Clean()
+			So(rep.makeTmpDir(), ShouldBeNil)
+			So(os.Mkdir(filepath.Join(rep.tmpDir, "dash0"), 0777), ShouldBeNil)
+			_, err := rep.Generate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "already exists")
+		})
+	})
+}
+
+// nowDelayClient serves a dashboard whose time picker delays now, recording the ranges panels are rendered for
+type nowDelayClient struct {
+	mockGrafanaClient
+	nowDelay string
+	rendered []grafana.TimeRange
+}
+
+func (m *nowDelayClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
+	return grafana.NewDashboard([]byte(`{"dashboard":{"title":"Delayed","timepicker":{"nowDelay":"`+m.nowDelay+`"},"panels":[{"type":"graph","id":1}]}}`), nil), nil
+}
+
+func (m *nowDelayClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	m.rendered = append(m.rendered, t)
+	return m.mockGrafanaClient.GetPanelPng(p, dashName, t)
+}
+
+func TestNowDelay(t *testing.T) {
+	Convey("When generating a report of a dashboard with a now delay", t, func() {
+		defer stubCompiler(1)()
+		generate := func(g *nowDelayClient, t grafana.TimeRange, opts Options) string {
+			rep := new(g, "testDash", t, "", opts)
+			defer rep.Clean()
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			defer pdf.Close()
+			b, _ := ioutil.ReadAll(pdf)
+			return string(b)
+		}
+
+		Convey("A range ending now should end earlier by the delay, noted on the cover", func() {
+			g := &nowDelayClient{nowDelay: "1m"}
+			tex := generate(g, grafana.TimeRange{From: "now-6h", To: "now"}, Options{})
+			So(g.rendered[0].To, ShouldEqual, "now-1m")
+			So(tex, ShouldContainSubstring, "Excluding the last 1m of possibly incomplete data")
+		})
+
+		Convey("An absolute range should be kept", func() {
+			g := &nowDelayClient{nowDelay: "1m"}
+			tex := generate(g, grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, Options{})
+			So(g.rendered[0].To, ShouldEqual, "1453213647000")
+			So(tex, ShouldNotContainSubstring, "Excluding the last")
+		})
+
+		Convey("Dashboards without a delay should render up to now", func() {
+			g := &nowDelayClient{}
+			generate(g, grafana.TimeRange{From: "now-6h", To: "now"}, Options{})
+			So(g.rendered[0].To, ShouldEqual, "now")
+		})
+
+		Convey("The delay should be ignored when disabled", func() {
+			g := &nowDelayClient{nowDelay: "1m"}
+			tex := generate(g, grafana.TimeRange{From: "now-6h", To: "now"}, Options{IgnoreNowDelay: true})
+			So(g.rendered[0].To, ShouldEqual, "now")
+			So(tex, ShouldNotContainSubstring, "Excluding the last")
+		})
+	})
+}
+
+// agedImage is a panel image rendered age ago
+type agedImage struct {
+	io.ReadCloser
+	age time.Duration
+}
+
+func (a agedImage) RenderAge() time.Duration {
+	return a.age
+}
+
+// staleClient serves the images of the panels in stale from a cache three hours old
+type staleClient struct {
+	mockGrafanaClient
+	stale map[int]bool
+}
+
+func (c *staleClient) GetPanelPng(p grafana.Panel, dashName string, t grafana.TimeRange) (io.ReadCloser, error) {
+	body, _ := c.mockGrafanaClient.GetPanelPng(p, dashName, t)
+	if c.stale[p.Id] {
+		return agedImage{body, 3 * time.Hour}, nil
+	}
+	return agedImage{body, time.Second}, nil
+}
+
+func TestStaleImages(t *testing.T) {
+	Convey("When the renderer serves some panel images from an old cache", t, func() {
+		defer stubCompiler(1)()
+		defer func(d time.Duration) { MaxRenderAge = d }(MaxRenderAge)
+		generate := func() string {
+			rep := new(&staleClient{stale: map[int]bool{22: true}}, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			defer rep.Clean()
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			defer pdf.Close()
+			b, _ := ioutil.ReadAll(pdf)
+			return string(b)
+		}
+
+		Convey("Images older than MaxRenderAge should be captioned and counted on the cover", func() {
+			MaxRenderAge = 5 * time.Minute
+			tex := generate()
+			So(strings.Count(tex, "Possibly outdated: the image was rendered 3h0m0s before the report."), ShouldEqual, 1)
+			So(tex, ShouldContainSubstring, `\textbf{Warning:} 1 panel image was served from a cache`)
+		})
+
+		Convey("Nothing should be flagged while the check is disabled", func() {
+			MaxRenderAge = 0
+			tex := generate()
+			So(tex, ShouldNotContainSubstring, "Possibly outdated")
+		})
+	})
+}
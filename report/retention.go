@@ -0,0 +1,56 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// retentionWarnings captions the panels of dash that query data sources whose retention ends after the
+// start of the report's time range, and returns one cover page warning per affected data source
+func (rep *report) retentionWarnings(dash *grafana.Dashboard, now time.Time) []string {
+	affected := map[string]bool{}
+	for i, p := range dash.Panels {
+		exceeded := rep.options.Retention.Exceeded(p, rep.time, now)
+		if len(exceeded) == 0 {
+			continue
+		}
+		for _, ds := range exceeded {
+			affected[ds] = true
+		}
+		dash.Panels[i].Warning = grafana.SanitizeLaTexInput(fmt.Sprintf("Incomplete data: the report starts before the retention of %v.", strings.Join(exceeded, ", ")))
+	}
+
+	names := make([]string, 0, len(affected))
+	for ds := range affected {
+		names = append(names, ds)
+	}
+	sort.Strings(names)
+
+	var warnings []string
+	for _, ds := range names {
+		warnings = append(warnings, grafana.SanitizeLaTexInput(fmt.Sprintf(
+			"The report starts before the %v retention of data source %v. Panels using it show no data for the start of the report.",
+			grafana.FormatRetention(rep.options.Retention[ds]), ds)))
+	}
+	return warnings
+}
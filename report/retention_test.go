@@ -0,0 +1,77 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const retentionDashJSON = `
+{"Dashboard":
+	{
+		"Title":"Retention",
+		"Panels":[
+			{"Type":"graph", "Id":1, "Datasource":"prom"},
+			{"Type":"graph", "Id":2, "Datasource":{"type":"loki","uid":"loki"}},
+			{"Type":"graph", "Id":3, "Datasource":"graphite"}
+		]
+	}
+}`
+
+func TestRetentionWarnings(t *testing.T) {
+	Convey("When a report has data source retention configured", t, func() {
+		now := time.Date(2018, 3, 15, 12, 0, 0, 0, time.UTC)
+		retention := grafana.Retention{"prom": 30 * 24 * time.Hour, "loki": 7 * 24 * time.Hour}
+
+		cases := []struct {
+			desc      string
+			from      string
+			retention grafana.Retention
+			warnings  int
+			captioned []bool
+		}{
+			{"a range within all retentions", "now-1d", retention, 0, []bool{false, false, false}},
+			{"a range exceeding one retention", "now-14d", retention, 1, []bool{false, true, false}},
+			{"a range exceeding all retentions", "now-90d", retention, 2, []bool{true, true, false}},
+			{"no retention configured", "now-90d", nil, 0, []bool{false, false, false}},
+		}
+		for _, c := range cases {
+			Convey("It should warn correctly for "+c.desc, func() {
+				rep := new(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{From: c.from, To: "now"}, "", Options{Retention: c.retention})
+				dash := grafana.NewDashboard([]byte(retentionDashJSON), url.Values{})
+				warnings := rep.retentionWarnings(&dash, now)
+				So(warnings, ShouldHaveLength, c.warnings)
+				for i, p := range dash.Panels {
+					So(p.Warning != "", ShouldEqual, c.captioned[i])
+				}
+			})
+		}
+
+		Convey("The cover page warning should name the data source and its retention", func() {
+			rep := new(&mockGrafanaClient{0, url.Values{}}, "testDash", grafana.TimeRange{From: "now-90d", To: "now"}, "", Options{Retention: retention})
+			dash := grafana.NewDashboard([]byte(retentionDashJSON), url.Values{})
+			warnings := rep.retentionWarnings(&dash, now)
+			So(warnings[1], ShouldContainSubstring, "30d retention of data source prom")
+			So(dash.Panels[0].Warning, ShouldContainSubstring, "prom")
+		})
+	})
+}
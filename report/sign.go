@@ -0,0 +1,185 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// SigningKey signs the reports requested with Options.Sign, nil when signing is not configured. See LoadSigningKey
+var SigningKey crypto.Signer
+
+// Signature algorithms, as named by SignatureAlgorithm
+const (
+	SignatureEd25519 = "Ed25519"
+	SignatureRSA     = "RSA-PKCS1v15-SHA256"
+)
+
+// LoadSigningKey reads an Ed25519 or RSA private key from a PEM file, in PKCS #8 or, for RSA, PKCS #1 form
+func LoadSigningKey(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key %v: %v", file, err)
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *rsa.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("signing key %v is a %T, expected an Ed25519 or RSA key", file, key)
+}
+
+// LoadPublicKey reads an Ed25519 or RSA public key from a PEM file, in PKIX or, for RSA, PKCS #1 form
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing public key %v: %v", file, err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *rsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("public key %v is a %T, expected an Ed25519 or RSA key", file, key)
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %v", file)
+	}
+	return block, nil
+}
+
+// SignatureAlgorithm names the algorithm of the key's signatures, see SignatureEd25519 and SignatureRSA
+func SignatureAlgorithm(key crypto.PublicKey) string {
+	if _, ok := key.(ed25519.PublicKey); ok {
+		return SignatureEd25519
+	}
+	return SignatureRSA
+}
+
+// Sign returns the detached signature of the data read from r: an Ed25519 signature of the data,
+// or an RSA PKCS #1 v1.5 signature of its SHA-256 digest, which openssl dgst -sha256 -verify checks
+func Sign(key crypto.Signer, r io.Reader) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		//Ed25519 signs the whole message rather than a digest
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+}
+
+// ErrBadSignature is returned by Verify when the signature does not match the data
+var ErrBadSignature = errors.New("signature does not match the report")
+
+// Verify checks a signature made by Sign of the data read from r against the public key
+func Verify(key crypto.PublicKey, r io.Reader, sig []byte) error {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(k, data, sig) {
+			return ErrBadSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, h.Sum(nil), sig) != nil {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key %T", key)
+}
+
+// signingKey returns the key signing the report, nil for unsigned reports
+func (rep *report) signingKey() crypto.Signer {
+	if !rep.options.Sign {
+		return nil
+	}
+	return SigningKey
+}
+
+// signFile returns the signature of the file at path
+func signFile(key crypto.Signer, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Sign(key, f)
+}
+
+// SignReader returns the signature of a report that can be rewound, and rewinds it
+func SignReader(key crypto.Signer, report io.ReadSeeker) ([]byte, error) {
+	if _, err := report.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sig, err := Sign(key, report)
+	if _, seekErr := report.Seek(0, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	return sig, err
+}
@@ -0,0 +1,116 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeKeyPair writes the key and its public key as PEM files to dir and returns their paths
+func writeKeyPair(dir string, name string, key crypto.Signer, pkcs1 bool) (private string, public string) {
+	var privBlock, pubBlock *pem.Block
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok && pkcs1 {
+		privBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+		pubBlock = &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		So(err, ShouldBeNil)
+		privBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		der, err = x509.MarshalPKIXPublicKey(key.Public())
+		So(err, ShouldBeNil)
+		pubBlock = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+	private, public = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".pub.pem")
+	So(ioutil.WriteFile(private, pem.EncodeToMemory(privBlock), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(public, pem.EncodeToMemory(pubBlock), 0644), ShouldBeNil)
+	return private, public
+}
+
+func TestSign(t *testing.T) {
+	Convey("When reports are signed", t, func() {
+		dir := t.TempDir()
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		pdf := "%PDF-1.5 the report"
+
+		keys := []struct {
+			name      string
+			key       crypto.Signer
+			pkcs1     bool
+			algorithm string
+		}{
+			{"ed25519", edKey, false, SignatureEd25519},
+			{"rsa-pkcs8", rsaKey, false, SignatureRSA},
+			{"rsa-pkcs1", rsaKey, true, SignatureRSA},
+		}
+		for _, k := range keys {
+			Convey("With a "+k.name+" key", func() {
+				privFile, pubFile := writeKeyPair(dir, k.name, k.key, k.pkcs1)
+				key, err := LoadSigningKey(privFile)
+				So(err, ShouldBeNil)
+				pub, err := LoadPublicKey(pubFile)
+				So(err, ShouldBeNil)
+				So(SignatureAlgorithm(pub), ShouldEqual, k.algorithm)
+
+				sig, err := Sign(key, strings.NewReader(pdf))
+				So(err, ShouldBeNil)
+
+				Convey("The signature should verify against the public key", func() {
+					So(Verify(pub, strings.NewReader(pdf), sig), ShouldBeNil)
+				})
+
+				Convey("A changed report should not verify", func() {
+					So(Verify(pub, strings.NewReader(pdf+" "), sig), ShouldEqual, ErrBadSignature)
+				})
+			})
+		}
+
+		Convey("Keys of other types should be rejected", func() {
+			ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			So(err, ShouldBeNil)
+			privFile, pubFile := writeKeyPair(dir, "ecdsa", ecKey, false)
+			_, err = LoadSigningKey(privFile)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "expected an Ed25519 or RSA key")
+			_, err = LoadPublicKey(pubFile)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Files without a PEM key should be rejected", func() {
+			file := filepath.Join(dir, "key.pem")
+			So(ioutil.WriteFile(file, []byte("not a key"), 0600), ShouldBeNil)
+			_, err := LoadSigningKey(file)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no PEM data")
+		})
+	})
+}
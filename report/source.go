@@ -0,0 +1,104 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sourceArchive packs the report's build directory, i.e. the TeX file and the images it includes by relative path, into a tar.gz.
+// Unpacked anywhere, it compiles with a plain pdflatex of the TeX file. The archive is deleted by Clean.
+func (rep *report) sourceArchive() (*os.File, error) {
+	path := filepath.Join(rep.tmpDir, rep.job()+"-source.tar.gz")
+	f, err := createTmpFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating source archive: %w", err)
+	}
+	err = writeSourceArchive(f, rep.tmpDir, path)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing source archive: %w", err)
+	}
+	return f, nil
+}
+
+// writeSourceArchive writes the files in dir, except the archive at skip, as a tar.gz with paths relative to dir
+func writeSourceArchive(w io.Writer, dir string, skip string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir || path == skip || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		//owners of the build machine mean nothing where the archive is unpacked
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// generateSource returns the source archive in place of the pdf
+func (rep *report) generateSource(panels int, start time.Time) (io.ReadCloser, error) {
+	file, err := rep.sourceArchive()
+	if err != nil {
+		return nil, err
+	}
+	rep.recordStats(file, 0, panels, start)
+	return file, nil
+}
@@ -0,0 +1,126 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// unpackSource extracts a source archive into dir, returning the names of its entries
+func unpackSource(r io.Reader, dir string) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, hdr.Name)
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(path, 0777); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestSourceArchive(t *testing.T) {
+	Convey("When generating the source of a report", t, func() {
+		compiled := false
+		orig := compileTeX
+		defer func() { compileTeX = orig }()
+		compileTeX = func(_ context.Context, dir string, texFile string) ([]byte, error) {
+			compiled = true
+			return nil, nil
+		}
+		gClient := &jpegClient{}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Source: true})
+		defer rep.Clean()
+		archive, err := rep.Generate()
+		So(err, ShouldBeNil)
+		defer archive.Close()
+		dir, err := ioutil.TempDir("", "source")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		names, err := unpackSource(archive, dir)
+		So(err, ShouldBeNil)
+
+		Convey("LaTeX should not be run", func() {
+			So(compiled, ShouldBeFalse)
+		})
+
+		Convey("It should hold the TeX file and every panel image at relative paths", func() {
+			So(names, ShouldContain, "report.tex")
+			So(names, ShouldContain, "images/")
+			So(names, ShouldContain, "images/image1.png")
+			So(names, ShouldContain, "images/image22.jpg")
+			So(names, ShouldNotContain, "report-source.tar.gz")
+			tex, err := ioutil.ReadFile(filepath.Join(dir, "report.tex"))
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `\graphicspath{ {images/} }`)
+			So(string(tex), ShouldNotContainSubstring, rep.tmpDir)
+		})
+
+		Convey("The stats should describe the archive", func() {
+			So(rep.Stats().Bytes, ShouldBeGreaterThan, 0)
+			So(rep.Stats().Pages, ShouldEqual, 0)
+		})
+
+		Convey("It should compile with a plain pdflatex elsewhere", func() {
+			if _, err := exec.LookPath("pdflatex"); err != nil {
+				SkipSo("pdflatex is not installed")
+				return
+			}
+			cmd := exec.Command("pdflatex", "-halt-on-error", "-interaction", "nonstopmode", "report.tex")
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			So(err, ShouldBeNil)
+			So(string(out), ShouldContainSubstring, "Output written")
+			_, err = os.Stat(filepath.Join(dir, "report.pdf"))
+			So(err, ShouldBeNil)
+		})
+	})
+}
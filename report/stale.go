@@ -0,0 +1,81 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// MaxRenderAge is the age above which a panel image counts as served from a stale cache rather than rendered for the report.
+// Such panels are captioned with a warning. 0 disables the check.
+var MaxRenderAge time.Duration
+
+// staleImages holds the render ages of the panel images older than MaxRenderAge, by panel id.
+// Shared by the volumes of a report, which are compiled from the same images.
+type staleImages struct {
+	mu   sync.Mutex
+	ages map[int]time.Duration
+}
+
+// noteRenderAge records the panel's image as stale when the renderer's response was older than MaxRenderAge
+func (rep *report) noteRenderAge(p grafana.Panel, img interface{}) {
+	ager, ok := img.(grafana.RenderAger)
+	if MaxRenderAge <= 0 || !ok || ager.RenderAge() <= MaxRenderAge || rep.stale == nil {
+		return
+	}
+	log.Printf("Panel %v was rendered %v ago, more than the allowed %v", p.Id, ager.RenderAge(), MaxRenderAge)
+	rep.stale.mu.Lock()
+	defer rep.stale.mu.Unlock()
+	if rep.stale.ages == nil {
+		rep.stale.ages = map[int]time.Duration{}
+	}
+	rep.stale.ages[p.Id] = ager.RenderAge()
+}
+
+// staleWarnings captions the panels whose images were stale and returns a warning for the cover, if any were
+func (rep *report) staleWarnings(dash *grafana.Dashboard) []string {
+	if rep.stale == nil {
+		return nil
+	}
+	rep.stale.mu.Lock()
+	defer rep.stale.mu.Unlock()
+	if len(rep.stale.ages) == 0 {
+		return nil
+	}
+	for i, p := range dash.Panels {
+		age, ok := rep.stale.ages[p.Id]
+		if !ok {
+			continue
+		}
+		warning := grafana.SanitizeLaTexInput(fmt.Sprintf("Possibly outdated: the image was rendered %v before the report.", age.Round(time.Second)))
+		if dash.Panels[i].Warning != "" {
+			warning = dash.Panels[i].Warning + " " + warning
+		}
+		dash.Panels[i].Warning = warning
+	}
+	images := fmt.Sprintf("%d panel images were", len(rep.stale.ages))
+	if len(rep.stale.ages) == 1 {
+		images = "1 panel image was"
+	}
+	return []string{grafana.SanitizeLaTexInput(fmt.Sprintf(
+		"%s served from a cache, rendered more than %v before the report. They may not show the report's time range.", images, MaxRenderAge))}
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Stats summarizes a generated report, e.g. for showing it before the download
+type Stats struct {
+	Pages    int   //total over all volumes, 0 when the LaTeX output has no page count
+	Panels   int   //panels rendered into the report
+	Bytes    int64 //size of the pdf, or of the zip of its volumes
+	Duration time.Duration
+	//size of the build directory once the report was generated: the panel images, TeX and LaTeX's aux files and the output
+	BuildBytes int64
+}
+
+// StatsReporter is implemented by reports that can summarize themselves after Generate
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Stats returns the summary of the last Generate, the zero value before
+func (rep *report) Stats() Stats {
+	return rep.stats
+}
+
+// recordStats stores the summary of the generated file
+func (rep *report) recordStats(file *os.File, pages int, panels int, start time.Time) {
+	rep.stats = Stats{Pages: pages, Panels: panels, Duration: time.Since(start)}
+	if info, err := file.Stat(); err == nil {
+		rep.stats.Bytes = info.Size()
+	} else {
+		log.Println("Error reading report size:", err)
+	}
+	if size, err := dirSize(rep.tmpDir); err == nil {
+		rep.stats.BuildBytes = size
+	} else {
+		log.Println("Error reading build directory size:", err)
+	}
+	log.Printf("Generated report: %+v", rep.stats)
+}
@@ -0,0 +1,98 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultStyle is the built-in style used when a request does not select one
+const DefaultStyle = "classic"
+
+//go:embed styles/*.tex
+var styleFS embed.FS
+
+// defaultTemplate replaces the default style for requests that select neither a template nor a style.
+// defaultTemplateDir is the directory of its file, from which it may include files. Set by LoadDefaultTemplate.
+var defaultTemplate, defaultTemplateDir string
+
+// defaultTemplateParams are the parameters declared by defaultTemplate
+var defaultTemplateParams TemplateParams
+
+// DefaultTemplateParams returns the parameters declared by the template loaded with LoadDefaultTemplate, if any
+func DefaultTemplateParams() TemplateParams {
+	return defaultTemplateParams
+}
+
+// LoadDefaultTemplate reads the TeX template file at path and uses it instead of the default style.
+// The file is checked as ValidateTemplate does. On error the previous default is kept.
+func LoadDefaultTemplate(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	tex, err := NormalizeTemplate(content)
+	if err != nil {
+		return fmt.Errorf("default template %v: %w", file, err)
+	}
+	dir := filepath.Dir(file)
+	if err := ValidateTemplate(tex, Options{TemplateDir: dir}); err != nil {
+		return fmt.Errorf("default template %v: %w", file, err)
+	}
+	params, err := ParseTemplateParams(tex)
+	if err != nil {
+		return fmt.Errorf("default template %v: %w", file, err)
+	}
+	defaultTemplate, defaultTemplateDir, defaultTemplateParams = tex, dir, params
+	return nil
+}
+
+// Styles returns the names of the built-in report styles, sorted
+func Styles() []string {
+	entries, err := styleFS.ReadDir("styles")
+	if err != nil {
+		log.Println("Error listing report styles:", err)
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".tex"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// styleTemplate returns the TeX template of the named built-in style.
+// Empty and unknown names fall back to the default style, the latter with a warning.
+func styleTemplate(name string) string {
+	if name == "" {
+		name = DefaultStyle
+	}
+	b, err := styleFS.ReadFile(path.Join("styles", name+".tex"))
+	if err != nil {
+		log.Printf("Unknown report style %q, using %v", name, DefaultStyle)
+		b, _ = styleFS.ReadFile(path.Join("styles", DefaultStyle+".tex"))
+	}
+	return string(b)
+}
@@ -0,0 +1,180 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStyles(t *testing.T) {
+	Convey("The built-in styles should be listed", t, func() {
+		So(Styles(), ShouldResemble, []string{"classic", "compact", "executive"})
+	})
+
+	Convey("When rendering each built-in style", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		dashboard, _ := gClient.GetDashboard("")
+		summaries := []Summary{{PanelId: 22, Title: "Requests", Series: "A", Min: 1, Max: 3, Mean: 2, Last: 3}}
+
+		for _, style := range Styles() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", Options{Style: style})
+			err := rep.generateTeXFile(dashboard, summaries, []string{"Old data"})
+			tex, _ := ioutil.ReadFile(rep.texPath())
+			rep.Clean()
+
+			Convey("The "+style+" style should include every panel", func() {
+				So(err, ShouldBeNil)
+				So(strings.Count(string(tex), `\includegraphics`), ShouldEqual, len(dashboard.Panels))
+				So(string(tex), ShouldContainSubstring, `\end{document}`)
+			})
+
+			Convey("The "+style+" style should include the summary and warnings", func() {
+				So(string(tex), ShouldContainSubstring, "Requests & A")
+				So(string(tex), ShouldContainSubstring, "Old data")
+			})
+		}
+	})
+
+	Convey("When rendering each built-in style with a glossary", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		dashboard := grafana.NewDashboard([]byte(`{"dashboard":{"title":"Glossary","templating":{"list":[
+			{"name":"host","label":"Host","type":"query","query":"label_values(host)","current":{"text":"db1","value":"db1"}},
+			{"name":"step","type":"interval","query":"1m,1h","current":{"text":"1h","value":"1h"}}]},"panels":[]}}`), url.Values{})
+
+		for _, style := range Styles() {
+			for _, show := range []bool{false, true} {
+				rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Style: style, Glossary: show})
+				err := rep.generateTeXFile(dashboard, nil, nil)
+				tex, _ := ioutil.ReadFile(rep.texPath())
+				rep.Clean()
+				So(err, ShouldBeNil)
+
+				if show {
+					Convey("The "+style+" style should describe every variable", func() {
+						So(string(tex), ShouldContainSubstring, `\section*{Variables}`)
+						So(string(tex), ShouldContainSubstring, `\item[{host}] Host, query variable. Value: db1. Defined by: label\_values(host).`)
+						So(string(tex), ShouldContainSubstring, `\item[{step}] interval variable. Value: 1h. Defined by: 1m,1h.`)
+					})
+				} else {
+					Convey("The "+style+" style should omit the glossary unless requested", func() {
+						So(string(tex), ShouldNotContainSubstring, `\section*{Variables}`)
+					})
+				}
+			}
+		}
+	})
+
+	Convey("When rendering each built-in style for a dashboard with skipped panels", t, func() {
+		gClient := &mockGrafanaClient{0, url.Values{}}
+		dashboard := grafana.NewDashboard([]byte(`{"dashboard":{"title":"Home","panels":[
+			{"type":"dashlist","id":1,"title":"Starred"},{"type":"news","id":2},{"type":"graph","id":3,"title":"CPU"}]}}`), url.Values{})
+
+		for _, style := range Styles() {
+			for _, note := range []bool{false, true} {
+				rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Style: style, NoteSkippedPanels: note})
+				err := rep.generateTeXFile(dashboard, nil, nil)
+				tex, _ := ioutil.ReadFile(rep.texPath())
+				rep.Clean()
+				So(err, ShouldBeNil)
+
+				if note {
+					Convey("The "+style+" style should note the skipped panels", func() {
+						So(string(tex), ShouldContainSubstring, `\section*{Panels left out}`)
+						So(string(tex), ShouldContainSubstring, `\item Starred (dashlist)`)
+						So(string(tex), ShouldContainSubstring, `\item Untitled panel (news)`)
+					})
+				} else {
+					Convey("The "+style+" style should not note them unless requested", func() {
+						So(string(tex), ShouldNotContainSubstring, `\section*{Panels left out}`)
+					})
+				}
+			}
+		}
+	})
+
+	Convey("When selecting a style", t, func() {
+		Convey("No style should use the classic style", func() {
+			So(styleTemplate(""), ShouldEqual, styleTemplate("classic"))
+		})
+
+		Convey("An unknown style should fall back to the classic style", func() {
+			So(styleTemplate("fancy"), ShouldEqual, styleTemplate("classic"))
+		})
+
+		Convey("A custom template should take precedence over the style", func() {
+			rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{}, "custom", Options{Style: "compact"})
+			So(rep.texTemplate, ShouldEqual, "custom")
+		})
+	})
+
+	Convey("When loading a default template file", t, func() {
+		dir, err := ioutil.TempDir("", "default-template")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer func() { defaultTemplate, defaultTemplateDir = "", "" }()
+		file := filepath.Join(dir, "house.tex")
+		So(ioutil.WriteFile(filepath.Join(dir, "logo.tex"), []byte("house logo"), 0644), ShouldBeNil)
+
+		Convey("Without one the classic style should be the default", func() {
+			rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{}, "", Options{})
+			So(rep.texTemplate, ShouldEqual, styleTemplate("classic"))
+		})
+
+		Convey("A valid file should replace the classic style", func() {
+			So(ioutil.WriteFile(file, []byte("\xef\xbb\xbfhouse [[.Title]]\r\n[[includeFile \"logo.tex\"]]"), 0644), ShouldBeNil)
+			So(LoadDefaultTemplate(file), ShouldBeNil)
+			rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{}, "", Options{})
+			So(rep.texTemplate, ShouldEqual, "house [[.Title]]\n[[includeFile \"logo.tex\"]]")
+			So(rep.trusted(), ShouldBeTrue)
+			So(rep.options.TemplateDir, ShouldEqual, dir)
+
+			Convey("It should be able to include files from its directory", func() {
+				dashboard, _ := (&mockGrafanaClient{}).GetDashboard("")
+				So(rep.generateTeXFile(dashboard, nil, nil), ShouldBeNil)
+				tex, _ := ioutil.ReadFile(rep.texPath())
+				rep.Clean()
+				So(string(tex), ShouldContainSubstring, "house logo")
+			})
+
+			Convey("A selected style should take precedence over it", func() {
+				rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{}, "", Options{Style: "compact"})
+				So(rep.texTemplate, ShouldEqual, styleTemplate("compact"))
+			})
+
+			Convey("A custom template should take precedence over it", func() {
+				rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{}, "custom", Options{})
+				So(rep.texTemplate, ShouldEqual, "custom")
+			})
+		})
+
+		Convey("An invalid file should be rejected and keep the classic style", func() {
+			So(ioutil.WriteFile(file, []byte("[[if .Title]]unterminated"), 0644), ShouldBeNil)
+			So(LoadDefaultTemplate(file), ShouldNotBeNil)
+			So(LoadDefaultTemplate(filepath.Join(dir, "missing.tex")), ShouldNotBeNil)
+			rep := new(&mockGrafanaClient{}, "testDash", grafana.TimeRange{}, "", Options{})
+			So(rep.texTemplate, ShouldEqual, styleTemplate("classic"))
+		})
+	})
+}
@@ -0,0 +1,126 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/IzakMarais/reporter/format"
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// Summary holds statistics of the first series of a panel over the report's time range.
+// Strings are sanitized for TeX consumption.
+type Summary struct {
+	PanelId int
+	Title   string
+	Series  string
+	Unit    string
+	Min     float64
+	Max     float64
+	Mean    float64
+	Last    float64
+
+	unit   string //the Grafana unit id Unit was sanitized from
+	locale format.Locale
+}
+
+// Format formats a statistic of the summary, scaled to its unit and with the separators of the report's locale, e.g. 1.5 GiB
+func (s Summary) Format(v float64) string {
+	return grafana.SanitizeLaTexInput(format.Value(v, s.unit, s.locale))
+}
+
+// summaries computes the summaries of the panels listed in options.Summary, in that order.
+// Panels whose data can't be fetched or summarized are skipped with a warning.
+func (rep *report) summaries(dash grafana.Dashboard) []Summary {
+	var sums []Summary
+	for _, id := range rep.options.Summary {
+		p, ok := findPanel(dash, id)
+		if !ok {
+			log.Printf("Warning: skipping summary of panel %v: no such panel", id)
+			continue
+		}
+		data, err := rep.gClient.GetPanelData(p, rep.time)
+		if err != nil {
+			log.Printf("Warning: skipping summary of panel %v: %v", id, err)
+			continue
+		}
+		s, err := summarize(p, data)
+		if err != nil {
+			log.Printf("Warning: skipping summary of panel %v: %v", id, err)
+			continue
+		}
+		s.locale = rep.options.Locale
+		sums = append(sums, s)
+	}
+	return sums
+}
+
+func findPanel(dash grafana.Dashboard, id int) (grafana.Panel, bool) {
+	for _, p := range dash.Panels {
+		if p.Id == id {
+			return p, true
+		}
+	}
+	return grafana.Panel{}, false
+}
+
+// summarize computes min, max, mean and last value of the first numeric field of a single frame result.
+// Null values are ignored. The unit is taken from the panel's field config, else from the data source.
+func summarize(p grafana.Panel, data grafana.PanelData) (Summary, error) {
+	if len(data.Frames) == 0 {
+		return Summary{}, errors.New("query returned no data")
+	}
+	if len(data.Frames) > 1 {
+		return Summary{}, fmt.Errorf("query returned %d frames, only single frame results can be summarized", len(data.Frames))
+	}
+
+	for _, f := range data.Frames[0].Fields {
+		if !f.IsNumeric() {
+			continue
+		}
+		s := Summary{PanelId: p.Id, Title: p.Title, Series: grafana.SanitizeLaTexInput(f.Name)}
+		s.unit = p.Unit()
+		if s.unit == "" {
+			s.unit = f.Unit
+		}
+		s.Unit = grafana.SanitizeLaTexInput(s.unit)
+
+		n, sum := 0, 0.0
+		s.Min, s.Max = math.Inf(1), math.Inf(-1)
+		for _, v := range f.Values {
+			x, ok := v.(float64)
+			if !ok {
+				continue
+			}
+			n++
+			sum += x
+			s.Min = math.Min(s.Min, x)
+			s.Max = math.Max(s.Max, x)
+			s.Last = x
+		}
+		if n == 0 {
+			return Summary{}, fmt.Errorf("series %v has no values", f.Name)
+		}
+		s.Mean = sum / float64(n)
+		return s, nil
+	}
+	return Summary{}, errors.New("query returned no numeric series")
+}
@@ -0,0 +1,163 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/IzakMarais/reporter/format"
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fixture responses of Grafana's /api/ds/query endpoint
+const (
+	promSingleSeries = `
+{"results":{"A":{"frames":[{
+	"schema":{"refId":"A","fields":[
+		{"name":"Time","type":"time"},
+		{"name":"Value","type":"number","labels":{"instance":"db1"},"config":{"unit":"reqps"}}]},
+	"data":{"values":[[1,2,3,4],[4,null,1,7]]}}]}}}`
+
+	promMultiSeries = `
+{"results":{"A":{"frames":[
+	{"schema":{"fields":[{"name":"Time","type":"time"},{"name":"Value","type":"number"}]},"data":{"values":[[1],[1]]}},
+	{"schema":{"fields":[{"name":"Time","type":"time"},{"name":"Value","type":"number"}]},"data":{"values":[[1],[2]]}}]}}}`
+
+	sqlTable = `
+{"results":{"A":{"frames":[{
+	"schema":{"refId":"A","fields":[
+		{"name":"time","type":"time"},
+		{"name":"host","type":"string"},
+		{"name":"cpu_load","type":"number"},
+		{"name":"mem","type":"number"}]},
+	"data":{"values":[[1,2,3],["a","b","c"],[0.25,0.75,0.5],[10,20,30]]}}]}}}`
+
+	sqlStrings = `
+{"results":{"A":{"frames":[{
+	"schema":{"fields":[{"name":"time","type":"time"},{"name":"status","type":"string"}]},
+	"data":{"values":[[1,2],["ok","failed"]]}}]}}}`
+)
+
+func summarizeFixture(p grafana.Panel, fixture string) (Summary, error) {
+	data, err := grafana.NewPanelData([]byte(fixture))
+	So(err, ShouldBeNil)
+	return summarize(p, data)
+}
+
+func TestSummarize(t *testing.T) {
+	Convey("When summarizing panel data", t, func() {
+		Convey("A Prometheus series should be summarized ignoring nulls", func() {
+			s, err := summarizeFixture(grafana.Panel{Id: 4, Title: "Requests"}, promSingleSeries)
+			So(err, ShouldBeNil)
+			So(s.PanelId, ShouldEqual, 4)
+			So(s.Title, ShouldEqual, "Requests")
+			So(s.Min, ShouldEqual, 1)
+			So(s.Max, ShouldEqual, 7)
+			So(s.Mean, ShouldEqual, 4)
+			So(s.Last, ShouldEqual, 7)
+
+			Convey("The unit should be taken from the data source when the panel has none", func() {
+				So(s.Unit, ShouldEqual, "reqps")
+				So(s.Format(s.Mean), ShouldEqual, "4 req/s")
+			})
+		})
+
+		Convey("The panel's unit should take precedence", func() {
+			p := grafana.Panel{Id: 4}
+			p.FieldConfig.Defaults.Unit = "ops_per_sec"
+			s, err := summarizeFixture(p, promSingleSeries)
+			So(err, ShouldBeNil)
+			So(s.Unit, ShouldEqual, `ops\_per\_sec`)
+			So(s.Format(s.Max), ShouldEqual, `7 ops\_per\_sec`)
+		})
+
+		Convey("The first numeric column of an SQL table should be summarized", func() {
+			s, err := summarizeFixture(grafana.Panel{Id: 5}, sqlTable)
+			So(err, ShouldBeNil)
+			So(s.Series, ShouldEqual, `cpu\_load`)
+			So(s.Min, ShouldEqual, 0.25)
+			So(s.Max, ShouldEqual, 0.75)
+			So(s.Mean, ShouldEqual, 0.5)
+			So(s.Last, ShouldEqual, 0.5)
+			So(s.Format(s.Max), ShouldEqual, "0.75")
+		})
+
+		Convey("Multi-frame results should return an error", func() {
+			_, err := summarizeFixture(grafana.Panel{Id: 4}, promMultiSeries)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Non-numeric results should return an error", func() {
+			_, err := summarizeFixture(grafana.Panel{Id: 6}, sqlStrings)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Empty results should return an error", func() {
+			_, err := summarize(grafana.Panel{Id: 6}, grafana.PanelData{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+type panelDataClient struct {
+	mockGrafanaClient
+	data map[int]string
+}
+
+func (m *panelDataClient) GetPanelData(p grafana.Panel, t grafana.TimeRange) (grafana.PanelData, error) {
+	fixture, ok := m.data[p.Id]
+	if !ok {
+		return grafana.PanelData{}, errors.New("query failed")
+	}
+	return grafana.NewPanelData([]byte(fixture))
+}
+
+func TestReportSummaries(t *testing.T) {
+	Convey("When a report summarizes several panels", t, func() {
+		gClient := &panelDataClient{mockGrafanaClient{0, url.Values{}}, map[int]string{1: promSingleSeries, 22: sqlStrings, 44: sqlTable}}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", Options{Summary: []int{44, 22, 33, 1000, 1}})
+		defer rep.Clean()
+		dash, _ := gClient.GetDashboard("testDash")
+
+		Convey("Panels that can be summarized should be in the requested order, the others skipped", func() {
+			sums := rep.summaries(dash)
+			So(sums, ShouldHaveLength, 2)
+			So(sums[0].PanelId, ShouldEqual, 44)
+			So(sums[1].PanelId, ShouldEqual, 1)
+		})
+
+		Convey("The default template should list the summaries in a table", func() {
+			err := rep.generateTeXFile(dash, []Summary{{Title: "Requests", Series: "Value", Unit: "reqps", Min: 1, Max: 7, Mean: 4, Last: 7, unit: "reqps"}}, nil)
+			So(err, ShouldBeNil)
+			tex, err := ioutil.ReadFile(rep.texPath())
+			So(err, ShouldBeNil)
+			So(string(tex), ShouldContainSubstring, `Requests & Value & 1 req/s & 7 req/s & 4 req/s & 7 req/s \\`)
+		})
+
+		Convey("The statistics should be written for the report's locale", func() {
+			rep.options.Locale = format.Locale{Decimal: ",", Group: "."}
+			sums := rep.summaries(dash)
+			So(sums[1].Format(sums[1].Mean), ShouldEqual, "4 req/s")
+			So(sums[1].Format(1234.6), ShouldEqual, "1.235 req/s")
+			So(sums[0].Format(sums[0].Mean), ShouldEqual, "0,5")
+		})
+	})
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// TemplateParams are the request parameters a template declares in its leading comments, e.g.
+//
+//	%% reporter: require extra-customer, require var-region, default panelsPerPage=2
+//
+// Declarations are separated by commas, so default values can't contain commas.
+// Only the comment lines before the first other line of the template are read.
+type TemplateParams struct {
+	Required []string   //in declaration order
+	Defaults url.Values //one value per parameter
+}
+
+// TemplateParamsError is returned for malformed declarations
+type TemplateParamsError struct {
+	Line int
+	Msg  string
+}
+
+func (e *TemplateParamsError) Error() string {
+	return fmt.Sprintf("invalid reporter declaration on line %d: %v", e.Line, e.Msg)
+}
+
+var (
+	declarationRegExp = regexp.MustCompile(`^%+\s*reporter:(.*)$`)
+	paramNameRegExp   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+)
+
+// undeclarableParams can't be required or defaulted by a template: the token is a credential,
+// and the template is selected before its declarations are read
+var undeclarableParams = map[string]bool{"apitoken": true, "tokenName": true, "template": true}
+
+// ParseTemplateParams reads the parameters declared by the template's leading reporter: comments
+func ParseTemplateParams(tex string) (TemplateParams, error) {
+	params := TemplateParams{Defaults: url.Values{}}
+	required := map[string]bool{}
+	for i, line := range strings.Split(tex, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "%") {
+			break
+		}
+		m := declarationRegExp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		fail := func(format string, args ...interface{}) (TemplateParams, error) {
+			return TemplateParams{}, &TemplateParamsError{i + 1, fmt.Sprintf(format, args...)}
+		}
+		for _, decl := range strings.Split(m[1], ",") {
+			decl = strings.TrimSpace(decl)
+			keyword, rest, _ := strings.Cut(decl, " ")
+			rest = strings.TrimSpace(rest)
+			switch keyword {
+			case "require":
+				if !paramNameRegExp.MatchString(rest) || undeclarableParams[rest] {
+					return fail("invalid parameter %q in %q", rest, decl)
+				}
+				if _, ok := params.Defaults[rest]; ok {
+					return fail("%v is both required and defaulted", rest)
+				}
+				if !required[rest] {
+					required[rest] = true
+					params.Required = append(params.Required, rest)
+				}
+			case "default":
+				name, value, ok := strings.Cut(rest, "=")
+				name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+				if !ok || !paramNameRegExp.MatchString(name) || undeclarableParams[name] {
+					return fail("expected default name=value, got %q", decl)
+				}
+				if required[name] {
+					return fail("%v is both required and defaulted", name)
+				}
+				if prev, ok := params.Defaults[name]; ok && prev[0] != value {
+					return fail("conflicting defaults for %v", name)
+				}
+				params.Defaults.Set(name, value)
+			case "":
+				return fail("empty declaration")
+			default:
+				return fail("unknown declaration %q, expected require or default", decl)
+			}
+		}
+	}
+	return params, nil
+}
+
+// Empty reports whether the template declares no parameters
+func (p TemplateParams) Empty() bool {
+	return len(p.Required) == 0 && len(p.Defaults) == 0
+}
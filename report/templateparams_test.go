@@ -0,0 +1,86 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTemplateParams(t *testing.T) {
+	Convey("When the parameters declared by a template are parsed", t, func() {
+		Convey("Leading declarations should be read in order", func() {
+			params, err := ParseTemplateParams(`
+%% reporter: require extra-customer, default orientation=landscape
+% an ordinary comment
+%reporter:require var-region,default  var-env = prod , require extra-customer
+\documentclass{article}
+%% reporter: require ignored
+`)
+			So(err, ShouldBeNil)
+			So(params.Required, ShouldResemble, []string{"extra-customer", "var-region"})
+			So(params.Defaults, ShouldResemble, url.Values{"orientation": {"landscape"}, "var-env": {"prod"}})
+			So(params.Empty(), ShouldBeFalse)
+		})
+
+		Convey("Default values may contain = and be empty", func() {
+			params, err := ParseTemplateParams("%% reporter: default extra-note=a=b, default var-host=")
+			So(err, ShouldBeNil)
+			So(params.Defaults, ShouldResemble, url.Values{"extra-note": {"a=b"}, "var-host": {""}})
+		})
+
+		Convey("A template without declarations should declare nothing", func() {
+			params, err := ParseTemplateParams("\\documentclass{article}\n")
+			So(err, ShouldBeNil)
+			So(params.Empty(), ShouldBeTrue)
+			params, err = ParseTemplateParams("")
+			So(err, ShouldBeNil)
+			So(params.Empty(), ShouldBeTrue)
+		})
+
+		Convey("Repeating the same default should be allowed", func() {
+			params, err := ParseTemplateParams("%% reporter: default style=plain\n%% reporter: default style=plain")
+			So(err, ShouldBeNil)
+			So(params.Defaults, ShouldResemble, url.Values{"style": {"plain"}})
+		})
+
+		Convey("Malformed declarations should fail naming their line", func() {
+			for _, tex := range []string{
+				"%% reporter:",
+				"%% reporter: require a,",
+				"%% reporter: require",
+				"%% reporter: require 1abc",
+				"%% reporter: require extra customer",
+				"%% reporter: need extra-customer",
+				"%% reporter: default orientation",
+				"%% reporter: default =landscape",
+				"%% reporter: require apitoken",
+				"%% reporter: default template=other",
+				"%% reporter: require var-a, default var-a=b",
+				"%% reporter: default var-a=b, require var-a",
+				"%% reporter: default var-a=b, default var-a=c",
+			} {
+				_, err := ParseTemplateParams("\n" + tex)
+				So(err, ShouldHaveSameTypeAs, &TemplateParamsError{})
+				So(err.(*TemplateParamsError).Line, ShouldEqual, 2)
+				So(err.Error(), ShouldStartWith, "invalid reporter declaration on line 2: ")
+			}
+		})
+	})
+}
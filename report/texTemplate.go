@@ -16,28 +16,51 @@
 
 package report
 
-const defaultTemplate = `
+const defaultMultiTemplate = `
 %use square brackets as golang text templating delimiters
 \documentclass{article}
 \usepackage{graphicx}
 \usepackage[margin=1in]{geometry}
+\usepackage[hidelinks]{hyperref}
 
-\graphicspath{ {images/} }
+[[with .Trace]]\makeatletter
+\def\ps@plain{\let\@oddhead\@empty\let\@evenhead\@empty\def\@oddfoot{\tiny [[.]]\hfil\normalsize\thepage}\let\@evenfoot\@oddfoot}
+\makeatother
+\pagestyle{plain}
+[[end]]
 \begin{document}
-\title{[[.Title]] [[if .VariableValues]] \\ \large [[.VariableValues]] [[end]] [[if .Description]] \\ \small [[.Description]] [[end]]}
-\date{[[.FromFormatted]]\\to\\[[.ToFormatted]]}
+\title{[[.Title]][[if .Volumes]]\\ \large Volume [[.Volume]] of [[.Volumes]][[end]]}
+\date{[[.FromFormatted]]\\to\\[[.ToFormatted]]\\ \small Time zone: [[.TimezoneFormatted]]}
 \maketitle
+[[range .Dashboards]][[$dir := .ImageDir]]
+\clearpage
+\phantomsection\addcontentsline{toc}{section}{\texorpdfstring{[[.Title]]}{[[.PlainTitle]]}}
+\section*{[[.Title]][[if .VariableValues]] \\ \large [[.VariableValues]][[end]]}
+[[if .NowDelay]]{\small Excluding the last [[.NowDelay]] of possibly incomplete data}\par[[end]]
+[[range .Warnings]]\textbf{Warning:} [[.]]\par
+[[end]][[if .Description]][[.Description]]\par[[end]]
 \begin{center}
 [[range .Panels]][[if .IsSingleStat]]\begin{minipage}{0.3\textwidth}
-\includegraphics[width=\textwidth]{image[[.Id]]}
+\includegraphics[width=\textwidth]{[[$dir]]/image[[.Id]]}
+[[if .Warning]]\\ {\small\textit{[[.Warning]]}}[[end]]
+[[if .Sources]]\\ {\small Source: [[join .Sources ", "]]}[[end]]
 \end{minipage}
 [[else]]\par
 \vspace{0.5cm}
-\includegraphics[width=\textwidth]{image[[.Id]]}
+\includegraphics[width=\textwidth,height=0.45\textheight,keepaspectratio]{[[$dir]]/image[[.Id]]}
+[[if .Warning]]\\ {\small\textit{[[.Warning]]}}[[end]]
+[[if .Sources]]\\ {\small Source: [[join .Sources ", "]]}[[end]]
 \par
 \vspace{0.5cm}
 [[end]][[end]]
-
 \end{center}
+[[end]]
+[[if .Missing]]\clearpage
+\section*{Missing dashboards}
+The following entries could not be resolved to a dashboard and are not part of this report:
+\begin{itemize}
+[[range .Missing]]\item [[.]]
+[[end]]\end{itemize}
+[[end]]
 \end{document}
 `
@@ -0,0 +1,74 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// TraceFooter prints a traceability line in the page footer of the built-in templates, naming the reporter and Grafana
+// versions, the generation time and the requesting host. Disable it for reports handed to external readers
+var TraceFooter = true
+
+// ReporterVersion is the version of the reporter printed in the trace footer
+var ReporterVersion = "unknown"
+
+// Trace identifies the software and request that produced a report, as passed to the TeX templates.
+// Its fields are escaped for TeX and "unknown" when not available.
+type Trace struct {
+	Reporter    string
+	Grafana     string //version and commit of Grafana, from its health API
+	GeneratedAt string //UTC
+	RequestedBy string
+}
+
+// String returns the traceability line printed in the footer
+func (t Trace) String() string {
+	return fmt.Sprintf("Reporter %s, Grafana %s, generated %s for %s", t.Reporter, t.Grafana, t.GeneratedAt, t.RequestedBy)
+}
+
+// newTrace describes the report generated at now. It returns nil when TraceFooter is disabled.
+// Grafana's version is fetched once per report; failing to fetch it does not fail the report.
+func (rep *report) newTrace(now time.Time) *Trace {
+	if !TraceFooter {
+		return nil
+	}
+	version := "unknown"
+	h, err := rep.gClient.GetHealth()
+	if err != nil {
+		log.Println("Error fetching Grafana version for the trace footer:", err)
+	} else if h.Version != "" {
+		version = h.Version
+		if h.Commit != "" {
+			version += " (" + h.Commit + ")"
+		}
+	}
+	requestedBy := rep.options.RequestedBy
+	if requestedBy == "" {
+		requestedBy = "unknown"
+	}
+	return &Trace{
+		Reporter:    grafana.SanitizeLaTexInput(ReporterVersion),
+		Grafana:     grafana.SanitizeLaTexInput(version),
+		GeneratedAt: now.UTC().Format("2006-01-02 15:04:05 MST"),
+		RequestedBy: grafana.SanitizeLaTexInput(requestedBy),
+	}
+}
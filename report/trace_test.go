@@ -0,0 +1,97 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTrace(t *testing.T) {
+	Convey("When describing a generated report for its trace footer", t, func() {
+		health := `{"commit":"abc123","database":"ok","version":"9.5.2"}`
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/health" || health == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, health)
+		}))
+		defer ts.Close()
+		defer func(orig string) { ReporterVersion = orig }(ReporterVersion)
+		ReporterVersion = "2.0.1"
+		generatedAt := time.Date(2026, 3, 4, 10, 30, 0, 0, time.FixedZone("CET", 3600))
+		newRep := func(opts Options) *report {
+			return new(grafana.NewV5Client(ts.URL, "", url.Values{}), "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", opts)
+		}
+
+		Convey("It should name the reporter and Grafana versions, the time and the requesting host", func() {
+			trace := newRep(Options{RequestedBy: "10.0.0.7"}).newTrace(generatedAt)
+			So(trace, ShouldResemble, &Trace{Reporter: "2.0.1", Grafana: "9.5.2 (abc123)", GeneratedAt: "2026-03-04 09:30:00 UTC", RequestedBy: "10.0.0.7"})
+			So(trace.String(), ShouldEqual, "Reporter 2.0.1, Grafana 9.5.2 (abc123), generated 2026-03-04 09:30:00 UTC for 10.0.0.7")
+		})
+
+		Convey("Missing health data should give unknown", func() {
+			health = `{"database":"ok"}`
+			trace := newRep(Options{}).newTrace(generatedAt)
+			So(trace.Grafana, ShouldEqual, "unknown")
+			So(trace.RequestedBy, ShouldEqual, "unknown")
+		})
+
+		Convey("A failing health check should give unknown, not fail", func() {
+			health = ""
+			trace := newRep(Options{}).newTrace(generatedAt)
+			So(trace.Grafana, ShouldEqual, "unknown")
+		})
+
+		Convey("It should be left out when the trace footer is disabled", func() {
+			defer func() { TraceFooter = true }()
+			TraceFooter = false
+			So(newRep(Options{}).newTrace(generatedAt), ShouldBeNil)
+		})
+
+		Convey("The built-in styles should print it in the footer", func() {
+			gClient := &mockGrafanaClient{}
+			dash, _ := gClient.GetDashboard("")
+			for _, style := range Styles() {
+				rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{Style: style, RequestedBy: "host_1"})
+				rep.trace = rep.newTrace(generatedAt)
+				So(rep.generateTeXFile(dash, nil, nil), ShouldBeNil)
+				tex, err := ioutil.ReadFile(rep.texPath())
+				rep.Clean()
+				So(err, ShouldBeNil)
+				So(string(tex), ShouldContainSubstring, `\def\@oddfoot{\tiny Reporter 2.0.1, Grafana 9.5.2 (abc123), generated 2026-03-04 09:30:00 UTC for host\_1\hfil`)
+			}
+
+			Convey("But not without a trace", func() {
+				rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+				defer rep.Clean()
+				So(rep.generateTeXFile(dash, nil, nil), ShouldBeNil)
+				tex, _ := ioutil.ReadFile(rep.texPath())
+				So(string(tex), ShouldNotContainSubstring, `\@oddfoot`)
+			})
+		})
+	})
+}
@@ -0,0 +1,50 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"fmt"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// VersionChangedError is returned in strict mode when the dashboard was saved while its panels were rendered
+type VersionChangedError struct {
+	Dashboard string
+	From      int
+	To        int
+}
+
+func (e *VersionChangedError) Error() string {
+	return fmt.Sprintf("dashboard %v changed from version %d to %d while the report was generated, please retry the report", e.Dashboard, e.From, e.To)
+}
+
+// versionWarning re-fetches the dashboard after its panels were rendered and describes a change of its version,
+// which means the images may not match the dashboard's structure. In strict mode a change is an error instead.
+func (rep *report) versionWarning(dash grafana.Dashboard) (string, error) {
+	current, err := rep.gClient.GetDashboard(rep.dashName)
+	if err != nil {
+		return "", fmt.Errorf("error re-fetching dashboard %v to check its version: %w", rep.dashName, err)
+	}
+	if current.Version == dash.Version {
+		return "", nil
+	}
+	if rep.options.StrictVersion {
+		return "", &VersionChangedError{rep.dashName, dash.Version, current.Version}
+	}
+	return grafana.SanitizeLaTexInput(fmt.Sprintf("The dashboard changed from version %d to %d while this report was generated, so some panels may not match their titles or layout.", dash.Version, current.Version)), nil
+}
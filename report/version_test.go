@@ -0,0 +1,99 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// editedClient returns a dashboard whose version increases by edits on every fetch
+type editedClient struct {
+	mockGrafanaClient
+	fetches int
+	edits   int
+}
+
+func (m *editedClient) GetDashboard(dashName string) (grafana.Dashboard, error) {
+	dash, _ := m.mockGrafanaClient.GetDashboard(dashName)
+	dash.Version = 1 + m.fetches*m.edits
+	m.fetches++
+	return dash, nil
+}
+
+func TestVersionWarning(t *testing.T) {
+	Convey("When checking the dashboard version after rendering", t, func() {
+		gClient := &editedClient{mockGrafanaClient: mockGrafanaClient{0, url.Values{}}}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{CheckVersion: true})
+		defer rep.Clean()
+		dash, _ := gClient.GetDashboard("testDash")
+
+		Convey("An unchanged dashboard should not give a warning", func() {
+			warning, err := rep.versionWarning(dash)
+			So(err, ShouldBeNil)
+			So(warning, ShouldBeEmpty)
+		})
+
+		Convey("A changed dashboard should give a warning naming both versions", func() {
+			gClient.edits = 1
+			warning, err := rep.versionWarning(dash)
+			So(err, ShouldBeNil)
+			So(warning, ShouldContainSubstring, "from version 1 to 2")
+		})
+
+		Convey("A changed dashboard should fail in strict mode", func() {
+			gClient.edits = 1
+			rep.options.StrictVersion = true
+			_, err := rep.versionWarning(dash)
+			var versionErr *VersionChangedError
+			So(errors.As(err, &versionErr), ShouldBeTrue)
+			So(versionErr.From, ShouldEqual, 1)
+			So(versionErr.To, ShouldEqual, 2)
+			So(err.Error(), ShouldContainSubstring, "retry")
+		})
+	})
+
+	Convey("When generating a report of a dashboard edited during rendering", t, func() {
+		gClient := &editedClient{mockGrafanaClient: mockGrafanaClient{0, url.Values{}}, edits: 1}
+		defer stubCompiler(1)()
+
+		Convey("The warning should be on the cover", func() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{CheckVersion: true})
+			defer rep.Clean()
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			pdf.Close()
+			So(gClient.fetches, ShouldEqual, 2)
+			tex, _ := ioutil.ReadFile(rep.texPath())
+			So(string(tex), ShouldContainSubstring, "from version 1 to 2")
+		})
+
+		Convey("Without the check the dashboard should be fetched once", func() {
+			rep := new(gClient, "testDash", grafana.TimeRange{From: "now-1h", To: "now"}, "", Options{})
+			defer rep.Clean()
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			pdf.Close()
+			So(gClient.fetches, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,213 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/IzakMarais/reporter/grafana"
+)
+
+// maxVolumeAttempts bounds how often the volumes are re-planned when a volume still has too many pages
+const maxVolumeAttempts = 3
+
+// the file name may be wrapped over several lines of the log
+var pagesRegExp = regexp.MustCompile(`Output written on [^(]*\((\d+) pages?`)
+
+// pageCount reads the number of pages from pdflatex's output. xelatex reports the
+// pages of its xdv file the same way, before xdvipdfmx converts it.
+func pageCount(latexOutput []byte) (int, error) {
+	m := pagesRegExp.FindSubmatch(latexOutput)
+	if m == nil {
+		return 0, fmt.Errorf("no page count in LaTeX output")
+	}
+	return strconv.Atoi(string(m[1]))
+}
+
+// volumeUnits groups the dashboard's panels into the units that are kept together in a volume:
+// the rows of dashboards that have them, otherwise the single panels
+func volumeUnits(dash grafana.Dashboard) [][]grafana.Panel {
+	var units [][]grafana.Panel
+	if len(dash.Rows) > 0 {
+		for _, r := range dash.Rows {
+			if len(r.Panels) > 0 {
+				units = append(units, r.Panels)
+			}
+		}
+		return units
+	}
+	for _, p := range dash.Panels {
+		units = append(units, []grafana.Panel{p})
+	}
+	return units
+}
+
+// planVolumes partitions the units into about n volumes of similar panel counts.
+// Units are only split when a single unit is larger than a volume.
+func planVolumes(units [][]grafana.Panel, n int) [][]grafana.Panel {
+	total := 0
+	for _, u := range units {
+		total += len(u)
+	}
+	if n < 1 {
+		n = 1
+	}
+	size := (total + n - 1) / n
+	if size < 1 {
+		size = 1
+	}
+
+	var volumes [][]grafana.Panel
+	var cur []grafana.Panel
+	for _, u := range units {
+		if len(cur) > 0 && len(cur)+len(u) > size {
+			volumes = append(volumes, cur)
+			cur = nil
+		}
+		for len(u) > size {
+			volumes = append(volumes, u[:size])
+			u = u[size:]
+		}
+		cur = append(cur, u...)
+	}
+	if len(cur) > 0 {
+		volumes = append(volumes, cur)
+	}
+	return volumes
+}
+
+// generateVolumes compiles the dashboard as several volumes of at most MaxPagesPerVolume pages and
+// returns them zipped. The first estimate is based on the page count of the single report.
+func (rep *report) generateVolumes(dash grafana.Dashboard, summaries []Summary, warnings []string, pages int) (*os.File, error) {
+	max := rep.options.MaxPagesPerVolume
+	units := volumeUnits(dash)
+	n := (pages + max - 1) / max
+
+	var pdfs []string
+	for attempt := 1; attempt <= maxVolumeAttempts; attempt++ {
+		plan := planVolumes(units, n)
+		var needed int
+		var err error
+		pdfs, needed, err = rep.compileVolumes(dash, plan, summaries, warnings)
+		if err != nil {
+			return nil, err
+		}
+		if needed <= len(plan) || len(plan) == len(dash.Panels) {
+			break
+		}
+		log.Printf("Volumes of %d panels are too long, retrying with %d volumes", len(plan[0]), needed)
+		n = needed
+	}
+	return rep.zipVolumes(pdfs, rep.dashName)
+}
+
+// compileVolumes compiles one volume per entry in plan. It returns the paths of the pdfs and
+// the number of volumes needed to keep each below the page limit.
+func (rep *report) compileVolumes(dash grafana.Dashboard, plan [][]grafana.Panel, summaries []Summary, warnings []string) (pdfs []string, needed int, err error) {
+	max := rep.options.MaxPagesPerVolume
+	for i, panels := range plan {
+		vol := *rep
+		vol.jobName = fmt.Sprintf("volume%d", i+1)
+		vol.volume = i + 1
+		vol.volumes = len(plan)
+
+		var volSummaries []Summary
+		if i == 0 {
+			volSummaries = summaries
+		}
+		err = vol.generateTeXFile(volumeDashboard(dash, panels), volSummaries, warnings)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error generating TeX file for volume %d: %w", i+1, err)
+		}
+		var pdf *os.File
+		var pages int
+		pdf, pages, err = vol.runLaTeX()
+		if err != nil {
+			return nil, 0, fmt.Errorf("error compiling volume %d: %w", i+1, err)
+		}
+		pdf.Close()
+		pdfs = append(pdfs, vol.pdfPath())
+		if pages > max {
+			needed += (pages + max - 1) / max
+		} else {
+			needed++
+		}
+	}
+	return pdfs, needed, nil
+}
+
+// volumeDashboard returns a copy of dash limited to panels, dropping rows left empty
+func volumeDashboard(dash grafana.Dashboard, panels []grafana.Panel) grafana.Dashboard {
+	ids := map[int]bool{}
+	for _, p := range panels {
+		ids[p.Id] = true
+	}
+	dash.Panels = panels
+	var rows []grafana.Row
+	for _, r := range dash.Rows {
+		var kept []grafana.Panel
+		for _, p := range r.Panels {
+			if ids[p.Id] {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) > 0 {
+			r.Panels = kept
+			rows = append(rows, r)
+		}
+	}
+	dash.Rows = rows
+	return dash
+}
+
+// zipVolumes writes the volume pdfs and their manifest, naming dashboard as their source, to report.zip in the temporary directory
+func (rep *report) zipVolumes(pdfs []string, dashboard string) (*os.File, error) {
+	path := filepath.Join(rep.tmpDir, reportJob+".zip")
+	f, err := createTmpFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zip file at %v: %w", path, err)
+	}
+	zw := &zipManifestWriter{zw: zip.NewWriter(f), key: rep.signingKey()}
+	generatedAt := time.Now().UTC()
+	for i, pdf := range pdfs {
+		entry := ManifestFile{Dashboard: dashboard, From: rep.time.From, To: rep.time.To, GeneratedAt: generatedAt}
+		err = zw.addFile(fmt.Sprintf("volume-%d-of-%d.pdf", i+1, len(pdfs)), pdf, entry)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	err = zw.close()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing zip file at %v: %w", path, err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error rewinding zip file at %v: %v", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,280 @@
+/*
+   Copyright 2016 Vastech SA (PTY) LTD
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IzakMarais/reporter/grafana"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func panels(ids ...int) []grafana.Panel {
+	var ps []grafana.Panel
+	for _, id := range ids {
+		ps = append(ps, grafana.Panel{Id: id, Type: "graph"})
+	}
+	return ps
+}
+
+func panelIds(volumes [][]grafana.Panel) [][]int {
+	var ids [][]int
+	for _, v := range volumes {
+		var vol []int
+		for _, p := range v {
+			vol = append(vol, p.Id)
+		}
+		ids = append(ids, vol)
+	}
+	return ids
+}
+
+func TestPageCount(t *testing.T) {
+	Convey("When reading the page count from LaTeX output", t, func() {
+		Convey("The count should be taken from the output written line", func() {
+			n, err := pageCount([]byte("Some log\nOutput written on report.pdf (312 pages, 1234567 bytes).\nTranscript written on report.log."))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 312)
+		})
+
+		Convey("A single page should be read", func() {
+			n, err := pageCount([]byte("Output written on report.pdf (1 page, 1234 bytes)."))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+		})
+
+		Convey("The count should be read from a pdflatex log with a wrapped file name", func() {
+			log := `[13] [14] (./report.aux) )</usr/share/texlive/texmf-dist/fonts/type1/public/amsfonts/cm/cmr10.pfb>
+Output written on /tmp/reporter/tmp/0c5b6b1e-0f6e-4b83-9d1a-5d6a2d3c6f1e/report
+.pdf (14 pages, 2201843 bytes).
+Transcript written on report.log.`
+			n, err := pageCount([]byte(log))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 14)
+		})
+
+		Convey("The count should be read from a xelatex log", func() {
+			log := `[13] [14] (./report.aux) )
+Output written on report.xdv (14 pages, 1032816 bytes).
+Transcript written on report.log.
+report.xdv -> report.pdf
+[1][2][3][4][5][6][7][8][9][10][11][12][13][14]
+2201843 bytes written`
+			n, err := pageCount([]byte(log))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 14)
+		})
+
+		Convey("Output without a count should return an error", func() {
+			_, err := pageCount([]byte("No pages of output."))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPlanVolumes(t *testing.T) {
+	Convey("When planning volumes", t, func() {
+		Convey("Rows should be kept together", func() {
+			units := [][]grafana.Panel{panels(1, 2), panels(3, 4), panels(5, 6)}
+			So(panelIds(planVolumes(units, 2)), ShouldResemble, [][]int{{1, 2}, {3, 4}, {5, 6}})
+		})
+
+		Convey("Small rows should share a volume", func() {
+			units := [][]grafana.Panel{panels(1), panels(2), panels(3, 4), panels(5), panels(6)}
+			So(panelIds(planVolumes(units, 2)), ShouldResemble, [][]int{{1, 2}, {3, 4, 5}, {6}})
+		})
+
+		Convey("Single panels should be spread evenly", func() {
+			units := [][]grafana.Panel{panels(1), panels(2), panels(3), panels(4)}
+			So(panelIds(planVolumes(units, 2)), ShouldResemble, [][]int{{1, 2}, {3, 4}})
+		})
+
+		Convey("A row larger than a volume should be split", func() {
+			units := [][]grafana.Panel{panels(1), panels(2, 3, 4, 5, 6)}
+			So(panelIds(planVolumes(units, 3)), ShouldResemble, [][]int{{1}, {2, 3}, {4, 5}, {6}})
+		})
+	})
+}
+
+// stubCompiler replaces the LaTeX compiler with one that writes a fake pdf and reports
+// pagesPerPanel pages for every panel image included in the TeX file
+func stubCompiler(pagesPerPanel int) (restore func()) {
+	orig := compileTeX
+	compileTeX = func(_ context.Context, dir string, texFile string) ([]byte, error) {
+		tex, err := ioutil.ReadFile(filepath.Join(dir, texFile))
+		if err != nil {
+			return nil, err
+		}
+		pages := strings.Count(string(tex), `\includegraphics`) * pagesPerPanel
+		pdf := strings.TrimSuffix(texFile, ".tex") + ".pdf"
+		err = ioutil.WriteFile(filepath.Join(dir, pdf), tex, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintf("Output written on %s (%d pages, 100 bytes).", pdf, pages)), nil
+	}
+	return func() { compileTeX = orig }
+}
+
+func TestGenerateVolumes(t *testing.T) {
+	Convey("When generating a report with a page limit", t, func() {
+		defer stubCompiler(10)()
+		gClient := &mockGrafanaClient{}
+		rep := new(gClient, "testDash", grafana.TimeRange{From: "1453206447000", To: "1453213647000"}, "", Options{MaxPagesPerVolume: 30})
+		defer rep.Clean()
+
+		Convey("A report within the limit should be a single pdf", func() {
+			rep.options.MaxPagesPerVolume = 100
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			defer pdf.Close()
+			b, _ := ioutil.ReadAll(pdf)
+			So(string(b), ShouldContainSubstring, `\begin{document}`)
+
+			stats := rep.Stats()
+			So(stats.Panels, ShouldBeGreaterThan, 0)
+			So(stats.Pages, ShouldEqual, 10*stats.Panels)
+			So(stats.Bytes, ShouldEqual, len(b))
+			size, err := dirSize(rep.tmpDir)
+			So(err, ShouldBeNil)
+			So(stats.BuildBytes, ShouldEqual, size)
+			So(stats.BuildBytes, ShouldBeGreaterThan, stats.Bytes)
+		})
+
+		Convey("A longer report should be a zip of volumes", func() {
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			pdf.Close()
+
+			z, err := zip.OpenReader(filepath.Join(rep.tmpDir, "report.zip"))
+			So(err, ShouldBeNil)
+			defer z.Close()
+			var names []string
+			for _, f := range z.File {
+				names = append(names, f.Name)
+			}
+			So(names, ShouldResemble, []string{"volume-1-of-4.pdf", "volume-2-of-4.pdf", "volume-3-of-4.pdf", "volume-4-of-4.pdf", ManifestName})
+
+			Convey("The stats should count the pages of all volumes and the size of the zip", func() {
+				info, err := os.Stat(filepath.Join(rep.tmpDir, "report.zip"))
+				So(err, ShouldBeNil)
+				So(rep.Stats().Bytes, ShouldEqual, info.Size())
+				So(rep.Stats().Pages, ShouldEqual, 10*rep.Stats().Panels)
+			})
+
+			Convey("The manifest should list each volume with its checksum", func() {
+				f, err := z.File[4].Open()
+				So(err, ShouldBeNil)
+				defer f.Close()
+				var manifest Manifest
+				So(json.NewDecoder(f).Decode(&manifest), ShouldBeNil)
+				So(manifest.Files, ShouldHaveLength, 4)
+				for i, entry := range manifest.Files {
+					So(entry.Name, ShouldEqual, z.File[i].Name)
+					So(entry.Dashboard, ShouldEqual, "testDash")
+					So(entry.From, ShouldEqual, "1453206447000")
+					So(entry.To, ShouldEqual, "1453213647000")
+					So(entry.GeneratedAt.IsZero(), ShouldBeFalse)
+
+					vol, err := z.File[i].Open()
+					So(err, ShouldBeNil)
+					b, err := ioutil.ReadAll(vol)
+					vol.Close()
+					So(err, ShouldBeNil)
+					sum := sha256.Sum256(b)
+					So(entry.Sha256, ShouldEqual, hex.EncodeToString(sum[:]))
+					So(entry.Size, ShouldEqual, len(b))
+				}
+			})
+
+			Convey("Each volume should note its number on the cover", func() {
+				f, err := z.File[1].Open()
+				So(err, ShouldBeNil)
+				defer f.Close()
+				b, _ := ioutil.ReadAll(f)
+				So(string(b), ShouldContainSubstring, "Volume 2 of 4")
+			})
+		})
+
+		Convey("Signed volumes should each have a signature entry and manifest signature", func() {
+			defer func(orig crypto.Signer) { SigningKey = orig }(SigningKey)
+			pub, key, err := ed25519.GenerateKey(rand.Reader)
+			So(err, ShouldBeNil)
+			SigningKey = key
+			rep.options.Sign = true
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			pdf.Close()
+
+			z, err := zip.OpenReader(filepath.Join(rep.tmpDir, "report.zip"))
+			So(err, ShouldBeNil)
+			defer z.Close()
+			So(z.File, ShouldHaveLength, 9)
+			So(z.File[0].Name, ShouldEqual, "volume-1-of-4.pdf")
+			So(z.File[1].Name, ShouldEqual, "volume-1-of-4.pdf.sig")
+			read := func(f *zip.File) []byte {
+				r, err := f.Open()
+				So(err, ShouldBeNil)
+				defer r.Close()
+				b, err := ioutil.ReadAll(r)
+				So(err, ShouldBeNil)
+				return b
+			}
+			sig := read(z.File[1])
+			So(Verify(pub, bytes.NewReader(read(z.File[0])), sig), ShouldBeNil)
+
+			var manifest Manifest
+			So(json.Unmarshal(read(z.File[8]), &manifest), ShouldBeNil)
+			So(manifest.SignatureAlgorithm, ShouldEqual, SignatureEd25519)
+			So(manifest.Files[0].Signature, ShouldEqual, base64.StdEncoding.EncodeToString(sig))
+		})
+
+		Convey("Volumes still over the limit should be split further", func() {
+			rep.options.MaxPagesPerVolume = 45
+			defer stubCompiler(20)()
+			pdf, err := rep.Generate()
+			So(err, ShouldBeNil)
+			pdf.Close()
+
+			z, err := zip.OpenReader(filepath.Join(rep.tmpDir, "report.zip"))
+			So(err, ShouldBeNil)
+			defer z.Close()
+			So(len(z.File), ShouldEqual, 6)
+		})
+	})
+
+	Convey("When the volumes cannot be written", t, func() {
+		rep := &report{tmpDir: filepath.Join(os.DevNull, "missing")}
+		_, err := rep.zipVolumes(nil, "")
+		So(err, ShouldNotBeNil)
+	})
+}